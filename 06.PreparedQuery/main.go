@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
-	"sync"
 
+	"github.com/devlights/try-golang-db/internal/fanout"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -30,6 +31,18 @@ func init() {
 // 返されたステートメントから複数のクエリや実行を同時に実行することができます。
 // ステートメントが不要になったら、呼び出し元はステートメントの Close メソッドを呼び出さなければなりません。
 //
+// 複数のゴルーチンから同時に利用する際は、エラーの扱いに注意が必要となる。
+// 各ゴルーチンから外側の err 変数に書き込むとデータ競合となり、
+// エラー用チャネルから最初の1件だけを返すと残りのエラーは捨てられてしまう。
+//
+// 本サンプルでは internal/fanout を利用して
+//
+//   - 同時実行数を制限 (Limit)
+//   - 結果はパラメータの順序で取得
+//   - エラーは errors.Join でまとめて取得 (CollectAll)
+//
+// という形で実行している。最初の失敗で残りを中断したい場合は fanout.FailFast を指定する。
+//
 // # REFERENCES
 //   - https://go.dev/doc/database/prepared-statements
 //   - https://pkg.go.dev/database/sql@go1.21.6#DB.Prepare
@@ -43,16 +56,16 @@ func main() {
 	   $ task -d 06.PreparedQuery/
 	   task: [default] cp -f ../chinook.db .
 	   task: [default] go run main.go
-	   id=10   name=Billy Cobham
-	   id=8    name=Audioslave
-	   id=9    name=BackBeat
-	   id=6    name=Antônio Carlos Jobim
 	   id=1    name=AC/DC
+	   id=2    name=Accept
 	   id=3    name=Aerosmith
-	   id=7    name=Apocalyptica
 	   id=4    name=Alanis Morissette
-	   id=2    name=Accept
 	   id=5    name=Alice In Chains
+	   id=6    name=Antônio Carlos Jobim
+	   id=7    name=Apocalyptica
+	   id=8    name=Audioslave
+	   id=9    name=BackBeat
+	   id=10   name=Billy Cobham
 	*/
 }
 
@@ -85,44 +98,56 @@ func run() error {
 
 	const LOOP_COUNT = 10
 	var (
-		wg    sync.WaitGroup
-		errCh = make(chan error, LOOP_COUNT)
+		ctx    = context.Background()
+		params = make([][]any, LOOP_COUNT)
+		opts   = fanout.Options{Limit: 4, Mode: fanout.CollectAll}
 	)
 
-	wg.Add(LOOP_COUNT)
+	for i := range LOOP_COUNT {
+		params[i] = []any{i + 1}
+	}
 
-	for i := 0; i < LOOP_COUNT; i++ {
-		go func(i int) {
-			defer wg.Done()
+	var (
+		artists []Artist
+	)
 
-			var (
-				row  *sql.Row
-				id   int
-				name string
-			)
+	artists, err = fanout.QueryStmt(ctx, stmt, params, scanArtist, opts)
+	for _, a := range artists {
+		if a.Id == 0 {
+			continue // 失敗したものはゼロ値のまま
+		}
 
-			row = stmt.QueryRow(i)
-			err = row.Scan(&id, &name)
-			if err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					errCh <- fmt.Errorf("ErrNoRows: %d", i)
-					return
-				}
+		log.Printf("id=%v\tname=%v", a.Id, a.Name)
+	}
 
-				errCh <- fmt.Errorf("sql.Row.Scan: %w", err)
-				return
-			}
+	if err != nil {
+		return fmt.Errorf("fanout.QueryStmt: %w", err)
+	}
 
-			log.Printf("id=%v\tname=%v", id, name)
-		}(i + 1)
+	return nil
+}
+
+type (
+	Artist struct {
+		Id   int
+		Name string
 	}
+)
 
-	wg.Wait()
-	close(errCh)
+func scanArtist(row *sql.Row) (Artist, error) {
+	var (
+		artist Artist
+		err    error
+	)
 
-	for e := range errCh {
-		return e
+	err = row.Scan(&artist.Id, &artist.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return artist, fmt.Errorf("ErrNoRows: %w", err)
+		}
+
+		return artist, fmt.Errorf("sql.Row.Scan: %w", err)
 	}
 
-	return nil
+	return artist, nil
 }
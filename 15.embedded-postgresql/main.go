@@ -1,24 +1,23 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"io"
 	"log"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	_ "embed"
 
+	"github.com/devlights/try-golang-db/internal/lifecycle"
+	"github.com/devlights/try-golang-db/internal/pgfixture"
+	"github.com/devlights/try-golang-db/internal/ready"
 	embedpsql "github.com/fergusstrange/embedded-postgres"
 	_ "github.com/lib/pq"
 )
 
-const (
-	dsn = "host=localhost port=5432 user=postgres password=postgres dbname=postgres sslmode=disable"
-)
-
 var (
 	//go:embed northwind.sql
 	northwindSQL string // ビルド時にSQLファイルを埋め込む
@@ -37,19 +36,33 @@ func main() {
 	//
 	// ダウンロードされた PostgreSQL は ~/.embedded-postgres-go に配置される。
 	//
+	// 起動とデータベースの作成は internal/pgfixture で行う。テストでは pgfixture.Main と pgfixture.NewDB を利用する。
+	//
 	log.Println("=> START")
 	defer func() { log.Println("=> END") }()
 
-	if err := run(); err != nil {
+	// SIGINT/SIGTERM を受け取ると ctx がキャンセルされ、run が戻った後に
+	// 登録した後始末 (*sql.DB のクローズ、PostgreSQL の停止と一時ディレクトリの削除) を逆順に1回だけ行う。
+	var (
+		lc = lifecycle.New(context.Background(), lifecycle.Options{})
+	)
+	err := lc.Run(func(ctx context.Context) error {
+		return run(ctx, lc)
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
 		panic(err)
 	}
 }
 
-func run() error {
+func run(ctx context.Context, lc *lifecycle.Manager) error {
+	//
+	// embedded postgres の起動
 	//
-	// embedded postgres の設定と起動
+	// pgfixture は、空いているポートとプロセス毎の一時ディレクトリで起動するため、
+	// 同時に複数実行したり、テストから利用したりできる。
+	// northwind.sql はテンプレートデータベースに投入され、CreateDatabase でそのコピーを作成する。
 	//
-	// ログバッファ
+	// ログ出力先
 	var (
 		logBuf = io.Discard
 	)
@@ -57,77 +70,51 @@ func run() error {
 		logBuf = os.Stdout
 	}
 
-	// 設定
 	var (
-		conf embedpsql.Config
+		cancel context.CancelFunc
 	)
-	conf = embedpsql.DefaultConfig().
-		Username("postgres").
-		Password("postgres").
-		Database("postgres").
-		Port(5432).
-		Version(embedpsql.V18).
-		StartTimeout(30 * time.Second).
-		Logger(logBuf)
-
-	// データベース起動
-	var (
-		pg = embedpsql.NewDatabase(conf)
-	)
-	if err := pg.Start(); err != nil {
+	ctx, cancel = context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	srv, err := pgfixture.Start(ctx, pgfixture.Options{
+		Version:      embedpsql.V18,
+		TemplateName: "northwind_template",
+		TemplateSQL:  northwindSQL,
+		StartTimeout: 30 * time.Second,
+		Logger:       logBuf,
+	})
+	if err != nil {
 		return err
 	}
+	lc.OnShutdown("embedded-postgres", func(context.Context) error {
+		return srv.Stop()
+	})
 
-	// シグナルハンドリング
-	var (
-		sigCh = make(chan os.Signal, 1)
-	)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigCh
-		log.Println("\n==> Shutting down...")
-
-		if err := pg.Stop(); err != nil {
-			log.Printf("pg.Stop error: %v", err)
-		}
-
-		os.Exit(0)
-	}()
-
-	defer func() {
-		if err := pg.Stop(); err != nil {
-			log.Printf("==> pg.Stop error: %v", err)
-		}
-
-		log.Println("==> embedded-postgres stopped")
-	}()
-
-	log.Println("==> embedded-postgres started")
+	log.Printf("==> embedded-postgres started (port=%d)", srv.Port())
 
 	//
-	// database/sql で接続
+	// テンプレートからデータベースを作成して database/sql で接続
 	//
-	var (
-		db  *sql.DB
-		err error
+	const (
+		dbName = "northwind"
 	)
-	db, err = sql.Open("postgres", dsn)
-	if err != nil {
+	if err = srv.CreateDatabase(ctx, dbName); err != nil {
 		return err
 	}
-	defer db.Close()
 
-	if err := db.Ping(); err != nil {
+	var (
+		db *sql.DB
+	)
+	db, err = sql.Open("postgres", srv.DSN(dbName))
+	if err != nil {
 		return err
 	}
-	log.Println("==> ping OK")
+	lc.CloseDB("database/sql", db)
 
-	//
-	// スキーマとデータ投入
-	//
-	if _, err = db.Exec(northwindSQL); err != nil {
+	if err = ready.WaitReady(ctx, db, ready.Policy{AttemptTimeout: 5 * time.Second}); err != nil {
 		return err
 	}
+	log.Println("==> ping OK")
 
 	//
 	// クエリ発行
@@ -144,7 +131,7 @@ func run() error {
 	var (
 		rows *sql.Rows
 	)
-	if rows, err = db.Query(query); err != nil {
+	if rows, err = db.QueryContext(ctx, query); err != nil {
 		return err
 	}
 	defer rows.Close()
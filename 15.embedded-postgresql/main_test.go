@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/devlights/try-golang-db/internal/pgfixture"
+	embedpsql "github.com/fergusstrange/embedded-postgres"
+)
+
+// TestMain は、テストバイナリ全体で1つの PostgreSQL を起動し、northwind.sql を投入したテンプレートを作成する。
+//
+// PostgreSQL を起動できない環境 (キャッシュが無くオフライン、root で実行しているなど) ではテストをスキップする。
+// 環境変数 PGFIXTURE_REQUIRED が設定されている場合は、起動できなければ失敗とする。
+func TestMain(m *testing.M) {
+	os.Exit(pgfixture.Main(m, pgfixture.Options{
+		Version:           embedpsql.V18,
+		TemplateName:      "northwind_template",
+		TemplateSQL:       northwindSQL,
+		StartTimeout:      30 * time.Second,
+		SkipIfUnavailable: os.Getenv("PGFIXTURE_REQUIRED") == "",
+	}))
+}
+
+func TestOrdersByCountry(t *testing.T) {
+	var (
+		db = pgfixture.NewDB(t)
+	)
+
+	rows, err := db.Query(`
+		SELECT ship_country, COUNT(*) AS order_count
+		FROM orders
+		GROUP BY ship_country
+		ORDER BY order_count DESC
+		LIMIT 10`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var (
+		n    int
+		prev = int(^uint(0) >> 1)
+	)
+	for rows.Next() {
+		var (
+			country string
+			count   int
+		)
+		if err = rows.Scan(&country, &count); err != nil {
+			t.Fatal(err)
+		}
+		if count > prev {
+			t.Errorf("%s: %d is not in descending order (prev %d)", country, count, prev)
+		}
+		prev = count
+		n++
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Errorf("rows = %d, want 10", n)
+	}
+}
+
+// TestIsolated は、テスト毎のデータベースがテンプレートのコピーで、互いに影響しないことを確認する。
+func TestIsolated(t *testing.T) {
+	var (
+		a = pgfixture.NewDB(t)
+		b = pgfixture.NewDB(t)
+	)
+
+	var (
+		before int
+	)
+	if err := b.QueryRow("SELECT count(*) FROM order_details").Scan(&before); err != nil {
+		t.Fatal(err)
+	}
+	if before == 0 {
+		t.Fatal("template has no order_details")
+	}
+
+	if _, err := a.Exec("DELETE FROM order_details"); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		after int
+	)
+	if err := b.QueryRow("SELECT count(*) FROM order_details").Scan(&after); err != nil {
+		t.Fatal(err)
+	}
+	if after != before {
+		t.Errorf("order_details in another database = %d, want %d", after, before)
+	}
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/devlights/try-golang-db/internal/pragma"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"modernc.org/sqlite"
+)
+
+const (
+	maxTrackId = 3503 // chinook.db の tracks の件数
+	rangeSize  = 100
+	insertRows = 10
+)
+
+type (
+	// target は、ベンチマーク対象のドライバとPRAGMAプロファイルの組み合わせ
+	target struct {
+		driverName string
+		drv        driver.Driver
+		profile    string
+		pragmas    pragma.Profile
+	}
+
+	// benchmark は、一つのベンチマーク
+	benchmark struct {
+		name string
+		fn   func(b *testing.B, db *sql.DB)
+	}
+
+	// measurement は、ベンチマークの測定結果
+	measurement struct {
+		bench  string
+		target target
+		result testing.BenchmarkResult
+	}
+
+	// pragmaConnector は、接続ごとにPRAGMAを発行する driver.Connector
+	//
+	// 13/14 のようにドライバ毎の接続フックを利用すると
+	// modernc 側がプロセスグローバルになってしまい「デフォルト設定」と比較できないため、
+	// ここでは sql.OpenDB に渡すコネクタ側でPRAGMAを発行する。
+	pragmaConnector struct {
+		drv     driver.Driver
+		dsn     string
+		pragmas pragma.Profile
+	}
+)
+
+func (c *pragmaConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.drv.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.pragmas.Settings) == 0 {
+		return conn, nil
+	}
+
+	pc, ok := conn.(pragma.Conn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("%T does not implement pragma.Conn", conn)
+	}
+
+	if _, err = c.pragmas.Apply(ctx, pc); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("PRAGMA setup: %w", err)
+	}
+
+	return conn, nil
+}
+
+func (c *pragmaConnector) Driver() driver.Driver {
+	return c.drv
+}
+
+var (
+	dbFile = flag.String("db", "./chinook.db", "ベンチマークに利用するデータベースファイル")
+	only   = flag.String("run", "", "指定した文字列を名前に含むベンチマークのみ実行")
+)
+
+// 16.DriverBenchmark
+//
+// 本リポジトリでは cgo が必要な mattn/go-sqlite3 と、Pure Go な modernc.org/sqlite の
+// 2つのドライバを利用しているが、性能差を測ったことがなかったので比較する。
+//
+// 同じベンチマークは main_test.go にもあり、go test -bench で実行できる (benchstat での比較はこちら)。
+//
+//	$ go test -bench . -benchmem -benchtime 500ms
+//
+// 本プログラムでは、testing.Benchmark() を利用して通常のプログラムから実行している。
+// 結果は testing.BenchmarkResult で返ってくるので、それを集計してドライバ間の比率を含むレポートを出力している。
+//
+// 測定するのは以下。
+//
+//   - PointLookup/adhoc    : 主キーで1件取得 (02.Query と同様に毎回クエリ文字列を渡す)
+//   - PointLookup/prepared : 主キーで1件取得 (06.PreparedQuery と同様に *sql.Stmt を使い回す)
+//   - RangeScan            : tracks を主キーの範囲で100件取得
+//   - TxInsert             : 1トランザクションで10件INSERT (05.Transaction と同様)
+//   - ConcurrentReaders    : 複数ゴルーチンから同時に主キーで1件取得 (b.RunParallel)
+//
+// それぞれ、PRAGMA未設定(default) と 13/14 と同じ pragma.Server() を適用した状態(pragma) で測定する。
+// PRAGMA journal_mode=WAL はDBファイルに永続化されるため、プロファイル毎にDBファイルをコピーして利用する。
+//
+// testing.Init() を呼び出しておくと -test.benchtime などのフラグも指定できる。
+//
+//	$ go run . -test.benchtime=500ms -run Point
+//
+// # REFERENCES
+//   - https://pkg.go.dev/testing@go1.26.0#Benchmark
+//   - https://pkg.go.dev/testing@go1.26.0#BenchmarkResult
+//   - https://pkg.go.dev/database/sql@go1.26.0#OpenDB
+//   - https://www.sqlite.org/pragma.html
+func main() {
+	log.SetFlags(0)
+
+	testing.Init()
+	flag.Parse()
+
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 16.DriverBenchmark/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run . -test.benchtime=500ms
+	   (途中経過は省略)
+
+	   BENCHMARK             DRIVER   PROFILE  ns/op    B/op   allocs/op  ns/op(vs mattn)
+	   PointLookup/adhoc     mattn    default  17064    718    25         1.00
+	   PointLookup/adhoc     modernc  default  19883    557    19         1.17
+	   PointLookup/adhoc     mattn    pragma   12583    718    25         1.00
+	   PointLookup/adhoc     modernc  pragma   17406    557    19         1.38
+	   PointLookup/prepared  mattn    default  12499    653    23         1.00
+	   PointLookup/prepared  modernc  default  13530    573    20         1.08
+	   PointLookup/prepared  mattn    pragma   8294     653    23         1.00
+	   PointLookup/prepared  modernc  pragma   8673     573    20         1.05
+	   RangeScan             mattn    default  296589   11384  925        1.00
+	   RangeScan             modernc  default  266081   14198  1111       0.90
+	   RangeScan             mattn    pragma   217047   11369  924        1.00
+	   RangeScan             modernc  pragma   225740   14181  1110       1.04
+	   TxInsert              mattn    default  1349757  4660   169        1.00
+	   TxInsert              modernc  default  1620732  2667   118        1.20
+	   TxInsert              mattn    pragma   104503   4828   176        1.00
+	   TxInsert              modernc  pragma   99282    2749   126        0.95
+	   ConcurrentReaders     mattn    default  12874    653    23         1.00
+	   ConcurrentReaders     modernc  default  15224    573    20         1.18
+	   ConcurrentReaders     mattn    pragma   7322     653    23         1.00
+	   ConcurrentReaders     modernc  pragma   8569     573    20         1.17
+	*/
+}
+
+func run() error {
+	var (
+		results []measurement
+	)
+
+	for _, t := range targets() {
+		var (
+			path = fmt.Sprintf("./bench-%s-%s.db", t.driverName, t.profile)
+		)
+		defer removeDB(path)
+
+		db, err := openTarget(t, *dbFile, path)
+		if err != nil {
+			return err
+		}
+
+		for _, bm := range benchmarks() {
+			if *only != "" && !strings.Contains(bm.name, *only) {
+				continue
+			}
+
+			var (
+				r = testing.Benchmark(func(b *testing.B) {
+					b.ReportAllocs()
+					bm.fn(b, db)
+				})
+			)
+			if r.N == 0 {
+				db.Close()
+				return fmt.Errorf("benchmark %s (%s/%s) failed", bm.name, t.driverName, t.profile)
+			}
+
+			log.Printf("%-22s %-8s %-8s %s", bm.name, t.driverName, t.profile, r.String())
+			results = append(results, measurement{bm.name, t, r})
+		}
+
+		if err = db.Close(); err != nil {
+			return fmt.Errorf("db.Close: %w", err)
+		}
+	}
+
+	log.Println()
+	report(os.Stdout, results)
+
+	return nil
+}
+
+// targets は、測定するドライバとPRAGMAプロファイルの組み合わせを返す。
+func targets() []target {
+	return []target{
+		{driverName: "mattn", drv: &sqlite3.SQLiteDriver{}, profile: "default"},
+		{driverName: "modernc", drv: &sqlite.Driver{}, profile: "default"},
+		{driverName: "mattn", drv: &sqlite3.SQLiteDriver{}, profile: "pragma", pragmas: pragma.Server()},
+		{driverName: "modernc", drv: &sqlite.Driver{}, profile: "pragma", pragmas: pragma.Server()},
+	}
+}
+
+// benchmarks は、測定するベンチマークを返す。
+func benchmarks() []benchmark {
+	return []benchmark{
+		{"PointLookup/adhoc", benchPointLookupAdhoc},
+		{"PointLookup/prepared", benchPointLookupPrepared},
+		{"RangeScan", benchRangeScan},
+		{"TxInsert", benchTxInsert},
+		{"ConcurrentReaders", benchConcurrentReaders},
+	}
+}
+
+// openTarget は、src を path にコピーし、t のドライバとPRAGMAプロファイルで開く。
+func openTarget(t target, src, path string) (*sql.DB, error) {
+	if err := copyFile(path, src); err != nil {
+		return nil, fmt.Errorf("copyFile: %w", err)
+	}
+
+	var (
+		db = sql.OpenDB(&pragmaConnector{drv: t.drv, dsn: path, pragmas: t.pragmas})
+	)
+	db.SetMaxOpenConns(runtime.GOMAXPROCS(0))
+	db.SetMaxIdleConns(runtime.GOMAXPROCS(0))
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("db.Ping(%s/%s): %w", t.driverName, t.profile, err)
+	}
+
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS bench_artists (Id INTEGER PRIMARY KEY, Name TEXT)"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create bench_artists: %w", err)
+	}
+
+	return db, nil
+}
+
+func benchPointLookupAdhoc(b *testing.B, db *sql.DB) {
+	var (
+		rnd  = rand.New(rand.NewPCG(1, 2))
+		id   int
+		name string
+	)
+
+	for b.Loop() {
+		err := db.QueryRow("SELECT TrackId, Name FROM tracks WHERE TrackId = ?", rnd.IntN(maxTrackId)+1).Scan(&id, &name)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchPointLookupPrepared(b *testing.B, db *sql.DB) {
+	stmt, err := db.Prepare("SELECT TrackId, Name FROM tracks WHERE TrackId = ?")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer stmt.Close()
+
+	var (
+		rnd  = rand.New(rand.NewPCG(1, 2))
+		id   int
+		name string
+	)
+
+	for b.Loop() {
+		if err = stmt.QueryRow(rnd.IntN(maxTrackId)+1).Scan(&id, &name); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchRangeScan(b *testing.B, db *sql.DB) {
+	var (
+		rnd          = rand.New(rand.NewPCG(1, 2))
+		id, millis   int
+		name         string
+		composer     sql.NullString
+		unitPrice    float64
+		count, start int
+	)
+
+	for b.Loop() {
+		start = rnd.IntN(maxTrackId-rangeSize) + 1
+
+		rows, err := db.Query(
+			"SELECT TrackId, Name, Composer, Milliseconds, UnitPrice FROM tracks WHERE TrackId BETWEEN ? AND ? ORDER BY TrackId",
+			start, start+rangeSize-1)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		count = 0
+		for rows.Next() {
+			if err = rows.Scan(&id, &name, &composer, &millis, &unitPrice); err != nil {
+				rows.Close()
+				b.Fatal(err)
+			}
+			count++
+		}
+		if err = rows.Err(); err != nil {
+			b.Fatal(err)
+		}
+		rows.Close()
+
+		if count != rangeSize {
+			b.Fatalf("got %d rows, want %d", count, rangeSize)
+		}
+	}
+}
+
+func benchTxInsert(b *testing.B, db *sql.DB) {
+	if _, err := db.Exec("DELETE FROM bench_artists"); err != nil {
+		b.Fatal(err)
+	}
+
+	var (
+		next = 0
+	)
+
+	for b.Loop() {
+		tx, err := db.Begin()
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for range insertRows {
+			next++
+			if _, err = tx.Exec("INSERT INTO bench_artists (Id, Name) VALUES (?, ?)", next, fmt.Sprintf("test%d", next)); err != nil {
+				tx.Rollback()
+				b.Fatal(err)
+			}
+		}
+
+		if err = tx.Commit(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchConcurrentReaders(b *testing.B, db *sql.DB) {
+	stmt, err := db.Prepare("SELECT TrackId, Name FROM tracks WHERE TrackId = ?")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer stmt.Close()
+
+	b.RunParallel(func(pb *testing.PB) {
+		var (
+			rnd  = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+			id   int
+			name string
+		)
+
+		for pb.Next() {
+			if err := stmt.QueryRow(rnd.IntN(maxTrackId)+1).Scan(&id, &name); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// report は、測定結果を表形式で出力する。
+//
+// 最後の列は、同じベンチマーク・同じプロファイルの mattn の ns/op を 1.00 とした比率。
+func report(w io.Writer, results []measurement) {
+	type key struct{ bench, profile string }
+
+	var (
+		base = make(map[key]float64)
+		tw   = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	)
+	for _, m := range results {
+		if m.target.driverName == "mattn" {
+			base[key{m.bench, m.target.profile}] = float64(m.result.NsPerOp())
+		}
+	}
+
+	fmt.Fprintln(tw, "BENCHMARK\tDRIVER\tPROFILE\tns/op\tB/op\tallocs/op\tns/op(vs mattn)")
+	for _, bm := range orderByBench(results) {
+		var (
+			ratio = "-"
+		)
+		if v, ok := base[key{bm.bench, bm.target.profile}]; ok && v > 0 {
+			ratio = fmt.Sprintf("%.2f", float64(bm.result.NsPerOp())/v)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%s\n",
+			bm.bench,
+			bm.target.driverName,
+			bm.target.profile,
+			bm.result.NsPerOp(),
+			bm.result.AllocedBytesPerOp(),
+			bm.result.AllocsPerOp(),
+			ratio)
+	}
+
+	tw.Flush()
+}
+
+// orderByBench は、測定順 (ターゲット毎) に並んでいる結果をベンチマーク毎に並べ替える。
+func orderByBench(results []measurement) []measurement {
+	var (
+		names  []string
+		seen   = make(map[string]bool)
+		sorted = make([]measurement, 0, len(results))
+	)
+	for _, m := range results {
+		if !seen[m.bench] {
+			seen[m.bench] = true
+			names = append(names, m.bench)
+		}
+	}
+
+	for _, n := range names {
+		for _, m := range results {
+			if m.bench == n {
+				sorted = append(sorted, m)
+			}
+		}
+	}
+
+	return sorted
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+func removeDB(path string) {
+	for _, p := range []string{path, path + "-wal", path + "-shm"} {
+		os.Remove(p)
+	}
+}
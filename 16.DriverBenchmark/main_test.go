@@ -0,0 +1,53 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkDrivers は、main と同じベンチマークを go test -bench で実行する。
+//
+// 名前は ベンチマーク/ドライバ/プロファイル となるため、-bench で絞り込める。
+//
+//	$ go test -bench 'RangeScan/.*/pragma' -benchmem
+func BenchmarkDrivers(b *testing.B) {
+	var (
+		src     = fixture(b)
+		targets = targets()
+		dbs     = make([]*sql.DB, len(targets))
+	)
+	for i, t := range targets {
+		db, err := openTarget(t, src, filepath.Join(b.TempDir(), "chinook.db"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() { db.Close() })
+
+		dbs[i] = db
+	}
+
+	for _, bm := range benchmarks() {
+		for i, t := range targets {
+			b.Run(bm.name+"/"+t.driverName+"/"+t.profile, func(b *testing.B) {
+				b.ReportAllocs()
+				bm.fn(b, dbs[i])
+			})
+		}
+	}
+}
+
+// fixture は、ベンチマークに利用する chinook.db のパスを返す。見つからない場合はスキップする。
+func fixture(tb testing.TB) string {
+	tb.Helper()
+
+	for _, p := range []string{*dbFile, "../chinook.db"} {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+
+	tb.Skip("chinook.db not found (copy it to this directory or the repository root)")
+	return ""
+}
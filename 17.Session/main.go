@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/devlights/try-golang-db/internal/session"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	driver     = "sqlite3"
+	datasource = "./chinook.db"
+	extraDB    = "./extra.db"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 17.Session
+//
+// 08.Conn の db.Conn() を発展させ、コネクションを固定したセッションとして利用する。
+//
+// 一時テーブルやATTACH、コネクション単位のPRAGMAは、そのコネクションでしか有効ではない。
+// *sql.DB のまま実行すると、次のクエリがプール内の別のコネクションで実行されてしまうため
+// 「さっき作った一時テーブルが無い」といった状況になる。
+//
+// internal/session の Session は *sql.Conn を保持し、
+//
+//   - CreateTempTable : 一時テーブルの作成 (Release 時に DROP)
+//   - Attach          : ATTACH DATABASE (Release 時に DETACH)
+//   - SetPragma       : コネクション単位のPRAGMA変更 (Release 時に変更前の値に戻す)
+//   - SetVar          : PostgreSQL のセッション変数 (Release 時に DISCARD ALL)
+//
+// を記録しておき、Release で元に戻してからプールに戻す。
+// 元に戻せなかった場合は *sql.Conn.Raw() で driver.ErrBadConn を返してコネクションを破棄する。
+// これにより、状態が残ったコネクションがプールに戻ることはない。
+//
+// # REFERENCES
+//   - https://pkg.go.dev/database/sql@go1.26.0#Conn
+//   - https://pkg.go.dev/database/sql@go1.26.0#Conn.Raw
+//   - https://www.sqlite.org/lang_attach.html
+//   - https://www.sqlite.org/lang_createtable.html
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 17.Session/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run main.go
+	   [session] AC/DC                     favorite=true   note=best
+	   [session] Accept                    favorite=true   note=classic
+	   [session] cache_size=-64000
+	   [release] ok
+	   [pool   ] cache_size=-2000  temp objects=0  attached=0
+	   [dirty  ] session: connection state could not be restored; connection discarded
+	   marked dirty: raw PRAGMA via Conn()
+	   [pool   ] open connections=0
+	*/
+}
+
+func run() error {
+	var (
+		db  *sql.DB
+		err error
+	)
+
+	db, err = sql.Open(driver, datasource)
+	if err != nil {
+		return fmt.Errorf("sql.Open: %w", err)
+	}
+	defer db.Close()
+
+	// 戻されたコネクションを確実に次のセッションで再利用させるため1本にしておく
+	db.SetMaxOpenConns(1)
+
+	if err = prepareExtraDB(); err != nil {
+		return fmt.Errorf("prepareExtraDB: %w", err)
+	}
+	defer os.Remove(extraDB)
+
+	var (
+		ctx = context.Background()
+	)
+
+	if err = useSession(ctx, db); err != nil {
+		return err
+	}
+
+	if err = showPoolState(ctx, db); err != nil {
+		return err
+	}
+
+	if err = dirtySession(ctx, db); err != nil {
+		return err
+	}
+
+	log.Printf("[pool   ] open connections=%d", db.Stats().OpenConnections)
+
+	return nil
+}
+
+func useSession(ctx context.Context, db *sql.DB) (err error) {
+	var (
+		s *session.Session
+	)
+
+	s, err = session.Open(ctx, db, session.SQLite)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := s.Release(ctx); e != nil {
+			err = errors.Join(err, e)
+			return
+		}
+
+		log.Println("[release] ok")
+	}()
+
+	if err = s.CreateTempTable(ctx, "favorites", "ArtistId INTEGER PRIMARY KEY"); err != nil {
+		return err
+	}
+
+	if _, err = s.ExecContext(ctx, "INSERT INTO favorites VALUES (1), (2)"); err != nil {
+		return fmt.Errorf("insert favorites: %w", err)
+	}
+
+	if err = s.Attach(ctx, extraDB, "extra"); err != nil {
+		return err
+	}
+
+	if err = s.SetPragma(ctx, "cache_size", "-64000"); err != nil {
+		return err
+	}
+
+	const (
+		query = `
+			SELECT a.Name, f.ArtistId IS NOT NULL, n.Note
+			FROM artists a
+				JOIN temp.favorites f ON f.ArtistId = a.ArtistId
+				JOIN extra.notes n ON n.ArtistId = a.ArtistId
+			ORDER BY a.ArtistId`
+	)
+	var (
+		rows *sql.Rows
+	)
+
+	rows, err = s.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("s.QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			name     string
+			favorite bool
+			note     string
+		)
+
+		if err = rows.Scan(&name, &favorite, &note); err != nil {
+			return fmt.Errorf("rows.Scan: %w", err)
+		}
+
+		log.Printf("[session] %-25s favorite=%v\tnote=%s", name, favorite, note)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows.Err: %w", err)
+	}
+
+	var (
+		cacheSize int
+	)
+	if err = s.QueryRowContext(ctx, "PRAGMA cache_size").Scan(&cacheSize); err != nil {
+		return fmt.Errorf("PRAGMA cache_size: %w", err)
+	}
+	log.Printf("[session] cache_size=%d", cacheSize)
+
+	return nil
+}
+
+// showPoolState は、Release 後にプールへ戻ったコネクションの状態を確認する。
+func showPoolState(ctx context.Context, db *sql.DB) error {
+	var (
+		cacheSize, temps, attached int
+	)
+
+	if err := db.QueryRowContext(ctx, "PRAGMA cache_size").Scan(&cacheSize); err != nil {
+		return fmt.Errorf("PRAGMA cache_size: %w", err)
+	}
+
+	if err := db.QueryRowContext(ctx, "SELECT count(*) FROM temp.sqlite_schema").Scan(&temps); err != nil {
+		return fmt.Errorf("temp.sqlite_schema: %w", err)
+	}
+
+	if err := db.QueryRowContext(ctx, "SELECT count(*) - 2 FROM pragma_database_list").Scan(&attached); err != nil {
+		return fmt.Errorf("pragma_database_list: %w", err)
+	}
+
+	log.Printf("[pool   ] cache_size=%d  temp objects=%d  attached=%d", cacheSize, temps, attached)
+
+	return nil
+}
+
+// dirtySession は、Session を経由せずにコネクションの状態を変更した場合の動作を確認する。
+func dirtySession(ctx context.Context, db *sql.DB) error {
+	s, err := session.Open(ctx, db, session.SQLite)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.Conn().ExecContext(ctx, "PRAGMA cache_size=-1"); err != nil {
+		s.Release(ctx)
+		return fmt.Errorf("PRAGMA cache_size: %w", err)
+	}
+	s.MarkDirty("raw PRAGMA via Conn()")
+
+	err = s.Release(ctx)
+	if !errors.Is(err, session.ErrDirty) {
+		return fmt.Errorf("expected ErrDirty, got %v", err)
+	}
+
+	log.Printf("[dirty  ] %v", err)
+
+	return nil
+}
+
+func prepareExtraDB() error {
+	db, err := sql.Open(driver, extraDB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		DROP TABLE IF EXISTS notes;
+		CREATE TABLE notes (ArtistId INTEGER PRIMARY KEY, Note TEXT);
+		INSERT INTO notes VALUES (1, 'best'), (2, 'classic');`)
+
+	return err
+}
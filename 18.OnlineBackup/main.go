@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/devlights/try-golang-db/internal/backup"
+	_ "github.com/mattn/go-sqlite3"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	datasource = "./chinook.db"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 18.OnlineBackup
+//
+// 各サンプルの Taskfile では chinook.db を cp -f でコピーしているが、
+// 13/14 のようにWALモードで書き込みを行っている最中のデータベースを cp でコピーするのは安全ではない。
+// (-wal ファイルに残っている内容が反映されない、書き込み途中のページをコピーしてしまう 等)
+//
+// SQLiteにはオンラインバックアップAPIが用意されており、書き込み中でも一貫性のあるコピーを作成できる。
+// database/sql からは直接呼び出せないため、*sql.Conn.Raw() でドライバのコネクションを取り出して利用する。
+//
+//	conn.Raw(func(driverConn any) error {
+//		c := driverConn.(*sqlite3.SQLiteConn) // mattn/go-sqlite3 の場合
+//		...
+//	})
+//
+// internal/backup では mattn/go-sqlite3 と modernc.org/sqlite の両方に対応しており、
+// どちらでもない場合は VACUUM INTO にフォールバックする。
+// バックアップは指定ページ数ずつ (PagesPerStep) 進め、ステップ毎に進捗コールバックを呼び出す。
+// ステップ間では他のコネクションが書き込みを行えるため、書き込み側を長時間待たせることはない。
+//
+// 注意点として、バックアップ中に別のコネクションがコピー元を更新すると、SQLiteは最初からコピーし直す。
+// 書き込みが続くと終わらなくなるため、想定ステップ数を MaxRestarts 以上超えた場合は残りを一度にコピーする。
+// 最後に PRAGMA integrity_check でコピー先を検証する。
+//
+// 本サンプルでは、別のゴルーチンで INSERT を繰り返しながらバックアップを取得している。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/backup.html
+//   - https://pkg.go.dev/database/sql@go1.26.0#Conn.Raw
+//   - https://pkg.go.dev/github.com/mattn/go-sqlite3#SQLiteConn.Backup
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 18.OnlineBackup/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run main.go
+	   [sqlite3] remaining=  54/  74
+	   [sqlite3] remaining=  54/  74   (書き込みによりやり直しが発生している)
+	   ...
+	   [sqlite3] remaining=   0/  74   (MaxRestarts を超えたため残りを一度にコピー)
+	   [sqlite3] method=mattn backup API     pages=74    elapsed=97ms  inserted during backup=79
+	   [sqlite3] ./backup-sqlite3.db: artists=354
+	   [sqlite ] remaining=  54/  74
+	   ...
+	   [sqlite ] remaining=   0/  74
+	   [sqlite ] method=modernc backup API   pages=74    elapsed=118ms  inserted during backup=79
+	   [sqlite ] ./backup-sqlite.db: artists=476
+	*/
+}
+
+func run() error {
+	var (
+		targets = []struct {
+			driver string
+			dsn    string
+		}{
+			{"sqlite3", datasource + "?_journal_mode=WAL&_busy_timeout=2000"},
+			{"sqlite", datasource + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(2000)"},
+		}
+	)
+
+	for _, t := range targets {
+		if err := backupWhileWriting(t.driver, t.dsn); err != nil {
+			return fmt.Errorf("%s: %w", t.driver, err)
+		}
+	}
+
+	return nil
+}
+
+func backupWhileWriting(driver, dsn string) error {
+	var (
+		db  *sql.DB
+		err error
+	)
+
+	db, err = sql.Open(driver, dsn)
+	if err != nil {
+		return fmt.Errorf("sql.Open: %w", err)
+	}
+	defer db.Close()
+
+	if err = db.Ping(); err != nil {
+		return fmt.Errorf("db.Ping: %w", err)
+	}
+
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		writerCtx   context.Context
+		stopWriter  context.CancelFunc
+		wg          sync.WaitGroup
+		inserted    int
+		writerErr   error
+	)
+	defer cancel()
+
+	writerCtx, stopWriter = context.WithCancel(ctx)
+	wg.Go(func() {
+		inserted, writerErr = keepInserting(writerCtx, db)
+	})
+
+	var (
+		dst    = fmt.Sprintf("./backup-%s.db", driver)
+		result *backup.Result
+		opts   = backup.Options{
+			PagesPerStep: 20,
+			Interval:     5 * time.Millisecond,
+			Overwrite:    true,
+			OnProgress: func(p backup.Progress) {
+				log.Printf("[%-7s] remaining=%4d/%4d", driver, p.Remaining, p.PageCount)
+			},
+		}
+	)
+
+	result, err = backup.Backup(ctx, db, dst, opts)
+	stopWriter()
+	wg.Wait()
+
+	if err != nil {
+		return fmt.Errorf("backup.Backup: %w", err)
+	}
+	if writerErr != nil {
+		return fmt.Errorf("writer: %w", writerErr)
+	}
+
+	log.Printf("[%-7s] method=%-20s pages=%-5d elapsed=%v  inserted during backup=%d",
+		driver, result.Method, result.PageCount, result.Elapsed.Round(time.Millisecond), inserted)
+
+	return showArtistCount(driver, dst)
+}
+
+// keepInserting は、ctx がキャンセルされるまで artists に INSERT を繰り返す。
+func keepInserting(ctx context.Context, db *sql.DB) (int, error) {
+	var (
+		count  = 0
+		ticker = time.NewTicker(time.Millisecond)
+	)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, err := db.ExecContext(ctx, "INSERT INTO artists (Name) VALUES (?)", fmt.Sprintf("backup-test-%d", count))
+		if err != nil {
+			if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+				return count, nil
+			}
+
+			return count, err
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+func showArtistCount(driver, path string) error {
+	db, err := sql.Open(driver, path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	defer os.Remove(path)
+
+	var (
+		count int
+	)
+	if err = db.QueryRow("SELECT count(*) FROM artists").Scan(&count); err != nil {
+		return err
+	}
+
+	log.Printf("[%-7s] %s: artists=%d", driver, path, count)
+
+	return nil
+}
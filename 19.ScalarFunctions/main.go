@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/devlights/try-golang-db/internal/sqlfunc"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	datasource = "./chinook.db"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 19.ScalarFunctions
+//
+// Goで実装した関数をSQLから呼び出す (アプリケーション定義関数)。
+//
+// SQLiteには REGEXP 演算子の構文はあるが、実装となる regexp() 関数は標準では用意されていない。
+// 同様に、編集距離やUnicode正規化のような処理もSQLだけでは書けない。
+// SQLiteはアプリケーション側で関数を登録できるため、Goの標準ライブラリ等で実装したものを組み込める。
+//
+// 登録方法はドライバ毎に異なる。
+//
+//   - mattn/go-sqlite3  : ConnectHook の中で conn.RegisterFunc() を呼ぶ (コネクション毎)
+//   - modernc.org/sqlite: sqlite.RegisterFunction() を呼ぶ (プロセスで一度)
+//
+// internal/sqlfunc で関数を一度だけ定義し、両方のドライバに登録している。
+// 本サンプルでは、同じSQLを両方のドライバで実行し、結果が一致することを確認している。
+//
+// Deterministic (同じ引数なら常に同じ結果) を指定した関数は、インデックス式にも利用できる。
+// 本サンプルでは nfkc(Name) に対する式インデックスを作成している。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/appfunc.html
+//   - https://www.sqlite.org/lang_expr.html#the_like_glob_regexp_match_and_extract_operators
+//   - https://www.sqlite.org/expridx.html
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 19.ScalarFunctions/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run main.go
+	   [regexp          ] rows=1   same=true
+	       2 | Accept
+	   [levenshtein     ] rows=1   same=true
+	       Accept | 1
+	   [nfkc            ] rows=1   same=true
+	       AC/DC | アクセプト | 123 | <nil>
+	   [nfkc index      ] rows=1   same=true
+	       1 | AC/DC
+	   [json_path_exists] rows=3   same=true
+	       {"name":"x","tags":[1,2]} | 1 | 1
+	       {"tags":[1]} | 0 | 0
+	       [] | 0 | 0
+	   [plan            ] SEARCH a USING INDEX idx_artists_nfkc (<expr>=?)
+	*/
+}
+
+type (
+	query struct {
+		name string
+		sql  string
+	}
+)
+
+var (
+	queries = []query{
+		{
+			"regexp",
+			`SELECT ArtistId, Name FROM artists WHERE Name REGEXP '^A[a-z]+$' ORDER BY ArtistId`,
+		},
+		{
+			"levenshtein",
+			`SELECT Name, levenshtein(Name, 'Acept') AS d FROM artists WHERE levenshtein(Name, 'Acept') <= 2 ORDER BY d, Name`,
+		},
+		{
+			"nfkc",
+			`SELECT nfkc('ＡＣ／ＤＣ'), nfkc('ｱｸｾﾌﾟﾄ'), nfkc('①②③'), nfkc(NULL)`,
+		},
+		{
+			"nfkc index",
+			`SELECT a.ArtistId, a.Name FROM artists a WHERE nfkc(a.Name) = nfkc('ＡＣ／ＤＣ')`,
+		},
+		{
+			"json_path_exists",
+			`SELECT j.value, json_path_exists(j.value, '$.tags[1]'), json_path_exists(j.value, '$.name')
+			 FROM json_each('["{\"name\":\"x\",\"tags\":[1,2]}", "{\"tags\":[1]}", "[]"]') j`,
+		},
+	}
+)
+
+func run() error {
+	var (
+		registry *sqlfunc.Registry
+		err      error
+	)
+
+	registry, err = sqlfunc.NewRegistry(sqlfunc.Builtins()...)
+	if err != nil {
+		return err
+	}
+
+	// mattn/go-sqlite3: ConnectHook で登録する別名ドライバを用意する
+	sql.Register("sqlite3_funcs", &sqlite3.SQLiteDriver{
+		ConnectHook: registry.RegisterMattn,
+	})
+
+	// modernc.org/sqlite: プロセスグローバルに登録する
+	if err = registry.RegisterModernc(); err != nil {
+		return err
+	}
+
+	var (
+		mattn, modernc *sql.DB
+	)
+
+	if mattn, err = sql.Open("sqlite3_funcs", datasource); err != nil {
+		return fmt.Errorf("sql.Open(mattn): %w", err)
+	}
+	defer mattn.Close()
+
+	if modernc, err = sql.Open("sqlite", datasource); err != nil {
+		return fmt.Errorf("sql.Open(modernc): %w", err)
+	}
+	defer modernc.Close()
+
+	// 決定的関数は式インデックスに使える (非決定的だと CREATE INDEX がエラーになる)
+	if _, err = mattn.Exec("CREATE INDEX IF NOT EXISTS idx_artists_nfkc ON artists (nfkc(Name))"); err != nil {
+		return fmt.Errorf("create index: %w", err)
+	}
+	defer mattn.Exec("DROP INDEX IF EXISTS idx_artists_nfkc")
+
+	for _, q := range queries {
+		var (
+			r1, r2 [][]any
+		)
+
+		if r1, err = queryAll(mattn, q.sql); err != nil {
+			return fmt.Errorf("%s (mattn): %w", q.name, err)
+		}
+		if r2, err = queryAll(modernc, q.sql); err != nil {
+			return fmt.Errorf("%s (modernc): %w", q.name, err)
+		}
+
+		log.Printf("[%-16s] rows=%-3d same=%v", q.name, len(r1), reflect.DeepEqual(r1, r2))
+		for _, row := range r1[:min(len(r1), 5)] {
+			log.Printf("    %s", format(row))
+		}
+	}
+
+	// modernc で式インデックスが使われていることを確認
+	var (
+		id, parent, notused int
+		plan                string
+	)
+	err = modernc.QueryRow("EXPLAIN QUERY PLAN "+queries[3].sql).Scan(&id, &parent, &notused, &plan)
+	if err != nil {
+		return fmt.Errorf("explain: %w", err)
+	}
+	log.Printf("[plan            ] %s", plan)
+
+	return nil
+}
+
+// queryAll は、全ての行を []any で取得する。
+//
+// ドライバ間で比較できるよう、[]byte は string に揃える。
+func queryAll(db *sql.DB, query string) ([][]any, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		result [][]any
+	)
+	for rows.Next() {
+		var (
+			values = make([]any, len(cols))
+			ptrs   = make([]any, len(cols))
+		)
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+
+		if err = rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+
+		result = append(result, values)
+	}
+
+	return result, rows.Err()
+}
+
+func format(row []any) string {
+	var (
+		sb strings.Builder
+	)
+	for i, v := range row {
+		if i > 0 {
+			sb.WriteString(" | ")
+		}
+		fmt.Fprintf(&sb, "%v", v)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/devlights/try-golang-db/internal/sqlfunc"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 20.Collation
+//
+// 日本語を含むデータを ORDER BY で並び替えるための照合順序 (COLLATE) を登録する。
+//
+// SQLite標準の照合順序は以下の3つのみ。
+//
+//   - BINARY : UTF-8のバイト順 (デフォルト)
+//   - NOCASE : ASCII の大文字小文字のみ無視
+//   - RTRIM  : 末尾の空白を無視
+//
+// BINARY ではカタカナがひらがなの後ろ、漢字はさらにその後ろ、全角英数は最後尾に並んでしまう。
+// golang.org/x/text/collate を使うと、Unicode照合アルゴリズム (UCA) に日本語のルールを加えた順序で比較できる。
+//
+// internal/sqlfunc の JapaneseCollations() で以下の照合順序を用意しており、
+// 19.ScalarFunctions と同じく sqlfunc.Registry を通して両方のドライバに登録している。
+//
+//   - ja_JP       : 日本語の辞書順
+//   - ja_JP_ci    : 大文字小文字を区別しない
+//   - ja_JP_ci_wi : 大文字小文字と全角半角を区別しない
+//
+// 照合順序は ORDER BY だけでなく、= による比較や GROUP BY / DISTINCT、インデックスにも影響する。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/datatype3.html#collation
+//   - https://pkg.go.dev/golang.org/x/text/collate
+//   - https://pkg.go.dev/github.com/mattn/go-sqlite3#SQLiteConn.RegisterCollation
+//   - https://pkg.go.dev/modernc.org/sqlite#RegisterCollationUtf8
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 20.Collation/
+	   task: [default] go run main.go
+	   [BINARY     ] same=true  Apple, Zebra, apple, あいこ, さくら, アイコ, サクラ, 佐藤, 山田, Ａｐｐｌｅ, ｻｸﾗ
+	   [NOCASE     ] same=true  apple, Apple, Zebra, あいこ, さくら, アイコ, サクラ, 佐藤, 山田, Ａｐｐｌｅ, ｻｸﾗ
+	   [ja_JP      ] same=true  apple, Apple, Ａｐｐｌｅ, Zebra, あいこ, アイコ, さくら, サクラ, ｻｸﾗ, 佐藤, 山田
+	   [ja_JP_ci   ] same=true  apple, Apple, Ａｐｐｌｅ, Zebra, あいこ, アイコ, さくら, サクラ, ｻｸﾗ, 佐藤, 山田
+	   [ja_JP_ci_wi] same=true  apple, Ａｐｐｌｅ, Apple, Zebra, あいこ, アイコ, さくら, サクラ, ｻｸﾗ, 佐藤, 山田
+	   [= 'apple'  ] ja_JP=1 ja_JP_ci=2 ja_JP_ci_wi=3
+	   [DISTINCT   ] ja_JP=11 ja_JP_ci=10 ja_JP_ci_wi=8
+	*/
+}
+
+var (
+	names = []string{
+		"山田", "サクラ", "apple", "Zebra", "さくら", "佐藤", "Ａｐｐｌｅ", "アイコ", "ｻｸﾗ", "Apple", "あいこ",
+	}
+	collations = []string{
+		"BINARY", "NOCASE", "ja_JP", "ja_JP_ci", "ja_JP_ci_wi",
+	}
+)
+
+func run() error {
+	var (
+		registry = &sqlfunc.Registry{}
+	)
+	for _, c := range sqlfunc.JapaneseCollations() {
+		if err := registry.AddCollation(c); err != nil {
+			return err
+		}
+	}
+
+	sql.Register("sqlite3_ja", &sqlite3.SQLiteDriver{
+		ConnectHook: registry.RegisterMattn,
+	})
+	if err := registry.RegisterModernc(); err != nil {
+		return err
+	}
+
+	var (
+		mattn, modernc *sql.DB
+		err            error
+	)
+
+	if mattn, err = open("sqlite3_ja"); err != nil {
+		return err
+	}
+	defer mattn.Close()
+
+	if modernc, err = open("sqlite"); err != nil {
+		return err
+	}
+	defer modernc.Close()
+
+	for _, c := range collations {
+		var (
+			q      = fmt.Sprintf("SELECT name FROM people ORDER BY name COLLATE %s", c)
+			r1, r2 []string
+		)
+
+		if r1, err = queryStrings(mattn, q); err != nil {
+			return fmt.Errorf("%s (mattn): %w", c, err)
+		}
+		if r2, err = queryStrings(modernc, q); err != nil {
+			return fmt.Errorf("%s (modernc): %w", c, err)
+		}
+
+		log.Printf("[%-11s] same=%-5v %s", c, slices.Equal(r1, r2), strings.Join(r1, ", "))
+	}
+
+	// 照合順序は比較演算子やDISTINCTの結果にも影響する
+	var (
+		eq, distinct []string
+	)
+	for _, c := range collations[2:] {
+		var (
+			n, d int
+		)
+
+		if err = mattn.QueryRow(fmt.Sprintf("SELECT count(*) FROM people WHERE name = 'apple' COLLATE %s", c)).Scan(&n); err != nil {
+			return err
+		}
+		if err = mattn.QueryRow(fmt.Sprintf("SELECT count(DISTINCT name COLLATE %s) FROM people", c)).Scan(&d); err != nil {
+			return err
+		}
+
+		eq = append(eq, fmt.Sprintf("%s=%d", c, n))
+		distinct = append(distinct, fmt.Sprintf("%s=%d", c, d))
+	}
+
+	log.Printf("[%-11s] %s", "= 'apple'", strings.Join(eq, " "))
+	log.Printf("[%-11s] %s", "DISTINCT", strings.Join(distinct, " "))
+
+	return nil
+}
+
+// open は、メモリ上にデータベースを作成してテストデータを投入する。
+func open(driver string) (*sql.DB, error) {
+	db, err := sql.Open(driver, ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("sql.Open(%s): %w", driver, err)
+	}
+
+	// :memory: はコネクション毎に別のデータベースとなるため1本に固定する
+	db.SetMaxOpenConns(1)
+
+	if _, err = db.Exec("CREATE TABLE people (name TEXT NOT NULL)"); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	for _, name := range names {
+		if _, err = db.Exec("INSERT INTO people (name) VALUES (?)", name); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+func queryStrings(db *sql.DB, query string) ([]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		result []string
+	)
+	for rows.Next() {
+		var (
+			s string
+		)
+		if err = rows.Scan(&s); err != nil {
+			return nil, err
+		}
+
+		result = append(result, s)
+	}
+
+	return result, rows.Err()
+}
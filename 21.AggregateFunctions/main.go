@@ -0,0 +1,212 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/devlights/try-golang-db/internal/sqlfunc"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	datasource = "./chinook.db"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 21.AggregateFunctions
+//
+// Goで実装した集約関数・ウィンドウ関数をSQLから呼び出す。
+//
+// 19.ScalarFunctions は1行毎に値を返すスカラー関数だったが、集約関数は複数行から1つの値を求める。
+// SQLiteには count/sum/avg/min/max/group_concat 等はあるが、中央値やパーセンタイル、最頻値は用意されていない。
+//
+// 集約関数は以下のように呼び出される。
+//
+//   - グループ毎に状態を生成 (sqlfunc.Aggregate.New)
+//   - 行毎に Step
+//   - 最後に Value で結果を取得
+//
+// ウィンドウ関数 (OVER 句) として使う場合は、フレームから外れた行に対して Inverse も呼ばれる。
+//
+// 登録方法はドライバ毎に異なる。
+//
+//   - mattn/go-sqlite3  : conn.RegisterAggregator() (Step/Done メソッドを持つ型のコンストラクタを渡す)
+//   - modernc.org/sqlite: sqlite.RegisterFunction() に MakeAggregate を指定する
+//
+// ただし mattn/go-sqlite3 はウィンドウ関数の登録 (sqlite3_create_window_function) に対応していないため、
+// 登録した集約関数を OVER 句で使うと "may not be used as a window function" エラーとなる。
+// 本サンプルでは、ウィンドウ関数のクエリは modernc.org/sqlite でのみ実行している。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/appfunc.html
+//   - https://www.sqlite.org/windowfunctions.html#user_defined_aggregate_window_functions
+//   - https://pkg.go.dev/github.com/mattn/go-sqlite3#SQLiteConn.RegisterAggregator
+//   - https://pkg.go.dev/modernc.org/sqlite#FunctionImpl
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+}
+
+type (
+	query struct {
+		name        string
+		sql         string
+		moderncOnly bool
+	}
+)
+
+var (
+	queries = []query{
+		{
+			"by year",
+			`SELECT strftime('%Y', InvoiceDate) AS y, count(*), median(Total), percentile(Total, 90), mode(Total)
+			 FROM invoices GROUP BY y ORDER BY y`,
+			false,
+		},
+		{
+			"unit price",
+			`SELECT mode(UnitPrice), median(Quantity), string_agg_distinct(UnitPrice, ' / ') FROM invoice_items`,
+			false,
+		},
+		{
+			"countries",
+			`SELECT string_agg_distinct(BillingCountry, ', ') FROM (SELECT * FROM invoices WHERE Total >= 17 ORDER BY InvoiceId)`,
+			false,
+		},
+		{
+			"window",
+			`SELECT InvoiceId, Total,
+			        median(Total) OVER w,
+			        mode(BillingCountry) OVER w,
+			        string_agg_distinct(BillingCountry, ',') OVER w
+			 FROM invoices
+			 WINDOW w AS (ORDER BY InvoiceId ROWS BETWEEN 2 PRECEDING AND CURRENT ROW)
+			 ORDER BY InvoiceId LIMIT 6`,
+			true,
+		},
+	}
+)
+
+func run() error {
+	var (
+		registry = &sqlfunc.Registry{}
+	)
+	for _, a := range sqlfunc.BuiltinAggregates() {
+		if err := registry.AddAggregate(a); err != nil {
+			return err
+		}
+	}
+
+	sql.Register("sqlite3_agg", &sqlite3.SQLiteDriver{
+		ConnectHook: registry.RegisterMattn,
+	})
+	if err := registry.RegisterModernc(); err != nil {
+		return err
+	}
+
+	var (
+		mattn, modernc *sql.DB
+		err            error
+	)
+
+	if mattn, err = sql.Open("sqlite3_agg", datasource); err != nil {
+		return fmt.Errorf("sql.Open(mattn): %w", err)
+	}
+	defer mattn.Close()
+
+	if modernc, err = sql.Open("sqlite", datasource); err != nil {
+		return fmt.Errorf("sql.Open(modernc): %w", err)
+	}
+	defer modernc.Close()
+
+	for _, q := range queries {
+		var (
+			r1, r2 [][]any
+		)
+
+		if r2, err = queryAll(modernc, q.sql); err != nil {
+			return fmt.Errorf("%s (modernc): %w", q.name, err)
+		}
+
+		if q.moderncOnly {
+			_, err = queryAll(mattn, q.sql)
+			log.Printf("[%-10s] rows=%-3d (mattn: %v)", q.name, len(r2), err)
+		} else {
+			if r1, err = queryAll(mattn, q.sql); err != nil {
+				return fmt.Errorf("%s (mattn): %w", q.name, err)
+			}
+			log.Printf("[%-10s] rows=%-3d same=%v", q.name, len(r2), reflect.DeepEqual(r1, r2))
+		}
+
+		for _, row := range r2 {
+			log.Printf("    %s", format(row))
+		}
+	}
+
+	return nil
+}
+
+// queryAll は、全ての行を []any で取得する。
+//
+// ドライバ間で比較できるよう、[]byte は string に揃える。
+func queryAll(db *sql.DB, query string) ([][]any, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		result [][]any
+	)
+	for rows.Next() {
+		var (
+			values = make([]any, len(cols))
+			ptrs   = make([]any, len(cols))
+		)
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+
+		if err = rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+
+		result = append(result, values)
+	}
+
+	return result, rows.Err()
+}
+
+func format(row []any) string {
+	var (
+		sb strings.Builder
+	)
+	for i, v := range row {
+		if i > 0 {
+			sb.WriteString(" | ")
+		}
+		fmt.Fprintf(&sb, "%v", v)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"iter"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/devlights/try-golang-db/internal/vtable"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const (
+	datasource = "./chinook.db"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 22.VirtualTable
+//
+// Goのデータを仮想テーブルとして公開し、chinook のテーブルと JOIN する。
+//
+// 仮想テーブルは、SQLiteのテーブルの読み出しをアプリケーション側で実装する仕組み。
+// データを実テーブルにINSERTせずに、スライスやマップ、CSV等をそのままSQLから参照できる。
+//
+// internal/vtable では、以下のデータを仮想テーブルにできる。
+//
+//   - vtable.Slice   : 構造体のスライス
+//   - vtable.Map     : キー → 構造体 のマップ (キー列の等価条件はマップの検索で処理)
+//   - vtable.Seq     : 構造体の iter.Seq
+//   - vtable.CSV     : CSV (1行目がヘッダ)
+//
+// 構造体の場合、列はフィールドから決まり、列名は `db:"name"` タグで指定できる。
+//
+// WHERE 句や JOIN の等価条件 (col = ?) は、SQLiteから仮想テーブルに渡される (push-down)。
+// これにより、マップのような検索できるデータでは全件を走査せずに済む。
+//
+// mattn/go-sqlite3 の仮想テーブル対応はビルドタグ sqlite_vtable を指定した場合のみ有効になるため、
+// 本サンプルは go run -tags sqlite_vtable で実行する。
+// また、modernc.org/sqlite はコネクション毎のフックでは仮想テーブルを作成できないため、
+// vtable.Registry.OpenModernc で *sql.DB を開いている。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/vtab.html
+//   - https://pkg.go.dev/github.com/mattn/go-sqlite3#SQLiteConn.CreateModule
+//   - https://pkg.go.dev/modernc.org/sqlite/vtab
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+}
+
+type (
+	// Rating は、スライスで保持しているアーティストの評価。
+	Rating struct {
+		ArtistId int    `db:"ArtistId"`
+		Stars    int    `db:"Stars"`
+		Comment  string `db:"Comment"`
+		internal string
+	}
+
+	// Profile は、マップで保持しているアーティストのプロフィール。
+	Profile struct {
+		Country string  `db:"Country"`
+		Since   int     `db:"Since"`
+		Website *string `db:"Website"`
+	}
+
+	// Goal は、iter.Seq で生成する年毎の売上目標。
+	Goal struct {
+		Year   string  `db:"Year"`
+		Target float64 `db:"Target"`
+	}
+)
+
+const (
+	salesCSV = `TrackId,Campaign
+1,spring
+2,spring
+3,summer
+`
+)
+
+var (
+	queries = []struct {
+		name string
+		sql  string
+	}{
+		{
+			"slice",
+			`SELECT a.Name, r.Stars, r.Comment FROM ratings r JOIN artists a ON a.ArtistId = r.ArtistId ORDER BY r.Stars DESC, a.Name`,
+		},
+		{
+			"map",
+			`SELECT a.ArtistId, a.Name, p.Country, p.Since, p.Website FROM artists a JOIN profiles p ON p.ArtistId = a.ArtistId ORDER BY a.ArtistId`,
+		},
+		{
+			"iter.Seq",
+			`SELECT g.Year, g.Target, round(sum(i.Total), 2), sum(i.Total) >= g.Target
+			 FROM goals g JOIN invoices i ON strftime('%Y', i.InvoiceDate) = g.Year
+			 GROUP BY g.Year ORDER BY g.Year`,
+		},
+		{
+			"csv",
+			`SELECT c.Campaign, count(*), t.Name FROM campaigns c JOIN tracks t ON t.TrackId = c.TrackId GROUP BY c.Campaign ORDER BY c.Campaign`,
+		},
+		{
+			"lookup",
+			`SELECT ArtistId, Country FROM profiles WHERE ArtistId = 2`,
+		},
+	}
+)
+
+func run() error {
+	registry, err := newRegistry()
+	if err != nil {
+		return err
+	}
+
+	sql.Register("sqlite3_vtab", &sqlite3.SQLiteDriver{
+		ConnectHook: registry.RegisterMattn,
+	})
+
+	var (
+		mattn, modernc *sql.DB
+	)
+
+	if mattn, err = sql.Open("sqlite3_vtab", datasource); err != nil {
+		return fmt.Errorf("sql.Open(mattn): %w", err)
+	}
+	defer mattn.Close()
+
+	if modernc, err = registry.OpenModernc(datasource); err != nil {
+		return fmt.Errorf("OpenModernc: %w", err)
+	}
+	defer modernc.Close()
+
+	for _, q := range queries {
+		var (
+			r1, r2 [][]any
+		)
+
+		if r1, err = queryAll(mattn, q.sql); err != nil {
+			return fmt.Errorf("%s (mattn): %w", q.name, err)
+		}
+		if r2, err = queryAll(modernc, q.sql); err != nil {
+			return fmt.Errorf("%s (modernc): %w", q.name, err)
+		}
+
+		log.Printf("[%-8s] rows=%-3d same=%v", q.name, len(r1), reflect.DeepEqual(r1, r2))
+		for _, row := range r1 {
+			log.Printf("    %s", format(row))
+		}
+	}
+
+	// 等価条件が仮想テーブルに渡されていることを実行計画で確認する
+	// (INDEX 0:0 の後ろの 0 が、Filter に渡される列番号 (= ArtistId))
+	plan, err := queryAll(mattn, "EXPLAIN QUERY PLAN "+queries[len(queries)-1].sql)
+	if err != nil {
+		return err
+	}
+	for _, row := range plan {
+		log.Printf("[plan    ] %v", row[3])
+	}
+
+	return nil
+}
+
+func newRegistry() (*vtable.Registry, error) {
+	var (
+		website = "https://www.acdc.com"
+		ratings = []Rating{
+			{ArtistId: 1, Stars: 5, Comment: "legend"},
+			{ArtistId: 2, Stars: 4, Comment: "classic"},
+			{ArtistId: 3, Stars: 3, Comment: "good", internal: "not exposed"},
+		}
+		profiles = map[int]Profile{
+			1: {Country: "Australia", Since: 1973, Website: &website},
+			2: {Country: "Germany", Since: 1976},
+		}
+		goals iter.Seq[Goal] = func(yield func(Goal) bool) {
+			for year := 2009; year <= 2013; year++ {
+				if !yield(Goal{Year: fmt.Sprint(year), Target: 450}) {
+					return
+				}
+			}
+		}
+		campaigns = func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(salesCSV)), nil
+		}
+	)
+
+	var (
+		registry = &vtable.Registry{}
+		sources  = []struct {
+			name string
+			src  func() (vtable.Source, error)
+		}{
+			{"ratings", func() (vtable.Source, error) { return vtable.Slice(ratings) }},
+			{"profiles", func() (vtable.Source, error) { return vtable.Map(profiles, "ArtistId") }},
+			{"goals", func() (vtable.Source, error) { return vtable.Seq(goals) }},
+			{"campaigns", func() (vtable.Source, error) { return vtable.CSV(campaigns) }},
+		}
+	)
+	for _, s := range sources {
+		src, err := s.src()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.name, err)
+		}
+
+		if err = registry.Add(s.name, src); err != nil {
+			return nil, err
+		}
+	}
+
+	return registry, nil
+}
+
+// queryAll は、全ての行を []any で取得する。
+//
+// ドライバ間で比較できるよう、[]byte は string に揃える。
+func queryAll(db *sql.DB, query string) ([][]any, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		result [][]any
+	)
+	for rows.Next() {
+		var (
+			values = make([]any, len(cols))
+			ptrs   = make([]any, len(cols))
+		)
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+
+		if err = rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+
+		result = append(result, values)
+	}
+
+	return result, rows.Err()
+}
+
+func format(row []any) string {
+	var (
+		sb strings.Builder
+	)
+	for i, v := range row {
+		if i > 0 {
+			sb.WriteString(" | ")
+		}
+		fmt.Fprintf(&sb, "%v", v)
+	}
+
+	return sb.String()
+}
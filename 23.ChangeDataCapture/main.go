@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/devlights/try-golang-db/internal/cdc"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const (
+	driver     = "sqlite3_cdc"
+	datasource = "./chinook.db"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 23.ChangeDataCapture
+//
+// テーブルの変更 (INSERT/UPDATE/DELETE) を、プロセス内で購読する。
+//
+// mattn/go-sqlite3 では、14.ConnHook_mattn と同じく ConnectHook の中で
+// コネクションに以下のフックを登録できる。
+//
+//   - RegisterUpdateHook   : 行が変更される度に呼ばれる (操作、データベース名、テーブル名、rowid)
+//   - RegisterCommitHook   : コミットの直前に呼ばれる
+//   - RegisterRollbackHook : ロールバック時に呼ばれる
+//
+// 更新フックはトランザクションの確定前に呼ばれるため、そのまま通知するとロールバックされた変更まで届いてしまう。
+// また、コミットフックの後でも COMMIT が SQLITE_BUSY で失敗することがある。
+// internal/cdc では、変更をコネクション毎にバッファしておき、COMMIT の完了を確認してから1トランザクション分をまとめて配信し、
+// ロールバック時には破棄する。COMMIT の完了は文の実行後に確認するため、stream.Driver でラップしたドライバを登録する。
+// 購読者は cdc.Subscription.C (チャネル) から受信する。
+//
+// 購読時にテーブル名を指定すると、そのテーブルの変更のみを受信できる。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/c3ref/update_hook.html
+//   - https://www.sqlite.org/c3ref/commit_hook.html
+//   - https://pkg.go.dev/github.com/mattn/go-sqlite3#SQLiteConn.RegisterUpdateHook
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 23.ChangeDataCapture/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run main.go
+	   [all    ] tx#1 INSERT artists(276), INSERT artists(277), UPDATE albums(1)
+	   [all    ] tx#2 DELETE artists(277)
+	   [all    ] tx#3 UPDATE tracks(1)
+	   [artists] tx#1 INSERT artists(276), INSERT artists(277)
+	   [artists] tx#2 DELETE artists(277)
+	   [stats  ] committed=3 rolledBack=1 changes=5
+	*/
+}
+
+func run() error {
+	var (
+		stream = cdc.New()
+	)
+	defer stream.Close()
+
+	sql.Register(driver, stream.Driver(&sqlite3.SQLiteDriver{}))
+
+	db, err := sql.Open(driver, datasource)
+	if err != nil {
+		return fmt.Errorf("sql.Open: %w", err)
+	}
+	defer db.Close()
+
+	var (
+		subs = []struct {
+			name string
+			sub  *cdc.Subscription
+		}{
+			{"all", stream.Subscribe(cdc.Options{})},
+			{"artists", stream.Subscribe(cdc.Options{Tables: []string{"artists"}})},
+		}
+	)
+
+	if err = makeChanges(context.Background(), db); err != nil {
+		return err
+	}
+
+	// 配信は購読者毎のキューに溜まっているため、後からまとめて受信できる
+	for _, s := range subs {
+		for {
+			select {
+			case tx := <-s.sub.C:
+				log.Printf("[%-7s] tx#%d %s", s.name, tx.Seq, format(tx))
+				continue
+			case <-time.After(100 * time.Millisecond):
+			}
+			break
+		}
+		s.sub.Close()
+	}
+
+	st := stream.Stats()
+	log.Printf("[stats  ] committed=%d rolledBack=%d changes=%d", st.Committed, st.RolledBack, st.Changes)
+
+	return nil
+}
+
+func makeChanges(ctx context.Context, db *sql.DB) error {
+	// 1. 複数テーブルを変更してコミット → 1トランザクションとして配信される
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, q := range []string{
+		"INSERT INTO artists (Name) VALUES ('cdc-1'), ('cdc-2')",
+		"UPDATE albums SET Title = Title WHERE AlbumId = 1",
+	} {
+		if _, err = tx.ExecContext(ctx, q); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %w", q, err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	// 2. ロールバック → 配信されない
+	tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "INSERT INTO artists (Name) VALUES ('rolled-back')"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Rollback(); err != nil {
+		return err
+	}
+
+	// 3. 自動コミット → 文毎に配信される
+	if _, err = db.ExecContext(ctx, "DELETE FROM artists WHERE Name = 'cdc-2'"); err != nil {
+		return err
+	}
+
+	// 4. artists 以外の変更 → artists の購読者には配信されない
+	if _, err = db.ExecContext(ctx, "UPDATE tracks SET Name = Name WHERE TrackId = 1"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func format(tx cdc.Tx) string {
+	var (
+		parts = make([]string, len(tx.Changes))
+	)
+	for i, c := range tx.Changes {
+		parts[i] = fmt.Sprintf("%s %s(%d)", c.Op, c.Table, c.RowID)
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/devlights/try-golang-db/internal/firewall"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const (
+	driver     = "sqlite3_firewall"
+	datasource = "./chinook.db"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 24.QueryFirewall
+//
+// SQLiteの認可コールバックを使って、コネクションで実行できる操作を制限する。
+//
+// SQLiteは文のコンパイル時に、その文が行う操作 (テーブル・列の読み出し、INSERT、CREATE TABLE、ATTACH、PRAGMA など) を
+// 1つずつ認可コールバックに問い合わせる。mattn/go-sqlite3 では RegisterAuthorizer でコールバックを登録できる。
+//
+// internal/firewall では、許可・拒否のルールを宣言的に記述した Policy に従って応答する。
+// ルールは操作 (Action) または操作の分類 (Class) と、データベース・テーブル・列・オブジェクト名のパターンで指定し、
+// 先頭から順に評価して最初に一致したものが適用される。応答は以下の3種類。
+//
+//   - allow  : 許可する
+//   - deny   : 文をエラーにする
+//   - ignore : エラーにせず無かったことにする (列の読み出しであれば NULL が返る)
+//
+// 認可コールバックは 14.ConnHook_mattn と同様に ConnectHook の中で登録する (Firewall.Hook)。
+// SQLiteのエラーは "not authorized" のみであるため、Firewall.Driver でドライバを包み、
+// 何がどのルールで拒否されたのかを持つ *firewall.DeniedError に変換している。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/c3ref/set_authorizer.html
+//   - https://www.sqlite.org/c3ref/c_alter_table.html
+//   - https://pkg.go.dev/github.com/mattn/go-sqlite3#SQLiteConn.RegisterAuthorizer
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 24.QueryFirewall/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run main.go
+	   [OK    ] SELECT count(*) FROM invoices                                  [[412]]
+	   [OK    ] SELECT InvoiceId, CustomerId FROM invoices ORDER BY InvoiceId LIMIT 2 [[1 <nil>] [2 <nil>]]
+	   [OK    ] UPDATE artists SET Name = Name WHERE ArtistId = 1              rows=1
+	   [DENIED] DELETE FROM invoice_items WHERE InvoiceId = 1                  firewall: DELETE on main.invoice_items denied by rule "readonly-invoices"
+	   [DENIED] CREATE TABLE plugin_data (x)                                   firewall: CREATE_TABLE on main.plugin_data denied by rule "no-ddl"
+	   [DENIED] ATTACH DATABASE ':memory:' AS other                            firewall: ATTACH (:memory:) denied by rule "no-ddl"
+	   [OK    ] PRAGMA table_info(artists)                                     [[0 ArtistId INTEGER 1 <nil> 1] [1 Name NVARCHAR(120) 0 <nil> 0]]
+	   [DENIED] PRAGMA journal_mode = DELETE                                   firewall: PRAGMA (journal_mode) denied by rule "no-pragma"
+	   [error ] is ErrDenied=true, sqlite3 code=23 (authorization denied)
+	*/
+}
+
+const (
+	// policyJSON は、プラグインやレポート処理向けのポリシー。
+	//
+	//   - DDL、ATTACH、REINDEX/ANALYZE は禁止
+	//   - 請求関連のテーブルは読み出しのみ
+	//   - 顧客IDは見せない (NULL にする)
+	//   - PRAGMA は table_info のみ
+	policyJSON = `{
+  "default": "allow",
+  "rules": [
+    {"name": "no-ddl",            "effect": "deny",   "classes": ["ddl", "attach", "maintenance"]},
+    {"name": "readonly-invoices", "effect": "deny",   "classes": ["write"], "table": "invoice*"},
+    {"name": "hide-customer",     "effect": "ignore", "actions": ["read"],  "table": "invoices", "column": "CustomerId"},
+    {"name": "table-info",        "effect": "allow",  "actions": ["pragma"], "object": "table_info"},
+    {"name": "no-pragma",         "effect": "deny",   "actions": ["pragma"]}
+  ]
+}`
+)
+
+var (
+	statements = []string{
+		"SELECT count(*) FROM invoices",
+		"SELECT InvoiceId, CustomerId FROM invoices ORDER BY InvoiceId LIMIT 2",
+		"UPDATE artists SET Name = Name WHERE ArtistId = 1",
+		"DELETE FROM invoice_items WHERE InvoiceId = 1",
+		"CREATE TABLE plugin_data (x)",
+		"ATTACH DATABASE ':memory:' AS other",
+		"PRAGMA table_info(artists)",
+		"PRAGMA journal_mode = DELETE",
+	}
+)
+
+func run() error {
+	policy, err := firewall.ParsePolicy([]byte(policyJSON))
+	if err != nil {
+		return err
+	}
+
+	fw, err := firewall.New(policy)
+	if err != nil {
+		return err
+	}
+
+	sql.Register(driver, fw.Driver(&sqlite3.SQLiteDriver{
+		ConnectHook: fw.Hook,
+	}))
+
+	db, err := sql.Open(driver, datasource)
+	if err != nil {
+		return fmt.Errorf("sql.Open: %w", err)
+	}
+	defer db.Close()
+
+	var (
+		lastErr error
+	)
+	for _, stmt := range statements {
+		result, err := execute(db, stmt)
+		if err != nil {
+			if !errors.Is(err, firewall.ErrDenied) {
+				return fmt.Errorf("%s: %w", stmt, err)
+			}
+
+			log.Printf("[DENIED] %-62s %v", stmt, err)
+			lastErr = err
+			continue
+		}
+
+		log.Printf("[OK    ] %-62s %s", stmt, result)
+	}
+
+	// 変換後のエラーからも、ドライバの元のエラーを取り出せる
+	var (
+		sqliteErr sqlite3.Error
+	)
+	errors.As(lastErr, &sqliteErr)
+	log.Printf("[error ] is ErrDenied=%v, sqlite3 code=%d (%v)", errors.Is(lastErr, firewall.ErrDenied), sqliteErr.Code, sqliteErr.Code)
+
+	return nil
+}
+
+// execute は、SELECT/PRAGMA であれば結果の行を、それ以外は更新件数を文字列で返す。
+func execute(db *sql.DB, stmt string) (string, error) {
+	if !strings.HasPrefix(stmt, "SELECT") && !strings.HasPrefix(stmt, "PRAGMA") {
+		result, err := db.Exec(stmt)
+		if err != nil {
+			return "", err
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("rows=%d", n), nil
+	}
+
+	rows, err := db.Query(stmt)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		values [][]any
+	)
+	for rows.Next() {
+		var (
+			row  = make([]any, len(cols))
+			ptrs = make([]any, len(cols))
+		)
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+
+		if err = rows.Scan(ptrs...); err != nil {
+			return "", err
+		}
+
+		for i, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[i] = string(b)
+			}
+		}
+
+		values = append(values, row)
+	}
+
+	return fmt.Sprint(values), rows.Err()
+}
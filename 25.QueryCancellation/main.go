@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/devlights/try-golang-db/internal/interrupt"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 25.QueryCancellation
+//
+// コンテキストのキャンセルで、実行中のクエリが本当に中断されることを確認する。
+//
+// 13.ConnHook_modernc / 14.ConnHook_mattn では PingContext に 100ms のタイムアウトを付けているが、
+// Ping はすぐに終わるため、時間のかかるクエリが途中で止まるのかは分からない。
+//
+// ここでは internal/interrupt を使って、わざと時間のかかるクエリ (再帰CTE) を実行し、
+// 100ms 後にキャンセル (context.WithCancel) または期限切れ (context.WithTimeout) にして以下を確認する。
+//
+//   - 中断してから速やかにクエリが戻ってくること (overrun)
+//   - エラーが canceled / timeout に分類できること (kind)
+//   - 中断したコネクションが正常なままプールに戻ること (reused)
+//   - プールから新しく取得したコネクションでクエリを実行できること (pool)
+//
+// SQLiteの2つのドライバは sqlite3_interrupt() でクエリを止め、コネクションはそのまま再利用される。
+// lib/pq は CancelRequest でサーバ側のクエリを止めた上で、コネクションを破棄する。
+// そのため PostgreSQL では reused=false が正しい動作となる。
+//
+// PostgreSQL は環境変数 PG_DSN が設定されている場合のみ確認する。
+// (15.embedded-postgresql を起動している場合は以下のDSNで接続できる)
+//
+//	PG_DSN="host=localhost port=5432 user=postgres password=postgres dbname=postgres sslmode=disable"
+//
+// いずれかのケースが失敗した場合は終了コード 1 で終了する。
+//
+// 同じ確認は internal/interrupt のテスト (task test) でも行っている。
+//
+// # REFERENCES
+//   - https://pkg.go.dev/database/sql@go1.26.0#DB.QueryContext
+//   - https://www.sqlite.org/c3ref/interrupt.html
+//   - https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-FLOW-CANCELING-REQUESTS
+func main() {
+	ok, err := run()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+
+	/*
+	   $ task -d 25.QueryCancellation/
+	   task: [default] go run main.go
+	   DRIVER    CASE                ELAPSED  OVERRUN  KIND      REUSED  POOL  RESULT
+	   sqlite3   aggregate/cancel    100ms    0s       canceled  true    ok    PASS
+	   sqlite3   rows/cancel         100ms    0s       canceled  true    ok    PASS
+	   sqlite3   aggregate/deadline  100ms    0s       timeout   true    ok    PASS
+	   sqlite3   rows/deadline       100ms    0s       timeout   true    ok    PASS
+	   sqlite    aggregate/cancel    110ms    10ms     canceled  true    ok    PASS
+	   sqlite    rows/cancel         100ms    0s       canceled  true    ok    PASS
+	   sqlite    aggregate/deadline  100ms    0s       timeout   true    ok    PASS
+	   sqlite    rows/deadline       100ms    0s       timeout   true    ok    PASS
+	   postgres  (skipped: PG_DSN is not set)
+	*/
+}
+
+type target struct {
+	driver      string
+	dsn         string
+	slow, many  string
+	expectReuse bool
+}
+
+func run() (bool, error) {
+	var (
+		targets = []target{
+			{"sqlite3", ":memory:", interrupt.SlowSQLite, interrupt.ManyRowsSQLite, true},
+			{"sqlite", ":memory:", interrupt.SlowSQLite, interrupt.ManyRowsSQLite, true},
+			{"postgres", os.Getenv("PG_DSN"), interrupt.SlowPostgres, interrupt.ManyRowsPostgres, false},
+		}
+		tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		ok = true
+	)
+	defer tw.Flush()
+
+	fmt.Fprintln(tw, "DRIVER\tCASE\tELAPSED\tOVERRUN\tKIND\tREUSED\tPOOL\tRESULT")
+
+	for _, t := range targets {
+		if t.dsn == "" {
+			fmt.Fprintf(tw, "%s\t(skipped: PG_DSN is not set)\n", t.driver)
+			continue
+		}
+
+		results, err := check(t)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", t.driver, err)
+		}
+
+		for _, r := range results {
+			var (
+				pool   = "ok"
+				result = "PASS"
+			)
+			if r.PoolErr != nil {
+				pool = "NG"
+			}
+			if !r.OK() {
+				result = "FAIL"
+				ok = false
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t%v\t%v\t%s\t%v\t%s\t%s\n",
+				t.driver, r.Case.Name, r.Elapsed.Round(10*time.Millisecond), r.Overrun.Round(10*time.Millisecond),
+				r.Kind, r.Reused, pool, result)
+			for _, f := range r.Failures {
+				fmt.Fprintf(tw, "\t  - %s\n", f)
+			}
+		}
+	}
+
+	return ok, nil
+}
+
+func check(t target) ([]interrupt.Result, error) {
+	db, err := sql.Open(t.driver, t.dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// 中断したコネクションが再利用されるかを確認しやすいよう、コネクションは1本にする
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	)
+	defer cancel()
+
+	if err = db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+
+	var (
+		cases = interrupt.Cases(t.slow, t.many)
+		opts  = interrupt.Options{
+			After:       100 * time.Millisecond,
+			MaxOverrun:  500 * time.Millisecond,
+			ExpectReuse: t.expectReuse,
+		}
+	)
+
+	return interrupt.RunAll(ctx, db, cases, opts), nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/devlights/try-golang-db/internal/attach"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const (
+	driver     = "sqlite3_attach"
+	datasource = "./chinook.db"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 26.AttachDatabase
+//
+// ATTACH DATABASE で複数のSQLiteファイルをまたいだクエリを実行する。
+//
+// chinook.db の他に、テナント毎のデータベースファイル (tenant_a.db, tenant_b.db) があるとする。
+// ATTACH DATABASE でファイルにスキーマ名を付けると、1つのSQLで複数のファイルのテーブルを JOIN したり、
+// INSERT INTO main.x SELECT ... FROM tenant_a.y のようにファイル間でコピーしたりできる。
+//
+// ただし、ATTACH はコネクション単位の状態であるため、*sql.DB (コネクションプール) で使うには
+// 全てのコネクションで同じようにアタッチされている必要がある。
+// internal/attach の Catalog は、
+//
+//   - 接続フック (14.ConnHook_mattn と同じ ConnectHook) で、新しいコネクションに全てアタッチする
+//   - Catalog.Conn で、既に開いているコネクションにも後から追加したデータベースをアタッチする
+//   - スキーマ名を検証する (SQLにパラメータとして渡せないため)
+//
+// ことで、これを実現している。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/lang_attach.html
+//   - https://www.sqlite.org/pragma.html#pragma_database_list
+//   - https://www.sqlite.org/atomiccommit.html#_multi_file_commit
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 26.AttachDatabase/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run main.go
+	   [validate] "main"     : attach: invalid schema name: "main" is reserved
+	   [validate] "a; DROP"  : attach: invalid schema name: "a; DROP"
+	   [validate] "TENANT_A" : attach: invalid schema name: "TENANT_A" is already added
+	   [join    ] AC/DC        5 (tenant_a)
+	   [join    ] Accept       4 (tenant_a)
+	   [join    ] Aerosmith    3 (tenant_a)
+	   [db      ] no such table: tenant_b.ratings
+	   [conn    ] tenant_b rows=2
+	   [copy    ] copied=[3 2] total=5
+	   [copy    ] failed: copy "tenant_b"."missing" -> "main"."tenant_ratings": no such table: tenant_b.missing
+	   [copy    ] rolled back, total=5
+	*/
+}
+
+func run() error {
+	dir, err := os.MkdirTemp("", "try-golang-db-attach-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		tenantA = filepath.Join(dir, "tenant_a.db")
+		tenantB = filepath.Join(dir, "tenant_b.db")
+	)
+	if err = seed(tenantA, [][2]int{{1, 5}, {2, 4}, {3, 3}}); err != nil {
+		return err
+	}
+	if err = seed(tenantB, [][2]int{{1, 2}, {4, 5}}); err != nil {
+		return err
+	}
+
+	var (
+		ctx     = context.Background()
+		catalog = &attach.Catalog{}
+	)
+
+	// 最初は tenant_a のみ。読み取り専用でアタッチする
+	if err = catalog.Add(attach.Database{Alias: "tenant_a", Path: tenantA, ReadOnly: true}); err != nil {
+		return err
+	}
+
+	// スキーマ名の検証
+	for _, alias := range []string{"main", "a; DROP", "TENANT_A"} {
+		err := catalog.Add(attach.Database{Alias: alias, Path: tenantB})
+		log.Printf("[validate] %-11q: %v", alias, err)
+	}
+
+	sql.Register(driver, &sqlite3.SQLiteDriver{
+		ConnectHook: catalog.Hook,
+	})
+
+	db, err := sql.Open(driver, datasource)
+	if err != nil {
+		return fmt.Errorf("sql.Open: %w", err)
+	}
+	defer db.Close()
+
+	// 1. ファイルをまたいだ JOIN (プールのどのコネクションでも tenant_a が使える)
+	if err = join(ctx, db); err != nil {
+		return err
+	}
+
+	// 2. 後から tenant_b を追加する
+	//
+	// 既に開いているコネクションには接続フックが呼ばれないため、*sql.DB のままではアタッチされていない。
+	// Catalog.Conn で取得したコネクションでは、足りないデータベースがアタッチされる。
+	if err = catalog.Add(attach.Database{Alias: "tenant_b", Path: tenantB}); err != nil {
+		return err
+	}
+
+	var (
+		n int
+	)
+	if err = db.QueryRowContext(ctx, "SELECT count(*) FROM tenant_b.ratings").Scan(&n); err != nil {
+		log.Printf("[db      ] %v", err)
+	}
+
+	conn, err := catalog.Conn(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err = conn.QueryRowContext(ctx, "SELECT count(*) FROM tenant_b.ratings").Scan(&n); err != nil {
+		return err
+	}
+	log.Printf("[conn    ] tenant_b rows=%d", n)
+
+	// 3. 1つのトランザクションで、各テナントの評価を main にコピーする
+	if _, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS main.tenant_ratings (ArtistId INTEGER, Stars INTEGER)"); err != nil {
+		return err
+	}
+
+	var (
+		columns = []string{"ArtistId", "Stars"}
+		copies  = []attach.Copy{
+			{From: attach.T("tenant_a", "ratings"), To: attach.T("main", "tenant_ratings"), Columns: columns},
+			{From: attach.T("tenant_b", "ratings"), To: attach.T("main", "tenant_ratings"), Columns: columns, Where: "Stars >= ?", Args: []any{1}},
+		}
+	)
+	counts, err := catalog.CopyTx(ctx, conn, copies...)
+	if err != nil {
+		return err
+	}
+	log.Printf("[copy    ] copied=%v total=%d", counts, total(ctx, conn))
+
+	// 途中で失敗した場合は、先に実行したコピーも含めてロールバックされる
+	copies = append(copies, attach.Copy{From: attach.T("tenant_b", "missing"), To: attach.T("main", "tenant_ratings")})
+	if _, err = catalog.CopyTx(ctx, conn, copies...); err != nil {
+		log.Printf("[copy    ] failed: %v", err)
+	}
+	log.Printf("[copy    ] rolled back, total=%d", total(ctx, conn))
+
+	return nil
+}
+
+// seed は、テナントのデータベースファイルを作成する。
+func seed(path string, ratings [][2]int) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err = db.Exec("CREATE TABLE ratings (ArtistId INTEGER, Stars INTEGER)"); err != nil {
+		return err
+	}
+
+	for _, r := range ratings {
+		if _, err = db.Exec("INSERT INTO ratings VALUES (?, ?)", r[0], r[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func join(ctx context.Context, db *sql.DB) error {
+	const (
+		query = `
+			SELECT a.Name, r.Stars
+			FROM main.artists a JOIN tenant_a.ratings r ON r.ArtistId = a.ArtistId
+			ORDER BY r.Stars DESC`
+	)
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var (
+		name  string
+		stars int
+	)
+	for rows.Next() {
+		if err = rows.Scan(&name, &stars); err != nil {
+			return err
+		}
+		log.Printf("[join    ] %-12s %d (tenant_a)", name, stars)
+	}
+
+	return rows.Err()
+}
+
+func total(ctx context.Context, conn *sql.Conn) int {
+	var (
+		n int
+	)
+	conn.QueryRowContext(ctx, "SELECT count(*) FROM main.tenant_ratings").Scan(&n)
+
+	return n
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/devlights/try-golang-db/internal/snapshot"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// 元のデータベースは読み取り専用で開く (cp -f でコピーする必要が無い)
+	datasource = "file:../chinook.db?mode=ro"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 27.Snapshot
+//
+// データベース全体を []byte に保存し、メモリ上のデータベースとして復元する。
+//
+// 他のサンプルの Taskfile では、実行前に cp -f ../chinook.db . で元のデータベースをコピーしている。
+// テストで同じことをすると、テスト毎にファイルのコピーが必要になる。
+//
+// SQLiteには、データベースをバイト列に変換する sqlite3_serialize() と、
+// バイト列をメモリ上のデータベースとして開き直す sqlite3_deserialize() がある。
+// internal/snapshot では、最初に一度だけスナップショットを取得し、テスト毎にメモリ上へ復元する。
+// 元のファイルは読み取り専用で開くだけなので、変更されることはない。
+// (modernc.org/sqlite は Deserialize に問題があるため、復元はバックアップAPIで行っている)
+//
+//   - snapshot.Take          : *sql.DB のメインデータベースのスナップショットを取得する
+//   - Snapshot.Open          : スナップショットを復元した、メモリ上の *sql.DB を開く
+//   - Snapshot.Restore       : 既存のコネクションをスナップショットの内容に戻す
+//
+// # REFERENCES
+//   - https://www.sqlite.org/c3ref/serialize.html
+//   - https://www.sqlite.org/c3ref/deserialize.html
+//   - https://pkg.go.dev/database/sql@go1.26.0#Conn.Raw
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 27.Snapshot/
+	   task: [default] go run main.go
+	   [take    ] sqlite3  303104 bytes (serialize) in 700µs
+	   [test #1 ] sqlite3  before=2240 after=2245 restore=800µs
+	   [test #2 ] sqlite3  before=2240 after=2245 restore=600µs
+	   [test #3 ] sqlite3  before=2240 after=2245 restore=600µs
+	   [restore ] sqlite3  deleted=0 restored=2240 (serialize)
+	   [take    ] sqlite   303104 bytes (serialize) in 1.3ms
+	   [test #1 ] sqlite   before=2240 after=2245 restore=2.4ms
+	   [test #2 ] sqlite   before=2240 after=2245 restore=2.1ms
+	   [test #3 ] sqlite   before=2240 after=2245 restore=1.7ms
+	   [restore ] sqlite   deleted=0 restored=2240 (backup API)
+	*/
+}
+
+func run() error {
+	var (
+		ctx = context.Background()
+	)
+	for _, driverName := range []string{"sqlite3", "sqlite"} {
+		s, err := take(ctx, driverName)
+		if err != nil {
+			return fmt.Errorf("%s: %w", driverName, err)
+		}
+		log.Printf("[take    ] %-8s %d bytes (%s) in %v", driverName, len(s.Data), s.Method, s.Elapsed.Round(100*time.Microsecond))
+
+		drv, err := driverOf(driverName)
+		if err != nil {
+			return err
+		}
+
+		// テスト毎にメモリ上へ復元し、行を追加しても次のテストに影響しないことを確認する
+		for i := range 3 {
+			if err = fakeTest(ctx, s, drv, driverName, i+1); err != nil {
+				return err
+			}
+		}
+
+		// 既存のコネクションを元に戻す
+		if err = reset(ctx, s, drv, driverName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func take(ctx context.Context, driverName string) (*snapshot.Snapshot, error) {
+	db, err := sql.Open(driverName, datasource)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return snapshot.Take(ctx, db)
+}
+
+// driverOf は、ドライバ名に対応する driver.Driver を返す。
+func driverOf(driverName string) (driver.Driver, error) {
+	if driverName == "sqlite3" {
+		return &sqlite3.SQLiteDriver{}, nil
+	}
+
+	// modernc.org/sqlite はグローバルに登録されたドライバを使う
+	db, err := sql.Open(driverName, "")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return db.Driver(), nil
+}
+
+// fakeTest は、テストの代わり。復元したデータベースに行を追加する。
+func fakeTest(ctx context.Context, s *snapshot.Snapshot, drv driver.Driver, driverName string, no int) error {
+	var (
+		start = time.Now()
+		db    = s.Open(drv)
+	)
+	defer db.Close()
+
+	before, err := count(ctx, db)
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+
+	for i := range 5 {
+		if _, err = db.ExecContext(ctx, "INSERT INTO invoice_items (InvoiceId, TrackId, UnitPrice, Quantity) VALUES (1, ?, 0.99, 1)", i+1); err != nil {
+			return fmt.Errorf("insert: %w", err)
+		}
+	}
+
+	after, err := count(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[test #%d ] %-8s before=%d after=%d restore=%v", no, driverName, before, after, elapsed.Round(100*time.Microsecond))
+
+	return nil
+}
+
+// reset は、全ての行を削除したコネクションを Restore で元に戻す。
+func reset(ctx context.Context, s *snapshot.Snapshot, drv driver.Driver, driverName string) error {
+	var (
+		db = s.Open(drv)
+	)
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "DELETE FROM invoice_items"); err != nil {
+		return err
+	}
+
+	var (
+		deleted, restored int
+	)
+	if err = conn.QueryRowContext(ctx, "SELECT count(*) FROM invoice_items").Scan(&deleted); err != nil {
+		return err
+	}
+
+	method, err := s.Restore(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if err = conn.QueryRowContext(ctx, "SELECT count(*) FROM invoice_items").Scan(&restored); err != nil {
+		return err
+	}
+
+	log.Printf("[restore ] %-8s deleted=%d restored=%d (%s)", driverName, deleted, restored, method)
+
+	return nil
+}
+
+func count(ctx context.Context, db *sql.DB) (int, error) {
+	var (
+		n int
+	)
+	err := db.QueryRowContext(ctx, "SELECT count(*) FROM invoice_items").Scan(&n)
+
+	return n, err
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/devlights/try-golang-db/internal/pragma"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"modernc.org/sqlite"
+)
+
+const (
+	datasource = "./chinook.db"
+)
+
+const (
+	// profilesJSON は、設定ファイルなどから読み込むプロファイルの例。
+	//
+	//   - foreign_key は foreign_keys の書き間違い (エラーにならず何も起きない)
+	//   - mmap_size は SQLITE_MAX_MMAP_SIZE (既定値 0x7fff0000) を超えているため丸められる
+	profilesJSON = `[
+  {
+    "name": "app",
+    "settings": [
+      {"name": "journal_mode", "value": "WAL", "required": true},
+      {"name": "busy_timeout", "value": 3000, "required": true},
+      {"name": "foreign_key",  "value": true},
+      {"name": "mmap_size",    "value": 4294967296}
+    ]
+  }
+]`
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 28.PragmaProfile
+//
+// PRAGMA の組み合わせを名前付きのプロファイルとして宣言し、適用後に読み戻して検証する。
+//
+// 13.ConnHook_modernc, 14.ConnHook_mattn では、接続フックで PRAGMA を実行するだけだった。
+// しかし PRAGMA の多くは、設定できなくてもエラーにならない。
+//
+//   - journal_mode=WAL は、:memory: では memory のまま (WAL 非対応のファイルシステムでは delete のまま)
+//   - 存在しない PRAGMA 名は、何もせずに成功する
+//   - 上限を超えた値は、黙って丸められる
+//
+// internal/pragma の Profile は、設定を適用した後に PRAGMA name で値を読み戻して比較する。
+// Required な設定が反映されなかった場合は接続フックがエラーを返すため、そのコネクションは利用されない。
+//
+// プロファイルは Go の構造体 (pragma.Server() など定義済みのもの) か JSON (pragma.ParseProfiles) で用意する。
+//
+//   - server    : 13/14/16 で利用しているもの (WAL + synchronous=NORMAL)
+//   - batch     : 一括書き込み用 (synchronous=OFF)
+//   - read-only : query_only=ON
+//   - test      : テスト用 (journal_mode=MEMORY, foreign_keys=ON)
+//
+// # REFERENCES
+//   - https://www.sqlite.org/pragma.html
+//   - https://www.sqlite.org/wal.html#persistence_of_wal_mode
+//   - https://www.sqlite.org/compile.html#max_mmap_size
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 28.PragmaProfile/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run main.go
+	   [app     ] applied 4 settings
+	   [app     ] not applied (required=false): foreign_key: want true, got (none)
+	   [app     ] not applied (required=false): mmap_size: want 4294967296, got 2147418112
+	   [server  ] ./chinook.db ping: <nil> (ErrNotApplied=false)
+	   [server  ] :memory:     ping: pragma: profile "server": required setting not applied (journal_mode: want WAL, got memory) (ErrNotApplied=true)
+	   [read-only] artists=275
+	   [read-only] delete: attempt to write a readonly database (8)
+	*/
+}
+
+func run() error {
+	var (
+		ctx = context.Background()
+	)
+
+	// 1. JSON から読み込んだプロファイルを適用する (Required でない設定の不一致は報告のみ)
+	profiles, err := pragma.ParseProfiles([]byte(profilesJSON))
+	if err != nil {
+		return err
+	}
+	if err = applyJSON(ctx, profiles[0]); err != nil {
+		return err
+	}
+
+	// 2. 接続フックで server プロファイルを適用する
+	//
+	// ファイルのデータベースであれば全て反映されるが、:memory: では journal_mode が memory のままとなり、
+	// Required な設定が反映されていないため、コネクションの取得自体が失敗する。
+	sql.Register("sqlite3_server", &sqlite3.SQLiteDriver{
+		ConnectHook: pragma.Server().Hook,
+	})
+
+	for _, dsn := range []string{datasource, ":memory:"} {
+		err := ping(ctx, "sqlite3_server", dsn)
+		log.Printf("[server  ] %-12s ping: %v (ErrNotApplied=%v)", dsn, err, errors.Is(err, pragma.ErrNotApplied))
+	}
+
+	// 3. modernc.org/sqlite で read-only プロファイルを適用する
+	//
+	// 接続フックはプロセスグローバル。query_only=ON のため書き込みは失敗する。
+	sqlite.RegisterConnectionHook(pragma.ReadOnly().ModerncHook)
+
+	return readOnly(ctx)
+}
+
+func applyJSON(ctx context.Context, p pragma.Profile) error {
+	db, err := sql.Open("sqlite3", datasource)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var (
+		mismatches []pragma.Mismatch
+	)
+	err = conn.Raw(func(driverConn any) error {
+		var err error
+		mismatches, err = p.Apply(ctx, driverConn.(*sqlite3.SQLiteConn))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[%-8s] applied %d settings", p.Name, len(p.Settings))
+	for _, m := range mismatches {
+		log.Printf("[%-8s] not applied (required=%v): %s", p.Name, m.Required, m)
+	}
+
+	return nil
+}
+
+func ping(ctx context.Context, driverName, dsn string) error {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.PingContext(ctx)
+}
+
+func readOnly(ctx context.Context) error {
+	db, err := sql.Open("sqlite", datasource)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var (
+		n int
+	)
+	if err = db.QueryRowContext(ctx, "SELECT count(*) FROM artists").Scan(&n); err != nil {
+		return fmt.Errorf("select: %w", err)
+	}
+	log.Printf("[read-only] artists=%d", n)
+
+	_, err = db.ExecContext(ctx, "DELETE FROM artists WHERE ArtistId = 1")
+	log.Printf("[read-only] delete: %v", err)
+
+	return nil
+}
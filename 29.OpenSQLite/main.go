@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/devlights/try-golang-db/internal/dbopen"
+	"github.com/devlights/try-golang-db/internal/pragma"
+	"github.com/devlights/try-golang-db/internal/sqlfunc"
+)
+
+const (
+	datasource = "./chinook.db"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 29.OpenSQLite
+//
+// mattn/go-sqlite3 と modernc.org/sqlite の接続フックの違いを隠して、*sql.DB を開く。
+//
+// 13.ConnHook_modernc, 14.ConnHook_mattn の通り、新しいコネクションに処理を行う方法はドライバ毎に異なる。
+//
+//   - mattn/go-sqlite3  : sql.Register で別名のドライバを登録する (同じ名前で2回登録すると panic する)
+//   - modernc.org/sqlite: sqlite.RegisterConnectionHook でプロセスグローバルに登録する (全ての *sql.DB が対象となる)
+//
+// internal/dbopen の OpenSQLite は、sql.OpenDB に渡すコネクタの中でコネクションを開いてからフックを実行する。
+// フックは *sql.DB 毎に持つため、同じドライバ・同じファイルでも *sql.DB 毎に異なる設定にできる。
+//
+//	db, err := dbopen.OpenSQLite(path, dbopen.Options{
+//		Driver:    dbopen.Modernc,
+//		Functions: registry,           // internal/sqlfunc
+//		Pragmas:   &profile,           // internal/pragma
+//		Hooks:     []dbopen.Hook{...}, // 任意の処理
+//	})
+//
+// ドライバ名が必要な場合は dbopen.Register を使う。名前をキーに一度だけ sql.Register するため、何度呼んでも panic しない。
+// 同じ名前を異なる設定で登録しようとした場合は、最初の設定が黙って使われないように dbopen.ErrConflict となる。
+//
+// # REFERENCES
+//   - https://pkg.go.dev/database/sql@go1.26.0#OpenDB
+//   - https://pkg.go.dev/database/sql@go1.26.0#Register
+//   - https://pkg.go.dev/modernc.org/sqlite#RegisterConnectionHook
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 29.OpenSQLite/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run main.go
+	   [hook    ] sqlite3 writer: new connection
+	   [writer  ] sqlite3 query_only=0 levenshtein=3 (<nil>) update=<nil>
+	   [hook    ] sqlite3 reader: new connection
+	   [reader  ] sqlite3 query_only=1 levenshtein=0 (no such function: levenshtein) update=attempt to write a readonly database
+	   [hook    ] sqlite  writer: new connection
+	   [writer  ] sqlite  query_only=0 levenshtein=3 (<nil>) update=<nil>
+	   [hook    ] sqlite  reader: new connection
+	   [reader  ] sqlite  query_only=1 levenshtein=3 (<nil>) update=attempt to write a readonly database (8)
+	   [register] dbopen_sqlite3_server <nil>
+	   [register] dbopen_sqlite3_server <nil>
+	   [register] conflict=true dbopen: name is already registered with different options: server (driver sqlite3)
+	   [register] conflict=true dbopen: name is already registered with different options: server (driver sqlite3)
+	   [register] conflict=true dbopen: name is already registered with different options: server (driver sqlite3)
+	   [register] sql.Open("dbopen_sqlite3_server") journal_mode=wal
+	*/
+}
+
+func run() error {
+	var (
+		ctx      = context.Background()
+		server   = pragma.Server()
+		readOnly = pragma.ReadOnly()
+	)
+
+	registry, err := sqlfunc.NewRegistry(sqlfunc.Builtins()...)
+	if err != nil {
+		return err
+	}
+
+	// 1. 同じドライバ・同じファイルで、異なる設定の *sql.DB を2つ開く
+	//
+	// フックと PRAGMA は *sql.DB 毎に異なる。ただし、modernc.org/sqlite へのSQL関数の登録はプロセスグローバルのため、
+	// Functions を指定していない reader でも levenshtein が使える。
+	for _, drv := range []dbopen.Driver{dbopen.Mattn, dbopen.Modernc} {
+		writer, err := dbopen.OpenSQLite(datasource, dbopen.Options{
+			Driver:    drv,
+			Functions: registry,
+			Pragmas:   &server,
+			Hooks:     []dbopen.Hook{logHook(drv, "writer")},
+		})
+		if err != nil {
+			return err
+		}
+		defer writer.Close()
+
+		reader, err := dbopen.OpenSQLite(datasource, dbopen.Options{
+			Driver:  drv,
+			Pragmas: &readOnly,
+			Hooks:   []dbopen.Hook{logHook(drv, "reader")},
+		})
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		for _, x := range []struct {
+			name string
+			db   *sql.DB
+		}{{"writer", writer}, {"reader", reader}} {
+			show(ctx, drv, x.name, x.db)
+		}
+	}
+
+	// 2. ドライバ名での登録は、同じ設定であれば何度呼び出しても panic しない
+	var (
+		name string
+	)
+	for range 2 {
+		name, err = dbopen.Register("server", dbopen.Options{Driver: dbopen.Mattn, Pragmas: &server})
+		log.Printf("[register] %s %v", name, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// 同じ名前で異なる設定を登録しようとするとエラーになる
+	for _, opts := range []dbopen.Options{
+		{Driver: dbopen.Modernc, Pragmas: &server},
+		{Driver: dbopen.Mattn, Pragmas: &readOnly},
+		{Driver: dbopen.Mattn},
+	} {
+		_, err = dbopen.Register("server", opts)
+		log.Printf("[register] conflict=%v %v", errors.Is(err, dbopen.ErrConflict), err)
+	}
+
+	db, err := sql.Open(name, datasource)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var (
+		mode string
+	)
+	if err = db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&mode); err != nil {
+		return err
+	}
+	log.Printf("[register] sql.Open(%q) journal_mode=%s", name, mode)
+
+	return nil
+}
+
+// logHook は、コネクションを開いたことを出力するフックを返す。
+func logHook(drv dbopen.Driver, name string) dbopen.Hook {
+	return func(_ context.Context, _ dbopen.Conn) error {
+		log.Printf("[hook    ] %-7s %s: new connection", drv, name)
+		return nil
+	}
+}
+
+// show は、query_only の値と、SQL関数の呼び出し、書き込みの結果を出力する。
+func show(ctx context.Context, drv dbopen.Driver, name string, db *sql.DB) {
+	var (
+		queryOnly int
+		distance  sql.NullInt64
+	)
+	db.QueryRowContext(ctx, "PRAGMA query_only").Scan(&queryOnly)
+
+	funcErr := db.QueryRowContext(ctx, "SELECT levenshtein('kitten', 'sitting')").Scan(&distance)
+	_, writeErr := db.ExecContext(ctx, "UPDATE artists SET Name = Name WHERE ArtistId = 1")
+
+	log.Printf("[%-8s] %-7s query_only=%d levenshtein=%v (%v) update=%v", name, drv, queryOnly, distance.Int64, funcErr, writeErr)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/devlights/try-golang-db/internal/dbopen"
+	"github.com/devlights/try-golang-db/internal/pragma"
+	"github.com/devlights/try-golang-db/internal/rwpool"
+)
+
+const (
+	datasource = "./chinook.db"
+	readers    = 4
+	duration   = 500 * time.Millisecond
+	readQuery  = `
+		SELECT count(*)
+		FROM tracks t JOIN albums a ON a.AlbumId = t.AlbumId
+		WHERE t.Name LIKE ? OR a.Title LIKE ?`
+)
+
+type (
+	// database は、*sql.DB と rwpool.Pool の共通部分
+	database interface {
+		QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+		ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+		BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	}
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 30.ReadWriteSplit
+//
+// WALモードのSQLiteで、書き込み用と読み込み用のコネクションプールを分ける。
+//
+// 13.ConnHook_modernc, 14.ConnHook_mattn では *sql.DB 全体を SetMaxOpenConns(1) にしている。
+// 書き込み同士の競合 (database is locked) は防げるが、読み込みも1本のコネクションで順番に実行されるため、
+// WALモードの「WRITER 1本 + READER 複数」の並行性が活かせない。
+//
+// internal/rwpool の Pool は、
+//
+//   - writer: コネクション1本、BEGIN IMMEDIATE (_txlock=immediate)、pragma.Server()
+//   - reader: コネクション N 本、query_only=ON、pragma.ReadOnly()
+//
+// の2つの *sql.DB を持ち、Query* を reader、Exec と BeginTx を writer に振り分ける。
+// rwpool.ReadYourWrites で印を付けた context では、書き込んだ後の読み込みを writer で行う。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/wal.html#concurrency
+//   - https://www.sqlite.org/lang_transaction.html#deferred_immediate_and_exclusive_transactions
+//   - https://www.sqlite.org/pragma.html#pragma_query_only
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	// CPUが1コアの環境での結果。reads はほぼ変わらないが、writes は読み込みを待たなくなった分だけ増えている。
+	/*
+	   $ task -d 30.ReadWriteSplit/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run main.go
+	   [single ] reads=881    writes=340 (500ms, readers=4)
+	   [rwpool ] reads=692    writes=2954 (500ms, readers=4)
+	   [reader ] delete: attempt to write a readonly database
+	   [ryw    ] before=275 snapshot-tx=275 reader=276 read-your-writes=276
+	*/
+}
+
+func run() error {
+	var (
+		ctx     = context.Background()
+		profile = pragma.Server()
+	)
+
+	// 1. *sql.DB 全体を1本のコネクションにした場合
+	single, err := dbopen.OpenSQLite(datasource, dbopen.Options{Pragmas: &profile})
+	if err != nil {
+		return err
+	}
+	defer single.Close()
+	single.SetMaxOpenConns(1)
+
+	if err = measure(ctx, "single", single); err != nil {
+		return err
+	}
+
+	// 2. writer と reader に分けた場合
+	//
+	// 書き込みが読み込みの後ろに並ばなくなる。CPUが複数あれば、読み込みも並行して実行される。
+	pool, err := rwpool.Open(ctx, datasource, rwpool.Options{Readers: readers})
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	if err = measure(ctx, "rwpool", pool); err != nil {
+		return err
+	}
+
+	// 3. reader は query_only=ON
+	_, err = pool.Reader().ExecContext(ctx, "DELETE FROM bench_writes")
+	log.Printf("[reader ] delete: %v", err)
+
+	// 4. read-your-writes
+	return readYourWrites(ctx, pool)
+}
+
+// measure は、readers 個のゴルーチンで読み込みながら、1つのゴルーチンで書き込み、duration の間に完了した数を出力する。
+func measure(ctx context.Context, name string, db database) error {
+	if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS bench_writes (Id INTEGER PRIMARY KEY, At TEXT)"); err != nil {
+		return err
+	}
+
+	var (
+		ctx2, cancel = context.WithTimeout(ctx, duration)
+		wg           sync.WaitGroup
+		reads        atomic.Int64
+		writes       atomic.Int64
+		errs         = make([]error, readers+1)
+	)
+	defer cancel()
+
+	for i := range readers {
+		wg.Go(func() {
+			var (
+				n int
+			)
+			for ctx2.Err() == nil {
+				if err := db.QueryRowContext(ctx2, readQuery, "%love%", "%rock%").Scan(&n); err != nil {
+					if ctx2.Err() == nil {
+						errs[i] = err
+					}
+					return
+				}
+				reads.Add(1)
+			}
+		})
+	}
+
+	wg.Go(func() {
+		for ctx2.Err() == nil {
+			if err := write(ctx2, db); err != nil {
+				if ctx2.Err() == nil {
+					errs[readers] = err
+				}
+				return
+			}
+			writes.Add(1)
+		}
+	})
+
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	log.Printf("[%-7s] reads=%-6d writes=%d (%v, readers=%d)", name, reads.Load(), writes.Load(), duration, readers)
+
+	return nil
+}
+
+func write(ctx context.Context, db database) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "INSERT INTO bench_writes (At) VALUES (datetime('now'))"); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// readYourWrites は、書き込み前に開始した読み込みトランザクションと、ReadYourWrites の違いを出力する。
+func readYourWrites(ctx context.Context, pool *rwpool.Pool) error {
+	// 書き込みより前に開始した読み込みトランザクションは、開始時点のスナップショットを参照し続ける
+	snapshot, err := pool.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer snapshot.Rollback()
+
+	var (
+		before, inTx, plain, ryw int
+		rywCtx                   = rwpool.ReadYourWrites(ctx)
+	)
+	if err = snapshot.QueryRowContext(ctx, "SELECT count(*) FROM artists").Scan(&before); err != nil {
+		return err
+	}
+
+	if _, err = pool.ExecContext(rywCtx, "INSERT INTO artists (Name) VALUES ('rwpool')"); err != nil {
+		return err
+	}
+
+	snapshot.QueryRowContext(ctx, "SELECT count(*) FROM artists").Scan(&inTx)
+	pool.QueryRowContext(ctx, "SELECT count(*) FROM artists").Scan(&plain)
+	pool.QueryRowContext(rywCtx, "SELECT count(*) FROM artists").Scan(&ryw)
+
+	log.Printf("[ryw    ] before=%d snapshot-tx=%d reader=%d read-your-writes=%d", before, inTx, plain, ryw)
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/devlights/try-golang-db/internal/dbopen"
+	"github.com/devlights/try-golang-db/internal/groupcommit"
+)
+
+const (
+	datasource = "./chinook.db"
+	workers    = 32
+	perWorker  = 50
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 31.GroupCommit
+//
+// SQLiteへの書き込みを1つのゴルーチンに集め、複数の書き込みを1つのトランザクションでコミットする (グループコミット)。
+//
+// 13.ConnHook_modernc, 14.ConnHook_mattn では SetMaxOpenConns(1) で書き込みを直列化している。
+// この状態で多数のゴルーチンから 05.Transaction のような小さな INSERT を1件ずつ実行すると、
+// 1件毎にコミットが発生し、コミットのコスト (ジャーナルの書き込み、fsync) が処理時間の大半を占める。
+//
+// internal/groupcommit の Writer は、各ゴルーチンから書き込み処理 (Job) をチャネルで受け取り、
+// MaxBatch 件または MaxDelay 経過するまで溜めてから、1つのトランザクションでまとめて実行する。
+// 各 Job は SAVEPOINT の中で実行するため、失敗した Job の変更のみが取り消され、結果は Job 毎に返る。
+//
+// ここでは 05.Transaction と同じく PRAGMA は既定値 (journal_mode=DELETE, synchronous=FULL) のまま比較している。
+// pragma.Server() (WAL + synchronous=NORMAL) ではコミットのコストが小さいため、差は小さくなる。
+// また、MaxDelay の間は次の Job を待つため、書き込みが少ない場合は1件毎のレイテンシが最大 MaxDelay 増える。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/faq.html#q19
+//   - https://www.sqlite.org/lang_savepoint.html
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 31.GroupCommit/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run main.go
+	   [autocommit ] rows=1600 elapsed=1.537s         1041 rows/s
+	   [groupcommit] rows=1600 elapsed=155ms         10299 rows/s
+	   [stats      ] jobs=1600 batches=50 max-batch=32 avg-batch=32.0
+	   [partial    ] worker=100 seq=0 id=1602  err=<nil>
+	   [partial    ] worker=0   seq=0 id=0     err=UNIQUE constraint failed: events.Worker, events.Seq
+	   [partial    ] worker=100 seq=1 id=1601  err=<nil>
+	   [partial    ] worker=100 rows=2
+	*/
+}
+
+func run() error {
+	var (
+		ctx = context.Background()
+	)
+
+	db, err := dbopen.OpenSQLite(datasource, dbopen.Options{})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	const (
+		ddl = `CREATE TABLE IF NOT EXISTS events (
+			Id     INTEGER PRIMARY KEY,
+			Worker INTEGER NOT NULL,
+			Seq    INTEGER NOT NULL,
+			UNIQUE (Worker, Seq))`
+	)
+	if _, err = db.ExecContext(ctx, ddl); err != nil {
+		return err
+	}
+
+	// 1. 1件ずつコミットする
+	elapsed, err := parallel(func(worker, seq int) error {
+		_, err := db.ExecContext(ctx, "INSERT INTO events (Worker, Seq) VALUES (?, ?)", worker, seq)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	report("autocommit", elapsed)
+
+	if _, err = db.ExecContext(ctx, "DELETE FROM events"); err != nil {
+		return err
+	}
+
+	// 2. グループコミット
+	w := groupcommit.New(db, groupcommit.Options{MaxBatch: 64, MaxDelay: time.Millisecond})
+	defer w.Close()
+
+	elapsed, err = parallel(func(worker, seq int) error {
+		return w.Do(ctx, func(ctx context.Context, tx groupcommit.Tx) error {
+			_, err := tx.ExecContext(ctx, "INSERT INTO events (Worker, Seq) VALUES (?, ?)", worker, seq)
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+	report("groupcommit", elapsed)
+
+	s := w.Stats()
+	log.Printf("[stats      ] jobs=%d batches=%d max-batch=%d avg-batch=%.1f", s.Jobs, s.Batches, s.MaxBatch, float64(s.Jobs)/float64(s.Batches))
+
+	// 3. 同じトランザクションの中で失敗した Job の変更のみが取り消される
+	return partialFailure(ctx, db, w)
+}
+
+// parallel は、workers 個のゴルーチンで fn を perWorker 回ずつ実行し、掛かった時間を返す。
+func parallel(fn func(worker, seq int) error) (time.Duration, error) {
+	var (
+		start = time.Now()
+		wg    sync.WaitGroup
+		errs  = make([]error, workers)
+	)
+	for i := range workers {
+		wg.Go(func() {
+			for seq := range perWorker {
+				if err := fn(i, seq); err != nil {
+					errs[i] = err
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	return time.Since(start), errors.Join(errs...)
+}
+
+func report(name string, elapsed time.Duration) {
+	var (
+		rows = workers * perWorker
+	)
+	log.Printf("[%-11s] rows=%d elapsed=%-10v %8.0f rows/s", name, rows, elapsed.Round(time.Millisecond), float64(rows)/elapsed.Seconds())
+}
+
+// partialFailure は、重複する行を INSERT する Job を混ぜて実行する。
+func partialFailure(ctx context.Context, db *sql.DB, w *groupcommit.Writer) error {
+	var (
+		wg   sync.WaitGroup
+		ids  = make([]int64, 3)
+		errs = make([]error, 3)
+		rows = [][2]int{{100, 0}, {0, 0}, {100, 1}} // 2つ目は 1. で登録済み (UNIQUE 制約違反)
+	)
+	for i, r := range rows {
+		wg.Go(func() {
+			errs[i] = w.Do(ctx, func(ctx context.Context, tx groupcommit.Tx) error {
+				result, err := tx.ExecContext(ctx, "INSERT INTO events (Worker, Seq) VALUES (?, ?)", r[0], r[1])
+				if err != nil {
+					return err
+				}
+				ids[i], _ = result.LastInsertId()
+
+				return nil
+			})
+		})
+	}
+	wg.Wait()
+
+	for i, r := range rows {
+		log.Printf("[partial    ] worker=%-3d seq=%d id=%-5d err=%v", r[0], r[1], ids[i], errs[i])
+	}
+
+	var (
+		n int
+	)
+	if err := db.QueryRowContext(ctx, "SELECT count(*) FROM events WHERE Worker = 100").Scan(&n); err != nil {
+		return fmt.Errorf("count: %w", err)
+	}
+	log.Printf("[partial    ] worker=100 rows=%d", n)
+
+	return nil
+}
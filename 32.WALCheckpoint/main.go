@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/devlights/try-golang-db/internal/checkpoint"
+	"github.com/devlights/try-golang-db/internal/dbopen"
+	"github.com/devlights/try-golang-db/internal/pragma"
+)
+
+const (
+	datasource = "./chinook.db"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 32.WALCheckpoint
+//
+// WALモードのチェックポイントを、自動チェックポイントではなくアプリケーション側から実行する。
+//
+// internal/pragma の Server() (13.ConnHook_modernc, 14.ConnHook_mattn と同じPRAGMA) のコメントにある通り、
+// 高負荷環境では wal_autocheckpoint=0 で自動チェックポイントを無効にし、アプリケーション側で
+// sqlite3_wal_checkpoint_v2() (PRAGMA wal_checkpoint) を呼ぶ設計が考えられる。
+//
+// internal/checkpoint の Checkpointer は、ゴルーチンで以下のタイミングにチェックポイントを実行し、
+// busy / log / checkpointed の値を記録する。
+//
+//   - Interval 毎に Mode (既定値 PASSIVE) で実行する
+//   - -wal ファイルが MaxWALSize を超えたら SizeMode (既定値 TRUNCATE) で実行する
+//
+// ここでは、書き込みを続けながら Checkpointer を動かした後、
+// 読み込みトランザクションが残っている間は PASSIVE で全てを書き戻せないことを確認している。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/wal.html#ckpt
+//   - https://www.sqlite.org/pragma.html#pragma_wal_checkpoint
+//   - https://www.sqlite.org/c3ref/wal_checkpoint_v2.html
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 32.WALCheckpoint/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run main.go
+	   [checkpoint] TRUNCATE (size): busy=false log=0 checkpointed=0 wal=1083592B elapsed=8.016ms
+	   [checkpoint] PASSIVE (interval): busy=false log=206 checkpointed=206 wal=848752B elapsed=3.361ms
+	   [checkpoint] TRUNCATE (size): busy=false log=0 checkpointed=0 wal=1079472B elapsed=3.077ms
+	   [checkpoint] TRUNCATE (size): busy=false log=0 checkpointed=0 wal=1157752B elapsed=10.682ms
+	   [checkpoint] PASSIVE (interval): busy=false log=102 checkpointed=102 wal=420272B elapsed=1.61ms
+	   [wal       ] size=576832
+	   [checkpoint] PASSIVE (manual): busy=false log=160 checkpointed=140 wal=659232B elapsed=1.692ms
+	   [reader    ] PASSIVE with open read tx: busy=false log=160 checkpointed=140
+	   [checkpoint] TRUNCATE (manual): busy=false log=0 checkpointed=0 wal=659232B elapsed=2.825ms
+	   [wal       ] size=0
+	   [stats     ] runs=7 busy=0 errors=0 checkpointed=448
+	*/
+}
+
+func run() error {
+	var (
+		ctx     = context.Background()
+		profile = pragma.Server()
+	)
+
+	// 自動チェックポイントを無効にする (コネクション単位の設定のため、全てのコネクションで設定する)
+	for i, s := range profile.Settings {
+		if s.Name == "wal_autocheckpoint" {
+			profile.Settings[i].Value = "0"
+			profile.Settings[i].Required = true
+		}
+	}
+
+	db, err := dbopen.OpenSQLite(datasource, dbopen.Options{Pragmas: &profile})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err = db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS blobs (Id INTEGER PRIMARY KEY, Data BLOB)"); err != nil {
+		return err
+	}
+
+	cp, err := checkpoint.Start(ctx, db, checkpoint.Options{
+		Interval:     300 * time.Millisecond,
+		MaxWALSize:   1 << 20,
+		PollInterval: 10 * time.Millisecond,
+		OnResult: func(r checkpoint.Result) {
+			log.Printf("[checkpoint] %s", r)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer cp.Stop()
+
+	// 1. 書き込みを続ける
+	var (
+		deadline = time.Now().Add(700 * time.Millisecond)
+	)
+	for time.Now().Before(deadline) {
+		if err = write(ctx, db, 20); err != nil {
+			return err
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cp.Stop()
+	log.Printf("[wal       ] size=%d", walSize())
+
+	// 2. 読み込みトランザクションが残っていると、それ以降のフレームは書き戻せない
+	reader, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	var (
+		n int
+	)
+	if err = reader.QueryRowContext(ctx, "SELECT count(*) FROM blobs").Scan(&n); err != nil {
+		return err
+	}
+
+	if err = write(ctx, db, 50); err != nil {
+		return err
+	}
+
+	r, err := cp.Checkpoint(ctx, checkpoint.Passive)
+	if err != nil {
+		return err
+	}
+	log.Printf("[reader    ] PASSIVE with open read tx: busy=%v log=%d checkpointed=%d", r.Busy, r.Log, r.Checkpointed)
+
+	reader.Rollback()
+
+	// 3. 読み込みトランザクションが終わったので、TRUNCATE で全て書き戻し、-wal ファイルを空にする
+	if _, err = cp.Checkpoint(ctx, checkpoint.Truncate); err != nil {
+		return err
+	}
+	log.Printf("[wal       ] size=%d", walSize())
+
+	s := cp.Stats()
+	log.Printf("[stats     ] runs=%d busy=%d errors=%d checkpointed=%d", s.Runs, s.Busy, s.Errors, s.Checkpointed)
+
+	return nil
+}
+
+// write は、1KBの行を rows 件、1つのトランザクションで INSERT する。
+func write(ctx context.Context, db *sql.DB, rows int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		data = []byte(strings.Repeat("x", 1024))
+	)
+	for range rows {
+		if _, err = tx.ExecContext(ctx, "INSERT INTO blobs (Data) VALUES (?)", data); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func walSize() int64 {
+	fi, err := os.Stat(datasource + "-wal")
+	if err != nil {
+		return 0
+	}
+
+	return fi.Size()
+}
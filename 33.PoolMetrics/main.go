@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/devlights/try-golang-db/internal/dbopen"
+	"github.com/devlights/try-golang-db/internal/dbstats"
+)
+
+const (
+	datasource = "./chinook.db"
+	varName    = "sqlstats"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 33.PoolMetrics
+//
+// 複数の *sql.DB のコネクションプールの統計 (sql.DBStats) を、
+// Prometheus のテキスト形式 (/metrics) と expvar (/debug/vars) で公開する。
+//
+// internal/dbstats の Collector に名前を付けて *sql.DB を登録すると、
+// スクレイプの度に db.Stats() を呼び出して、全てのフィールドをメトリクスとして返す。
+//
+// ここでは、SetMaxOpenConns(2), SetMaxIdleConns(1) の *sql.DB で8つのクエリを並行に実行し、
+// コネクション待ち (WaitCount, WaitDuration) とアイドル数の上限で閉じられたコネクション (MaxIdleClosed) を発生させている。
+// HTTPサーバは net/http/httptest で起動している。
+//
+// # REFERENCES
+//   - https://pkg.go.dev/database/sql@go1.26.0#DBStats
+//   - https://pkg.go.dev/net/http/httptest@go1.26.0#NewServer
+//   - https://pkg.go.dev/expvar@go1.26.0
+//   - https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 33.PoolMetrics/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run main.go
+	   GET /metrics (text/plain; version=0.0.4; charset=utf-8)
+	     # TYPE go_sql_max_open_connections gauge
+	     go_sql_max_open_connections{db_name="chinook"} 2
+	     go_sql_max_open_connections{db_name="memory"} 1
+	     # TYPE go_sql_open_connections gauge
+	     go_sql_open_connections{db_name="chinook"} 1
+	     go_sql_open_connections{db_name="memory"} 1
+	     # TYPE go_sql_in_use_connections gauge
+	     go_sql_in_use_connections{db_name="chinook"} 0
+	     go_sql_in_use_connections{db_name="memory"} 0
+	     # TYPE go_sql_idle_connections gauge
+	     go_sql_idle_connections{db_name="chinook"} 1
+	     go_sql_idle_connections{db_name="memory"} 1
+	     # TYPE go_sql_wait_count_total counter
+	     go_sql_wait_count_total{db_name="chinook"} 6
+	     go_sql_wait_count_total{db_name="memory"} 0
+	     # TYPE go_sql_wait_duration_seconds_total counter
+	     go_sql_wait_duration_seconds_total{db_name="chinook"} 0.126330031
+	     go_sql_wait_duration_seconds_total{db_name="memory"} 0
+	     # TYPE go_sql_max_idle_closed_total counter
+	     go_sql_max_idle_closed_total{db_name="chinook"} 1
+	     go_sql_max_idle_closed_total{db_name="memory"} 0
+	     # TYPE go_sql_max_idle_time_closed_total counter
+	     go_sql_max_idle_time_closed_total{db_name="chinook"} 0
+	     go_sql_max_idle_time_closed_total{db_name="memory"} 0
+	     # TYPE go_sql_max_lifetime_closed_total counter
+	     go_sql_max_lifetime_closed_total{db_name="chinook"} 0
+	     go_sql_max_lifetime_closed_total{db_name="memory"} 0
+	   GET /debug/vars (application/json; charset=utf-8)
+	     sqlstats={"chinook":{"MaxOpenConnections":2,"OpenConnections":1,"InUse":0,"Idle":1,"WaitCount":6,"WaitDuration":126330031,"MaxIdleClosed":1,"MaxIdleTimeClosed":0,"MaxLifetimeClosed":0},"memory":{"MaxOpenConnections":1,"OpenConnections":1,"InUse":0,"Idle":1,"WaitCount":0,"WaitDuration":0,"MaxIdleClosed":0,"MaxIdleTimeClosed":0,"MaxLifetimeClosed":0}}
+	*/
+}
+
+func run() error {
+	var (
+		ctx       = context.Background()
+		collector = dbstats.New()
+	)
+
+	chinook, err := dbopen.OpenSQLite(datasource, dbopen.Options{})
+	if err != nil {
+		return err
+	}
+	defer chinook.Close()
+
+	chinook.SetMaxOpenConns(2)
+	chinook.SetMaxIdleConns(1)
+
+	memory, err := dbopen.OpenSQLite(":memory:", dbopen.Options{})
+	if err != nil {
+		return err
+	}
+	defer memory.Close()
+
+	memory.SetMaxOpenConns(1)
+
+	for name, db := range map[string]*sql.DB{"chinook": chinook, "memory": memory} {
+		if err = collector.Add(name, db); err != nil {
+			return err
+		}
+	}
+	if err = collector.Publish(varName); err != nil {
+		return err
+	}
+
+	// 負荷をかける
+	if err = load(ctx, chinook, 8); err != nil {
+		return err
+	}
+	if err = memory.PingContext(ctx); err != nil {
+		return err
+	}
+
+	// /metrics と /debug/vars を公開する
+	var (
+		mux = http.NewServeMux()
+	)
+	mux.Handle("/metrics", collector)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// Prometheus のテキスト形式
+	body, contentType, err := get(srv.URL + "/metrics")
+	if err != nil {
+		return err
+	}
+	log.Printf("GET /metrics (%s)", contentType)
+
+	sc := bufio.NewScanner(strings.NewReader(body))
+	for sc.Scan() {
+		// HELP は長いので省略
+		if line := sc.Text(); !strings.HasPrefix(line, "# HELP") {
+			log.Printf("  %s", line)
+		}
+	}
+
+	// expvar (cmdline, memstats なども含まれるため、登録した変数のみ表示)
+	body, contentType, err = get(srv.URL + "/debug/vars")
+	if err != nil {
+		return err
+	}
+	log.Printf("GET /debug/vars (%s)", contentType)
+
+	var (
+		vars map[string]json.RawMessage
+	)
+	if err = json.Unmarshal([]byte(body), &vars); err != nil {
+		return err
+	}
+	log.Printf("  %s=%s", varName, vars[varName])
+
+	return nil
+}
+
+// load は、db で n 個のクエリを並行に実行する。
+func load(ctx context.Context, db *sql.DB, n int) error {
+	const (
+		query = `SELECT count(*) FROM tracks t1 JOIN tracks t2 ON t2.AlbumId = t1.AlbumId`
+	)
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, n)
+	)
+	for i := range n {
+		wg.Go(func() {
+			var (
+				count int
+			)
+			errs[i] = db.QueryRowContext(ctx, query).Scan(&count)
+		})
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("query #%d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func get(url string) (body, contentType string, err error) {
+	res, err := http.Get(url)
+	if err != nil {
+		return "", "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	return string(b), res.Header.Get("Content-Type"), nil
+}
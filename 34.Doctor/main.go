@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/devlights/try-golang-db/internal/dbopen"
+	"github.com/devlights/try-golang-db/internal/doctor"
+	"github.com/devlights/try-golang-db/internal/pragma"
+	_ "github.com/lib/pq"
+)
+
+const (
+	exitOK      = 0
+	exitProblem = 1
+	exitError   = 2
+)
+
+var (
+	driverName  = flag.String("driver", "sqlite3", "ドライバ (sqlite3, sqlite, postgres)")
+	dsn         = flag.String("dsn", "./chinook.db", "接続文字列 (SQLite の場合はファイル名または URI)")
+	format      = flag.String("format", "text", "出力形式 (text, json)")
+	profileName = flag.String("profile", "server", "実際の値を確認するPRAGMAのプロファイル名 (SQLite のみ。空の場合は確認しない)")
+	longTx      = flag.Duration("long-tx", 5*time.Minute, "長時間実行中とみなすトランザクションの時間 (PostgreSQL のみ)")
+	timeout     = flag.Duration("timeout", time.Minute, "診断全体のタイムアウト")
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 34.Doctor
+//
+// データベースの状態を診断するコマンド。
+//
+// internal/doctor を利用して、SQLite では以下を確認する。
+//
+//   - PRAGMA integrity_check, quick_check, foreign_key_check
+//   - journal_mode, page_size, page_count, freelist_count, -wal ファイルのサイズ
+//   - 13.ConnHook_modernc, 14.ConnHook_mattn と同じ pragma.Server() の各PRAGMAの実際の値
+//
+// PostgreSQL では、バージョン、コネクション数、データベースのサイズ、長時間実行中のトランザクションを確認する。
+//
+//	$ go run main.go -driver postgres -dsn "host=localhost port=5432 user=postgres password=postgres dbname=postgres sslmode=disable"
+//
+// 結果はテキストまたは JSON で標準出力に出力する。終了コードは以下の通り。
+//
+//   - 0: 問題なし (warn のみの場合を含む)
+//   - 1: fail の項目がある
+//   - 2: 診断を実行できなかった (接続できないなど)
+//
+// 診断用のコネクションにはプロファイルを適用しないため (journal_mode=WAL などでDBファイルを変更しないため)、
+// コネクション毎の設定 (synchronous, cache_size など) は既定値のまま warn となる。
+// SQLite はDBファイルが存在しないと新しく作成してしまうため、読み込み専用 (mode=ro) で開いている。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/pragma.html#pragma_integrity_check
+//   - https://www.sqlite.org/pragma.html#pragma_foreign_key_check
+//   - https://www.postgresql.org/docs/current/monitoring-stats.html#MONITORING-PG-STAT-ACTIVITY-VIEW
+func main() {
+	flag.Parse()
+	os.Exit(run())
+
+	/*
+	   $ task -d 34.Doctor/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run main.go
+	   driver: *sqlite3.SQLiteDriver
+	   [ok]    integrity_check                     ok
+	   [ok]    quick_check                         ok
+	   [ok]    foreign_key_check                   ok
+	   [ok]    journal_mode                        delete
+	   [ok]    page_size                           4096
+	   [ok]    page_count                          74  (303104 bytes)
+	   [ok]    freelist_count                      0
+	   [ok]    wal_size                            0  (no -wal file)
+	   [warn]  pragma journal_mode (server)        delete  (want WAL)
+	   [ok]    pragma synchronous (server)         NORMAL
+	   [warn]  pragma busy_timeout (server)        5000  (want 2000)
+	   [warn]  pragma cache_size (server)          -2000  (want -32000)
+	   [warn]  pragma temp_store (server)          0  (want MEMORY)
+	   [warn]  pragma mmap_size (server)           0  (want 268435456)
+	   [ok]    pragma wal_autocheckpoint (server)  1000
+	   ok=10 warn=5 fail=0
+	   task: [default] go run main.go -format json -profile ""
+	   {
+	     "driver": "*sqlite3.SQLiteDriver",
+	     "checks": [
+	       {
+	         "name": "integrity_check",
+	         "status": "ok",
+	         "value": "ok"
+	       },
+	       {
+	         "name": "quick_check",
+	         "status": "ok",
+	         "value": "ok"
+	       },
+	       {
+	         "name": "foreign_key_check",
+	         "status": "ok",
+	         "value": "ok"
+	       },
+	       {
+	         "name": "journal_mode",
+	         "status": "ok",
+	         "value": "delete"
+	       },
+	       {
+	         "name": "page_size",
+	         "status": "ok",
+	         "value": "4096"
+	       },
+	       {
+	         "name": "page_count",
+	         "status": "ok",
+	         "value": "74",
+	         "detail": "303104 bytes"
+	       },
+	       {
+	         "name": "freelist_count",
+	         "status": "ok",
+	         "value": "0"
+	       },
+	       {
+	         "name": "wal_size",
+	         "status": "ok",
+	         "value": "0",
+	         "detail": "no -wal file"
+	       }
+	     ],
+	     "healthy": true
+	   }
+	*/
+}
+
+func run() int {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), *timeout)
+	)
+	defer cancel()
+
+	report, err := diagnose(ctx)
+	if err != nil {
+		log.Printf("doctor: %v", err)
+		return exitError
+	}
+
+	switch *format {
+	case "json":
+		err = report.WriteJSON(os.Stdout)
+	default:
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		log.Printf("doctor: %v", err)
+		return exitError
+	}
+
+	if !report.Healthy() {
+		return exitProblem
+	}
+
+	return exitOK
+}
+
+func diagnose(ctx context.Context) (*doctor.Report, error) {
+	if *format != "text" && *format != "json" {
+		return nil, fmt.Errorf("unknown format %q", *format)
+	}
+
+	switch *driverName {
+	case "postgres":
+		db, err := sql.Open("postgres", *dsn)
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+
+		return doctor.Postgres(ctx, db, doctor.PostgresOptions{LongTransaction: *longTx})
+	case "sqlite3", "sqlite":
+		var (
+			opts = doctor.SQLiteOptions{}
+			drv  = dbopen.Mattn
+		)
+		if *driverName == "sqlite" {
+			drv = dbopen.Modernc
+		}
+		if *profileName != "" {
+			p, err := pragma.Lookup(*profileName)
+			if err != nil {
+				return nil, err
+			}
+			opts.Profile = &p
+		}
+
+		db, err := dbopen.OpenSQLite(readOnly(*dsn), dbopen.Options{Driver: drv})
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+
+		return doctor.SQLite(ctx, db, opts)
+	}
+
+	return nil, fmt.Errorf("unknown driver %q", *driverName)
+}
+
+// readOnly は、ファイル名を読み込み専用の URI にする。既に URI の場合はそのまま返す。
+func readOnly(path string) string {
+	if len(path) >= 5 && path[:5] == "file:" {
+		return path
+	}
+
+	return "file:" + path + "?mode=ro"
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/devlights/try-golang-db/internal/dbopen"
+	"github.com/devlights/try-golang-db/internal/pragma"
+	"github.com/devlights/try-golang-db/internal/stress"
+)
+
+var (
+	duration   = flag.Duration("duration", time.Second, "設定毎に負荷をかける時間")
+	readers    = flag.Int("readers", 4, "読み込みゴルーチンの数 (プロセス毎)")
+	writers    = flag.Int("writers", 4, "書き込みゴルーチンの数 (プロセス毎)")
+	procs      = flag.Int("procs", 2, "プロセス間の競合を測定する設定で起動する子プロセスの数")
+	useModernc = flag.Bool("modernc", false, "modernc.org/sqlite を利用する (既定は mattn/go-sqlite3)")
+)
+
+// 35.LockContention
+//
+// SQLite のロック競合 (database is locked) が、PRAGMA やプールの設定によってどう変わるかを測定する。
+//
+// 13.ConnHook_modernc, 14.ConnHook_mattn のコメントでは、コネクションプールから複数のコネクションで書き込むと
+// SQLITE_BUSY が発生する理由を説明しているが、実際にどの程度発生するのかは測っていなかった。
+//
+// internal/stress で、読み込みと書き込みのゴルーチン (と子プロセス) から負荷をかけ、
+// スループット・レイテンシのパーセンタイル・BUSY/LOCKED の数を設定毎に集計する。
+// 書き込みは、1つのトランザクションの中で SELECT してから INSERT している。
+//
+//   - delete/deferred    : PRAGMA 未設定 (ロールバックジャーナル)、BEGIN DEFERRED
+//   - wal/deferred       : pragma.Server() (WAL, busy_timeout=2000)、BEGIN DEFERRED
+//   - wal/immediate      : pragma.Server()、BEGIN IMMEDIATE (_txlock=immediate)
+//   - wal/immediate/1conn: 上に加えて SetMaxOpenConns(1) (13/14 と同じ)
+//   - wal/immediate/procs: wal/immediate に加えて、同じ負荷をかける子プロセスを起動する
+//
+// BEGIN DEFERRED では、読み込みから書き込みへの昇格時に他のコネクションが書き込んでいると、
+// busy_timeout で待たずに SQLITE_BUSY になる。BEGIN IMMEDIATE では、開始時に書き込みロックを取るため
+// busy_timeout の間待つことができ、BUSY は発生しなくなる (その分、待ち時間がレイテンシに表れる)。
+//
+// PRAGMA journal_mode=WAL はDBファイルに永続化されるため、設定毎に新しいDBファイルを作成している。
+// mattn/go-sqlite3 は既定で busy_timeout=5000 だが、modernc.org/sqlite は 0 (待たずに BUSY) である。
+// -modernc で実行すると、delete/deferred では読み込みもほとんど BUSY になる。
+//
+// 結果は実行環境 (CPU数、ストレージ) に大きく依存する。以下は 1CPU の環境での結果。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/lockingv3.html
+//   - https://www.sqlite.org/wal.html#concurrency
+//   - https://www.sqlite.org/rescode.html#busy
+//   - https://www.sqlite.org/lang_transaction.html#deferred_immediate_and_exclusive_transactions
+func main() {
+	log.SetFlags(0)
+
+	// 子プロセスとして起動された場合は、負荷をかけて結果を返すだけ
+	if stress.IsChild() {
+		os.Exit(stress.ChildMain())
+	}
+
+	flag.Parse()
+
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 35.LockContention/
+	   task: [default] go run main.go
+	   CONFIG               OP     ops/s  p50    p90    p99      max       BUSY  LOCKED  OTHER
+	   delete/deferred      read   3814   23µs   29µs   121µs    329.1ms   0     0       0
+	   delete/deferred      write  1444   466µs  566µs  1.92ms   306.58ms  3064  0       0
+	   wal/deferred         read   8911   27µs   52µs   223µs    498.49ms  0     0       0
+	   wal/deferred         write  7477   44µs   61µs   302µs    190.77ms  8667  0       0
+	   wal/immediate        read   34364  24µs   30µs   58µs     776.17ms  0     0       0
+	   wal/immediate        write  1234   39µs   60µs   635µs    583.27ms  0     0       0
+	   wal/immediate/1conn  read   9196   282µs  880µs  2.88ms   13.57ms   0     0       0
+	   wal/immediate/1conn  write  8954   293µs  901µs  3.12ms   10.91ms   0     0       0
+	   wal/immediate/procs  read   27895  25µs   41µs   10.86ms  139.42ms  0     0       0
+	   wal/immediate/procs  write  1737   46µs   69µs   73.94ms  918.44ms  0     0       0
+	*/
+}
+
+func run() error {
+	var (
+		ctx     = context.Background()
+		drv     = dbopen.Mattn
+		server  = pragma.Server()
+		configs = []stress.Config{
+			{Name: "delete/deferred"},
+			{Name: "wal/deferred", Profile: &server},
+			{Name: "wal/immediate", Profile: &server, TxLock: "immediate"},
+			{Name: "wal/immediate/1conn", Profile: &server, TxLock: "immediate", MaxOpenConns: 1},
+			{Name: "wal/immediate/procs", Profile: &server, TxLock: "immediate", Processes: *procs},
+		}
+		results []stress.Result
+	)
+	if *useModernc {
+		drv = dbopen.Modernc
+	}
+
+	dir, err := os.MkdirTemp("", "lock-contention-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	for i, cfg := range configs {
+		cfg.Driver = drv
+		cfg.Readers = *readers
+		cfg.Writers = *writers
+		cfg.Duration = *duration
+
+		var (
+			path = filepath.Join(dir, fmt.Sprintf("stress-%d.db", i))
+		)
+		if err = stress.Setup(ctx, path, drv); err != nil {
+			return err
+		}
+
+		r, err := stress.Run(ctx, path, cfg)
+		if err != nil {
+			return err
+		}
+		results = append(results, r)
+	}
+
+	report(results)
+
+	return nil
+}
+
+func report(results []stress.Result) {
+	var (
+		tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	)
+	defer tw.Flush()
+
+	fmt.Fprintln(tw, "CONFIG\tOP\tops/s\tp50\tp90\tp99\tmax\tBUSY\tLOCKED\tOTHER")
+	for _, r := range results {
+		for _, o := range []struct {
+			name string
+			op   stress.Op
+		}{
+			{"read", r.Read},
+			{"write", r.Write},
+		} {
+			fmt.Fprintf(tw, "%s\t%s\t%.0f\t%v\t%v\t%v\t%v\t%d\t%d\t%d\n",
+				r.Config.Name, o.name, o.op.PerSec,
+				round(o.op.P50), round(o.op.P90), round(o.op.P99), round(o.op.Max),
+				o.op.Busy, o.op.Locked, o.op.Other)
+			if o.op.LastError != "" {
+				log.Printf("%s/%s: %s", r.Config.Name, o.name, o.op.LastError)
+			}
+		}
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
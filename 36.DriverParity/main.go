@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/devlights/try-golang-db/internal/dbopen"
+	"github.com/devlights/try-golang-db/internal/parity"
+)
+
+const (
+	fixture = "./chinook.db"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// 36.DriverParity
+//
+// 同じクエリを mattn/go-sqlite3 と modernc.org/sqlite で実行し、結果の違いを報告する。
+//
+// 本リポジトリでは、cgo が利用できるかどうかで2つのドライバを使い分けている。
+// ドライバを切り替えた時に挙動が変わる箇所を把握しておくため、internal/parity で以下を比較している。
+//
+//   - 番号付きのサンプルで利用しているクエリの結果 (含めていないサンプルとその理由は internal/parity/cases.go に記載)
+//   - any で Scan した時の Go の型と値
+//   - ColumnTypes の内容 (10.ColumnTypes)
+//   - 複数の文を含むクエリ (11.NextResultSet)
+//   - time.Time の書き込みと読み込み
+//   - エラーの型とエラーコード
+//
+// Exec でデータを変更するクエリがあるため、フィクスチャ (chinook.db) をドライバ毎に一時ディレクトリにコピーして利用している。
+// 一時テーブルを利用するクエリがあるため、どちらも SetMaxOpenConns(1) としている。
+//
+// 主な違いは以下の通り (値そのものは、時刻以外はどちらも同じ)。
+//
+//   - ScanType: mattn は宣言された型から sql.NullInt64 などを返し、式の列は *interface {} となる。
+//     modernc は1行目の値の型から int64 などを返し、行が無い場合や NULL の場合は nil となる
+//   - time.Time のパラメータ: mattn は "2006-01-02 15:04:05.999999999-07:00" の形式で、
+//     modernc は time.Time.String() の形式で文字列として保存する。
+//     そのため、modernc で保存した値は datetime() などの日付関数で扱えない (NULL になる)
+//   - エラー: mattn は sqlite3.Error (値)、modernc は *sqlite.Error (ポインタ) で、メッセージの形式も異なる。
+//     エラーコードはどちらも同じ値が得られる
+//
+// # REFERENCES
+//   - https://pkg.go.dev/github.com/mattn/go-sqlite3#readme-supported-types
+//   - https://pkg.go.dev/modernc.org/sqlite#hdr-Supported_types
+//   - https://pkg.go.dev/database/sql@go1.26.0#ColumnType
+func main() {
+	if err := run(); err != nil {
+		log.Panic(err)
+	}
+
+	/*
+	   $ task -d 36.DriverParity/
+	   task: [default] cp -f ../chinook.db .
+	   task: [default] go run main.go
+	   [diff] 02.Query: SELECT ArtistId, Name FROM artists ORDER BY ArtistId DESC LIMIT 5
+	       column ArtistId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Name.ScanType: mattn=sql.NullString modernc=string
+	       column Name.Length: mattn=- modernc=9223372036854775807
+	   [diff] 03.QueryRow: SELECT ArtistId, Name FROM artists ORDER BY ArtistId DESC LIMIT 5
+	       column ArtistId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Name.ScanType: mattn=sql.NullString modernc=string
+	       column Name.Length: mattn=- modernc=9223372036854775807
+	   [diff] 06.PreparedQuery: SELECT * FROM artists WHERE ArtistId = ?
+	       column ArtistId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Name.ScanType: mattn=sql.NullString modernc=string
+	       column Name.Length: mattn=- modernc=9223372036854775807
+	   [diff] 08.Conn: SELECT Name from artists
+	       column Name.ScanType: mattn=sql.NullString modernc=string
+	       column Name.Length: mattn=- modernc=9223372036854775807
+	   [diff] 09.Columns: SELECT * FROM tracks LIMIT 1
+	       column TrackId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Name.ScanType: mattn=sql.NullString modernc=string
+	       column Name.Length: mattn=- modernc=9223372036854775807
+	       column AlbumId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column MediaTypeId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column GenreId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Composer.ScanType: mattn=sql.NullString modernc=string
+	       column Composer.Length: mattn=- modernc=9223372036854775807
+	       column Milliseconds.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Bytes.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column UnitPrice.ScanType: mattn=*interface {} modernc=float64
+	   [diff] 10.ColumnTypes: SELECT * FROM tracks LIMIT 1
+	       column TrackId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Name.ScanType: mattn=sql.NullString modernc=string
+	       column Name.Length: mattn=- modernc=9223372036854775807
+	       column AlbumId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column MediaTypeId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column GenreId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Composer.ScanType: mattn=sql.NullString modernc=string
+	       column Composer.Length: mattn=- modernc=9223372036854775807
+	       column Milliseconds.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Bytes.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column UnitPrice.ScanType: mattn=*interface {} modernc=float64
+	   [diff] 11.NextResultSet: SELECT ArtistId,Name FROM artists LIMIT 2;SELECT TrackId,Name FROM tracks LIMIT 2;
+	       column TrackId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Name.ScanType: mattn=sql.NullString modernc=string
+	       column Name.Length: mattn=- modernc=9223372036854775807
+	   [diff] 12.RowsScanDynamic: SELECT ArtistId, Name FROM artists ORDER BY ArtistId DESC LIMIT 5
+	       column ArtistId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Name.ScanType: mattn=sql.NullString modernc=string
+	       column Name.Length: mattn=- modernc=9223372036854775807
+	   [same] 13.ConnHook_modernc/14.ConnHook_mattn: PRAGMA busy_timeout = 2000
+	   [diff] 13.ConnHook_modernc/14.ConnHook_mattn: SELECT * FROM pragma_busy_timeout, pragma_journal_mode, pragma_foreign_keys
+	       column timeout.ScanType: mattn=*interface {} modernc=int64
+	       column journal_mode.ScanType: mattn=*interface {} modernc=string
+	       column journal_mode.Length: mattn=- modernc=9223372036854775807
+	       column foreign_keys.ScanType: mattn=*interface {} modernc=int64
+	   [diff] 16.DriverBenchmark: SELECT TrackId, Name FROM tracks WHERE TrackId = ?
+	       column TrackId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Name.ScanType: mattn=sql.NullString modernc=string
+	       column Name.Length: mattn=- modernc=9223372036854775807
+	   [diff] 16.DriverBenchmark: SELECT TrackId, Name, Composer, Milliseconds, UnitPrice FROM tracks WHERE TrackId BETWEEN ? AND ? ORDER BY TrackId
+	       column TrackId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Name.ScanType: mattn=sql.NullString modernc=string
+	       column Name.Length: mattn=- modernc=9223372036854775807
+	       column Composer.ScanType: mattn=sql.NullString modernc=string
+	       column Composer.Length: mattn=- modernc=9223372036854775807
+	       column Milliseconds.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column UnitPrice.ScanType: mattn=*interface {} modernc=float64
+	   [diff] 17.Session: SELECT count(*) - 2 FROM pragma_database_list
+	       column count(*) - 2.ScanType: mattn=*interface {} modernc=int64
+	   [diff] 18.OnlineBackup: SELECT count(*) FROM artists
+	       column count(*).ScanType: mattn=*interface {} modernc=int64
+	   [diff] 21.AggregateFunctions: SELECT strftime('%Y', InvoiceDate) AS y, count(*), round(sum(Total), 2) FROM invoices GROUP BY y ORDER BY y
+	       column y.ScanType: mattn=*interface {} modernc=string
+	       column y.Length: mattn=- modernc=9223372036854775807
+	       column count(*).ScanType: mattn=*interface {} modernc=int64
+	       column round(sum(Total), 2).ScanType: mattn=*interface {} modernc=float64
+	   [same] 04.Exec: INSERT INTO artists (ArtistId, Name) VALUES (?, ?)
+	   [same] 04.Exec: DELETE FROM artists WHERE ArtistId = ?
+	   [same] 05.Transaction: INSERT INTO artists (ArtistId, Name) VALUES (?, ?)
+	   [same] 07.PreparedQueryInTx: INSERT INTO artists (ArtistId, Name) VALUES (?, ?)
+	   [diff] 07.PreparedQueryInTx: SELECT * FROM artists ORDER BY ArtistId DESC LIMIT 10
+	       column ArtistId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Name.ScanType: mattn=sql.NullString modernc=string
+	       column Name.Length: mattn=- modernc=9223372036854775807
+	   [same] 05.Transaction: DELETE FROM artists WHERE ArtistId IN (990, 991)
+	   [diff] types: SELECT 1, 1.5, 'text', x'00ff', NULL, 1 = 1, 9223372036854775807
+	       column 1.ScanType: mattn=*interface {} modernc=int64
+	       column 1.5.ScanType: mattn=*interface {} modernc=float64
+	       column 'text'.ScanType: mattn=*interface {} modernc=string
+	       column 'text'.Length: mattn=- modernc=9223372036854775807
+	       column x'00ff'.ScanType: mattn=*interface {} modernc=[]uint8
+	       column x'00ff'.Length: mattn=- modernc=9223372036854775807
+	       column NULL.ScanType: mattn=*interface {} modernc=-
+	       column 1 = 1.ScanType: mattn=*interface {} modernc=int64
+	       column 9223372036854775807.ScanType: mattn=*interface {} modernc=int64
+	   [diff] types: SELECT UnitPrice, Milliseconds, Composer, NULL FROM tracks LIMIT 1
+	       column UnitPrice.ScanType: mattn=*interface {} modernc=float64
+	       column Milliseconds.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column Composer.ScanType: mattn=sql.NullString modernc=string
+	       column Composer.Length: mattn=- modernc=9223372036854775807
+	       column NULL.ScanType: mattn=*interface {} modernc=-
+	   [diff] types: SELECT ?, ?, ?, ?
+	       column ?.ScanType: mattn=*interface {} modernc=int64
+	       column ?.ScanType: mattn=*interface {} modernc=int64
+	       column ?.ScanType: mattn=*interface {} modernc=float64
+	       column ?.ScanType: mattn=*interface {} modernc=[]uint8
+	       column ?.Length: mattn=- modernc=9223372036854775807
+	   [same] types: SELECT typeof(?), typeof(?)
+	   [diff] time: SELECT InvoiceId, InvoiceDate FROM invoices ORDER BY InvoiceId LIMIT 2
+	       column InvoiceId.ScanType: mattn=sql.NullInt64 modernc=int64
+	       column InvoiceDate.ScanType: mattn=sql.NullTime modernc=string
+	       column InvoiceDate.Length: mattn=- modernc=9223372036854775807
+	   [diff] time: SELECT ?, typeof(?)
+	       column ?.ScanType: mattn=*interface {} modernc=string
+	       column ?.Length: mattn=- modernc=9223372036854775807
+	       column typeof(?).ScanType: mattn=*interface {} modernc=string
+	       column typeof(?).Length: mattn=- modernc=9223372036854775807
+	       row[0] ?: mattn=string "2024-01-02 03:04:05.6+09:00" modernc=string "2024-01-02 03:04:05.6 +0900 JST"
+	   [same] time: CREATE TEMP TABLE parity_time (at DATETIME, ts TIMESTAMP, d DATE, txt TEXT)
+	   [same] time: INSERT INTO parity_time VALUES (?, ?, ?, ?)
+	   [diff] time: SELECT at, ts, d, txt FROM parity_time
+	       column at.ScanType: mattn=sql.NullTime modernc=string
+	       column at.Length: mattn=- modernc=9223372036854775807
+	       column ts.ScanType: mattn=sql.NullTime modernc=string
+	       column ts.Length: mattn=- modernc=9223372036854775807
+	       column d.ScanType: mattn=sql.NullTime modernc=string
+	       column d.Length: mattn=- modernc=9223372036854775807
+	       column txt.ScanType: mattn=sql.NullString modernc=string
+	       column txt.Length: mattn=- modernc=9223372036854775807
+	       row[0] at: mattn=time.Time 2024-01-02T03:04:05.6+09:00 +0900 modernc=time.Time 2024-01-02T03:04:05.6+09:00 JST
+	       row[0] ts: mattn=time.Time 2024-01-02T03:04:05.6+09:00 +0900 modernc=time.Time 2024-01-02T03:04:05.6+09:00 JST
+	       row[0] d: mattn=time.Time 2024-01-02T03:04:05.6+09:00 +0900 modernc=time.Time 2024-01-02T03:04:05.6+09:00 JST
+	       row[0] txt: mattn=string "2024-01-02 03:04:05.6+09:00" modernc=string "2024-01-02 03:04:05.6 +0900 JST"
+	   [diff] time: SELECT datetime(at), at = datetime(at) FROM parity_time
+	       column datetime(at).ScanType: mattn=*interface {} modernc=-
+	       column at = datetime(at).ScanType: mattn=*interface {} modernc=-
+	       row[0] datetime(at): mattn=string "2024-01-01 18:04:05" modernc=<nil> NULL
+	       row[0] at = datetime(at): mattn=int64 0 modernc=<nil> NULL
+	   [diff] error: SELEC 1
+	       error: mattn=sqlite3.Error (code=1 extended=1): near "SELEC": syntax error modernc=*sqlite.Error (code=1 extended=1): SQL logic error: near "SELEC": syntax error (1)
+	   [diff] error: SELECT * FROM no_such_table
+	       error: mattn=sqlite3.Error (code=1 extended=1): no such table: no_such_table modernc=*sqlite.Error (code=1 extended=1): SQL logic error: no such table: no_such_table (1)
+	   [diff] error: INSERT INTO artists (ArtistId, Name) VALUES (1, 'duplicate')
+	       error: mattn=sqlite3.Error (code=19 extended=1555): UNIQUE constraint failed: artists.ArtistId modernc=*sqlite.Error (code=19 extended=1555): constraint failed: UNIQUE constraint failed: artists.ArtistId (1555)
+	   [same] error: SELECT ?
+	   same=10 diff=25
+	*/
+}
+
+func run() error {
+	var (
+		ctx = context.Background()
+	)
+
+	dir, err := os.MkdirTemp("", "driver-parity-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	mattn, err := open(dir, dbopen.Mattn)
+	if err != nil {
+		return err
+	}
+	defer mattn.Close()
+
+	modernc, err := open(dir, dbopen.Modernc)
+	if err != nil {
+		return err
+	}
+	defer modernc.Close()
+
+	report := parity.Run(ctx, mattn, modernc, parity.Cases())
+
+	return report.WriteText(os.Stdout)
+}
+
+// open は、フィクスチャを dir にコピーし、drv で開く。
+func open(dir string, drv dbopen.Driver) (*sql.DB, error) {
+	var (
+		path = filepath.Join(dir, drv.String()+".db")
+	)
+	if err := copyFile(path, fixture); err != nil {
+		return nil, err
+	}
+
+	db, err := dbopen.OpenSQLite(path, dbopen.Options{Driver: drv})
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,264 @@
+// Package attach は、ATTACH DATABASE を使って複数のSQLiteファイルをまたいだクエリを実行する。
+//
+// SQLiteでは ATTACH DATABASE で別のデータベースファイルに名前 (スキーマ名) を付けて接続し、
+// 以下のように1つのSQLの中で複数のファイルのテーブルを扱える。
+//
+//	SELECT a.Name, r.Stars FROM main.artists a JOIN tenant_a.ratings r ON r.ArtistId = a.ArtistId
+//	INSERT INTO main.ratings SELECT * FROM tenant_a.ratings
+//
+// ただし、ATTACH はコネクション単位の状態である (08.Conn, internal/session を参照)。
+// *sql.DB はコネクションプールのため、あるコネクションで ATTACH しても、次のクエリが別のコネクションで実行されると
+// スキーマ名が見つからずにエラーとなる。
+//
+// Catalog は、アタッチするデータベースの一覧を保持し、
+//
+//   - 接続フック (mattn: ConnectHook, modernc: RegisterConnectionHook) で、新しいコネクションに全てアタッチする
+//   - Conn で、既に開いているコネクションにも、後から追加されたデータベースをアタッチする
+//
+// ことで、プールのどのコネクションでも同じスキーマ名を使えるようにする。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/lang_attach.html
+//   - https://www.sqlite.org/pragma.html#pragma_database_list
+//   - https://www.sqlite.org/limits.html#max_attached
+//   - https://www.sqlite.org/atomiccommit.html#_multi_file_commit
+package attach
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"modernc.org/sqlite"
+)
+
+const (
+	// MaxAttached は、1つのコネクションにアタッチできるデータベースの数の上限 (SQLITE_MAX_ATTACHED の既定値)。
+	MaxAttached = 10
+)
+
+var (
+	// ErrInvalidSchema は、スキーマ名として使えない名前を指定した場合のエラー。
+	ErrInvalidSchema = errors.New("attach: invalid schema name")
+	// ErrUnknownSchema は、Catalog に登録されていないスキーマ名を指定した場合のエラー。
+	ErrUnknownSchema = errors.New("attach: unknown schema")
+)
+
+var (
+	identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// uriEscaper は、URI ファイル名のパス部分で特別な意味を持つ文字をエスケープする。
+	uriEscaper = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+)
+
+type (
+	// Database は、アタッチするデータベース。
+	Database struct {
+		// Alias は、スキーマ名。main, temp は使えない。
+		Alias string
+		// Path は、データベースファイルのパス。
+		Path string
+		// ReadOnly が true の場合は、読み取り専用 (URI の mode=ro) でアタッチする。
+		ReadOnly bool
+	}
+
+	// Catalog は、アタッチするデータベースの一覧。
+	Catalog struct {
+		mu  sync.RWMutex
+		dbs []Database
+	}
+)
+
+// ValidateSchema は、name がアタッチするデータベースのスキーマ名として使えるかを検査する。
+//
+// スキーマ名は SQL にパラメータとして渡せないため、英数字とアンダースコアのみに制限している。
+func ValidateSchema(name string) error {
+	if !identRe.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidSchema, name)
+	}
+	if isReserved(name) {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidSchema, name)
+	}
+
+	return nil
+}
+
+// Add は、アタッチするデータベースを追加する。
+//
+// 追加したデータベースは、以降に開いたコネクションでは接続フックでアタッチされる。
+// 既に開いているコネクションでは、Conn または Sync を通した時にアタッチされる。
+func (c *Catalog) Add(d Database) error {
+	if err := ValidateSchema(d.Alias); err != nil {
+		return err
+	}
+	if d.Path == "" {
+		return fmt.Errorf("attach: %s: empty path", d.Alias)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, x := range c.dbs {
+		if strings.EqualFold(x.Alias, d.Alias) {
+			return fmt.Errorf("%w: %q is already added", ErrInvalidSchema, d.Alias)
+		}
+	}
+	if len(c.dbs) >= MaxAttached {
+		return fmt.Errorf("attach: %s: too many databases (max %d)", d.Alias, MaxAttached)
+	}
+
+	c.dbs = append(c.dbs, d)
+
+	return nil
+}
+
+// Databases は、登録されているデータベースの一覧を返す。
+func (c *Catalog) Databases() []Database {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]Database(nil), c.dbs...)
+}
+
+// Has は、name が登録されているスキーマ名かどうかを返す。
+func (c *Catalog) Has(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, d := range c.dbs {
+		if strings.EqualFold(d.Alias, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Hook は、mattn/go-sqlite3 の新しいコネクションに全てのデータベースをアタッチする。
+//
+// sqlite3.SQLiteDriver の ConnectHook から呼び出す。
+func (c *Catalog) Hook(conn *sqlite3.SQLiteConn) error {
+	return c.attachAll(func(d Database) error {
+		_, err := conn.Exec(d.attachSQL(), []driver.Value{d.uri()})
+		return err
+	})
+}
+
+// ModerncHook は、modernc.org/sqlite の新しいコネクションに全てのデータベースをアタッチする。
+//
+// modernc.org/sqlite の接続フックはプロセスグローバルであるため、
+// sqlite.RegisterConnectionHook(catalog.ModerncHook) とした場合は全ての *sql.DB が対象となる。
+func (c *Catalog) ModerncHook(conn sqlite.ExecQuerierContext, _ string) error {
+	return c.attachAll(func(d Database) error {
+		_, err := conn.ExecContext(context.Background(), d.attachSQL(), []driver.NamedValue{{Ordinal: 1, Value: d.uri()}})
+		return err
+	})
+}
+
+func (c *Catalog) attachAll(attach func(Database) error) error {
+	for _, d := range c.Databases() {
+		if err := attach(d); err != nil {
+			return fmt.Errorf("attach %s: %w", d.Alias, err)
+		}
+	}
+
+	return nil
+}
+
+// Conn は、db からコネクションを1つ取得し、登録されている全てのデータベースがアタッチされた状態で返す。
+//
+// 利用が終わったら Close すること。
+func (c *Catalog) Conn(ctx context.Context, db *sql.DB) (*sql.Conn, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.Sync(ctx, conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Sync は、conn にアタッチされていないデータベースをアタッチする。
+//
+// アタッチ済みかどうかは PRAGMA database_list のスキーマ名で判断する。
+func (c *Catalog) Sync(ctx context.Context, conn *sql.Conn) error {
+	attached, err := databaseList(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range c.Databases() {
+		if attached[strings.ToLower(d.Alias)] {
+			continue
+		}
+
+		if _, err = conn.ExecContext(ctx, d.attachSQL(), d.uri()); err != nil {
+			return fmt.Errorf("attach %s: %w", d.Alias, err)
+		}
+	}
+
+	return nil
+}
+
+// databaseList は、conn にアタッチされているスキーマ名 (小文字) を返す。
+func databaseList(ctx context.Context, conn *sql.Conn) (map[string]bool, error) {
+	rows, err := conn.QueryContext(ctx, "PRAGMA database_list")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		names = make(map[string]bool)
+		seq   int
+		name  string
+		file  sql.NullString
+	)
+	for rows.Next() {
+		if err = rows.Scan(&seq, &name, &file); err != nil {
+			return nil, err
+		}
+		names[strings.ToLower(name)] = true
+	}
+
+	return names, rows.Err()
+}
+
+func (d Database) attachSQL() string {
+	return fmt.Sprintf("ATTACH DATABASE ? AS %s", quote(d.Alias))
+}
+
+// uri は、ATTACH DATABASE に渡すファイル名を返す。
+//
+// ReadOnly の場合は URI ファイル名 (file:path?mode=ro) にする。
+// mattn/go-sqlite3, modernc.org/sqlite はどちらも URI ファイル名を有効にしてデータベースを開くため、ATTACH でも URI が使える。
+func (d Database) uri() string {
+	switch {
+	case !d.ReadOnly:
+		return d.Path
+	case strings.HasPrefix(d.Path, "file:") && strings.Contains(d.Path, "?"):
+		return d.Path + "&mode=ro"
+	case strings.HasPrefix(d.Path, "file:"):
+		return d.Path + "?mode=ro"
+	default:
+		return "file:" + uriEscaper.Replace(d.Path) + "?mode=ro"
+	}
+}
+
+func isReserved(name string) bool {
+	return strings.EqualFold(name, "main") || strings.EqualFold(name, "temp")
+}
+
+func quote(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
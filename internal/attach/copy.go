@@ -0,0 +1,125 @@
+package attach
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+type (
+	// Table は、スキーマ名で修飾したテーブル名。
+	Table struct {
+		Schema string
+		Name   string
+	}
+
+	// Copy は、From のテーブルから To のテーブルへのコピー (INSERT INTO To SELECT ... FROM From)。
+	Copy struct {
+		From Table
+		To   Table
+		// Columns は、コピーする列。空の場合は全ての列 (SELECT *) となり、To と From の列の並びが同じである必要がある。
+		Columns []string
+		// Where は、コピーする行の条件 (WHERE 句の中身)。空の場合は全ての行。
+		Where string
+		// Args は、Where のパラメータ。
+		Args []any
+	}
+)
+
+// T は、schema.name の Table を返す。
+func T(schema, name string) Table {
+	return Table{Schema: schema, Name: name}
+}
+
+func (t Table) String() string {
+	return quote(t.Schema) + "." + quote(t.Name)
+}
+
+// sql は、INSERT INTO ... SELECT 文を生成する。
+func (cp Copy) sql() string {
+	var (
+		sb   strings.Builder
+		cols = "*"
+	)
+	if len(cp.Columns) > 0 {
+		var (
+			quoted = make([]string, len(cp.Columns))
+		)
+		for i, c := range cp.Columns {
+			quoted[i] = quote(c)
+		}
+		cols = strings.Join(quoted, ", ")
+
+		fmt.Fprintf(&sb, "INSERT INTO %s (%s) ", cp.To, cols)
+	} else {
+		fmt.Fprintf(&sb, "INSERT INTO %s ", cp.To)
+	}
+
+	fmt.Fprintf(&sb, "SELECT %s FROM %s", cols, cp.From)
+	if cp.Where != "" {
+		fmt.Fprintf(&sb, " WHERE %s", cp.Where)
+	}
+
+	return sb.String()
+}
+
+// checkSchema は、name が main, temp または登録済みのスキーマ名であるかを検査する。
+func (c *Catalog) checkSchema(name string) error {
+	if isReserved(name) {
+		return nil
+	}
+	if err := ValidateSchema(name); err != nil {
+		return err
+	}
+	if !c.Has(name) {
+		return fmt.Errorf("%w: %q", ErrUnknownSchema, name)
+	}
+
+	return nil
+}
+
+// CopyTx は、1つのトランザクションで copies を順に実行し、コピーした行数を返す。
+//
+// いずれかが失敗した場合は全てロールバックする。
+// conn は Conn で取得したもの (全てのデータベースがアタッチ済み) を渡すこと。
+//
+// 複数のファイルにまたがるトランザクションは、main のジャーナルモードが WAL でなければ全体でアトミックになる。
+// WAL の場合は、ファイル毎にはアトミックだが、クラッシュ時にファイル間で一部だけがコミットされる可能性がある。
+func (c *Catalog) CopyTx(ctx context.Context, conn *sql.Conn, copies ...Copy) ([]int64, error) {
+	for _, cp := range copies {
+		for _, t := range []Table{cp.From, cp.To} {
+			if err := c.checkSchema(t.Schema); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		counts = make([]int64, 0, len(copies))
+	)
+	for _, cp := range copies {
+		result, err := tx.ExecContext(ctx, cp.sql(), cp.Args...)
+		if err != nil {
+			return nil, fmt.Errorf("copy %s -> %s: %w", cp.From, cp.To, err)
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, n)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
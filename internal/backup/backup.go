@@ -0,0 +1,505 @@
+// Package backup は、稼働中のSQLiteデータベースのオンラインバックアップを行う。
+//
+// Taskfile では chinook.db を cp -f でコピーしているが、13/14 のようにWALモードで
+// 書き込みが行われている最中にファイルをコピーすると、-wal ファイルの内容が反映されていなかったり
+// 書き込み途中のページをコピーしてしまったりして、壊れたデータベースになる可能性がある。
+//
+// SQLiteにはオンラインバックアップAPI (sqlite3_backup_*) が用意されており、
+// 書き込み中のデータベースでも一貫性のあるコピーを作成できる。
+// database/sql からは直接利用できないため、*sql.Conn.Raw() でドライバのコネクションを取り出して利用する。
+//
+//   - mattn/go-sqlite3  : *sqlite3.SQLiteConn の Backup()
+//   - modernc.org/sqlite: ドライバのコネクションの NewBackup()
+//
+// どちらも利用できない場合は VACUUM INTO でコピーする。
+// バックアップ後は PRAGMA integrity_check でコピー先を検証する。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/backup.html
+//   - https://www.sqlite.org/c3ref/backup_finish.html
+//   - https://www.sqlite.org/lang_vacuum.html#vacuuminto
+//   - https://pkg.go.dev/database/sql@go1.26.0#Conn.Raw
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"modernc.org/sqlite"
+)
+
+// Method は、バックアップに利用した方法を表す。
+type Method string
+
+const (
+	MethodMattn   Method = "mattn backup API"
+	MethodModernc Method = "modernc backup API"
+	MethodVacuum  Method = "VACUUM INTO"
+)
+
+const (
+	defaultPagesPerStep = 100
+	defaultMaxRestarts  = 10
+)
+
+var (
+	// ErrIntegrity は、コピー先の PRAGMA integrity_check が ok を返さなかった場合のエラー。
+	ErrIntegrity = errors.New("backup: integrity check failed")
+
+	errUnsupported = errors.New("backup: driver does not support the backup API")
+)
+
+type (
+	// Progress は、バックアップの進捗。
+	Progress struct {
+		Remaining int // 残りページ数
+		PageCount int // 全ページ数
+	}
+
+	// Options は、バックアップの設定。
+	Options struct {
+		// PagesPerStep は、1ステップでコピーするページ数。0以下の場合は100。
+		//
+		// 1ステップの間はコピー元の読み取りロックを保持するため、
+		// 小さくするほど書き込み側を待たせる時間が短くなる。
+		PagesPerStep int
+		// Interval は、ステップ間で待機する時間。待機中は他のコネクションが書き込みを行える。
+		Interval time.Duration
+		// MaxRestarts は、コピー元の更新によるバックアップのやり直しを許容する回数。0以下の場合は10。
+		//
+		// バックアップ中に別のコネクションがコピー元を更新すると、SQLiteは次のステップで最初からコピーし直す。
+		// 書き込みが続いている場合は永久に終わらなくなるため、想定ステップ数をこの回数以上超えた場合は
+		// 残りを1ステップ (全ページ) でコピーする。WALモードであれば、その間も書き込みはブロックされない。
+		MaxRestarts int
+		// OnProgress は、ステップ毎に呼び出される。nil の場合は呼び出さない。
+		OnProgress func(Progress)
+		// Overwrite が true の場合、コピー先のファイルが既に存在していれば削除してから作成する。
+		Overwrite bool
+	}
+
+	// Result は、バックアップの結果。
+	Result struct {
+		Method    Method
+		PageCount int
+		Elapsed   time.Duration
+	}
+
+	// moderncConn は、modernc.org/sqlite のコネクションが持つバックアップ用メソッド
+	moderncConn interface {
+		NewBackup(dstUri string) (*sqlite.Backup, error)
+	}
+)
+
+// Backup は、src のメインデータベースを dstPath にコピーする。
+//
+// コピーは dstPath と同じディレクトリの一時ファイルに行い、Verify が成功してから dstPath にリネームする。
+// そのため、ctx がキャンセルされた場合や検証に失敗した場合でも dstPath に壊れたファイルは残らない
+// (Overwrite の場合は既存のファイルもそのまま残る)。
+func Backup(ctx context.Context, src *sql.DB, dstPath string, opts Options) (*Result, error) {
+	if err := checkDest(dstPath, opts.Overwrite); err != nil {
+		return nil, err
+	}
+
+	tmpPath, err := tempPath(dstPath)
+	if err != nil {
+		return nil, err
+	}
+	defer removeFiles(tmpPath)
+
+	var (
+		start  = time.Now()
+		result *Result
+	)
+
+	result, err = backup(ctx, src, tmpPath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = Verify(ctx, src.Driver(), tmpPath); err != nil {
+		return nil, err
+	}
+
+	if opts.Overwrite {
+		if err = removeFiles(dstPath); err != nil {
+			return nil, err
+		}
+	}
+	if err = os.Rename(tmpPath, dstPath); err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+
+	result.Elapsed = time.Since(start)
+
+	return result, nil
+}
+
+// Verify は、path のデータベースに対して PRAGMA integrity_check を実行し、ok 以外であればエラーを返す。
+func Verify(ctx context.Context, drv driver.Driver, path string) error {
+	conn, err := drv.Open(path)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer conn.Close()
+
+	queryer, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return fmt.Errorf("%T does not implement driver.QueryerContext", conn)
+	}
+
+	rows, err := queryer.QueryContext(ctx, "PRAGMA integrity_check", nil)
+	if err != nil {
+		return fmt.Errorf("integrity_check: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		values   = make([]driver.Value, len(rows.Columns()))
+		problems []error
+	)
+	for {
+		if err = rows.Next(values); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return fmt.Errorf("integrity_check: %w", err)
+		}
+
+		msg := toString(values[0])
+		if msg != "ok" {
+			problems = append(problems, errors.New(msg))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.Join(append([]error{ErrIntegrity}, problems...)...)
+	}
+
+	return nil
+}
+
+func backup(ctx context.Context, src *sql.DB, dstPath string, opts Options) (*Result, error) {
+	conn, err := src.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("src.Conn: %w", err)
+	}
+	defer conn.Close()
+
+	var (
+		result = &Result{}
+	)
+
+	err = conn.Raw(func(driverConn any) error {
+		switch c := driverConn.(type) {
+		case *sqlite3.SQLiteConn:
+			result.Method = MethodMattn
+			return backupMattn(ctx, c, dstPath, opts, result)
+		case moderncConn:
+			result.Method = MethodModernc
+			return backupModernc(ctx, c, dstPath, opts, result)
+		default:
+			return errUnsupported
+		}
+	})
+	if !errors.Is(err, errUnsupported) {
+		return result, err
+	}
+
+	// Raw の中で conn を利用するとデッドロックするため、Raw を抜けてから実行する
+	result.Method = MethodVacuum
+	if _, err = conn.ExecContext(ctx, "VACUUM INTO ?", dstPath); err != nil {
+		return nil, fmt.Errorf("VACUUM INTO: %w", err)
+	}
+
+	if err = conn.QueryRowContext(ctx, "PRAGMA page_count").Scan(&result.PageCount); err != nil {
+		return nil, fmt.Errorf("PRAGMA page_count: %w", err)
+	}
+
+	return result, nil
+}
+
+func backupMattn(ctx context.Context, src *sqlite3.SQLiteConn, dstPath string, opts Options, result *Result) error {
+	dc, err := (&sqlite3.SQLiteDriver{}).Open(dstPath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", dstPath, err)
+	}
+	defer dc.Close()
+
+	var (
+		dst = dc.(*sqlite3.SQLiteConn)
+		bk  *sqlite3.SQLiteBackup
+	)
+
+	bk, err = dst.Backup("main", src, "main")
+	if err != nil {
+		return fmt.Errorf("backup init: %w", err)
+	}
+
+	var (
+		pages = pagesPerStep(opts)
+		steps = 0
+		done  bool
+	)
+	for !done {
+		if err = ctx.Err(); err != nil {
+			bk.Finish()
+			return err
+		}
+
+		// コピー元がロックされている場合 (SQLITE_BUSY/SQLITE_LOCKED) は、エラーにならず done=false が返る
+		done, err = bk.Step(pages)
+		if err != nil {
+			bk.Finish()
+			return fmt.Errorf("backup step: %w", err)
+		}
+
+		steps++
+		result.PageCount = bk.PageCount()
+		notify(opts, Progress{Remaining: bk.Remaining(), PageCount: bk.PageCount()})
+
+		if tooManySteps(steps, bk.PageCount(), pages, opts) {
+			pages = -1
+		}
+
+		if !done {
+			if err = wait(ctx, opts.Interval); err != nil {
+				bk.Finish()
+				return err
+			}
+		}
+	}
+
+	if err = bk.Finish(); err != nil {
+		return fmt.Errorf("backup finish: %w", err)
+	}
+
+	return nil
+}
+
+func backupModernc(ctx context.Context, src moderncConn, dstPath string, opts Options, result *Result) error {
+	// modernc の Backup には残りページ数を取得する手段が無いため、事前に全ページ数を取得しておく
+	total, err := pageCount(ctx, src)
+	if err != nil {
+		return err
+	}
+	result.PageCount = total
+
+	var (
+		bk *sqlite.Backup
+	)
+
+	bk, err = src.NewBackup(dstPath)
+	if err != nil {
+		return fmt.Errorf("backup init: %w", err)
+	}
+
+	var (
+		pages  = pagesPerStep(opts)
+		steps  = 0
+		copied = 0
+		more   = true
+	)
+	for more {
+		if err = ctx.Err(); err != nil {
+			bk.Finish()
+			return err
+		}
+
+		more, err = bk.Step(int32(pages))
+		steps++
+		if err != nil {
+			if !isBusy(err) {
+				bk.Finish()
+				return fmt.Errorf("backup step: %w", err)
+			}
+
+			more = true
+		} else {
+			copied = min(copied+pages, total)
+			if !more || pages < 0 {
+				copied = total
+			}
+		}
+
+		notify(opts, Progress{Remaining: total - copied, PageCount: total})
+
+		if tooManySteps(steps, total, pages, opts) {
+			pages = -1
+		}
+
+		if more {
+			if err = wait(ctx, opts.Interval); err != nil {
+				bk.Finish()
+				return err
+			}
+		}
+	}
+
+	// Finish はコピー先のコネクションもクローズする
+	if err = bk.Finish(); err != nil {
+		return fmt.Errorf("backup finish: %w", err)
+	}
+
+	return nil
+}
+
+func pageCount(ctx context.Context, conn any) (int, error) {
+	queryer, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return 0, fmt.Errorf("%T does not implement driver.QueryerContext", conn)
+	}
+
+	rows, err := queryer.QueryContext(ctx, "PRAGMA page_count", nil)
+	if err != nil {
+		return 0, fmt.Errorf("PRAGMA page_count: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		values = make([]driver.Value, 1)
+	)
+	if err = rows.Next(values); err != nil {
+		return 0, fmt.Errorf("PRAGMA page_count: %w", err)
+	}
+
+	n, ok := values[0].(int64)
+	if !ok {
+		return 0, fmt.Errorf("PRAGMA page_count: unexpected type %T", values[0])
+	}
+
+	return int(n), nil
+}
+
+// isBusy は、エラーが SQLITE_BUSY または SQLITE_LOCKED (拡張エラーコードを含む) かどうかを返す。
+func isBusy(err error) bool {
+	var (
+		coder interface{ Code() int }
+	)
+	if !errors.As(err, &coder) {
+		return false
+	}
+
+	switch coder.Code() & 0xff {
+	case 5, 6: // SQLITE_BUSY, SQLITE_LOCKED
+		return true
+	}
+
+	return false
+}
+
+func checkDest(path string, overwrite bool) error {
+	_, err := os.Stat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	case err != nil:
+		return err
+	case !overwrite:
+		return fmt.Errorf("backup: %s already exists", path)
+	}
+
+	return nil
+}
+
+// tempPath は、path と同じディレクトリ (リネームできるように同じファイルシステム) の一時ファイル名を返す。
+//
+// VACUUM INTO は既存のファイルにはコピーできないため、名前を決めた後にファイルは削除しておく。
+func tempPath(path string) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("backup: %w", err)
+	}
+
+	var (
+		name = f.Name()
+	)
+	f.Close()
+
+	if err = os.Remove(name); err != nil {
+		return "", fmt.Errorf("backup: %w", err)
+	}
+
+	return name, nil
+}
+
+func removeFiles(path string) error {
+	var (
+		errs []error
+	)
+	for _, p := range []string{path, path + "-wal", path + "-shm", path + "-journal"} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func pagesPerStep(opts Options) int {
+	if opts.PagesPerStep <= 0 {
+		return defaultPagesPerStep
+	}
+
+	return opts.PagesPerStep
+}
+
+// tooManySteps は、コピー元の更新によるやり直しで想定ステップ数を大きく超えているかどうかを返す。
+func tooManySteps(steps, total, pages int, opts Options) bool {
+	if pages < 0 {
+		return false
+	}
+
+	var (
+		expected    = (total + pages - 1) / pages
+		maxRestarts = opts.MaxRestarts
+	)
+	if maxRestarts <= 0 {
+		maxRestarts = defaultMaxRestarts
+	}
+
+	return steps > expected+maxRestarts
+}
+
+func notify(opts Options, p Progress) {
+	if opts.OnProgress != nil {
+		opts.OnProgress(p)
+	}
+}
+
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	var (
+		timer = time.NewTimer(d)
+	)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func toString(v driver.Value) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	default:
+		return fmt.Sprint(x)
+	}
+}
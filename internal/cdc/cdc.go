@@ -0,0 +1,299 @@
+// Package cdc は、mattn/go-sqlite3 の更新フック・コミットフック・ロールバックフックを使って
+// テーブルの変更 (INSERT/UPDATE/DELETE) をプロセス内で購読する仕組みを提供する。
+//
+// SQLiteの更新フックは、行が変更される度に「どのテーブルのどの rowid が変更されたか」を通知する。
+// ただし、通知の時点ではまだトランザクションは確定しておらず、ロールバックされる可能性がある。
+// また、コミットフックも COMMIT の処理の途中 (書き込みの前) に呼ばれるため、その後で COMMIT が失敗することがある。
+// (ロールバックジャーナルモードで他のコネクションが読み取り中の場合など、SQLITE_BUSY となりトランザクションはそのまま残る)
+//
+// そこで、本パッケージでは変更をコネクション毎にバッファしておき、
+//
+//   - コミットフック     : COMMIT が始まったことを記録する
+//   - 文の実行後         : COMMIT が始まっていて、自動コミットモードに戻っていれば (コミットが完了していれば)
+//     バッファした変更を1トランザクション分 (Tx) としてまとめて購読者に配信する。
+//     戻っていなければ (COMMIT が失敗してトランザクションが残っていれば) 配信せずに再度の COMMIT を待つ
+//   - ロールバックフック : バッファした変更を破棄する
+//
+// とすることで、確定した変更のみを購読者に届ける。
+//
+// 「文の実行後」を知るために、Stream.Driver で mattn/go-sqlite3 のドライバをラップして sql.Register する。
+//
+//	stream := cdc.New()
+//	sql.Register("sqlite3_cdc", stream.Driver(&sqlite3.SQLiteDriver{}))
+//	sub := stream.Subscribe(cdc.Options{Tables: []string{"artists"}})
+//	for tx := range sub.C { ... }
+//
+// SQLiteの仕様上、以下の変更は通知されない。
+//
+//   - WITHOUT ROWID テーブルの変更
+//   - WHERE 句の無い DELETE (truncate 最適化が行われた場合)
+//   - ON CONFLICT REPLACE によって削除された行
+//
+// また、ROLLBACK TO (セーブポイントへの部分的なロールバック) ではロールバックフックが呼ばれないため、
+// 取り消された変更もコミット時に配信される。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/c3ref/update_hook.html
+//   - https://www.sqlite.org/c3ref/commit_hook.html
+//   - https://pkg.go.dev/github.com/mattn/go-sqlite3#SQLiteConn.RegisterUpdateHook
+package cdc
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Op は、変更の種類。
+type Op int
+
+const (
+	Insert Op = sqlite3.SQLITE_INSERT
+	Update Op = sqlite3.SQLITE_UPDATE
+	Delete Op = sqlite3.SQLITE_DELETE
+)
+
+func (o Op) String() string {
+	switch o {
+	case Insert:
+		return "INSERT"
+	case Update:
+		return "UPDATE"
+	case Delete:
+		return "DELETE"
+	default:
+		return fmt.Sprintf("Op(%d)", int(o))
+	}
+}
+
+type (
+	// Change は、1行分の変更。
+	Change struct {
+		Op       Op
+		Database string
+		Table    string
+		RowID    int64
+	}
+
+	// Tx は、コミットされた1トランザクション分の変更。
+	Tx struct {
+		// Seq は、Stream 内でのコミットの通し番号 (1から始まる)。
+		Seq uint64
+		// CommittedAt は、コミットの完了を確認した時刻。
+		CommittedAt time.Time
+		// Changes は、トランザクション内の変更 (発生順)。
+		//
+		// 複数の購読者で共有される場合があるため、変更しないこと。
+		Changes []Change
+	}
+
+	// Options は、購読の設定。
+	Options struct {
+		// Tables は、購読するテーブル名。空の場合は全てのテーブル。
+		Tables []string
+	}
+
+	// Stats は、Stream の統計情報。
+	Stats struct {
+		Committed  uint64 // 配信したトランザクション数 (変更が無いものは含まない)
+		RolledBack uint64 // 破棄したトランザクション数 (変更が無いものは含まない)
+		Changes    uint64 // 配信した変更の数
+	}
+
+	// Stream は、変更の配信元。
+	Stream struct {
+		mu     sync.Mutex
+		subs   []*Subscription
+		seq    uint64
+		closed bool
+
+		committed  atomic.Uint64
+		rolledBack atomic.Uint64
+		changes    atomic.Uint64
+	}
+
+	// Subscription は、購読者。C から Tx を受信する。
+	//
+	// 配信は購読者毎のキューを経由して行うため、受信が遅れても SQLite の処理 (コミット) を待たせることはない。
+	// その代わり、受信しない間はキューが伸び続けるため、不要になったら Close すること。
+	Subscription struct {
+		C <-chan Tx
+
+		tables map[string]bool
+		stream *Stream
+
+		mu     sync.Mutex
+		queue  []Tx
+		notify chan struct{}
+		done   chan struct{}
+		once   sync.Once
+	}
+)
+
+// New は、Stream を生成する。
+func New() *Stream {
+	return &Stream{}
+}
+
+// Subscribe は、購読を開始する。
+func (s *Stream) Subscribe(opts Options) *Subscription {
+	var (
+		c   = make(chan Tx)
+		sub = &Subscription{
+			C:      c,
+			stream: s,
+			notify: make(chan struct{}, 1),
+			done:   make(chan struct{}),
+		}
+	)
+	if len(opts.Tables) > 0 {
+		sub.tables = make(map[string]bool)
+		for _, t := range opts.Tables {
+			sub.tables[t] = true
+		}
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		close(c)
+		s.mu.Unlock()
+		return sub
+	}
+	s.subs = append(s.subs, sub)
+	s.mu.Unlock()
+
+	go sub.pump(c)
+
+	return sub
+}
+
+// Close は、全ての購読を終了する。以降のコミットは配信されない。
+func (s *Stream) Close() {
+	s.mu.Lock()
+	var (
+		subs = s.subs
+	)
+	s.subs = nil
+	s.closed = true
+	s.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.stop()
+	}
+}
+
+// Stats は、統計情報を返す。
+func (s *Stream) Stats() Stats {
+	return Stats{
+		Committed:  s.committed.Load(),
+		RolledBack: s.rolledBack.Load(),
+		Changes:    s.changes.Load(),
+	}
+}
+
+// publish は、コミットされた変更を購読者に配信する。コミットフックから呼ばれる。
+func (s *Stream) publish(changes []Change) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.seq++
+	s.committed.Add(1)
+	s.changes.Add(uint64(len(changes)))
+
+	var (
+		tx = Tx{Seq: s.seq, CommittedAt: time.Now(), Changes: changes}
+	)
+	for _, sub := range s.subs {
+		if filtered, ok := sub.filter(tx); ok {
+			sub.enqueue(filtered)
+		}
+	}
+}
+
+// Close は、購読を終了する。C はクローズされる。
+func (sub *Subscription) Close() {
+	s := sub.stream
+
+	s.mu.Lock()
+	s.subs = slices.DeleteFunc(s.subs, func(x *Subscription) bool { return x == sub })
+	s.mu.Unlock()
+
+	sub.stop()
+}
+
+func (sub *Subscription) stop() {
+	sub.once.Do(func() {
+		close(sub.done)
+	})
+}
+
+// filter は、購読対象のテーブルの変更のみを残した Tx を返す。対象の変更が無い場合は ok=false となる。
+func (sub *Subscription) filter(tx Tx) (Tx, bool) {
+	if sub.tables == nil {
+		return tx, true
+	}
+
+	var (
+		changes []Change
+	)
+	for _, c := range tx.Changes {
+		if sub.tables[c.Table] {
+			changes = append(changes, c)
+		}
+	}
+
+	if len(changes) == 0 {
+		return Tx{}, false
+	}
+
+	tx.Changes = changes
+
+	return tx, true
+}
+
+func (sub *Subscription) enqueue(tx Tx) {
+	sub.mu.Lock()
+	sub.queue = append(sub.queue, tx)
+	sub.mu.Unlock()
+
+	select {
+	case sub.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pump は、キューに溜まった Tx を順に c に送信する。
+func (sub *Subscription) pump(c chan<- Tx) {
+	defer close(c)
+
+	for {
+		sub.mu.Lock()
+		var (
+			queue = sub.queue
+		)
+		sub.queue = nil
+		sub.mu.Unlock()
+
+		for _, tx := range queue {
+			select {
+			case c <- tx:
+			case <-sub.done:
+				return
+			}
+		}
+
+		select {
+		case <-sub.notify:
+		case <-sub.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package cdc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+var (
+	driverSeq atomic.Int64
+)
+
+// open は、stream でラップしたドライバでファイルのDBを開く。
+//
+// ロールバックジャーナルモード (journal_mode=DELETE) で、ロック待ちをしない (_busy_timeout=0) 設定にしておく。
+// この設定では、他のコネクションが読み取り中 (SHARED ロック) の場合に COMMIT が SQLITE_BUSY で失敗する。
+func open(t *testing.T, stream *Stream) *sql.DB {
+	t.Helper()
+
+	var (
+		name = fmt.Sprintf("sqlite3_cdc_test_%d", driverSeq.Add(1))
+		dsn  = "file:" + filepath.Join(t.TempDir(), "test.db") + "?_journal_mode=DELETE&_busy_timeout=0"
+	)
+	sql.Register(name, stream.Driver(&sqlite3.SQLiteDriver{}))
+
+	db, err := sql.Open(name, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err = db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// receive は、sub から Tx を受信する。timeout までに受信できなければ ok=false を返す。
+func receive(sub *Subscription, timeout time.Duration) (Tx, bool) {
+	select {
+	case tx := <-sub.C:
+		return tx, true
+	case <-time.After(timeout):
+		return Tx{}, false
+	}
+}
+
+func TestCommitAndRollback(t *testing.T) {
+	var (
+		stream = New()
+		db     = open(t, stream)
+		sub    = stream.Subscribe(Options{Tables: []string{"items"}})
+	)
+	defer stream.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("INSERT INTO items (name) VALUES ('a'), ('b')"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := receive(sub, time.Second)
+	if !ok {
+		t.Fatal("committed transaction was not delivered")
+	}
+	if len(got.Changes) != 2 || got.Changes[0].Op != Insert || got.Changes[0].Table != "items" {
+		t.Errorf("changes = %v", got.Changes)
+	}
+
+	// ロールバックした変更は配信されない
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("DELETE FROM items WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 自動コミットモードの文、プリペアドステートメント、RETURNING 付きのクエリ
+	if _, err = db.Exec("UPDATE items SET name = 'x' WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := db.Prepare("DELETE FROM items WHERE id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if _, err = stmt.Exec(2); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		id int64
+	)
+	if err = db.QueryRow("INSERT INTO items (name) VALUES ('c') RETURNING id").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []Change{
+		{Op: Update, Database: "main", Table: "items", RowID: 1},
+		{Op: Delete, Database: "main", Table: "items", RowID: 2},
+		{Op: Insert, Database: "main", Table: "items", RowID: id},
+	} {
+		got, ok := receive(sub, time.Second)
+		if !ok {
+			t.Fatalf("%v was not delivered", want)
+		}
+		if len(got.Changes) != 1 || got.Changes[0] != want {
+			t.Errorf("changes = %v, want [%v]", got.Changes, want)
+		}
+	}
+
+	if got, ok := receive(sub, 50*time.Millisecond); ok {
+		t.Errorf("unexpected delivery: %v", got.Changes)
+	}
+
+	st := stream.Stats()
+	if st.Committed != 4 || st.RolledBack != 1 || st.Changes != 5 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+// TestFailedCommit は、コミットフックの後で COMMIT が SQLITE_BUSY で失敗した場合に、
+// 変更が配信されず、再度の COMMIT が成功した時に1回だけ配信されることを確認する。
+func TestFailedCommit(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		stream = New()
+		db     = open(t, stream)
+		sub    = stream.Subscribe(Options{})
+	)
+	defer stream.Close()
+
+	writer, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	reader, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	// 読み取りトランザクションで SHARED ロックを保持しておく
+	if _, err = reader.ExecContext(ctx, "BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		n int
+	)
+	if err = reader.QueryRowContext(ctx, "SELECT count(*) FROM items").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{"BEGIN", "INSERT INTO items (name) VALUES ('busy')"} {
+		if _, err = writer.ExecContext(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// EXCLUSIVE ロックを取得できないため COMMIT は失敗し、トランザクションは残る
+	_, err = writer.ExecContext(ctx, "COMMIT")
+	if code, ok := err.(sqlite3.Error); !ok || code.Code != sqlite3.ErrBusy {
+		t.Fatalf("COMMIT: err = %v, want SQLITE_BUSY", err)
+	}
+	if got, ok := receive(sub, 50*time.Millisecond); ok {
+		t.Fatalf("uncommitted changes were delivered: %v", got.Changes)
+	}
+
+	// 読み取りを終えてから再度 COMMIT すると成功し、変更が配信される
+	if _, err = reader.ExecContext(ctx, "COMMIT"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.ExecContext(ctx, "COMMIT"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := receive(sub, time.Second)
+	if !ok {
+		t.Fatal("changes were not delivered after the retried COMMIT")
+	}
+	if len(got.Changes) != 1 || got.Changes[0].Op != Insert {
+		t.Errorf("changes = %v", got.Changes)
+	}
+	if got, ok := receive(sub, 50*time.Millisecond); ok {
+		t.Errorf("unexpected delivery: %v", got.Changes)
+	}
+}
+
+// TestFailedTxCommit は、sql.Tx の Commit が失敗した場合 (mattn/go-sqlite3 は ROLLBACK する) に配信されないことを確認する。
+func TestFailedTxCommit(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		stream = New()
+		db     = open(t, stream)
+		sub    = stream.Subscribe(Options{})
+	)
+	defer stream.Close()
+
+	reader, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if _, err = reader.ExecContext(ctx, "BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		n int
+	)
+	if err = reader.QueryRowContext(ctx, "SELECT count(*) FROM items").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("INSERT INTO items (name) VALUES ('busy')"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err == nil {
+		t.Fatal("Commit: err = nil, want SQLITE_BUSY")
+	}
+
+	if got, ok := receive(sub, 50*time.Millisecond); ok {
+		t.Errorf("rolled back changes were delivered: %v", got.Changes)
+	}
+	if st := stream.Stats(); st.Committed != 0 || st.RolledBack != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+}
@@ -0,0 +1,210 @@
+package cdc
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+type (
+	// cdcDriver は、コネクションにフックを登録し、文の実行後にコミットの完了を確認するドライバ。
+	cdcDriver struct {
+		stream *Stream
+		base   *sqlite3.SQLiteDriver
+	}
+
+	// conn は、コネクション毎の変更のバッファ。
+	//
+	// フックと文の実行はどちらもコネクションを使用しているゴルーチンから呼ばれるため、ロックは不要。
+	conn struct {
+		*sqlite3.SQLiteConn
+
+		stream  *Stream
+		pending []Change
+		// committing は、コミットフックが呼ばれたが、コミットの完了をまだ確認していないことを表す。
+		committing bool
+	}
+
+	tx struct {
+		driver.Tx
+		c *conn
+	}
+
+	stmt struct {
+		driver.Stmt
+		c *conn
+	}
+
+	rows struct {
+		*sqlite3.SQLiteRows
+		c *conn
+	}
+)
+
+// Driver は、base で開いたコネクションの変更を配信するドライバを返す。sql.Register で登録して利用する。
+//
+// base の ConnectHook は、フックを登録する前に呼ばれる。
+// ConnectHook で更新フック・コミットフック・ロールバックフックを登録しても、本パッケージのフックで置き換えられる。
+func (s *Stream) Driver(base *sqlite3.SQLiteDriver) driver.Driver {
+	if base == nil {
+		base = &sqlite3.SQLiteDriver{}
+	}
+
+	return &cdcDriver{stream: s, base: base}
+}
+
+func (d *cdcDriver) Open(dsn string) (driver.Conn, error) {
+	dc, err := d.base.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		c = &conn{SQLiteConn: dc.(*sqlite3.SQLiteConn), stream: d.stream}
+	)
+
+	c.RegisterUpdateHook(func(op int, db, table string, rowid int64) {
+		c.pending = append(c.pending, Change{Op: Op(op), Database: db, Table: table, RowID: rowid})
+	})
+
+	c.RegisterCommitHook(func() int {
+		c.committing = true
+
+		// 0 以外を返すとコミットがロールバックに変わる
+		return 0
+	})
+
+	c.RegisterRollbackHook(func() {
+		if len(c.pending) > 0 {
+			c.stream.rolledBack.Add(1)
+		}
+		c.pending = nil
+		c.committing = false
+	})
+
+	return c, nil
+}
+
+// settle は、文の実行後に呼び出し、コミットが完了していれば変更を配信する。
+//
+// コミットフックが呼ばれた後で自動コミットモードに戻っていなければ、COMMIT が失敗してトランザクションが残っている。
+// その場合は変更を保持したままにしておき、再度の COMMIT (またはロールバック) を待つ。
+func (c *conn) settle() {
+	if !c.committing {
+		return
+	}
+	c.committing = false
+
+	if !c.AutoCommit() {
+		return
+	}
+
+	if len(c.pending) > 0 {
+		c.stream.publish(c.pending)
+	}
+	c.pending = nil
+}
+
+func (c *conn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	defer c.settle()
+	return c.SQLiteConn.Exec(query, args)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	defer c.settle()
+	return c.SQLiteConn.ExecContext(ctx, query, args)
+}
+
+func (c *conn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	defer c.settle()
+	return c.wrapRows(c.SQLiteConn.Query(query, args))
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	defer c.settle()
+	return c.wrapRows(c.SQLiteConn.QueryContext(ctx, query, args))
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	st, err := c.SQLiteConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stmt{Stmt: st, c: c}, nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	t, err := c.SQLiteConn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tx{Tx: t, c: c}, nil
+}
+
+// wrapRows は、自動コミットモードで変更を行うクエリ (INSERT ... RETURNING) のコミットが
+// 結果を読み終えた時に行われるため、Next と Close の後でも確認するようにする。
+func (c *conn) wrapRows(r driver.Rows, err error) (driver.Rows, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &rows{SQLiteRows: r.(*sqlite3.SQLiteRows), c: c}, nil
+}
+
+func (t *tx) Commit() error {
+	// mattn/go-sqlite3 は COMMIT が失敗した場合に ROLLBACK するため、その場合はロールバックフックで破棄される
+	defer t.c.settle()
+	return t.Tx.Commit()
+}
+
+func (t *tx) Rollback() error {
+	defer t.c.settle()
+	return t.Tx.Rollback()
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	defer s.c.settle()
+	return s.Stmt.Exec(args)
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	defer s.c.settle()
+	return s.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	defer s.c.settle()
+	return s.c.wrapRows(s.Stmt.Query(args))
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	defer s.c.settle()
+	return s.c.wrapRows(s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args))
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	err := r.SQLiteRows.Next(dest)
+	if errors.Is(err, io.EOF) {
+		r.c.settle()
+	}
+
+	return err
+}
+
+func (r *rows) Close() error {
+	defer r.c.settle()
+	return r.SQLiteRows.Close()
+}
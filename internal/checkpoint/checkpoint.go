@@ -0,0 +1,358 @@
+// Package checkpoint は、WALモードのSQLiteのチェックポイントをアプリケーション側から実行する。
+//
+// WALモードでは、書き込みはまず -wal ファイルに追記され、チェックポイントでメインのDBファイルに書き戻される。
+// 既定では、コミット時に -wal ファイルが wal_autocheckpoint ページ (既定値 1000) を超えていれば、
+// そのコミットを行ったコネクションが PASSIVE でチェックポイントを実行する。
+//
+// そのため、書き込みのレイテンシにチェックポイントの時間が含まれたり、
+// 読み込みが途切れない場合にチェックポイントが完了せず -wal ファイルが大きくなり続けたりすることがある。
+// internal/pragma の Server() のコメントにある通り、wal_autocheckpoint=0 で自動チェックポイントを無効にし、
+// アプリケーション側で実行する方法がある。
+//
+// Checkpointer は、ゴルーチンで以下のタイミングに PRAGMA wal_checkpoint(MODE) を実行する。
+//
+//   - Interval 毎 (Mode で実行)
+//   - -wal ファイルのサイズが MaxWALSize を超えた時 (SizeMode で実行)
+//
+// PRAGMA wal_checkpoint は sqlite3_wal_checkpoint_v2() を呼び出し、以下の3つの値を返す。
+//
+//   - busy         : RESTART/TRUNCATE で、読み込み・書き込み中のコネクションがあり完了できなかった場合に 1
+//   - log          : -wal ファイルのフレーム (ページ) 数。WALモードでない場合は -1
+//   - checkpointed : DBファイルに書き戻したフレーム数
+//
+// # REFERENCES
+//   - https://www.sqlite.org/wal.html#ckpt
+//   - https://www.sqlite.org/pragma.html#pragma_wal_checkpoint
+//   - https://www.sqlite.org/c3ref/wal_checkpoint_v2.html
+//   - https://www.sqlite.org/pragma.html#pragma_wal_autocheckpoint
+package checkpoint
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode は、チェックポイントのモード。
+type Mode string
+
+const (
+	// Passive は、読み込み・書き込みを待たずに、書き戻せるところまで書き戻す。
+	Passive Mode = "PASSIVE"
+	// Full は、書き込み中のコネクションを待ってから、全てのフレームを書き戻す (読み込みは待たない)。
+	Full Mode = "FULL"
+	// Restart は、Full に加えて、次の書き込みが -wal ファイルの先頭から始まるように読み込み中のコネクションも待つ。
+	Restart Mode = "RESTART"
+	// Truncate は、Restart に加えて、-wal ファイルのサイズを0にする。
+	Truncate Mode = "TRUNCATE"
+)
+
+// Reason は、チェックポイントを実行した理由。
+type Reason string
+
+const (
+	ReasonInterval Reason = "interval"
+	ReasonSize     Reason = "size"
+	ReasonManual   Reason = "manual"
+)
+
+type (
+	// Options は、Start のオプション。
+	Options struct {
+		// Mode は、Interval 毎に実行するモード。既定値は Passive。
+		Mode Mode
+		// Interval は、定期的に実行する間隔。0 の場合は定期的には実行しない (MaxWALSize とどちらかは必須)。
+		Interval time.Duration
+		// MaxWALSize は、-wal ファイルのサイズの閾値 (バイト)。0 の場合はサイズを監視しない (Interval とどちらかは必須)。
+		MaxWALSize int64
+		// SizeMode は、MaxWALSize を超えた時に実行するモード。既定値は Truncate。
+		SizeMode Mode
+		// PollInterval は、-wal ファイルのサイズを確認する間隔。既定値は 1秒。
+		PollInterval time.Duration
+		// WALPath は、-wal ファイルのパス。空の場合は PRAGMA database_list のメインデータベースのファイル名から求める。
+		WALPath string
+		// OnResult は、チェックポイントを実行する度に呼ばれる。
+		//
+		// Checkpointer のゴルーチン (Checkpoint で実行した場合は呼び出したゴルーチン) から呼ばれる。
+		OnResult func(Result)
+	}
+
+	// Result は、1回のチェックポイントの結果。
+	Result struct {
+		Mode   Mode
+		Reason Reason
+		// Busy は、完了できなかったかどうか (PRAGMA wal_checkpoint の1列目)。
+		Busy bool
+		// Log は、-wal ファイルのフレーム数。
+		Log int
+		// Checkpointed は、DBファイルに書き戻したフレーム数。
+		Checkpointed int
+		// WALSize は、実行前の -wal ファイルのサイズ。ファイル名が分からない場合は -1。
+		WALSize int64
+		// At は、開始した時刻。
+		At time.Time
+		// Elapsed は、掛かった時間。
+		Elapsed time.Duration
+		// Err は、実行に失敗した場合のエラー。
+		Err error
+	}
+
+	// Stats は、Checkpointer の統計。
+	Stats struct {
+		Runs   int64
+		Busy   int64
+		Errors int64
+		// Checkpointed は、各回の checkpointed の合計。
+		//
+		// checkpointed は -wal ファイルの先頭からの数であるため、-wal ファイルが先頭に戻るまでは同じフレームが複数回数えられる。
+		Checkpointed int64
+		Last         Result
+	}
+
+	// Checkpointer は、バックグラウンドでチェックポイントを実行する。
+	Checkpointer struct {
+		db      *sql.DB
+		opts    Options
+		walPath string
+
+		mu    sync.Mutex
+		stats Stats
+
+		cancel context.CancelFunc
+		done   chan struct{}
+		once   sync.Once
+	}
+)
+
+func (r Result) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s (%s): %v", r.Mode, r.Reason, r.Err)
+	}
+
+	return fmt.Sprintf("%s (%s): busy=%v log=%d checkpointed=%d wal=%dB elapsed=%v",
+		r.Mode, r.Reason, r.Busy, r.Log, r.Checkpointed, r.WALSize, r.Elapsed.Round(time.Microsecond))
+}
+
+// Start は、db のチェックポイントを実行するゴルーチンを開始する。
+//
+// 停止するには Stop を呼ぶ。db.Close より前に呼ぶこと (defer の場合は db.Close の defer より後に書く)。
+// Stop を呼ばずに db を閉じた場合も、閉じられたことを検知した時点でゴルーチンは終了する。
+//
+// Interval と MaxWALSize のどちらも指定しない場合は、ゴルーチンが何も実行せず db を閉じたことも検知できないため、エラーを返す。
+func Start(ctx context.Context, db *sql.DB, opts Options) (*Checkpointer, error) {
+	if opts.Interval <= 0 && opts.MaxWALSize <= 0 {
+		return nil, errors.New("checkpoint: Interval or MaxWALSize is required")
+	}
+	if opts.Mode == "" {
+		opts.Mode = Passive
+	}
+	if opts.SizeMode == "" {
+		opts.SizeMode = Truncate
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	for _, m := range []Mode{opts.Mode, opts.SizeMode} {
+		if err := m.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		walPath = opts.WALPath
+	)
+	if walPath == "" {
+		file, err := mainFile(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		if file != "" {
+			walPath = file + "-wal"
+		}
+	}
+	if opts.MaxWALSize > 0 && walPath == "" {
+		return nil, errors.New("checkpoint: MaxWALSize requires a file database")
+	}
+
+	var (
+		loopCtx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+		c               = &Checkpointer{
+			db:      db,
+			opts:    opts,
+			walPath: walPath,
+			cancel:  cancel,
+			done:    make(chan struct{}),
+		}
+	)
+	go c.loop(loopCtx)
+
+	return c, nil
+}
+
+func (m Mode) validate() error {
+	switch m {
+	case Passive, Full, Restart, Truncate:
+		return nil
+	}
+
+	return fmt.Errorf("checkpoint: invalid mode %q", string(m))
+}
+
+// mainFile は、メインデータベースのファイル名を返す。:memory: などファイルが無い場合は空文字。
+func mainFile(ctx context.Context, db *sql.DB) (string, error) {
+	rows, err := db.QueryContext(ctx, "PRAGMA database_list")
+	if err != nil {
+		return "", fmt.Errorf("checkpoint: database_list: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		seq  int
+		name string
+		file sql.NullString
+	)
+	for rows.Next() {
+		if err = rows.Scan(&seq, &name, &file); err != nil {
+			return "", err
+		}
+		if name == "main" {
+			return file.String, nil
+		}
+	}
+
+	return "", rows.Err()
+}
+
+func (c *Checkpointer) loop(ctx context.Context) {
+	defer close(c.done)
+
+	var (
+		interval <-chan time.Time
+		poll     <-chan time.Time
+	)
+	if c.opts.Interval > 0 {
+		t := time.NewTicker(c.opts.Interval)
+		defer t.Stop()
+		interval = t.C
+	}
+	if c.opts.MaxWALSize > 0 {
+		t := time.NewTicker(c.opts.PollInterval)
+		defer t.Stop()
+		poll = t.C
+	}
+
+	for {
+		var (
+			err error
+		)
+		select {
+		case <-ctx.Done():
+			return
+		case <-interval:
+			err = c.run(ctx, c.opts.Mode, ReasonInterval).Err
+		case <-poll:
+			if c.walSize() > c.opts.MaxWALSize {
+				err = c.run(ctx, c.opts.SizeMode, ReasonSize).Err
+			} else {
+				// サイズを確認するだけでは db が閉じられたことが分からないため、Ping で確認する
+				err = c.db.PingContext(ctx)
+			}
+		}
+
+		if dbClosed(err) {
+			return
+		}
+	}
+}
+
+// dbClosed は、err が *sql.DB やコネクションが閉じられたことによるエラーかどうかを返す。
+//
+// *sql.DB を閉じた後のエラー (sql: database is closed) は公開されていないため、メッセージで判定する。
+func dbClosed(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return errors.Is(err, sql.ErrConnDone) || strings.Contains(err.Error(), "sql: database is closed")
+}
+
+// Checkpoint は、mode でチェックポイントを1回実行する。Checkpointer のゴルーチンとは別に、呼び出したゴルーチンで実行する。
+func (c *Checkpointer) Checkpoint(ctx context.Context, mode Mode) (Result, error) {
+	if err := mode.validate(); err != nil {
+		return Result{}, err
+	}
+
+	r := c.run(ctx, mode, ReasonManual)
+
+	return r, r.Err
+}
+
+func (c *Checkpointer) run(ctx context.Context, mode Mode, reason Reason) Result {
+	var (
+		r    = Result{Mode: mode, Reason: reason, WALSize: c.walSize(), At: time.Now()}
+		busy int
+	)
+	err := c.db.QueryRowContext(ctx, fmt.Sprintf("PRAGMA wal_checkpoint(%s)", mode)).Scan(&busy, &r.Log, &r.Checkpointed)
+	r.Elapsed = time.Since(r.At)
+	r.Busy = busy != 0
+	if err != nil {
+		r.Err = fmt.Errorf("checkpoint: %w", err)
+	}
+
+	c.mu.Lock()
+	c.stats.Runs++
+	switch {
+	case r.Err != nil:
+		c.stats.Errors++
+	case r.Busy:
+		c.stats.Busy++
+	}
+	if r.Err == nil && r.Checkpointed > 0 {
+		c.stats.Checkpointed += int64(r.Checkpointed)
+	}
+	c.stats.Last = r
+	c.mu.Unlock()
+
+	if c.opts.OnResult != nil {
+		c.opts.OnResult(r)
+	}
+
+	return r
+}
+
+// walSize は、-wal ファイルのサイズを返す。ファイルが無い場合は 0、ファイル名が分からない場合は -1。
+func (c *Checkpointer) walSize() int64 {
+	if c.walPath == "" {
+		return -1
+	}
+
+	fi, err := os.Stat(c.walPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0
+		}
+		return -1
+	}
+
+	return fi.Size()
+}
+
+// Stats は、統計を返す。
+func (c *Checkpointer) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+// Stop は、ゴルーチンを停止し、終了するまで待つ。実行中のチェックポイントは中断する。何度呼び出してもよい。
+func (c *Checkpointer) Stop() {
+	c.once.Do(c.cancel)
+	<-c.done
+}
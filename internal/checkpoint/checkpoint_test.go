@@ -0,0 +1,102 @@
+package checkpoint
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func open(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_journal_mode=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err = db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestCheckpoint(t *testing.T) {
+	var (
+		db  = open(t)
+		ctx = context.Background()
+	)
+
+	// 手動で実行した結果のみを確認するため、定期的な実行は起こらない間隔にする
+	c, err := Start(ctx, db, Options{Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	if _, err = db.Exec("INSERT INTO items (id) VALUES (1), (2), (3)"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.Checkpoint(ctx, Truncate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Busy || r.Log != 0 || r.WALSize <= 0 {
+		t.Errorf("result = %v", r)
+	}
+	if size := c.walSize(); size != 0 {
+		t.Errorf("wal size after TRUNCATE = %d, want 0", size)
+	}
+	if s := c.Stats(); s.Runs != 1 || s.Errors != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+// TestStartWithoutTrigger は、Interval と MaxWALSize のどちらも指定しない場合にエラーとなることを確認する。
+func TestStartWithoutTrigger(t *testing.T) {
+	var (
+		db = open(t)
+	)
+
+	for _, opts := range []Options{
+		{},
+		{Mode: Full, PollInterval: 10 * time.Millisecond},
+		{Interval: -time.Second, MaxWALSize: -1},
+	} {
+		if c, err := Start(context.Background(), db, opts); err == nil {
+			c.Stop()
+			t.Errorf("%+v: err = nil, want error", opts)
+		}
+	}
+}
+
+// TestStopsWhenDBClosed は、Stop を呼ばずに db を閉じた場合にゴルーチンが終了することを確認する。
+func TestStopsWhenDBClosed(t *testing.T) {
+	for _, opts := range []Options{
+		{Interval: 10 * time.Millisecond},
+		{MaxWALSize: 1 << 30, PollInterval: 10 * time.Millisecond},
+	} {
+		var (
+			db = open(t)
+		)
+
+		c, err := Start(context.Background(), db, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+
+		select {
+		case <-c.done:
+		case <-time.After(time.Second):
+			t.Errorf("%+v: goroutine did not stop after db.Close", opts)
+		}
+		c.Stop()
+	}
+}
@@ -0,0 +1,275 @@
+// Package dbopen は、mattn/go-sqlite3 と modernc.org/sqlite の違いを隠して、
+// 接続フック・PRAGMA・SQL関数を設定した *sql.DB を開く。
+//
+// 新しいコネクションに対して処理を行う方法はドライバ毎に異なる。
+//
+//   - mattn/go-sqlite3  : ConnectHook を持つ SQLiteDriver を sql.Register で別名登録する (14.ConnHook_mattn)。
+//     同じ名前で2回 sql.Register すると panic する
+//   - modernc.org/sqlite: sqlite.RegisterConnectionHook でプロセスグローバルに登録する (13.ConnHook_modernc)。
+//     同じプロセス内の全ての *sql.DB に適用される
+//
+// OpenSQLite は、sql.OpenDB に渡すコネクタの中でドライバのコネクションを開き、その後にフックを実行する。
+// sql.Register を使わず、フックは *sql.DB 毎に持つため、同じプロセス内で *sql.DB 毎に異なるフックを設定できる。
+//
+// ドライバ名が必要な場合 (sql.Open しか受け付けないライブラリに渡す場合など) は Register を利用する。
+// Register は名前をキーに一度だけ sql.Register するため、同じ設定であれば何度呼び出しても panic しない。
+// 同じ名前で異なる設定を登録しようとした場合は、最初の設定が黙って使われないように ErrConflict を返す。
+//
+// # REFERENCES
+//   - https://pkg.go.dev/database/sql@go1.26.0#OpenDB
+//   - https://pkg.go.dev/database/sql@go1.26.0#Register
+//   - https://pkg.go.dev/github.com/mattn/go-sqlite3#SQLiteDriver
+//   - https://pkg.go.dev/modernc.org/sqlite#RegisterConnectionHook
+package dbopen
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/devlights/try-golang-db/internal/pragma"
+	"github.com/devlights/try-golang-db/internal/sqlfunc"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	_ "modernc.org/sqlite"
+)
+
+// Driver は、利用するSQLiteドライバ。
+type Driver int
+
+const (
+	// Mattn は、mattn/go-sqlite3 (cgo)。ゼロ値。
+	Mattn Driver = iota
+	// Modernc は、modernc.org/sqlite (Pure Go)。
+	Modernc
+)
+
+func (d Driver) String() string {
+	switch d {
+	case Mattn:
+		return "sqlite3"
+	case Modernc:
+		return "sqlite"
+	}
+
+	return fmt.Sprintf("Driver(%d)", int(d))
+}
+
+var (
+	// ErrConflict は、Register で登録済みの名前を異なる設定で登録しようとしたことを表す。
+	ErrConflict = errors.New("dbopen: name is already registered with different options")
+)
+
+var (
+	// registered は、Register で登録した名前とその設定
+	registered = struct {
+		mu    sync.Mutex
+		names map[string]Options
+	}{
+		names: make(map[string]Options),
+	}
+)
+
+type (
+	// Conn は、フックに渡されるドライバのコネクション。
+	//
+	// *sqlite3.SQLiteConn, modernc.org/sqlite のコネクションはどちらも満たす (pragma.Conn と同じ)。
+	Conn = pragma.Conn
+
+	// Hook は、新しいコネクションを開いた時に呼ばれる関数。エラーを返すとコネクションは利用されない。
+	Hook func(ctx context.Context, conn Conn) error
+
+	// Options は、OpenSQLite のオプション。
+	Options struct {
+		// Driver は、利用するドライバ。既定値は Mattn。
+		Driver Driver
+		// Functions は、コネクションに登録するSQL関数・照合順序。
+		//
+		// modernc.org/sqlite への登録はプロセスグローバルのため、Modernc の場合は全ての *sql.DB で利用できるようになる。
+		Functions *sqlfunc.Registry
+		// Pragmas は、コネクション毎に適用するPRAGMAのプロファイル。
+		Pragmas *pragma.Profile
+		// Hooks は、Functions, Pragmas の後に順に呼ばれる。
+		Hooks []Hook
+	}
+
+	connector struct {
+		drv  driver.Driver
+		dsn  string
+		opts Options
+	}
+
+	// hookDriver は、Register で登録するドライバ。Open でフックを実行する。
+	hookDriver struct {
+		drv  driver.Driver
+		opts Options
+	}
+)
+
+// OpenSQLite は、path のデータベースを opts の設定で開く。
+//
+// path はそのままドライバに渡すため、file: から始まる URI やドライバ毎のパラメータも指定できる。
+// *sql.DB が新しいコネクションを開く度に、Functions, Pragmas, Hooks の順に適用する。
+func OpenSQLite(path string, opts Options) (*sql.DB, error) {
+	drv, err := opts.driver()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.OpenDB(&connector{drv: drv, dsn: path, opts: opts}), nil
+}
+
+// Register は、opts の設定を持つドライバを name で sql.Register し、sql.Open に渡すドライバ名を返す。
+//
+// 同じ name・同じ設定で既に登録されている場合は、何もせずに同じドライバ名を返す。
+// 同じ name で設定が異なる場合は ErrConflict を返す。設定は以下のように比較する。
+//
+//   - Driver, Functions (ポインタ) が同じであること
+//   - Pragmas が両方 nil、または同じ内容であること
+//   - Hooks の数と各関数が同じであること
+//
+// 関数の値は == で比較できないため、Hooks は関数のコードのアドレスで比較する。
+// そのため、同じ関数リテラルから作ったクロージャ (キャプチャした値だけが異なるもの) は区別できない。
+func Register(name string, opts Options) (string, error) {
+	if name == "" {
+		return "", errors.New("dbopen: empty name")
+	}
+
+	var (
+		driverName = fmt.Sprintf("dbopen_%s_%s", opts.Driver, name)
+	)
+
+	registered.mu.Lock()
+	defer registered.mu.Unlock()
+
+	if prev, ok := registered.names[name]; ok {
+		if !prev.equal(opts) {
+			return "", fmt.Errorf("%w: %s (driver %s)", ErrConflict, name, prev.Driver)
+		}
+		return driverName, nil
+	}
+
+	drv, err := opts.driver()
+	if err != nil {
+		return "", err
+	}
+
+	sql.Register(driverName, &hookDriver{drv: drv, opts: opts})
+	registered.names[name] = opts
+
+	return driverName, nil
+}
+
+// equal は、Register で登録済みの設定 o と other が同じかどうかを返す。
+func (o Options) equal(other Options) bool {
+	switch {
+	case o.Driver != other.Driver, o.Functions != other.Functions:
+		return false
+	case (o.Pragmas == nil) != (other.Pragmas == nil):
+		return false
+	case o.Pragmas != nil && !reflect.DeepEqual(*o.Pragmas, *other.Pragmas):
+		return false
+	}
+
+	return slices.EqualFunc(o.Hooks, other.Hooks, func(a, b Hook) bool {
+		return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+	})
+}
+
+// driver は、ベースとなるドライバを返す。
+//
+// modernc.org/sqlite の sqlite.RegisterFunction などで登録した関数は、"sqlite" で登録されているドライバにのみ登録される。
+// &sqlite.Driver{} を新しく作るとそれらが使えないため、sql.Open で登録済みのドライバを取得している。
+func (o Options) driver() (driver.Driver, error) {
+	switch o.Driver {
+	case Mattn:
+		return &sqlite3.SQLiteDriver{}, nil
+	case Modernc:
+		if o.Functions != nil {
+			if err := o.Functions.RegisterModernc(); err != nil {
+				return nil, err
+			}
+		}
+
+		db, err := sql.Open(o.Driver.String(), "")
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+
+		return db.Driver(), nil
+	}
+
+	return nil, fmt.Errorf("dbopen: unknown driver %v", o.Driver)
+}
+
+// setup は、新しいコネクションに Functions, Pragmas, Hooks を適用する。失敗した場合はコネクションを閉じる。
+func (o Options) setup(ctx context.Context, conn driver.Conn) (driver.Conn, error) {
+	err := o.apply(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("dbopen: %w", err)
+	}
+
+	return conn, nil
+}
+
+func (o Options) apply(ctx context.Context, conn driver.Conn) error {
+	c, ok := conn.(Conn)
+	if !ok {
+		return fmt.Errorf("%T does not implement ExecerContext/QueryerContext", conn)
+	}
+
+	if o.Functions != nil {
+		if mc, ok := conn.(*sqlite3.SQLiteConn); ok {
+			if err := o.Functions.RegisterMattn(mc); err != nil {
+				return err
+			}
+		}
+	}
+
+	if o.Pragmas != nil {
+		if _, err := o.Pragmas.Apply(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	for i, h := range o.Hooks {
+		if err := h(ctx, c); err != nil {
+			return fmt.Errorf("hook #%d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.drv.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.opts.setup(ctx, conn)
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.drv
+}
+
+func (d *hookDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.drv.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.opts.setup(context.Background(), conn)
+}
+
+// OpenConnector は、driver.DriverContext の実装。sql.Open から呼ばれ、Connect で ctx をフックに渡せるようにする。
+func (d *hookDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	return &connector{drv: d.drv, dsn: dsn, opts: d.opts}, nil
+}
@@ -0,0 +1,73 @@
+package dbopen
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/devlights/try-golang-db/internal/pragma"
+)
+
+func TestRegisterConflict(t *testing.T) {
+	var (
+		server   = pragma.Server()
+		same     = pragma.Server() // 内容は同じで、別のポインタ
+		readOnly = pragma.ReadOnly()
+		hookA    = func(ctx context.Context, conn Conn) error { return nil }
+		hookB    = func(ctx context.Context, conn Conn) error { return errors.New("b") }
+		base     = Options{Driver: Mattn, Pragmas: &server, Hooks: []Hook{hookA}}
+	)
+
+	name, err := Register("test_conflict", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{"same options", base, false},
+		{"same pragma contents", Options{Driver: Mattn, Pragmas: &same, Hooks: []Hook{hookA}}, false},
+		{"different driver", Options{Driver: Modernc, Pragmas: &server, Hooks: []Hook{hookA}}, true},
+		{"different pragmas", Options{Driver: Mattn, Pragmas: &readOnly, Hooks: []Hook{hookA}}, true},
+		{"no pragmas", Options{Driver: Mattn, Hooks: []Hook{hookA}}, true},
+		{"different hook", Options{Driver: Mattn, Pragmas: &server, Hooks: []Hook{hookB}}, true},
+		{"extra hook", Options{Driver: Mattn, Pragmas: &server, Hooks: []Hook{hookA, hookA}}, true},
+		{"zero options", Options{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Register("test_conflict", tt.opts)
+			switch {
+			case tt.wantErr && !errors.Is(err, ErrConflict):
+				t.Errorf("err = %v, want %v", err, ErrConflict)
+			case !tt.wantErr && err != nil:
+				t.Errorf("err = %v", err)
+			case !tt.wantErr && got != name:
+				t.Errorf("name = %q, want %q", got, name)
+			}
+		})
+	}
+
+	// 最初に登録した設定で開けること
+	db, err := sql.Open(name, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var (
+		mode string
+	)
+	if err = db.QueryRow("PRAGMA synchronous").Scan(&mode); err != nil {
+		t.Fatal(err)
+	}
+	if mode != "1" {
+		t.Errorf("synchronous = %s, want 1 (NORMAL)", mode)
+	}
+}
@@ -0,0 +1,219 @@
+// Package dbstats は、複数の *sql.DB のコネクションプールの統計 (sql.DBStats) を、
+// Prometheus のテキスト形式と expvar で公開する。
+//
+// 13.ConnHook_modernc, 14.ConnHook_mattn では db.Stats().OpenConnections をログに出力しているだけだが、
+// プールの設定 (SetMaxOpenConns など) が適切かどうかは、他の値も合わせて時系列で見ないと判断できない。
+//
+//   - WaitCount, WaitDuration が増え続けている: MaxOpenConns が足りずにコネクション待ちが発生している
+//   - MaxIdleClosed が増え続けている: MaxIdleConns が小さく、開いては閉じるを繰り返している
+//   - MaxLifetimeClosed, MaxIdleTimeClosed: ConnMaxLifetime, ConnMaxIdleTime で閉じられた数
+//
+// Collector に名前を付けて *sql.DB を登録すると、取得する度 (スクレイプ毎) に db.Stats() を呼び出して値を返す。
+// メトリクス名は Prometheus の client_golang の collectors.NewDBStatsCollector に合わせている (ラベルは db_name)。
+// 外部のライブラリには依存しない。
+//
+// ローカルで公開する場合は以下のようにする。
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("/metrics", collector)
+//	mux.Handle("/debug/vars", expvar.Handler())
+//	http.ListenAndServe("127.0.0.1:9100", mux)
+//
+// # REFERENCES
+//   - https://pkg.go.dev/database/sql@go1.26.0#DBStats
+//   - https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format
+//   - https://pkg.go.dev/expvar@go1.26.0
+//   - https://github.com/prometheus/client_golang/blob/main/prometheus/collectors/dbstats_collector.go
+package dbstats
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// ContentType は、Prometheus のテキスト形式の Content-Type。
+	ContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+type (
+	// Sample は、ある時点の1つの *sql.DB の統計。
+	Sample struct {
+		Name  string
+		Stats sql.DBStats
+	}
+
+	// Collector は、名前を付けた *sql.DB の統計を集める。http.Handler として /metrics に登録できる。
+	Collector struct {
+		mu  sync.Mutex
+		dbs map[string]*sql.DB
+	}
+
+	// metric は、sql.DBStats の1つのフィールドに対応するメトリクス。
+	metric struct {
+		name  string
+		kind  string
+		help  string
+		value func(s sql.DBStats) float64
+	}
+)
+
+var (
+	metrics = []metric{
+		{"go_sql_max_open_connections", "gauge", "Maximum number of open connections to the database.",
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"go_sql_open_connections", "gauge", "The number of established connections both in use and idle.",
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"go_sql_in_use_connections", "gauge", "The number of connections currently in use.",
+			func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"go_sql_idle_connections", "gauge", "The number of idle connections.",
+			func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"go_sql_wait_count_total", "counter", "The total number of connections waited for.",
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"go_sql_wait_duration_seconds_total", "counter", "The total time blocked waiting for a new connection.",
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+		{"go_sql_max_idle_closed_total", "counter", "The total number of connections closed due to SetMaxIdleConns.",
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+		{"go_sql_max_idle_time_closed_total", "counter", "The total number of connections closed due to SetConnMaxIdleTime.",
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }},
+		{"go_sql_max_lifetime_closed_total", "counter", "The total number of connections closed due to SetConnMaxLifetime.",
+			func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+	}
+
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	// publishMu は、Publish の expvar.Get と expvar.Publish の間に他の Publish が割り込まないようにする
+	publishMu sync.Mutex
+)
+
+// New は、空の Collector を生成する。
+func New() *Collector {
+	return &Collector{dbs: make(map[string]*sql.DB)}
+}
+
+// Add は、db を name で登録する。同じ name が既に登録されている場合はエラー。
+func (c *Collector) Add(name string, db *sql.DB) error {
+	if name == "" {
+		return errors.New("dbstats: empty name")
+	}
+	if db == nil {
+		return fmt.Errorf("dbstats: %s: nil *sql.DB", name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.dbs[name]; ok {
+		return fmt.Errorf("dbstats: %s is already registered", name)
+	}
+	c.dbs[name] = db
+
+	return nil
+}
+
+// Remove は、name の登録を解除する。*sql.DB を Close した後も統計は取得できるが、不要になったら解除すること。
+func (c *Collector) Remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.dbs, name)
+}
+
+// Collect は、登録されている全ての *sql.DB の現在の統計を名前順で返す。
+func (c *Collector) Collect() []Sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var (
+		samples = make([]Sample, 0, len(c.dbs))
+	)
+	for name, db := range c.dbs {
+		samples = append(samples, Sample{Name: name, Stats: db.Stats()})
+	}
+	slices.SortFunc(samples, func(a, b Sample) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return samples
+}
+
+// WritePrometheus は、現在の統計を Prometheus のテキスト形式で w に書き込む。
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	var (
+		samples = c.Collect()
+		bw      = bufio.NewWriter(w)
+	)
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", m.name, m.kind)
+		for _, s := range samples {
+			fmt.Fprintf(bw, "%s{db_name=\"%s\"} %s\n",
+				m.name, labelEscaper.Replace(s.Name), strconv.FormatFloat(m.value(s.Stats), 'g', -1, 64))
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ServeHTTP は、http.Handler の実装。現在の統計を Prometheus のテキスト形式で返す。
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// ヘッダは送信済みのため、書き込みのエラー (クライアントの切断など) は返せない
+	_ = c.WritePrometheus(w)
+}
+
+// Var は、現在の統計を {"名前": sql.DBStats} の JSON で返す expvar.Var を返す。
+func (c *Collector) Var() expvar.Var {
+	return expvar.Func(func() any {
+		var (
+			m = make(map[string]sql.DBStats)
+		)
+		for _, s := range c.Collect() {
+			m[s.Name] = s.Stats
+		}
+
+		return m
+	})
+}
+
+// Publish は、Var を expvar に name で公開する。
+//
+// expvar.Publish は同じ名前で2回呼び出すと panic するため、既に公開されている場合はエラーを返す。
+// 複数のゴルーチンから同時に呼び出してもよい。このパッケージ以外から expvar.Publish で同じ名前が
+// 同時に公開された場合も、panic はエラーとして返す。
+func (c *Collector) Publish(name string) (err error) {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("dbstats: expvar %s is already published", name)
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("dbstats: expvar %s: %v", name, v)
+		}
+	}()
+	expvar.Publish(name, c.Var())
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package dbstats
+
+import (
+	"database/sql"
+	"encoding/json"
+	"expvar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func open(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(3)
+
+	if err = db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestMetricsHandler(t *testing.T) {
+	var (
+		c = New()
+	)
+	for _, name := range []string{"writer", `re"ad\er`} {
+		if err := c.Add(name, open(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Add("writer", open(t)); err == nil {
+		t.Error("duplicate Add: err = nil, want error")
+	}
+
+	var (
+		mux = http.NewServeMux()
+	)
+	mux.Handle("/metrics", c)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != ContentType {
+		t.Errorf("status=%d content-type=%q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	for _, want := range []string{
+		"# TYPE go_sql_max_open_connections gauge\n",
+		"# TYPE go_sql_wait_count_total counter\n",
+		`go_sql_max_open_connections{db_name="writer"} 3` + "\n",
+		`go_sql_open_connections{db_name="writer"} 1` + "\n",
+		`go_sql_idle_connections{db_name="re\"ad\\er"} 1` + "\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("body does not contain %q\n%s", want, body)
+		}
+	}
+
+	// 名前順に出力される
+	var (
+		text = string(body)
+	)
+	if strings.Index(text, `db_name="re\"ad\\er"`) > strings.Index(text, `db_name="writer"`) {
+		t.Errorf("samples are not sorted by name\n%s", text)
+	}
+
+	// GET, HEAD 以外は 405
+	res, err = http.Post(srv.URL+"/metrics", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %d, want %d", res.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	var (
+		c    = New()
+		name = "dbstats_test_publish"
+		errs = make([]error, 8)
+		wg   sync.WaitGroup
+	)
+	if err := c.Add("main", open(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同時に呼び出しても panic せず、1つだけ成功する
+	for i := range errs {
+		wg.Go(func() {
+			errs[i] = c.Publish(name)
+		})
+	}
+	wg.Wait()
+
+	var (
+		ok int
+	)
+	for _, err := range errs {
+		if err == nil {
+			ok++
+		}
+	}
+	if ok != 1 {
+		t.Errorf("successful Publish = %d, want 1 (%v)", ok, errs)
+	}
+
+	var (
+		got map[string]sql.DBStats
+	)
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := got["main"]; !ok || s.MaxOpenConnections != 3 {
+		t.Errorf("expvar %s = %v", name, got)
+	}
+}
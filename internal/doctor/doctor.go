@@ -0,0 +1,113 @@
+// Package doctor は、データベースの状態を診断し、結果をテキストまたはJSONで出力する。
+//
+// SQLite では以下を確認する。
+//
+//   - PRAGMA integrity_check, quick_check, foreign_key_check
+//   - journal_mode, page_size, page_count, freelist_count, -wal ファイルのサイズ
+//   - プロファイル (pragma.Server() など) の各PRAGMAの実際の値
+//
+// PostgreSQL では以下を確認する。
+//
+//   - バージョン
+//   - コネクション数 (max_connections に対する割合)
+//   - データベースのサイズ
+//   - 長時間実行中のトランザクション
+//
+// 各項目の結果は OK, Warn, Fail のいずれか。Fail が1つでもあれば Report.Healthy は false を返す。
+// 診断は読み込みのみで、データベースの内容や永続的な設定 (journal_mode など) は変更しない。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/pragma.html#pragma_integrity_check
+//   - https://www.sqlite.org/pragma.html#pragma_foreign_key_check
+//   - https://www.postgresql.org/docs/current/monitoring-stats.html#MONITORING-PG-STAT-ACTIVITY-VIEW
+//   - https://www.postgresql.org/docs/current/functions-admin.html#FUNCTIONS-ADMIN-DBSIZE
+package doctor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Status は、1つの診断項目の結果。
+type Status string
+
+const (
+	OK   Status = "ok"
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
+
+type (
+	// Check は、1つの診断項目。
+	Check struct {
+		Name   string `json:"name"`
+		Status Status `json:"status"`
+		// Value は、診断で得られた値。
+		Value string `json:"value"`
+		// Detail は、補足の説明。Warn, Fail の場合は理由。
+		Detail string `json:"detail,omitempty"`
+	}
+
+	// Report は、診断結果。
+	Report struct {
+		Driver string  `json:"driver"`
+		Checks []Check `json:"checks"`
+	}
+)
+
+func (r *Report) add(name string, status Status, value, detail string) {
+	r.Checks = append(r.Checks, Check{Name: name, Status: status, Value: value, Detail: detail})
+}
+
+// Count は、status の項目の数を返す。
+func (r *Report) Count(status Status) int {
+	var (
+		n int
+	)
+	for _, c := range r.Checks {
+		if c.Status == status {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Healthy は、Fail の項目が無い場合に true を返す。
+func (r *Report) Healthy() bool {
+	return r.Count(Fail) == 0
+}
+
+// WriteText は、診断結果を表形式のテキストで w に書き込む。
+func (r *Report) WriteText(w io.Writer) error {
+	var (
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	)
+
+	fmt.Fprintf(tw, "driver: %s\n", r.Driver)
+	for _, c := range r.Checks {
+		fmt.Fprintf(tw, "[%s]\t%s\t%s", c.Status, c.Name, c.Value)
+		if c.Detail != "" {
+			fmt.Fprintf(tw, "  (%s)", c.Detail)
+		}
+		fmt.Fprintln(tw)
+	}
+	fmt.Fprintf(tw, "ok=%d warn=%d fail=%d\n", r.Count(OK), r.Count(Warn), r.Count(Fail))
+
+	return tw.Flush()
+}
+
+// WriteJSON は、診断結果を JSON で w に書き込む。
+func (r *Report) WriteJSON(w io.Writer) error {
+	var (
+		enc = json.NewEncoder(w)
+	)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(struct {
+		*Report
+		Healthy bool `json:"healthy"`
+	}{r, r.Healthy()})
+}
@@ -0,0 +1,133 @@
+package doctor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// PostgresOptions は、PostgreSQL のオプション。
+type PostgresOptions struct {
+	// LongTransaction は、これより長く実行中のトランザクションを Fail とする。0 の場合は 5分。
+	LongTransaction time.Duration
+	// ConnectionRatio は、max_connections に対するコネクション数の割合がこれを超えた場合に Warn とする。0 の場合は 0.8。
+	ConnectionRatio float64
+}
+
+// Postgres は、db (PostgreSQL) を診断する。
+//
+// 他のセッションの情報 (pg_stat_activity の query など) は、pg_read_all_stats ロールなどが無いと参照できない。
+func Postgres(ctx context.Context, db *sql.DB, opts PostgresOptions) (*Report, error) {
+	if opts.LongTransaction <= 0 {
+		opts.LongTransaction = 5 * time.Minute
+	}
+	if opts.ConnectionRatio <= 0 {
+		opts.ConnectionRatio = 0.8
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var (
+		r = &Report{Driver: fmt.Sprintf("%T", db.Driver())}
+	)
+
+	var (
+		version string
+	)
+	if err = conn.QueryRowContext(ctx, "SHOW server_version").Scan(&version); err != nil {
+		return nil, fmt.Errorf("doctor: version: %w", err)
+	}
+	r.add("version", OK, version, "")
+
+	var (
+		connections, maxConnections int
+	)
+	err = conn.QueryRowContext(ctx, `
+		SELECT (SELECT count(*) FROM pg_stat_activity WHERE backend_type = 'client backend'),
+		       current_setting('max_connections')::int`).Scan(&connections, &maxConnections)
+	if err != nil {
+		return nil, fmt.Errorf("doctor: connections: %w", err)
+	}
+	var (
+		value = fmt.Sprintf("%d/%d", connections, maxConnections)
+		ratio = float64(connections) / float64(maxConnections)
+	)
+	if ratio > opts.ConnectionRatio {
+		r.add("connections", Warn, value, fmt.Sprintf("%.0f%% of max_connections", ratio*100))
+	} else {
+		r.add("connections", OK, value, "")
+	}
+
+	var (
+		name   string
+		size   int64
+		pretty string
+	)
+	err = conn.QueryRowContext(ctx, `
+		SELECT current_database(), pg_database_size(current_database()), pg_size_pretty(pg_database_size(current_database()))`).
+		Scan(&name, &size, &pretty)
+	if err != nil {
+		return nil, fmt.Errorf("doctor: database size: %w", err)
+	}
+	r.add("database_size", OK, strconv.FormatInt(size, 10), fmt.Sprintf("%s: %s", name, pretty))
+
+	if err = longTransactions(ctx, conn, r, opts.LongTransaction); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// longTransactions は、threshold より長く実行中のトランザクションを確認する。
+//
+// 長時間のトランザクションは、VACUUM による不要な行の回収を妨げ、ロックを保持し続ける。
+func longTransactions(ctx context.Context, conn *sql.Conn, r *Report, threshold time.Duration) error {
+	const (
+		query = `
+			SELECT pid, coalesce(usename, ''), coalesce(state, ''),
+			       extract(epoch FROM now() - xact_start)::float8, left(coalesce(query, ''), 60)
+			FROM pg_stat_activity
+			WHERE xact_start IS NOT NULL
+			  AND pid <> pg_backend_pid()
+			  AND now() - xact_start > make_interval(secs => $1)
+			ORDER BY xact_start`
+	)
+
+	rows, err := conn.QueryContext(ctx, query, threshold.Seconds())
+	if err != nil {
+		return fmt.Errorf("doctor: long transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		pid         int
+		user, state string
+		seconds     float64
+		text        string
+		msgs        []string
+	)
+	for rows.Next() {
+		if err = rows.Scan(&pid, &user, &state, &seconds, &text); err != nil {
+			return err
+		}
+		msgs = append(msgs, fmt.Sprintf("pid=%d user=%s state=%s elapsed=%v query=%q",
+			pid, user, state, time.Duration(seconds*float64(time.Second)).Round(time.Second), text))
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(msgs) == 0 {
+		r.add("long_transactions", OK, "0", fmt.Sprintf("> %v", threshold))
+	} else {
+		r.add("long_transactions", Fail, strconv.Itoa(len(msgs)), head(msgs))
+	}
+
+	return nil
+}
@@ -0,0 +1,270 @@
+package doctor
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/devlights/try-golang-db/internal/pragma"
+)
+
+const (
+	// maxMessages は、integrity_check などの結果を Detail に含める最大件数。
+	maxMessages = 5
+)
+
+// SQLiteOptions は、SQLite のオプション。
+type SQLiteOptions struct {
+	// Profile は、実際の値を確認するPRAGMAのプロファイル。nil の場合は確認しない。
+	//
+	// 診断用のコネクションにはプロファイルを適用しないため、コネクション毎の設定 (synchronous など) は
+	// 既定値と比較することになる。アプリケーションと同じ設定で確認する場合は、同じプロファイルで開いた *sql.DB を渡す。
+	Profile *pragma.Profile
+	// FreelistRatio は、page_count に対する freelist_count の割合がこれを超えた場合に Warn とする。0 の場合は 0.25。
+	FreelistRatio float64
+}
+
+// SQLite は、db (SQLite) を診断する。
+//
+// 診断は1つのコネクションで行う。診断を実行できなかった場合 (接続できないなど) はエラーを返す。
+func SQLite(ctx context.Context, db *sql.DB, opts SQLiteOptions) (*Report, error) {
+	if opts.FreelistRatio <= 0 {
+		opts.FreelistRatio = 0.25
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var (
+		r = &Report{Driver: fmt.Sprintf("%T", db.Driver())}
+	)
+
+	for _, name := range []string{"integrity_check", "quick_check"} {
+		msgs, err := column(ctx, conn, "PRAGMA "+name)
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) == 1 && msgs[0] == "ok" {
+			r.add(name, OK, "ok", "")
+		} else {
+			r.add(name, Fail, fmt.Sprintf("%d problem(s)", len(msgs)), head(msgs))
+		}
+	}
+
+	if err = foreignKeyCheck(ctx, conn, r); err != nil {
+		return nil, err
+	}
+
+	if err = sizes(ctx, conn, r, opts.FreelistRatio); err != nil {
+		return nil, err
+	}
+
+	if opts.Profile != nil {
+		if err = profile(ctx, conn, r, *opts.Profile); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// foreignKeyCheck は、PRAGMA foreign_key_check で外部キー制約に違反している行を確認する。
+//
+// foreign_keys=OFF で書き込まれた場合などに違反した行が存在しうる。
+func foreignKeyCheck(ctx context.Context, conn *sql.Conn, r *Report) error {
+	rows, err := conn.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return fmt.Errorf("doctor: foreign_key_check: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		table, parent string
+		rowid         sql.NullInt64
+		fkid          int
+		msgs          []string
+	)
+	for rows.Next() {
+		if err = rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return err
+		}
+		msgs = append(msgs, fmt.Sprintf("%s(rowid=%d) -> %s", table, rowid.Int64, parent))
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(msgs) == 0 {
+		r.add("foreign_key_check", OK, "ok", "")
+	} else {
+		r.add("foreign_key_check", Fail, fmt.Sprintf("%d violation(s)", len(msgs)), head(msgs))
+	}
+
+	return nil
+}
+
+// sizes は、journal_mode, ページサイズ, ページ数, 空きページ数, -wal ファイルのサイズを確認する。
+func sizes(ctx context.Context, conn *sql.Conn, r *Report, freelistRatio float64) error {
+	var (
+		journalMode                        string
+		pageSize, pageCount, freelistCount int64
+	)
+	for _, v := range []struct {
+		name string
+		dest any
+	}{
+		{"journal_mode", &journalMode},
+		{"page_size", &pageSize},
+		{"page_count", &pageCount},
+		{"freelist_count", &freelistCount},
+	} {
+		if err := conn.QueryRowContext(ctx, "PRAGMA "+v.name).Scan(v.dest); err != nil {
+			return fmt.Errorf("doctor: %s: %w", v.name, err)
+		}
+	}
+
+	r.add("journal_mode", OK, journalMode, "")
+	r.add("page_size", OK, strconv.FormatInt(pageSize, 10), "")
+	r.add("page_count", OK, strconv.FormatInt(pageCount, 10), fmt.Sprintf("%d bytes", pageSize*pageCount))
+
+	var (
+		ratio float64
+	)
+	if pageCount > 0 {
+		ratio = float64(freelistCount) / float64(pageCount)
+	}
+	if ratio > freelistRatio {
+		r.add("freelist_count", Warn, strconv.FormatInt(freelistCount, 10),
+			fmt.Sprintf("%.0f%% of pages are free, consider VACUUM", ratio*100))
+	} else {
+		r.add("freelist_count", OK, strconv.FormatInt(freelistCount, 10), "")
+	}
+
+	file, err := mainFile(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if file == "" {
+		r.add("wal_size", OK, "-", "no database file")
+		return nil
+	}
+
+	fi, err := os.Stat(file + "-wal")
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		r.add("wal_size", OK, "0", "no -wal file")
+	case err != nil:
+		r.add("wal_size", Warn, "-", err.Error())
+	default:
+		r.add("wal_size", OK, strconv.FormatInt(fi.Size(), 10), "bytes")
+	}
+
+	return nil
+}
+
+// profile は、p の各PRAGMAの実際の値を確認する。一致しない場合は Warn とする。
+func profile(ctx context.Context, conn *sql.Conn, r *Report, p pragma.Profile) error {
+	var (
+		mismatches = make(map[string]pragma.Mismatch)
+	)
+	err := conn.Raw(func(dc any) error {
+		c, ok := dc.(pragma.Conn)
+		if !ok {
+			return fmt.Errorf("%T does not implement ExecerContext/QueryerContext", dc)
+		}
+
+		ms, err := p.Verify(ctx, c)
+		if err != nil && !errors.Is(err, pragma.ErrNotApplied) {
+			return err
+		}
+		for _, m := range ms {
+			mismatches[m.Name] = m
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("doctor: profile %s: %w", p.Name, err)
+	}
+
+	for _, s := range p.Settings {
+		var (
+			name = fmt.Sprintf("pragma %s (%s)", s.Name, p.Name)
+		)
+		if m, ok := mismatches[s.Name]; ok {
+			got := m.Got
+			if got == "" {
+				got = "(none)"
+			}
+			r.add(name, Warn, got, fmt.Sprintf("want %s", s.Value))
+			continue
+		}
+		r.add(name, OK, string(s.Value), "")
+	}
+
+	return nil
+}
+
+// mainFile は、メインデータベースのファイル名を返す。:memory: などファイルが無い場合は空文字。
+func mainFile(ctx context.Context, conn *sql.Conn) (string, error) {
+	rows, err := conn.QueryContext(ctx, "PRAGMA database_list")
+	if err != nil {
+		return "", fmt.Errorf("doctor: database_list: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		seq  int
+		name string
+		file sql.NullString
+	)
+	for rows.Next() {
+		if err = rows.Scan(&seq, &name, &file); err != nil {
+			return "", err
+		}
+		if name == "main" {
+			return file.String, nil
+		}
+	}
+
+	return "", rows.Err()
+}
+
+// column は、query の結果の1列目を全て文字列で返す。
+func column(ctx context.Context, conn *sql.Conn, query string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("doctor: %s: %w", query, err)
+	}
+	defer rows.Close()
+
+	var (
+		values []string
+		v      string
+	)
+	for rows.Next() {
+		if err = rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	return values, rows.Err()
+}
+
+// head は、msgs の先頭 maxMessages 件を連結する。
+func head(msgs []string) string {
+	if len(msgs) > maxMessages {
+		return strings.Join(msgs[:maxMessages], "; ") + fmt.Sprintf("; ... and %d more", len(msgs)-maxMessages)
+	}
+
+	return strings.Join(msgs, "; ")
+}
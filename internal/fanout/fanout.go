@@ -0,0 +1,157 @@
+// Package fanout は、パラメータ違いの複数クエリを同時実行数の上限付きで並行実行する。
+//
+// 06.PreparedQuery のように *sql.Stmt を複数のゴルーチンから利用する場合、
+// 各ゴルーチンが共有変数にエラーを書き込んだり、最初のエラーだけを拾って
+// 残りを捨ててしまったりしがちである。本パッケージでは
+//
+//   - 結果とエラーはパラメータのインデックス毎のスロットに格納する (データ競合が発生しない)
+//   - 結果はパラメータと同じ順序で返す
+//   - FailFast では最初の失敗でコンテキストをキャンセルし、実行中のクエリも中断させる
+//   - CollectAll では全て実行し、発生したエラーを errors.Join でまとめて返す
+//
+// という形で上記の問題を回避する。
+//
+// # REFERENCES
+//   - https://pkg.go.dev/database/sql@go1.26.0#Stmt
+//   - https://pkg.go.dev/context@go1.26.0#WithCancelCause
+//   - https://pkg.go.dev/errors@go1.26.0#Join
+package fanout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Mode は、いずれかのクエリが失敗した際の振る舞いを表す。
+type Mode int
+
+const (
+	// FailFast は、最初の失敗でコンテキストをキャンセルし、そのエラーを返す。
+	FailFast Mode = iota
+	// CollectAll は、失敗があっても全てのクエリを実行し、エラーを errors.Join でまとめて返す。
+	CollectAll
+)
+
+// Options は、並行実行の設定を表す。
+type Options struct {
+	// Limit は、同時実行数の上限。0以下の場合は1として扱う。
+	//
+	// *sql.DB の SetMaxOpenConns より大きな値を指定しても
+	// コネクション待ちになるだけなので、通常はそれ以下の値を指定する。
+	Limit int
+	// Mode は、失敗時の振る舞い。
+	Mode Mode
+}
+
+// ScanFunc は、*sql.Row から1件分の結果を読み取る関数。
+type ScanFunc[T any] func(row *sql.Row) (T, error)
+
+// Error は、何番目のパラメータで失敗したのかを保持するエラー。
+type Error struct {
+	Index int
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("fanout[%d]: %v", e.Index, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Run は、fn を 0 から n-1 までのインデックスで並行に呼び出し、結果をインデックス順に返す。
+//
+// FailFast の場合、エラーが発生すると結果は nil となる。
+// CollectAll の場合、失敗したインデックスはゼロ値のまま結果を返し、エラーも合わせて返す。
+// 親コンテキストがキャンセルされた場合、未着手のインデックスはそのキャンセル理由で失敗扱いとなる。
+func Run[T any](ctx context.Context, n int, opts Options, fn func(ctx context.Context, i int) (T, error)) ([]T, error) {
+	var (
+		limit = max(opts.Limit, 1)
+		sem   = make(chan struct{}, limit)
+		wg    sync.WaitGroup
+
+		results = make([]T, n)
+		errs    = make([]error, n)
+
+		once     sync.Once
+		firstErr error
+
+		runCtx, cancel = context.WithCancelCause(ctx)
+	)
+	defer cancel(nil)
+
+	started := 0
+loop:
+	for ; started < n; started++ {
+		if runCtx.Err() != nil {
+			break
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-runCtx.Done():
+			break loop
+		}
+
+		i := started
+		wg.Go(func() {
+			defer func() { <-sem }()
+
+			v, err := fn(runCtx, i)
+			if err != nil {
+				errs[i] = &Error{Index: i, Err: err}
+
+				if opts.Mode == FailFast {
+					once.Do(func() {
+						firstErr = errs[i]
+						cancel(firstErr)
+					})
+				}
+				return
+			}
+
+			results[i] = v
+		})
+	}
+
+	wg.Wait()
+
+	if opts.Mode == FailFast {
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		if started < n {
+			return nil, fmt.Errorf("fanout: %w", context.Cause(ctx))
+		}
+
+		return results, nil
+	}
+
+	for i := started; i < n; i++ {
+		errs[i] = &Error{Index: i, Err: context.Cause(ctx)}
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// QueryStmt は、準備済みステートメントを params の各要素をパラメータとして並行に実行する。
+//
+// *sql.Stmt は複数のゴルーチンから同時に利用できるため、ステートメントは一つで良い。
+func QueryStmt[T any](ctx context.Context, stmt *sql.Stmt, params [][]any, scan ScanFunc[T], opts Options) ([]T, error) {
+	return Run(ctx, len(params), opts, func(ctx context.Context, i int) (T, error) {
+		return scan(stmt.QueryRowContext(ctx, params[i]...))
+	})
+}
+
+// QueryDB は、query を params の各要素をパラメータとして並行に実行する。
+//
+// 毎回アドホックにクエリを発行する。同じクエリを大量に実行する場合は QueryStmt の方が良い。
+func QueryDB[T any](ctx context.Context, db *sql.DB, query string, params [][]any, scan ScanFunc[T], opts Options) ([]T, error) {
+	return Run(ctx, len(params), opts, func(ctx context.Context, i int) (T, error) {
+		return scan(db.QueryRowContext(ctx, query, params[i]...))
+	})
+}
@@ -0,0 +1,201 @@
+package fanout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestRunOrderAndLimit(t *testing.T) {
+	const (
+		n     = 50
+		limit = 4
+	)
+	var (
+		running atomic.Int32
+		peak    atomic.Int32
+	)
+	results, err := Run(context.Background(), n, Options{Limit: limit}, func(ctx context.Context, i int) (int, error) {
+		cur := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if cur <= p || peak.CompareAndSwap(p, cur) {
+				break
+			}
+		}
+
+		// 後のインデックスほど早く終わるようにして、完了順と結果の順序が異なるようにする
+		time.Sleep(time.Duration(n-i) * 100 * time.Microsecond)
+		return i * i, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != n {
+		t.Fatalf("len(results) = %d, want %d", len(results), n)
+	}
+	for i, v := range results {
+		if v != i*i {
+			t.Errorf("results[%d] = %d, want %d", i, v, i*i)
+		}
+	}
+	if p := peak.Load(); p > limit {
+		t.Errorf("peak concurrency = %d, want <= %d", p, limit)
+	}
+}
+
+func TestRunFailFast(t *testing.T) {
+	var (
+		errBoom   = errors.New("boom")
+		cancelled atomic.Int32
+	)
+	results, err := Run(context.Background(), 20, Options{Limit: 4, Mode: FailFast}, func(ctx context.Context, i int) (int, error) {
+		if i == 3 {
+			return 0, errBoom
+		}
+
+		// 失敗したらコンテキストがキャンセルされ、実行中のものも中断されること
+		select {
+		case <-ctx.Done():
+			cancelled.Add(1)
+			return 0, ctx.Err()
+		case <-time.After(5 * time.Second):
+			return i, nil
+		}
+	})
+
+	if results != nil {
+		t.Errorf("results = %v, want nil", results)
+	}
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("err = %v, want %v", err, errBoom)
+	}
+
+	var (
+		fe *Error
+	)
+	if !errors.As(err, &fe) || fe.Index != 3 {
+		t.Errorf("err = %#v, want *Error with Index 3", err)
+	}
+	if cancelled.Load() == 0 {
+		t.Error("running functions were not cancelled")
+	}
+}
+
+func TestRunCollectAll(t *testing.T) {
+	var (
+		errOdd = errors.New("odd")
+	)
+	results, err := Run(context.Background(), 10, Options{Limit: 3, Mode: CollectAll}, func(ctx context.Context, i int) (int, error) {
+		if i%2 == 1 {
+			return 0, errOdd
+		}
+		return i, nil
+	})
+
+	if !errors.Is(err, errOdd) {
+		t.Fatalf("err = %v, want %v", err, errOdd)
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 5 {
+		t.Errorf("number of errors = %d, want 5", n)
+	}
+	for i, v := range results {
+		if i%2 == 0 && v != i {
+			t.Errorf("results[%d] = %d, want %d", i, v, i)
+		}
+		if i%2 == 1 && v != 0 {
+			t.Errorf("results[%d] = %d, want zero value", i, v)
+		}
+	}
+}
+
+func TestRunParentCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, mode := range []Mode{FailFast, CollectAll} {
+		var (
+			calls atomic.Int32
+		)
+		_, err := Run(ctx, 5, Options{Limit: 2, Mode: mode}, func(ctx context.Context, i int) (int, error) {
+			calls.Add(1)
+			return i, nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("mode=%d: err = %v, want %v", mode, err, context.Canceled)
+		}
+		if calls.Load() != 0 {
+			t.Errorf("mode=%d: fn called %d times, want 0", mode, calls.Load())
+		}
+	}
+}
+
+func TestQueryStmt(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(4)
+
+	stmt, err := db.Prepare("SELECT ? * 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	var (
+		params = make([][]any, 30)
+		scan   = func(row *sql.Row) (int, error) {
+			var v int
+			err := row.Scan(&v)
+			return v, err
+		}
+	)
+	for i := range params {
+		params[i] = []any{i}
+	}
+
+	for _, mode := range []Mode{FailFast, CollectAll} {
+		results, err := QueryStmt(context.Background(), stmt, params, scan, Options{Limit: 4, Mode: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range results {
+			if v != i*2 {
+				t.Errorf("mode=%d: results[%d] = %d, want %d", mode, i, v, i*2)
+			}
+		}
+	}
+}
+
+func TestQueryDBError(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var (
+		params = [][]any{{1}, {2}, {3}}
+		scan   = func(row *sql.Row) (int, error) {
+			var v int
+			err := row.Scan(&v)
+			return v, err
+		}
+	)
+	_, err = QueryDB(context.Background(), db, "SELECT * FROM no_such_table WHERE id = ?", params, scan, Options{Limit: 2, Mode: CollectAll})
+	if err == nil {
+		t.Fatal("err = nil, want error")
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != len(params) {
+		t.Errorf("number of errors = %d, want %d", n, len(params))
+	}
+}
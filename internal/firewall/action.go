@@ -0,0 +1,267 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Action は、SQLiteが認可コールバックに渡す操作の種類。
+//
+// 値は sqlite3.SQLITE_READ などの定数と同じ。
+type Action int
+
+const (
+	CreateIndex       Action = sqlite3.SQLITE_CREATE_INDEX
+	CreateTable       Action = sqlite3.SQLITE_CREATE_TABLE
+	CreateTempIndex   Action = sqlite3.SQLITE_CREATE_TEMP_INDEX
+	CreateTempTable   Action = sqlite3.SQLITE_CREATE_TEMP_TABLE
+	CreateTempTrigger Action = sqlite3.SQLITE_CREATE_TEMP_TRIGGER
+	CreateTempView    Action = sqlite3.SQLITE_CREATE_TEMP_VIEW
+	CreateTrigger     Action = sqlite3.SQLITE_CREATE_TRIGGER
+	CreateView        Action = sqlite3.SQLITE_CREATE_VIEW
+	Delete            Action = sqlite3.SQLITE_DELETE
+	DropIndex         Action = sqlite3.SQLITE_DROP_INDEX
+	DropTable         Action = sqlite3.SQLITE_DROP_TABLE
+	DropTempIndex     Action = sqlite3.SQLITE_DROP_TEMP_INDEX
+	DropTempTable     Action = sqlite3.SQLITE_DROP_TEMP_TABLE
+	DropTempTrigger   Action = sqlite3.SQLITE_DROP_TEMP_TRIGGER
+	DropTempView      Action = sqlite3.SQLITE_DROP_TEMP_VIEW
+	DropTrigger       Action = sqlite3.SQLITE_DROP_TRIGGER
+	DropView          Action = sqlite3.SQLITE_DROP_VIEW
+	Insert            Action = sqlite3.SQLITE_INSERT
+	Pragma            Action = sqlite3.SQLITE_PRAGMA
+	Read              Action = sqlite3.SQLITE_READ
+	Select            Action = sqlite3.SQLITE_SELECT
+	Transaction       Action = sqlite3.SQLITE_TRANSACTION
+	Update            Action = sqlite3.SQLITE_UPDATE
+	Attach            Action = sqlite3.SQLITE_ATTACH
+	Detach            Action = sqlite3.SQLITE_DETACH
+	AlterTable        Action = sqlite3.SQLITE_ALTER_TABLE
+	Reindex           Action = sqlite3.SQLITE_REINDEX
+	Analyze           Action = sqlite3.SQLITE_ANALYZE
+	CreateVTable      Action = sqlite3.SQLITE_CREATE_VTABLE
+	DropVTable        Action = sqlite3.SQLITE_DROP_VTABLE
+	Function          Action = sqlite3.SQLITE_FUNCTION
+	Savepoint         Action = sqlite3.SQLITE_SAVEPOINT
+	Recursive         Action = 33 // SQLITE_RECURSIVE (mattn/go-sqlite3 には定数が無い)
+)
+
+var (
+	actionNames = map[Action]string{
+		CreateIndex:       "create_index",
+		CreateTable:       "create_table",
+		CreateTempIndex:   "create_temp_index",
+		CreateTempTable:   "create_temp_table",
+		CreateTempTrigger: "create_temp_trigger",
+		CreateTempView:    "create_temp_view",
+		CreateTrigger:     "create_trigger",
+		CreateView:        "create_view",
+		Delete:            "delete",
+		DropIndex:         "drop_index",
+		DropTable:         "drop_table",
+		DropTempIndex:     "drop_temp_index",
+		DropTempTable:     "drop_temp_table",
+		DropTempTrigger:   "drop_temp_trigger",
+		DropTempView:      "drop_temp_view",
+		DropTrigger:       "drop_trigger",
+		DropView:          "drop_view",
+		Insert:            "insert",
+		Pragma:            "pragma",
+		Read:              "read",
+		Select:            "select",
+		Transaction:       "transaction",
+		Update:            "update",
+		Attach:            "attach",
+		Detach:            "detach",
+		AlterTable:        "alter_table",
+		Reindex:           "reindex",
+		Analyze:           "analyze",
+		CreateVTable:      "create_vtable",
+		DropVTable:        "drop_vtable",
+		Function:          "function",
+		Savepoint:         "savepoint",
+		Recursive:         "recursive",
+	}
+)
+
+func (a Action) String() string {
+	if s, ok := actionNames[a]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("action(%d)", int(a))
+}
+
+// Class は、Action の分類。
+func (a Action) Class() Class {
+	switch a {
+	case Read, Select, Recursive:
+		return ClassRead
+	case Insert, Update, Delete:
+		return ClassWrite
+	case Attach, Detach:
+		return ClassAttach
+	case Pragma:
+		return ClassPragma
+	case Transaction, Savepoint:
+		return ClassTransaction
+	case Function:
+		return ClassFunction
+	case Reindex, Analyze:
+		return ClassMaintenance
+	case CreateTempIndex, CreateTempTable, CreateTempTrigger, CreateTempView,
+		DropTempIndex, DropTempTable, DropTempTrigger, DropTempView:
+		return ClassTempDDL
+	default:
+		return ClassDDL
+	}
+}
+
+// MarshalText は、Action を名前 ("read", "create_table" など) に変換する。
+func (a Action) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText は、名前 ("read", "create_table" など) から Action を設定する。
+func (a *Action) UnmarshalText(b []byte) error {
+	var (
+		name = strings.ToLower(string(b))
+	)
+	for k, v := range actionNames {
+		if v == name {
+			*a = k
+			return nil
+		}
+	}
+
+	return fmt.Errorf("firewall: unknown action %q", b)
+}
+
+// Class は、操作の分類。
+//
+// 個々の Action を列挙しなくても、「書き込み全て」「DDL全て」といった単位でルールを書けるようにするためのもの。
+type Class int
+
+const (
+	ClassRead        Class = iota + 1 // SELECT, 列の読み出し, 再帰CTE
+	ClassWrite                        // INSERT, UPDATE, DELETE
+	ClassDDL                          // CREATE, DROP, ALTER (一時オブジェクトを除く)
+	ClassTempDDL                      // 一時オブジェクト (TEMP) の CREATE, DROP
+	ClassAttach                       // ATTACH, DETACH
+	ClassPragma                       // PRAGMA
+	ClassTransaction                  // BEGIN, COMMIT, ROLLBACK, SAVEPOINT
+	ClassFunction                     // 関数呼び出し
+	ClassMaintenance                  // REINDEX, ANALYZE
+)
+
+var (
+	classNames = map[Class]string{
+		ClassRead:        "read",
+		ClassWrite:       "write",
+		ClassDDL:         "ddl",
+		ClassTempDDL:     "temp_ddl",
+		ClassAttach:      "attach",
+		ClassPragma:      "pragma",
+		ClassTransaction: "transaction",
+		ClassFunction:    "function",
+		ClassMaintenance: "maintenance",
+	}
+)
+
+func (c Class) String() string {
+	if s, ok := classNames[c]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("class(%d)", int(c))
+}
+
+// MarshalText は、Class を名前 ("write", "ddl" など) に変換する。
+func (c Class) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText は、名前 ("write", "ddl" など) から Class を設定する。
+func (c *Class) UnmarshalText(b []byte) error {
+	var (
+		name = strings.ToLower(string(b))
+	)
+	for k, v := range classNames {
+		if v == name {
+			*c = k
+			return nil
+		}
+	}
+
+	return fmt.Errorf("firewall: unknown class %q", b)
+}
+
+// Request は、認可コールバック1回分の問い合わせ内容。
+//
+// SQLiteはコールバックに操作の種類毎に意味の異なる引数を渡すため、テーブル名・列名・オブジェクト名に整理したもの。
+type Request struct {
+	Action   Action
+	Database string // main, temp, ATTACH した別名など (無い場合は空)
+	Table    string // 対象のテーブル名 (無い場合は空)
+	Column   string // 対象の列名 (Read, Update のみ)
+	Object   string // インデックス・トリガー・ビュー・PRAGMA・関数・セーブポイントの名前、ATTACH のファイル名など
+}
+
+// newRequest は、認可コールバックの引数から Request を生成する。
+//
+// 引数の意味は https://www.sqlite.org/c3ref/c_alter_table.html を参照。
+func newRequest(op int, arg1, arg2, dbName string) Request {
+	var (
+		r = Request{Action: Action(op), Database: dbName}
+	)
+
+	switch r.Action {
+	case Read, Update:
+		r.Table, r.Column = arg1, arg2
+	case Insert, Delete, CreateTable, CreateTempTable, DropTable, DropTempTable, Analyze:
+		r.Table = arg1
+	case CreateVTable, DropVTable:
+		r.Table, r.Object = arg1, arg2
+	case CreateIndex, CreateTempIndex, DropIndex, DropTempIndex,
+		CreateTrigger, CreateTempTrigger, DropTrigger, DropTempTrigger:
+		r.Object, r.Table = arg1, arg2
+	case AlterTable:
+		r.Database, r.Table = arg1, arg2
+	case Function, Savepoint:
+		r.Object = arg2
+	case Detach:
+		r.Database = arg1
+	default:
+		// CreateView, DropView, Pragma, Transaction, Attach, Reindex など
+		r.Object = arg1
+	}
+
+	return r
+}
+
+func (r Request) String() string {
+	var (
+		sb strings.Builder
+	)
+	sb.WriteString(strings.ToUpper(r.Action.String()))
+
+	var (
+		target = r.Table
+	)
+	if target != "" && r.Column != "" {
+		target += "." + r.Column
+	}
+	if target != "" && r.Database != "" {
+		target = r.Database + "." + target
+	}
+	if target != "" {
+		sb.WriteString(" on " + target)
+	}
+	if r.Object != "" {
+		fmt.Fprintf(&sb, " (%s)", r.Object)
+	}
+
+	return sb.String()
+}
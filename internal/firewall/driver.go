@@ -0,0 +1,98 @@
+package firewall
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Driver は、d が開いたコネクションのエラーを *DeniedError に変換するドライバを返す。
+//
+// d の ConnectHook から Hook を呼び出しておくこと。呼び出されていない場合、コネクションを開く際にエラーとなる。
+func (f *Firewall) Driver(d *sqlite3.SQLiteDriver) driver.Driver {
+	return &fwDriver{base: d, f: f}
+}
+
+type fwDriver struct {
+	base *sqlite3.SQLiteDriver
+	f    *Firewall
+}
+
+func (d *fwDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	sc, ok := c.(*sqlite3.SQLiteConn)
+	if !ok {
+		c.Close()
+		return nil, fmt.Errorf("firewall: unexpected connection type %T", c)
+	}
+
+	st := d.f.take(sc)
+	if st == nil {
+		sc.Close()
+		return nil, errors.New("firewall: authorizer not installed; call Firewall.Hook from ConnectHook")
+	}
+
+	return &fwConn{SQLiteConn: sc, st: st}, nil
+}
+
+// fwConn は、*sqlite3.SQLiteConn のエラーを変換するコネクション。
+//
+// 認可コールバックは文のコンパイル時に呼ばれるため、文をコンパイルするメソッドのみを上書きしている。
+type fwConn struct {
+	*sqlite3.SQLiteConn
+	st *connState
+}
+
+func (c *fwConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	c.st.denied = nil
+	s, err := c.SQLiteConn.PrepareContext(ctx, query)
+	return s, c.classify(err)
+}
+
+func (c *fwConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.st.denied = nil
+	r, err := c.SQLiteConn.ExecContext(ctx, query, args)
+	return r, c.classify(err)
+}
+
+func (c *fwConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.st.denied = nil
+	r, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	return r, c.classify(err)
+}
+
+func (c *fwConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.st.denied = nil
+	tx, err := c.SQLiteConn.BeginTx(ctx, opts)
+	return tx, c.classify(err)
+}
+
+// classify は、認可エラーであれば直前に拒否した操作を持つ *DeniedError に変換する。
+func (c *fwConn) classify(err error) error {
+	var (
+		denied = c.st.denied
+	)
+	c.st.denied = nil
+
+	if err == nil || denied == nil {
+		return err
+	}
+
+	var (
+		sqliteErr sqlite3.Error
+	)
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrAuth {
+		return err
+	}
+
+	denied.Err = err
+
+	return denied
+}
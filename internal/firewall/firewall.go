@@ -0,0 +1,334 @@
+// Package firewall は、SQLiteの認可コールバック (sqlite3_set_authorizer) を使ったクエリファイアウォールを提供する。
+//
+// SQLiteは文をコンパイル (prepare) する際に、その文が行う操作
+// (テーブルの読み出し、INSERT、CREATE TABLE、ATTACH、PRAGMA など) を1つずつ認可コールバックに問い合わせる。
+// コールバックが拒否すると、文のコンパイルは "not authorized" エラーで失敗し、実行されない。
+//
+// 本パッケージでは、許可・拒否のルールを宣言的に記述した Policy を元に問い合わせに応答する。
+// プラグインやレポート処理に「読み出しのみ、ただし特定の列は見せない」といった制限付きのコネクションを渡す用途を想定している。
+//
+//	fw, err := firewall.New(firewall.Policy{
+//		Default: firewall.Allow,
+//		Rules: []firewall.Rule{
+//			{Name: "no-ddl", Effect: firewall.Deny, Classes: []firewall.Class{firewall.ClassDDL}},
+//			{Name: "hide-email", Effect: firewall.Ignore, Actions: []firewall.Action{firewall.Read}, Table: "customers", Column: "Email"},
+//		},
+//	})
+//	sql.Register("sqlite3_fw", fw.Driver(&sqlite3.SQLiteDriver{ConnectHook: fw.Hook}))
+//
+// 認可コールバックは 14.ConnHook_mattn と同様に ConnectHook の中でコネクション毎に登録する (Firewall.Hook)。
+// SQLiteが返すエラーは "not authorized" のみで、何が拒否されたのかは分からない。
+// Firewall.Driver で包んだドライバを使うと、エラーが拒否された操作と適用されたルールを持つ *DeniedError に変換される。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/c3ref/set_authorizer.html
+//   - https://www.sqlite.org/c3ref/c_alter_table.html
+//   - https://pkg.go.dev/github.com/mattn/go-sqlite3#SQLiteConn.RegisterAuthorizer
+package firewall
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrDenied は、ファイアウォールによって文が拒否されたことを表すエラー。
+	//
+	// errors.Is(err, firewall.ErrDenied) で判定でき、詳細は errors.As で *DeniedError を取り出して参照する。
+	ErrDenied = errors.New("firewall: statement denied")
+)
+
+// Effect は、ルールに一致した場合の応答。
+type Effect int
+
+const (
+	// Deny は、文のコンパイルをエラーにする。ゼロ値であるため、Policy.Default を省略すると全て拒否となる。
+	Deny Effect = iota
+	// Allow は、操作を許可する。
+	Allow
+	// Ignore は、エラーにせずに操作を無かったことにする。
+	//
+	// Read の場合はその列が NULL として読み出される。
+	// その他の操作の場合の挙動は https://www.sqlite.org/c3ref/c_deny.html を参照。
+	Ignore
+)
+
+var (
+	effectNames = map[Effect]string{
+		Deny:   "deny",
+		Allow:  "allow",
+		Ignore: "ignore",
+	}
+)
+
+func (e Effect) String() string {
+	if s, ok := effectNames[e]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("effect(%d)", int(e))
+}
+
+// MarshalText は、Effect を名前 ("allow", "deny", "ignore") に変換する。
+func (e Effect) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+// UnmarshalText は、名前 ("allow", "deny", "ignore") から Effect を設定する。
+func (e *Effect) UnmarshalText(b []byte) error {
+	var (
+		name = strings.ToLower(string(b))
+	)
+	for k, v := range effectNames {
+		if v == name {
+			*e = k
+			return nil
+		}
+	}
+
+	return fmt.Errorf("firewall: unknown effect %q", b)
+}
+
+type (
+	// Rule は、許可・拒否のルール。
+	//
+	// Actions と Classes の両方が空の場合は全ての操作に一致し、どちらかが指定されている場合はいずれかに一致する操作が対象となる。
+	// Database, Table, Column, Object は path.Match 形式のパターン (大文字小文字を区別しない) で、空の場合は何にでも一致する。
+	// パターンを指定した項目は、Request 側の値が空だと一致しない。
+	Rule struct {
+		// Name は、ルールの名前。拒否された場合のエラーに含まれる。
+		Name    string   `json:"name,omitempty"`
+		Effect  Effect   `json:"effect"`
+		Actions []Action `json:"actions,omitempty"`
+		Classes []Class  `json:"classes,omitempty"`
+
+		Database string `json:"database,omitempty"`
+		Table    string `json:"table,omitempty"`
+		Column   string `json:"column,omitempty"`
+		Object   string `json:"object,omitempty"`
+	}
+
+	// Policy は、ルールの一覧。
+	//
+	// ルールは先頭から順に評価し、最初に一致したルールの Effect で応答する。
+	// どのルールにも一致しない場合は Default で応答する。
+	Policy struct {
+		Default Effect `json:"default"`
+		Rules   []Rule `json:"rules"`
+	}
+
+	// DeniedError は、ファイアウォールによって文が拒否された場合のエラー。
+	DeniedError struct {
+		// Request は、拒否された操作。文の中で最初に拒否されたもの。
+		Request Request
+		// Rule は、適用されたルールの名前。Policy.Default で拒否された場合は空。
+		Rule string
+		// Err は、ドライバが返した元のエラー (sqlite3.Error, Code は sqlite3.ErrAuth)。
+		Err error
+	}
+)
+
+// ParsePolicy は、JSON から Policy を読み込む。
+//
+//	{
+//	  "default": "allow",
+//	  "rules": [
+//	    {"name": "no-ddl", "effect": "deny", "classes": ["ddl", "attach"]},
+//	    {"name": "readonly-invoices", "effect": "deny", "classes": ["write"], "table": "invoice*"}
+//	  ]
+//	}
+func ParsePolicy(b []byte) (Policy, error) {
+	var (
+		p   Policy
+		dec = json.NewDecoder(bytes.NewReader(b))
+	)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&p); err != nil {
+		return Policy{}, fmt.Errorf("firewall: parse policy: %w", err)
+	}
+
+	return p, p.validate()
+}
+
+func (p Policy) validate() error {
+	var (
+		errs []error
+	)
+	if _, ok := effectNames[p.Default]; !ok {
+		errs = append(errs, fmt.Errorf("firewall: default: unknown effect %d", p.Default))
+	}
+
+	for i, r := range p.Rules {
+		var (
+			name = r.Name
+		)
+		if name == "" {
+			name = fmt.Sprintf("rules[%d]", i)
+		}
+
+		if _, ok := effectNames[r.Effect]; !ok {
+			errs = append(errs, fmt.Errorf("firewall: %s: unknown effect %d", name, r.Effect))
+		}
+		for _, pattern := range []string{r.Database, r.Table, r.Column, r.Object} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("firewall: %s: bad pattern %q: %w", name, pattern, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// decide は、req に対する応答と、適用されたルールを返す。ルールが無い場合は nil。
+func (p Policy) decide(req Request) (Effect, *Rule) {
+	for i := range p.Rules {
+		if p.Rules[i].matches(req) {
+			return p.Rules[i].Effect, &p.Rules[i]
+		}
+	}
+
+	return p.Default, nil
+}
+
+func (r *Rule) matches(req Request) bool {
+	if len(r.Actions) > 0 || len(r.Classes) > 0 {
+		if !slices.Contains(r.Actions, req.Action) && !slices.Contains(r.Classes, req.Action.Class()) {
+			return false
+		}
+	}
+
+	return match(r.Database, req.Database) &&
+		match(r.Table, req.Table) &&
+		match(r.Column, req.Column) &&
+		match(r.Object, req.Object)
+}
+
+func match(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	if name == "" {
+		return false
+	}
+
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+
+	return ok
+}
+
+func (e *DeniedError) Error() string {
+	if e.Rule == "" {
+		return fmt.Sprintf("firewall: %s denied by default policy", e.Request)
+	}
+
+	return fmt.Sprintf("firewall: %s denied by rule %q", e.Request, e.Rule)
+}
+
+// Unwrap は、ErrDenied と元のエラーを返す。
+func (e *DeniedError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrDenied}
+	}
+
+	return []error{ErrDenied, e.Err}
+}
+
+type (
+	// connState は、コネクション毎の状態。
+	connState struct {
+		// denied は、直近の文で最初に拒否された操作。
+		//
+		// 認可コールバックと、それを呼び出すドライバの処理は同じゴルーチンで実行されるため、ロックは不要。
+		denied *DeniedError
+	}
+
+	// Firewall は、Policy に従って認可コールバックに応答する。
+	Firewall struct {
+		policy Policy
+
+		mu    sync.Mutex
+		conns map[*sqlite3.SQLiteConn]*connState
+	}
+)
+
+// New は、policy に従う Firewall を生成する。
+func New(policy Policy) (*Firewall, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+
+	f := &Firewall{
+		policy: Policy{
+			Default: policy.Default,
+			Rules:   slices.Clone(policy.Rules),
+		},
+		conns: make(map[*sqlite3.SQLiteConn]*connState),
+	}
+
+	return f, nil
+}
+
+// Hook は、conn に認可コールバックを登録する。
+//
+// sqlite3.SQLiteDriver の ConnectHook から呼び出す。
+// ConnectHook の中で Hook より前に実行した文 (PRAGMA の設定など) は制限されない。
+//
+// 拒否した操作を *DeniedError として返すには、ドライバを Driver で包むこと。
+// (Driver で包まない場合、Hook で用意したコネクション毎の状態は Firewall に残り続ける)
+func (f *Firewall) Hook(conn *sqlite3.SQLiteConn) error {
+	var (
+		st = &connState{}
+	)
+
+	f.mu.Lock()
+	f.conns[conn] = st
+	f.mu.Unlock()
+
+	conn.RegisterAuthorizer(func(op int, arg1, arg2, dbName string) int {
+		var (
+			req          = newRequest(op, arg1, arg2, dbName)
+			effect, rule = f.policy.decide(req)
+		)
+
+		switch effect {
+		case Allow:
+			return sqlite3.SQLITE_OK
+		case Ignore:
+			return sqlite3.SQLITE_IGNORE
+		}
+
+		if st.denied == nil {
+			st.denied = &DeniedError{Request: req}
+			if rule != nil {
+				st.denied.Rule = rule.Name
+			}
+		}
+
+		return sqlite3.SQLITE_DENY
+	})
+
+	return nil
+}
+
+// take は、conn の状態を取り出してファイアウォールの管理から外す。
+func (f *Firewall) take(conn *sqlite3.SQLiteConn) *connState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	st, ok := f.conns[conn]
+	if !ok {
+		return nil
+	}
+	delete(f.conns, conn)
+
+	return st
+}
@@ -0,0 +1,359 @@
+// Package groupcommit は、SQLiteへの書き込みを1つのゴルーチンに集め、複数の書き込みを1つのトランザクションでコミットする。
+//
+// SQLiteの書き込みはデータベース全体で同時に1つのみであり、13.ConnHook_modernc, 14.ConnHook_mattn では
+// SetMaxOpenConns(1) で直列化している。しかし、小さな INSERT を1件ずつコミットすると、
+// コミット毎にジャーナルの書き込みや fsync が発生し、それが処理時間の大半を占める。
+//
+// Writer は、呼び出し元から書き込み処理 (Job) をチャネルで受け取り、1つのゴルーチンで実行する。
+// 受け取った Job は MaxBatch 件、または最初の Job を受け取ってから MaxDelay 経過するまで溜めて、
+// 1つのトランザクションでまとめて実行・コミットする (グループコミット)。
+//
+// 各 Job は SAVEPOINT の中で実行するため、ある Job が失敗しても、その Job の変更だけが取り消され、
+// 同じトランザクションの他の Job には影響しない。結果 (エラー) は Job 毎に呼び出し元に返す。
+//
+// Job には *sql.Tx ではなく、ExecContext, QueryContext, QueryRowContext のみを持つ Tx を渡す。
+// Job が Commit や Rollback を呼び出したり、SAVEPOINT の外で PrepareContext した文を残したりすると、
+// 同じトランザクションの他の Job を巻き込むため。
+// コミット自体が失敗した場合は、そのトランザクションの全ての Job がエラーとなる。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/faq.html#q19
+//   - https://www.sqlite.org/lang_savepoint.html
+//   - https://www.sqlite.org/lang_transaction.html
+package groupcommit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrClosed は、Close 後に Do を呼び出した場合のエラー。
+	ErrClosed = errors.New("groupcommit: writer closed")
+)
+
+type (
+	// Job は、トランザクションの中で実行する書き込み処理。
+	//
+	// 結果を返したい場合は、クロージャで呼び出し元の変数に格納する。
+	// エラーを返すと、その Job の変更のみ取り消される (ROLLBACK TO SAVEPOINT)。
+	//
+	// ctx は Do に渡した ctx の値を引き継ぐが、キャンセルはされない (Tx の文も Do の ctx では中断されない)。
+	// 実行中の文を中断すると、同じトランザクションの他の Job の変更まで取り消されるため。
+	Job func(ctx context.Context, tx Tx) error
+
+	// Tx は、Job に渡すトランザクション。文の実行のみでき、Commit や Rollback は Writer が行う。
+	Tx interface {
+		ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+		QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+		QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	}
+
+	// jobTx は、Job に渡す Tx の実装。型アサーションで *sql.Tx を取り出せないようにラップする。
+	//
+	// Job がクロージャで呼び出し元の ctx を使った場合も中断されないように、渡された ctx のキャンセルは無視する。
+	jobTx struct {
+		tx *sql.Tx
+	}
+
+	// Options は、Writer のオプション。
+	Options struct {
+		// MaxBatch は、1つのトランザクションで実行する Job の最大数。0 の場合は 128。
+		MaxBatch int
+		// MaxDelay は、最初の Job を受け取ってから、他の Job を待つ最大時間。0 の場合は 2ms。
+		//
+		// 待っている間に MaxBatch 件に達した場合は、すぐに実行する。
+		MaxDelay time.Duration
+		// QueueSize は、実行待ちの Job を溜めておけるチャネルのサイズ。0 の場合は MaxBatch と同じ。
+		QueueSize int
+	}
+
+	// Stats は、Writer の統計。
+	Stats struct {
+		// Jobs は、実行した Job の数 (失敗したものを含む)。
+		Jobs int64
+		// Failed は、エラーとなった Job の数。
+		Failed int64
+		// Batches は、コミット (またはロールバック) したトランザクションの数。
+		Batches int64
+		// MaxBatch は、1つのトランザクションで実行した Job の最大数。
+		MaxBatch int64
+	}
+
+	// Writer は、書き込みを1つのゴルーチンで実行する。
+	Writer struct {
+		db   *sql.DB
+		opts Options
+
+		reqs    chan *request
+		quit    chan struct{}
+		stopped chan struct{}
+		once    sync.Once
+
+		jobs, failed, batches, maxBatch atomic.Int64
+	}
+
+	request struct {
+		ctx  context.Context
+		job  Job
+		done chan error
+	}
+)
+
+// New は、db に書き込む Writer を生成し、書き込み用のゴルーチンを開始する。
+//
+// db には書き込み用の *sql.DB (rwpool.Pool.Writer() など) を渡す。利用が終わったら Close すること。
+func New(db *sql.DB, opts Options) *Writer {
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = 128
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = 2 * time.Millisecond
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = opts.MaxBatch
+	}
+
+	w := &Writer{
+		db:      db,
+		opts:    opts,
+		reqs:    make(chan *request, opts.QueueSize),
+		quit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.loop()
+
+	return w
+}
+
+// Do は、job を書き込み用のゴルーチンで実行し、そのトランザクションがコミットされるまで待つ。
+//
+// job が成功し、トランザクションがコミットされた場合に nil を返す。
+// ctx がキャンセルされた場合、まだ実行されていなければ job は実行されない。
+// 既に実行中・実行済みの場合は、job を中断せずに、結果を待たずに ctx.Err() を返す (コミットされるかどうかは分からない)。
+func (w *Writer) Do(ctx context.Context, job Job) error {
+	var (
+		r = &request{ctx: ctx, job: job, done: make(chan error, 1)}
+	)
+
+	select {
+	case <-w.quit:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case w.reqs <- r:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.stopped:
+		return ErrClosed
+	}
+
+	select {
+	case err := <-r.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.stopped:
+		// 停止直前に処理された場合は結果を返す
+		select {
+		case err := <-r.done:
+			return err
+		default:
+			return ErrClosed
+		}
+	}
+}
+
+// Close は、受付済みの Job を全て実行してから、書き込み用のゴルーチンを停止する。
+func (w *Writer) Close() error {
+	w.once.Do(func() {
+		close(w.quit)
+	})
+	<-w.stopped
+
+	return nil
+}
+
+// Stats は、Writer の統計を返す。
+func (w *Writer) Stats() Stats {
+	return Stats{
+		Jobs:     w.jobs.Load(),
+		Failed:   w.failed.Load(),
+		Batches:  w.batches.Load(),
+		MaxBatch: w.maxBatch.Load(),
+	}
+}
+
+func (w *Writer) loop() {
+	defer close(w.stopped)
+
+	var (
+		batch = make([]*request, 0, w.opts.MaxBatch)
+	)
+	for {
+		select {
+		case r := <-w.reqs:
+			batch = w.collect(append(batch[:0], r))
+			w.run(batch)
+		case <-w.quit:
+			// 受付済みのものを実行してから停止する
+			for {
+				batch = w.drain(batch[:0])
+				if len(batch) == 0 {
+					return
+				}
+				w.run(batch)
+			}
+		}
+	}
+}
+
+// collect は、MaxBatch 件に達するか MaxDelay が経過するまで Job を追加する。
+func (w *Writer) collect(batch []*request) []*request {
+	var (
+		timer = time.NewTimer(w.opts.MaxDelay)
+	)
+	defer timer.Stop()
+
+	for len(batch) < w.opts.MaxBatch {
+		select {
+		case r := <-w.reqs:
+			batch = append(batch, r)
+		case <-timer.C:
+			return batch
+		case <-w.quit:
+			return batch
+		}
+	}
+
+	return batch
+}
+
+// drain は、チャネルに溜まっている Job を MaxBatch 件まで待たずに取り出す。
+func (w *Writer) drain(batch []*request) []*request {
+	for len(batch) < w.opts.MaxBatch {
+		select {
+		case r := <-w.reqs:
+			batch = append(batch, r)
+		default:
+			return batch
+		}
+	}
+
+	return batch
+}
+
+// run は、batch を1つのトランザクションで実行し、各呼び出し元に結果を返す。
+func (w *Writer) run(batch []*request) {
+	var (
+		errs = make([]error, len(batch))
+	)
+
+	w.batches.Add(1)
+	w.jobs.Add(int64(len(batch)))
+	if n := int64(len(batch)); n > w.maxBatch.Load() {
+		w.maxBatch.Store(n)
+	}
+
+	err := w.runTx(batch, errs)
+	for i, r := range batch {
+		if err != nil && errs[i] == nil {
+			errs[i] = err
+		}
+		if errs[i] != nil {
+			w.failed.Add(1)
+		}
+		r.done <- errs[i]
+	}
+}
+
+// runTx は、batch をトランザクションで実行する。各 Job のエラーは errs に、トランザクション自体のエラーは戻り値で返す。
+func (w *Writer) runTx(batch []*request, errs []error) error {
+	var (
+		ctx = context.Background()
+	)
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("groupcommit: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i, r := range batch {
+		if err = r.ctx.Err(); err != nil {
+			// 待っている間にキャンセルされたものは実行しない
+			errs[i] = err
+			continue
+		}
+
+		errs[i], err = runJob(r, tx)
+		if err != nil {
+			// SAVEPOINT を操作できない場合はトランザクション全体を諦める
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("groupcommit: commit: %w", err)
+	}
+
+	return nil
+}
+
+// runJob は、SAVEPOINT の中で r.job を実行する。
+//
+// 1つ目の戻り値は Job のエラー、2つ目は SAVEPOINT の操作に失敗した場合のエラー。
+func runJob(r *request, tx *sql.Tx) (jobErr, err error) {
+	var (
+		ctx = context.Background()
+	)
+
+	if _, err = tx.ExecContext(ctx, "SAVEPOINT groupcommit_job"); err != nil {
+		return nil, fmt.Errorf("groupcommit: savepoint: %w", err)
+	}
+
+	if jobErr = callJob(r, tx); jobErr != nil {
+		if _, err = tx.ExecContext(ctx, "ROLLBACK TO groupcommit_job"); err != nil {
+			return jobErr, fmt.Errorf("groupcommit: rollback to savepoint: %w", err)
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, "RELEASE groupcommit_job"); err != nil {
+		return jobErr, fmt.Errorf("groupcommit: release savepoint: %w", err)
+	}
+
+	return jobErr, nil
+}
+
+// callJob は、r.job を呼び出す。panic した場合はエラーとして扱う (書き込み用のゴルーチンを止めないため)。
+func callJob(r *request, tx *sql.Tx) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("groupcommit: job panicked: %v", v)
+		}
+	}()
+
+	// 文の実行中に ctx がキャンセルされると、ドライバが sqlite3_interrupt() を呼び出し、
+	// SAVEPOINT だけでなくトランザクション全体がロールバックされて、同じトランザクションの他の Job も失われる。
+	// そのため、キャンセルを確認するのは開始前 (runTx) のみとし、Job にはキャンセルされない ctx を渡す。
+	return r.job(context.WithoutCancel(r.ctx), jobTx{tx})
+}
+
+func (t jobTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.tx.ExecContext(context.WithoutCancel(ctx), query, args...)
+}
+
+func (t jobTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return t.tx.QueryContext(context.WithoutCancel(ctx), query, args...)
+}
+
+func (t jobTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return t.tx.QueryRowContext(context.WithoutCancel(ctx), query, args...)
+}
@@ -0,0 +1,179 @@
+package groupcommit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func open(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	if _, err = db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestPartialFailure(t *testing.T) {
+	var (
+		db   = open(t)
+		w    = New(db, Options{MaxBatch: 16})
+		ctx  = context.Background()
+		ids  = []int{1, 2, 2, 3, 1, 4}
+		errs = make([]error, len(ids))
+		wg   sync.WaitGroup
+	)
+
+	for i, id := range ids {
+		wg.Go(func() {
+			errs[i] = w.Do(ctx, func(ctx context.Context, tx Tx) error {
+				_, err := tx.ExecContext(ctx, "INSERT INTO items (id) VALUES (?)", id)
+				return err
+			})
+		})
+	}
+	wg.Wait()
+	w.Close()
+
+	var (
+		failed int
+	)
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed != 2 {
+		t.Errorf("failed jobs = %d, want 2 (%v)", failed, errs)
+	}
+
+	var (
+		n int
+	)
+	if err := db.QueryRow("SELECT count(*) FROM items").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("rows = %d, want 4", n)
+	}
+	if s := w.Stats(); s.Jobs != int64(len(ids)) || s.Failed != 2 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+// TestJobTx は、Job に渡す Tx から *sql.Tx を取り出せず、Job の中で読み込みもできることを確認する。
+func TestJobTx(t *testing.T) {
+	var (
+		db  = open(t)
+		w   = New(db, Options{})
+		ctx = context.Background()
+		n   int
+	)
+	defer w.Close()
+
+	err := w.Do(ctx, func(ctx context.Context, tx Tx) error {
+		if _, ok := tx.(*sql.Tx); ok {
+			t.Error("Job received *sql.Tx")
+		}
+		if _, ok := tx.(interface{ Commit() error }); ok {
+			t.Error("Job can call Commit")
+		}
+
+		if _, err := tx.ExecContext(ctx, "INSERT INTO items (id) VALUES (1)"); err != nil {
+			return err
+		}
+		return tx.QueryRowContext(ctx, "SELECT count(*) FROM items").Scan(&n)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("count in job = %d, want 1", n)
+	}
+}
+
+// TestCancelledJob は、同じトランザクションの Job の実行中に呼び出し元の ctx がキャンセルされても、
+// 実行中の文は中断されず、他の Job の変更が失われないことを確認する。
+func TestCancelledJob(t *testing.T) {
+	var (
+		db   = open(t)
+		w    = New(db, Options{MaxBatch: 2, MaxDelay: 10 * time.Second})
+		wg   sync.WaitGroup
+		errA error
+		errB error
+	)
+	defer w.Close()
+
+	if _, err := db.Exec("CREATE TABLE slow (x INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// MaxBatch が 2 のため、2つの Job が揃った時点で同じトランザクションで実行される
+	wg.Go(func() {
+		errA = w.Do(context.Background(), func(ctx context.Context, tx Tx) error {
+			_, err := tx.ExecContext(ctx, "INSERT INTO items (id) VALUES (1)")
+			return err
+		})
+	})
+	wg.Go(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+
+		errB = w.Do(ctx, func(jobCtx context.Context, tx Tx) error {
+			// 呼び出し元の ctx をそのまま使っても中断されない
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO slow
+				WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 3000000)
+				SELECT x FROM c`)
+			if jobCtx.Err() != nil {
+				t.Error("job ctx was cancelled")
+			}
+			return err
+		})
+	})
+	wg.Wait()
+
+	if errA != nil {
+		t.Errorf("healthy job: %v", errA)
+	}
+	if !errors.Is(errB, context.DeadlineExceeded) {
+		t.Errorf("cancelled job: err = %v, want %v", errB, context.DeadlineExceeded)
+	}
+
+	// Do が戻った後も、Writer は実行中の Job を最後まで実行してコミットする
+	w.Close()
+
+	var (
+		n int
+	)
+	if err := db.QueryRow("SELECT count(*) FROM items").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("rows of the healthy job = %d, want 1", n)
+	}
+	if err := db.QueryRow("SELECT count(*) FROM slow").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 3000000 {
+		t.Errorf("rows of the cancelled job = %d, want 3000000", n)
+	}
+	if s := w.Stats(); s.Failed != 0 || s.Batches != 1 {
+		t.Errorf("stats = %+v", s)
+	}
+}
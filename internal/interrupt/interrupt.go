@@ -0,0 +1,353 @@
+// Package interrupt は、実行中のクエリがコンテキストのキャンセルで本当に中断されるかを確認する。
+//
+// database/sql では、QueryContext 等に渡したコンテキストがキャンセルされると、ドライバにクエリの中断を依頼する。
+// 中断の方法はドライバ毎に異なる。
+//
+//   - mattn/go-sqlite3  : sqlite3_interrupt() を呼び出す。コネクションはそのまま再利用できる
+//   - modernc.org/sqlite: 同じく sqlite3_interrupt() を呼び出す。コネクションはそのまま再利用できる
+//   - lib/pq            : 別のコネクションから CancelRequest を送る。元のコネクションは不正な状態として破棄される
+//
+// 13/14 のように PingContext にタイムアウトを付けただけでは、時間のかかるクエリが実際に止まるのかは分からない。
+// Run は、わざと時間のかかるクエリ (再帰CTE) を実行してキャンセルし、以下を確認する。
+//
+//   - キャンセルしてから速やかにクエリが戻ってくること
+//   - エラーがキャンセル/タイムアウトとして分類できること
+//   - コネクションが正常な状態でプールに戻ること (または破棄された上で、プールから新しいコネクションを取得できること)
+//
+// # REFERENCES
+//   - https://pkg.go.dev/database/sql@go1.26.0#DB.QueryContext
+//   - https://www.sqlite.org/c3ref/interrupt.html
+//   - https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-FLOW-CANCELING-REQUESTS
+package interrupt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// SlowSQLite は、SQLiteで時間のかかる集計クエリ (結果が返るまで数分かかる)。
+	SlowSQLite = `WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 10000000000) SELECT count(*) FROM c`
+	// ManyRowsSQLite は、SQLiteで大量の行を返すクエリ。
+	ManyRowsSQLite = `WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 10000000000) SELECT x FROM c`
+	// SlowPostgres は、PostgreSQLで時間のかかる集計クエリ。
+	SlowPostgres = `WITH RECURSIVE c(x) AS (SELECT 1::bigint UNION ALL SELECT x + 1 FROM c WHERE x < 10000000000) SELECT count(*) FROM c`
+	// ManyRowsPostgres は、PostgreSQLで大量の行を返すクエリ。
+	ManyRowsPostgres = `SELECT x FROM generate_series(1::bigint, 10000000000) AS x`
+)
+
+const (
+	// pgQueryCanceled は、PostgreSQLのエラーコード query_canceled。
+	pgQueryCanceled = "57014"
+	// sqliteInterrupt は、SQLITE_INTERRUPT。modernc.org/sqlite のエラーも同じコードを返す。
+	sqliteInterrupt = 9
+)
+
+// Kind は、エラーの分類。
+type Kind int
+
+const (
+	None     Kind = iota // エラー無し
+	Canceled             // コンテキストのキャンセル
+	Timeout              // コンテキストの期限切れ
+	Other                // それ以外のエラー
+)
+
+func (k Kind) String() string {
+	switch k {
+	case None:
+		return "none"
+	case Canceled:
+		return "canceled"
+	case Timeout:
+		return "timeout"
+	default:
+		return "other"
+	}
+}
+
+// Classify は、ctx で実行したクエリのエラーを分類する。
+//
+// ドライバによっては context.Canceled ではなく独自のエラー (SQLITE_INTERRUPT や PostgreSQLの 57014) を返すため、
+// それらも中断として扱い、キャンセルか期限切れかは ctx から判断する。ctx は nil でもよい。
+func Classify(ctx context.Context, err error) Kind {
+	switch {
+	case err == nil:
+		return None
+	case errors.Is(err, context.DeadlineExceeded):
+		return Timeout
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	}
+
+	if !interrupted(err) {
+		return Other
+	}
+
+	if ctx != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return Timeout
+	}
+
+	var (
+		pqErr *pq.Error
+	)
+	if ctx == nil && errors.As(err, &pqErr) && strings.Contains(pqErr.Message, "timeout") {
+		// statement_timeout による中断
+		return Timeout
+	}
+
+	return Canceled
+}
+
+// interrupted は、ドライバ独自の「中断された」エラーかどうかを返す。
+func interrupted(err error) bool {
+	var (
+		mattnErr sqlite3.Error
+		pqErr    *pq.Error
+		coder    interface{ Code() int } // modernc.org/sqlite の *sqlite.Error
+	)
+	switch {
+	case errors.As(err, &mattnErr):
+		return mattnErr.Code == sqlite3.ErrInterrupt
+	case errors.As(err, &pqErr):
+		return string(pqErr.Code) == pgQueryCanceled
+	case errors.As(err, &coder):
+		return coder.Code()&0xff == sqliteInterrupt
+	}
+
+	return false
+}
+
+// Mode は、クエリを中断させる方法。
+type Mode int
+
+const (
+	// Cancel は、context.WithCancel で作ったコンテキストを After 経過後にキャンセルする。
+	Cancel Mode = iota
+	// Deadline は、context.WithTimeout で After 後に期限切れになるコンテキストを使う。
+	Deadline
+)
+
+func (m Mode) String() string {
+	if m == Deadline {
+		return "deadline"
+	}
+
+	return "cancel"
+}
+
+func (m Mode) want() Kind {
+	if m == Deadline {
+		return Timeout
+	}
+
+	return Canceled
+}
+
+type (
+	// Case は、中断を確認するクエリ。
+	Case struct {
+		Name  string
+		Query string
+		Mode  Mode
+		// Rows が true の場合は、結果を rows.Next で読み進めている途中で中断する。
+		// false の場合は、1行の結果 (集計結果) を待っている間に中断する。
+		Rows bool
+	}
+
+	// Options は、Run の設定。
+	Options struct {
+		// After は、クエリを開始してから中断するまでの時間。0の場合は 100ms。
+		After time.Duration
+		// MaxOverrun は、中断してからクエリが戻ってくるまでの許容時間。0の場合は 500ms。
+		MaxOverrun time.Duration
+		// ExpectReuse が true の場合、中断したコネクションがプールに戻り、再利用できることを要求する。
+		// (lib/pq はキャンセルしたコネクションを破棄するため false にする)
+		ExpectReuse bool
+	}
+
+	// Result は、Run の結果。
+	Result struct {
+		Case Case
+		// Err は、クエリが返したエラー。
+		Err error
+		// Kind は、Err の分類。
+		Kind Kind
+		// Elapsed は、クエリを開始してから戻ってくるまでの時間。
+		Elapsed time.Duration
+		// Overrun は、中断してからクエリが戻ってくるまでの時間。
+		Overrun time.Duration
+		// ConnErr は、中断したコネクションで続けて実行したクエリのエラー。
+		ConnErr error
+		// Reused は、中断したコネクションが破棄されずにプールに戻ったかどうか。
+		Reused bool
+		// PoolErr は、中断後にプールから取得したコネクションで実行したクエリのエラー。
+		PoolErr error
+		// Failures は、満たさなかった条件。空であれば成功。
+		Failures []string
+	}
+)
+
+// OK は、全ての条件を満たしたかどうかを返す。
+func (r Result) OK() bool {
+	return len(r.Failures) == 0
+}
+
+// Cases は、slow (集計) と many (大量の行) のクエリを、それぞれ Cancel と Deadline で中断するケースを返す。
+func Cases(slow, many string) []Case {
+	var (
+		cases []Case
+	)
+	for _, mode := range []Mode{Cancel, Deadline} {
+		cases = append(cases,
+			Case{Name: "aggregate/" + mode.String(), Query: slow, Mode: mode},
+			Case{Name: "rows/" + mode.String(), Query: many, Mode: mode, Rows: true},
+		)
+	}
+
+	return cases
+}
+
+// Run は、db で c のクエリを実行して中断し、結果を検査する。
+//
+// コネクションが破棄されたかどうかをプールの統計情報から判定するため、実行中は db を他で利用しないこと。
+func Run(ctx context.Context, db *sql.DB, c Case, opts Options) Result {
+	if opts.After <= 0 {
+		opts.After = 100 * time.Millisecond
+	}
+	if opts.MaxOverrun <= 0 {
+		opts.MaxOverrun = 500 * time.Millisecond
+	}
+
+	var (
+		r = Result{Case: c}
+	)
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		r.PoolErr = err
+		r.Failures = append(r.Failures, fmt.Sprintf("db.Conn: %v", err))
+		return r
+	}
+
+	var (
+		open        = db.Stats().OpenConnections
+		qctx, stop  = queryContext(ctx, c.Mode, opts.After)
+		start       = time.Now()
+		interruptAt = start.Add(opts.After)
+	)
+	r.Err = execute(qctx, conn, c)
+	r.Elapsed = time.Since(start)
+	r.Overrun = max(time.Since(interruptAt), 0)
+	r.Kind = Classify(qctx, r.Err)
+	stop()
+
+	// 同じコネクションで続けてクエリを実行できるか
+	r.ConnErr = ping(ctx, conn)
+	conn.Close()
+	r.Reused = r.ConnErr == nil && db.Stats().OpenConnections == open
+
+	// プールから取得したコネクションでクエリを実行できるか
+	r.PoolErr = ping(ctx, db)
+
+	switch {
+	case r.Err == nil:
+		r.Failures = append(r.Failures, "query was not interrupted")
+	case r.Kind != c.Mode.want():
+		r.Failures = append(r.Failures, fmt.Sprintf("error classified as %s, want %s: %v", r.Kind, c.Mode.want(), r.Err))
+	}
+	if r.Overrun > opts.MaxOverrun {
+		r.Failures = append(r.Failures, fmt.Sprintf("query returned %v after interrupt (max %v)", r.Overrun, opts.MaxOverrun))
+	}
+	if opts.ExpectReuse && !r.Reused {
+		r.Failures = append(r.Failures, fmt.Sprintf("connection was not returned to the pool (err=%v)", r.ConnErr))
+	}
+	if r.PoolErr != nil {
+		r.Failures = append(r.Failures, fmt.Sprintf("pool is unhealthy: %v", r.PoolErr))
+	}
+
+	return r
+}
+
+// RunAll は、cases を順に Run する。
+func RunAll(ctx context.Context, db *sql.DB, cases []Case, opts Options) []Result {
+	var (
+		results = make([]Result, 0, len(cases))
+	)
+	for _, c := range cases {
+		results = append(results, Run(ctx, db, c, opts))
+	}
+
+	return results
+}
+
+func queryContext(ctx context.Context, mode Mode, after time.Duration) (context.Context, context.CancelFunc) {
+	if mode == Deadline {
+		return context.WithTimeout(ctx, after)
+	}
+
+	var (
+		qctx, cancel = context.WithCancel(ctx)
+		timer        = time.AfterFunc(after, cancel)
+	)
+
+	return qctx, func() {
+		timer.Stop()
+		cancel()
+	}
+}
+
+func execute(ctx context.Context, conn *sql.Conn, c Case) error {
+	if !c.Rows {
+		var (
+			n int64
+		)
+		return conn.QueryRowContext(ctx, c.Query).Scan(&n)
+	}
+
+	rows, err := conn.QueryContext(ctx, c.Query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var (
+		x int64
+	)
+	for rows.Next() {
+		if err = rows.Scan(&x); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// ping は、q で簡単なクエリを実行する。
+func ping(ctx context.Context, q queryRower) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	var (
+		one int
+	)
+	if err := q.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return err
+	}
+	if one != 1 {
+		return fmt.Errorf("SELECT 1 returned %d", one)
+	}
+
+	return nil
+}
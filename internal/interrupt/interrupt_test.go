@@ -0,0 +1,166 @@
+package interrupt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	_ "modernc.org/sqlite"
+)
+
+// target は、中断を確認するドライバ。
+//
+// PostgreSQL は環境変数 PG_DSN が設定されている場合のみ確認する (25.QueryCancellation と同じ)。
+type target struct {
+	driver      string
+	dsn         string
+	slow, many  string
+	expectReuse bool
+}
+
+func targets() []target {
+	return []target{
+		{"sqlite3", ":memory:", SlowSQLite, ManyRowsSQLite, true},
+		{"sqlite", ":memory:", SlowSQLite, ManyRowsSQLite, true},
+		{"postgres", os.Getenv("PG_DSN"), SlowPostgres, ManyRowsPostgres, false},
+	}
+}
+
+// open は、t の DB をコネクション1本で開く。
+func open(tb testing.TB, t target) *sql.DB {
+	tb.Helper()
+
+	if t.dsn == "" {
+		tb.Skipf("%s: PG_DSN is not set", t.driver)
+	}
+
+	db, err := sql.Open(t.driver, t.dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	// 中断したコネクションが再利用されたかを確認できるよう、コネクションは1本にする
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err = db.PingContext(ctx); err != nil {
+		if t.driver == "postgres" {
+			tb.Skipf("%s: %v", t.driver, err)
+		}
+		tb.Fatal(err)
+	}
+
+	return db
+}
+
+func TestRun(t *testing.T) {
+	for _, tg := range targets() {
+		t.Run(tg.driver, func(t *testing.T) {
+			var (
+				db   = open(t, tg)
+				opts = Options{
+					After:       100 * time.Millisecond,
+					MaxOverrun:  500 * time.Millisecond,
+					ExpectReuse: tg.expectReuse,
+				}
+			)
+
+			for _, c := range Cases(tg.slow, tg.many) {
+				t.Run(c.Name, func(t *testing.T) {
+					ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+					defer cancel()
+
+					r := Run(ctx, db, c, opts)
+					for _, f := range r.Failures {
+						t.Error(f)
+					}
+					if r.Kind != c.Mode.want() {
+						t.Errorf("kind = %s, want %s (err=%v)", r.Kind, c.Mode.want(), r.Err)
+					}
+					if r.ConnErr != nil && tg.expectReuse {
+						t.Errorf("same connection is unhealthy: %v", r.ConnErr)
+					}
+					if r.Elapsed < opts.After {
+						t.Errorf("elapsed = %v, returned before the interrupt (%v)", r.Elapsed, opts.After)
+					}
+				})
+			}
+
+			// 中断を繰り返した後もプールのコネクションで普通にクエリを実行できること
+			var (
+				n int
+			)
+			if err := db.QueryRow("SELECT 1 + 1").Scan(&n); err != nil || n != 2 {
+				t.Errorf("after interrupts: n=%d err=%v", n, err)
+			}
+		})
+	}
+}
+
+// TestRunNotInterrupted は、すぐに終わるクエリでは「中断されなかった」として失敗することを確認する。
+func TestRunNotInterrupted(t *testing.T) {
+	var (
+		db = open(t, targets()[0])
+		c  = Case{Name: "fast", Query: "SELECT 1", Mode: Cancel}
+	)
+
+	r := Run(context.Background(), db, c, Options{ExpectReuse: true})
+	if r.OK() {
+		t.Fatal("OK() = true, want false")
+	}
+	if r.Err != nil || r.Kind != None {
+		t.Errorf("err=%v kind=%s, want nil/none", r.Err, r.Kind)
+	}
+	if !r.Reused || r.PoolErr != nil {
+		t.Errorf("reused=%v poolErr=%v", r.Reused, r.PoolErr)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	var (
+		canceled, cancel = context.WithCancel(context.Background())
+		expired, stop    = context.WithTimeout(context.Background(), -time.Second)
+		interruptErr     = sqlite3.Error{Code: sqlite3.ErrInterrupt}
+		pgCanceled       = &pq.Error{Code: pgQueryCanceled, Message: "canceling statement due to user request"}
+		pgTimeout        = &pq.Error{Code: pgQueryCanceled, Message: "canceling statement due to statement timeout"}
+	)
+	cancel()
+	defer stop()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want Kind
+	}{
+		{"nil", nil, nil, None},
+		{"context.Canceled", nil, fmt.Errorf("wrap: %w", context.Canceled), Canceled},
+		{"context.DeadlineExceeded", nil, context.DeadlineExceeded, Timeout},
+		{"sqlite interrupt/canceled ctx", canceled, interruptErr, Canceled},
+		{"sqlite interrupt/expired ctx", expired, interruptErr, Timeout},
+		{"sqlite busy", canceled, sqlite3.Error{Code: sqlite3.ErrBusy}, Other},
+		{"pq canceled/canceled ctx", canceled, pgCanceled, Canceled},
+		{"pq canceled/expired ctx", expired, pgCanceled, Timeout},
+		{"pq statement_timeout", nil, pgTimeout, Timeout},
+		{"pq other", nil, &pq.Error{Code: "42P01"}, Other},
+		{"other", nil, errors.New("boom"), Other},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.ctx, tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+// Package lifecycle は、シグナルを受け取った時や処理が終わった時の後始末 (graceful shutdown) をまとめて行う。
+//
+// 15.embedded-postgresql では、SIGINT のハンドラで pg.Stop() を呼んでから os.Exit(0) していたため、
+// db.Close() や defer が実行されず、正常に終了した場合は defer の pg.Stop() と合わせて2回停止しようとしていた。
+//
+// Manager は、signal.NotifyContext で作成したコンテキストを Run に渡す。
+// シグナルを受け取ると、コンテキストがキャンセルされ、Run に渡した関数が戻るのを待ってから後始末を行う。
+//
+//   - OnShutdown で登録した処理を、登録と逆の順序 (defer と同じ) で1回だけ実行する
+//   - CloseDB は、使用中のコネクションが返却されるのを待ってから *sql.DB を閉じる
+//   - 後始末のエラーは errors.Join でまとめて返す (途中でエラーになっても残りの処理は実行する)
+//
+// 1回目のシグナルで後始末を始めた後は、シグナルの扱いを既定に戻すため、
+// 後始末が終わらない場合はもう一度 Ctrl+C を押せば強制終了できる。
+//
+// # REFERENCES
+//   - https://pkg.go.dev/os/signal@go1.26.0#NotifyContext
+//   - https://pkg.go.dev/context@go1.26.0#Cause
+//   - https://pkg.go.dev/database/sql@go1.26.0#DB.Close
+package lifecycle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrAbandoned は、シグナルを受け取ってから Grace の間に Run に渡した関数が戻らなかったことを表す。
+	ErrAbandoned = errors.New("lifecycle: function did not return within grace period")
+)
+
+type (
+	// Options は、Manager のオプション。
+	Options struct {
+		// Signals は、後始末を始めるシグナル。空の場合は os.Interrupt と SIGTERM。
+		Signals []os.Signal
+		// Grace は、シグナルを受け取ってから Run に渡した関数が戻るのを待つ時間。0 の場合は 10秒。
+		Grace time.Duration
+		// ShutdownTimeout は、後始末全体のタイムアウト。0 の場合は 30秒。
+		ShutdownTimeout time.Duration
+		// Logger は、後始末の経過を出力するロガー。nil の場合は slog.Default()。
+		Logger *slog.Logger
+	}
+
+	// Manager は、シグナルの待ち受けと後始末を管理する。
+	Manager struct {
+		ctx  context.Context
+		stop context.CancelFunc
+		opts Options
+
+		mu     sync.Mutex
+		hooks  []hook
+		closed bool
+
+		once sync.Once
+		err  error
+	}
+
+	hook struct {
+		name string
+		fn   func(context.Context) error
+	}
+)
+
+// New は、parent を元に、シグナルでキャンセルされるコンテキストを持つ Manager を作成する。
+func New(parent context.Context, opts Options) *Manager {
+	if len(opts.Signals) == 0 {
+		opts.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	if opts.Grace <= 0 {
+		opts.Grace = 10 * time.Second
+	}
+	if opts.ShutdownTimeout <= 0 {
+		opts.ShutdownTimeout = 30 * time.Second
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	ctx, stop := signal.NotifyContext(parent, opts.Signals...)
+
+	return &Manager{ctx: ctx, stop: stop, opts: opts}
+}
+
+// Context は、シグナルを受け取るか Shutdown が呼ばれるとキャンセルされるコンテキストを返す。
+//
+// シグナルでキャンセルされた場合、context.Cause は "interrupt signal received" のようなエラーを返す。
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// OnShutdown は、後始末の処理を登録する。後始末では、登録と逆の順序で実行する。
+//
+// fn に渡すコンテキストには ShutdownTimeout が設定されている。
+// 後始末が終わった後に登録した場合 (Grace を過ぎてから Run に渡した関数が登録した場合など) は、すぐに実行する。
+func (m *Manager) OnShutdown(name string, fn func(context.Context) error) {
+	m.mu.Lock()
+	if !m.closed {
+		m.hooks = append(m.hooks, hook{name: name, fn: fn})
+		m.mu.Unlock()
+		return
+	}
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.opts.ShutdownTimeout)
+	defer cancel()
+
+	m.run(ctx, hook{name: name, fn: fn})
+}
+
+// CloseDB は、db を閉じる後始末を登録する。
+//
+// db.Close は使用中のコネクションの返却を待たないため、先に使用中のコネクションが無くなるのを待つ (Drain)。
+func (m *Manager) CloseDB(name string, db *sql.DB) {
+	m.OnShutdown(name, func(ctx context.Context) error {
+		return Drain(ctx, db)
+	})
+}
+
+// Run は、fn を実行し、fn が戻るかシグナルを受け取ったら後始末を行う。
+//
+// シグナルを受け取った場合は、fn が戻るのを Grace の間だけ待つ。戻らなかった場合は fn を待たずに後始末を行い、
+// ErrAbandoned を返す。戻り値は、fn のエラーと後始末のエラーをまとめたもの。
+func (m *Manager) Run(fn func(context.Context) error) error {
+	var (
+		done = make(chan error, 1)
+		err  error
+	)
+	go func() {
+		done <- fn(m.ctx)
+	}()
+
+	select {
+	case err = <-done:
+	case <-m.ctx.Done():
+		m.opts.Logger.Info("shutting down", "cause", context.Cause(m.ctx))
+
+		// 2回目のシグナルで強制終了できるように、シグナルの扱いを既定に戻す
+		m.stop()
+
+		timer := time.NewTimer(m.opts.Grace)
+		select {
+		case err = <-done:
+			timer.Stop()
+		case <-timer.C:
+			err = ErrAbandoned
+		}
+	}
+
+	return errors.Join(err, m.Shutdown())
+}
+
+// Shutdown は、登録された後始末を登録と逆の順序で実行する。
+//
+// 何度呼び出してもよい (2回目以降は1回目の結果を返す)。
+func (m *Manager) Shutdown() error {
+	m.once.Do(func() {
+		m.stop()
+
+		m.mu.Lock()
+		var (
+			hooks = m.hooks
+		)
+		m.hooks = nil
+		m.closed = true
+		m.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), m.opts.ShutdownTimeout)
+		defer cancel()
+
+		var (
+			errs []error
+		)
+		for i := len(hooks) - 1; i >= 0; i-- {
+			if err := m.run(ctx, hooks[i]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		m.err = errors.Join(errs...)
+	})
+
+	return m.err
+}
+
+// run は、h を実行して結果を出力する。
+func (m *Manager) run(ctx context.Context, h hook) error {
+	var (
+		start = time.Now()
+		err   = h.fn(ctx)
+	)
+	if err != nil {
+		m.opts.Logger.Error("shutdown step failed", "step", h.name, "elapsed", time.Since(start).Round(time.Millisecond), "err", err)
+		return fmt.Errorf("lifecycle: %s: %w", h.name, err)
+	}
+	m.opts.Logger.Info("shutdown step done", "step", h.name, "elapsed", time.Since(start).Round(time.Millisecond))
+
+	return nil
+}
+
+// Drain は、db の使用中のコネクションが無くなるのを待ってから db を閉じる。
+//
+// ctx が終了するまでに無くならなかった場合も db は閉じ、使用中のコネクションの数をエラーで返す。
+func Drain(ctx context.Context, db *sql.DB) error {
+	var (
+		ticker = time.NewTicker(10 * time.Millisecond)
+	)
+	defer ticker.Stop()
+
+	for db.Stats().InUse > 0 {
+		select {
+		case <-ctx.Done():
+			return errors.Join(
+				fmt.Errorf("drain: %d connection(s) still in use: %w", db.Stats().InUse, ctx.Err()),
+				db.Close())
+		case <-ticker.C:
+		}
+	}
+
+	return db.Close()
+}
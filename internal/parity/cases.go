@@ -0,0 +1,85 @@
+package parity
+
+import (
+	"time"
+)
+
+var (
+	// excluded は、Cases に含めていない番号付きのサンプルとその理由。
+	excluded = map[string]string{
+		"01.Open":                "sql.Open と Ping のみで、クエリを実行しない",
+		"15.embedded-postgresql": "PostgreSQL (lib/pq) のサンプルで、SQLiteのドライバは利用しない",
+		"19.ScalarFunctions":     "SQL関数の登録方法がドライバ毎に異なる (internal/sqlfunc のテストで両方のドライバを確認している)",
+		"20.Collation":           "照合順序の登録方法がドライバ毎に異なる (internal/sqlfunc のテストで両方のドライバを確認している)",
+		"22.VirtualTable":        "仮想テーブルの登録方法がドライバ毎に異なり、mattn/go-sqlite3 はビルドタグ sqlite_vtable が必要",
+		"23.ChangeDataCapture":   "mattn/go-sqlite3 のフックを利用するサンプルで、modernc.org/sqlite では動作しない",
+		"24.QueryFirewall":       "認可コールバックは mattn/go-sqlite3 の RegisterAuthorizer を利用する",
+		"25.QueryCancellation":   "クエリの中断は internal/interrupt のテストで両方のドライバを確認している",
+		"26.AttachDatabase":      "サンプルが作成するテナントのDBファイルを ATTACH して利用するため、フィクスチャだけでは実行できない",
+		"27.Snapshot":            "ドライバ毎に異なる API (sqlite3_serialize, バックアップAPI) を利用する",
+		"28.PragmaProfile":       "PRAGMA の適用は 13.ConnHook_modernc/14.ConnHook_mattn の Case と同等",
+		"29.OpenSQLite":          "実行するクエリは 18, 21 と同等で、SQL関数は 19 と同じ理由で含めない",
+		"30.ReadWriteSplit":      "サンプルが作成するテーブルを対象とし、実行するクエリは 04, 18 と同等",
+		"31.GroupCommit":         "サンプルが作成するテーブルを対象とし、実行するクエリは 04 と同等",
+		"32.WALCheckpoint":       "サンプルが作成するテーブルを対象とし、WALモードのファイルが必要",
+		"33.PoolMetrics":         "コネクションプールの統計のみで、クエリの結果は比較対象にならない",
+		"34.Doctor":              "PRAGMA による診断で、確認する値は 13.ConnHook_modernc/14.ConnHook_mattn の Case と同等",
+		"35.LockContention":      "複数のコネクションによるロックの競合を確認するもので、単一のクエリでは比較できない",
+		"36.DriverParity":        "このパッケージのサンプル",
+	}
+)
+
+// Cases は、番号付きのサンプルで利用しているクエリと、型・時刻・エラーの扱いを確認するクエリを返す。
+//
+// chinook.db を対象とする。Exec の Case はデータを変更するため、フィクスチャのコピーに対して実行すること。
+// 含めていないサンプルとその理由は excluded の通り。
+func Cases() []Case {
+	var (
+		at = time.Date(2024, 1, 2, 3, 4, 5, 600000000, time.FixedZone("JST", 9*60*60))
+	)
+
+	return []Case{
+		// 番号付きのサンプルのクエリ
+		{Source: "02.Query", Query: "SELECT ArtistId, Name FROM artists ORDER BY ArtistId DESC LIMIT 5"},
+		{Source: "03.QueryRow", Query: "SELECT ArtistId, Name FROM artists ORDER BY ArtistId DESC LIMIT 5"},
+		{Source: "06.PreparedQuery", Query: "SELECT * FROM artists WHERE ArtistId = ?", Args: []any{1}},
+		{Source: "08.Conn", Query: "SELECT Name from artists"},
+		{Source: "09.Columns", Query: "SELECT * FROM tracks LIMIT 1"},
+		{Source: "10.ColumnTypes", Query: "SELECT * FROM tracks LIMIT 1"},
+		{Source: "11.NextResultSet", Query: "SELECT ArtistId,Name FROM artists LIMIT 2;SELECT TrackId,Name FROM tracks LIMIT 2;"},
+		{Source: "12.RowsScanDynamic", Query: "SELECT ArtistId, Name FROM artists ORDER BY ArtistId DESC LIMIT 5"},
+		{Source: "13.ConnHook_modernc/14.ConnHook_mattn", Kind: Exec, Query: "PRAGMA busy_timeout = 2000"},
+		{Source: "13.ConnHook_modernc/14.ConnHook_mattn", Query: "SELECT * FROM pragma_busy_timeout, pragma_journal_mode, pragma_foreign_keys"},
+		{Source: "16.DriverBenchmark", Query: "SELECT TrackId, Name FROM tracks WHERE TrackId = ?", Args: []any{42}},
+		{Source: "16.DriverBenchmark", Query: "SELECT TrackId, Name, Composer, Milliseconds, UnitPrice FROM tracks WHERE TrackId BETWEEN ? AND ? ORDER BY TrackId", Args: []any{100, 199}},
+		{Source: "17.Session", Query: "SELECT count(*) - 2 FROM pragma_database_list"},
+		{Source: "18.OnlineBackup", Query: "SELECT count(*) FROM artists"},
+		{Source: "21.AggregateFunctions", Query: "SELECT strftime('%Y', InvoiceDate) AS y, count(*), round(sum(Total), 2) FROM invoices GROUP BY y ORDER BY y"},
+		{Source: "04.Exec", Kind: Exec, Query: "INSERT INTO artists (ArtistId, Name) VALUES (?, ?)", Args: []any{999, "test"}},
+		{Source: "04.Exec", Kind: Exec, Query: "DELETE FROM artists WHERE ArtistId = ?", Args: []any{999}},
+		{Source: "05.Transaction", Kind: Exec, Query: "INSERT INTO artists (ArtistId, Name) VALUES (?, ?)", Args: []any{990, "test990"}},
+		{Source: "07.PreparedQueryInTx", Kind: Exec, Query: "INSERT INTO artists (ArtistId, Name) VALUES (?, ?)", Args: []any{991, "test991"}},
+		{Source: "07.PreparedQueryInTx", Query: "SELECT * FROM artists ORDER BY ArtistId DESC LIMIT 10"},
+		{Source: "05.Transaction", Kind: Exec, Query: "DELETE FROM artists WHERE ArtistId IN (990, 991)"},
+
+		// 宣言された型と any で Scan した時の型
+		{Source: "types", Query: "SELECT 1, 1.5, 'text', x'00ff', NULL, 1 = 1, 9223372036854775807"},
+		{Source: "types", Query: "SELECT UnitPrice, Milliseconds, Composer, NULL FROM tracks LIMIT 1"},
+		{Source: "types", Query: "SELECT ?, ?, ?, ?", Args: []any{true, int8(-1), float32(0.5), []byte("bytes")}},
+		{Source: "types", Query: "SELECT typeof(?), typeof(?)", Args: []any{true, uint64(1) << 63}},
+
+		// 時刻
+		{Source: "time", Query: "SELECT InvoiceId, InvoiceDate FROM invoices ORDER BY InvoiceId LIMIT 2"},
+		{Source: "time", Query: "SELECT ?, typeof(?)", Args: []any{at, at}},
+		{Source: "time", Kind: Exec, Query: "CREATE TEMP TABLE parity_time (at DATETIME, ts TIMESTAMP, d DATE, txt TEXT)"},
+		{Source: "time", Kind: Exec, Query: "INSERT INTO parity_time VALUES (?, ?, ?, ?)", Args: []any{at, at, at, at}},
+		{Source: "time", Query: "SELECT at, ts, d, txt FROM parity_time"},
+		{Source: "time", Query: "SELECT datetime(at), at = datetime(at) FROM parity_time"},
+
+		// エラー
+		{Source: "error", Query: "SELEC 1"},
+		{Source: "error", Query: "SELECT * FROM no_such_table"},
+		{Source: "error", Kind: Exec, Query: "INSERT INTO artists (ArtistId, Name) VALUES (1, 'duplicate')"},
+		{Source: "error", Query: "SELECT ?", Args: []any{struct{}{}}},
+	}
+}
@@ -0,0 +1,395 @@
+// Package parity は、同じクエリを mattn/go-sqlite3 と modernc.org/sqlite で実行し、結果の違いを報告する。
+//
+// 本リポジトリでは、cgo が利用できるかどうかで2つのドライバを使い分けている。
+// どちらも同じ SQLite を利用しているが、database/sql とのやり取りはドライバ毎の実装であるため、
+// 以下のような点で違いが出ることがある。
+//
+//   - any で Scan した時の Go の型 (NUMERIC, DATETIME, BOOLEAN と宣言された列など)
+//   - ColumnTypes の DatabaseTypeName, ScanType, Nullable, Length, DecimalSize
+//   - 複数の文を含むクエリ (11.NextResultSet) の扱い
+//   - time.Time をパラメータに渡した時の文字列表現と、読み込んだ時の変換
+//   - エラーの型とエラーコードの取り出し方
+//
+// Run は、Case 毎に両方のドライバで実行した結果 (Observation) を比較し、違いを Report にまとめる。
+// 値は Go の型と文字列表現で比較する。
+//
+// # REFERENCES
+//   - https://pkg.go.dev/database/sql@go1.26.0#ColumnType
+//   - https://pkg.go.dev/database/sql@go1.26.0#Rows.NextResultSet
+//   - https://pkg.go.dev/github.com/mattn/go-sqlite3#readme-supported-types
+//   - https://pkg.go.dev/modernc.org/sqlite#hdr-Supported_types
+package parity
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// maxRows は、1つの結果セットで比較する最大行数。
+	maxRows = 5
+)
+
+// Kind は、Case の実行方法。
+type Kind int
+
+const (
+	// Query は、Query で実行し、全ての結果セットを読み込む。
+	Query Kind = iota
+	// Exec は、Exec で実行し、RowsAffected と LastInsertId を比較する。
+	Exec
+)
+
+type (
+	// Case は、比較する1つのクエリ。
+	Case struct {
+		// Source は、クエリの出典 (サンプルのディレクトリ名など)。
+		Source string
+		Kind   Kind
+		Query  string
+		Args   []any
+	}
+
+	// Column は、sql.ColumnType の内容。ok=false の値は "-" とする。
+	Column struct {
+		Name             string
+		DatabaseTypeName string
+		ScanType         string
+		Nullable         string
+		Length           string
+		DecimalSize      string
+	}
+
+	// Value は、any で Scan した値。
+	Value struct {
+		Type string
+		Repr string
+	}
+
+	// ResultSet は、1つの結果セット。
+	ResultSet struct {
+		Columns []Column
+		Rows    [][]Value
+	}
+
+	// Observation は、1つのドライバで Case を実行した結果。
+	Observation struct {
+		ResultSets   []ResultSet
+		RowsAffected string
+		LastInsertId string
+		// Err は、エラーの型・コード・メッセージ。エラーが無い場合は空。
+		Err string
+	}
+
+	// Difference は、1つの違い。
+	Difference struct {
+		// Aspect は、違いがあった項目 (columns[0].ScanType, rows[0][1], error など)。
+		Aspect  string
+		Mattn   string
+		Modernc string
+	}
+
+	// Comparison は、1つの Case の比較結果。
+	Comparison struct {
+		Case        Case
+		Differences []Difference
+	}
+
+	// Report は、全ての Case の比較結果。
+	Report struct {
+		Comparisons []Comparison
+	}
+)
+
+// Run は、cases を mattn, modernc の順に実行して比較する。
+//
+// Exec で一時テーブルを作る Case などがあるため、それぞれの *sql.DB は SetMaxOpenConns(1) にしておくこと。
+// 2つの *sql.DB は、同じ内容の別のファイル (フィクスチャのコピー) を開いておく。
+func Run(ctx context.Context, mattn, modernc *sql.DB, cases []Case) *Report {
+	var (
+		r = &Report{}
+	)
+	for _, c := range cases {
+		var (
+			a = observe(ctx, mattn, c)
+			b = observe(ctx, modernc, c)
+		)
+		r.Comparisons = append(r.Comparisons, Comparison{Case: c, Differences: compare(a, b)})
+	}
+
+	return r
+}
+
+// Same は、違いが無かった Case の数を返す。
+func (r *Report) Same() int {
+	var (
+		n int
+	)
+	for _, c := range r.Comparisons {
+		if len(c.Differences) == 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// WriteText は、比較結果をテキストで w に書き込む。
+func (r *Report) WriteText(w io.Writer) error {
+	var (
+		sb strings.Builder
+	)
+	for _, c := range r.Comparisons {
+		var (
+			status = "same"
+		)
+		if len(c.Differences) > 0 {
+			status = "diff"
+		}
+		fmt.Fprintf(&sb, "[%s] %s: %s\n", status, c.Case.Source, oneLine(c.Case.Query))
+		for _, d := range c.Differences {
+			fmt.Fprintf(&sb, "    %s: mattn=%s modernc=%s\n", d.Aspect, d.Mattn, d.Modernc)
+		}
+	}
+	fmt.Fprintf(&sb, "same=%d diff=%d\n", r.Same(), len(r.Comparisons)-r.Same())
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// observe は、db で c を実行した結果を返す。
+func observe(ctx context.Context, db *sql.DB, c Case) Observation {
+	var (
+		o Observation
+	)
+
+	if c.Kind == Exec {
+		res, err := db.ExecContext(ctx, c.Query, c.Args...)
+		if err != nil {
+			o.Err = describe(err)
+			return o
+		}
+		o.RowsAffected = result(res.RowsAffected())
+		o.LastInsertId = result(res.LastInsertId())
+		return o
+	}
+
+	rows, err := db.QueryContext(ctx, c.Query, c.Args...)
+	if err != nil {
+		o.Err = describe(err)
+		return o
+	}
+	defer rows.Close()
+
+	for {
+		rs, err := resultSet(rows)
+		if err != nil {
+			o.Err = describe(err)
+			return o
+		}
+		o.ResultSets = append(o.ResultSets, rs)
+
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	if err = rows.Err(); err != nil {
+		o.Err = describe(err)
+	}
+
+	return o
+}
+
+// resultSet は、現在の結果セットの列と先頭 maxRows 行を読み込む。
+func resultSet(rows *sql.Rows) (ResultSet, error) {
+	var (
+		rs ResultSet
+	)
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return rs, err
+	}
+	for _, t := range types {
+		rs.Columns = append(rs.Columns, column(t))
+	}
+
+	var (
+		values = make([]any, len(types))
+		ptrs   = make([]any, len(types))
+	)
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if len(rs.Rows) >= maxRows {
+			continue
+		}
+		if err = rows.Scan(ptrs...); err != nil {
+			return rs, err
+		}
+
+		var (
+			row = make([]Value, len(values))
+		)
+		for i, v := range values {
+			row[i] = value(v)
+		}
+		rs.Rows = append(rs.Rows, row)
+	}
+
+	return rs, rows.Err()
+}
+
+func column(t *sql.ColumnType) Column {
+	var (
+		c = Column{Name: t.Name(), DatabaseTypeName: t.DatabaseTypeName(), ScanType: "-", Nullable: "-", Length: "-", DecimalSize: "-"}
+	)
+	if st := t.ScanType(); st != nil {
+		c.ScanType = st.String()
+	}
+	if v, ok := t.Nullable(); ok {
+		c.Nullable = fmt.Sprint(v)
+	}
+	if v, ok := t.Length(); ok {
+		c.Length = fmt.Sprint(v)
+	}
+	if p, s, ok := t.DecimalSize(); ok {
+		c.DecimalSize = fmt.Sprintf("%d,%d", p, s)
+	}
+
+	return c
+}
+
+func value(v any) Value {
+	var (
+		repr string
+	)
+	switch x := v.(type) {
+	case nil:
+		repr = "NULL"
+	case []byte:
+		repr = fmt.Sprintf("%q", x)
+	case string:
+		repr = fmt.Sprintf("%q", x)
+	case time.Time:
+		// 名前の無いタイムゾーン (time.FixedZone("", ...)) は MST で +0900 のように出力される
+		repr = x.Format(time.RFC3339Nano + " MST")
+	default:
+		repr = fmt.Sprint(x)
+	}
+
+	return Value{Type: fmt.Sprintf("%T", v), Repr: repr}
+}
+
+func result(v int64, err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+
+	return fmt.Sprint(v)
+}
+
+// describe は、エラーの型とコード、メッセージを返す。
+func describe(err error) string {
+	var (
+		mattnErr sqlite3.Error
+		coder    interface{ Code() int } // modernc.org/sqlite の *sqlite.Error
+		code     string
+	)
+	switch {
+	case errors.As(err, &mattnErr):
+		code = fmt.Sprintf("code=%d extended=%d", int(mattnErr.Code), int(mattnErr.ExtendedCode))
+	case errors.As(err, &coder):
+		code = fmt.Sprintf("code=%d extended=%d", coder.Code()&0xff, coder.Code())
+	default:
+		code = "no code"
+	}
+
+	return fmt.Sprintf("%T (%s): %v", err, code, err)
+}
+
+// compare は、a (mattn) と b (modernc) の違いを返す。
+func compare(a, b Observation) []Difference {
+	var (
+		diffs []Difference
+		add   = func(aspect, x, y string) {
+			if x != y {
+				diffs = append(diffs, Difference{Aspect: aspect, Mattn: x, Modernc: y})
+			}
+		}
+	)
+
+	add("error", a.Err, b.Err)
+	add("rows affected", a.RowsAffected, b.RowsAffected)
+	add("last insert id", a.LastInsertId, b.LastInsertId)
+	add("result sets", fmt.Sprint(len(a.ResultSets)), fmt.Sprint(len(b.ResultSets)))
+
+	for i := range min(len(a.ResultSets), len(b.ResultSets)) {
+		var (
+			x, y   = a.ResultSets[i], b.ResultSets[i]
+			prefix = ""
+		)
+		if len(a.ResultSets) > 1 || len(b.ResultSets) > 1 {
+			prefix = fmt.Sprintf("result set[%d] ", i)
+		}
+
+		add(prefix+"columns", names(x.Columns), names(y.Columns))
+		for j := range min(len(x.Columns), len(y.Columns)) {
+			var (
+				cx, cy = x.Columns[j], y.Columns[j]
+				aspect = fmt.Sprintf("%scolumn %s.", prefix, cx.Name)
+			)
+			add(aspect+"DatabaseTypeName", cx.DatabaseTypeName, cy.DatabaseTypeName)
+			add(aspect+"ScanType", cx.ScanType, cy.ScanType)
+			add(aspect+"Nullable", cx.Nullable, cy.Nullable)
+			add(aspect+"Length", cx.Length, cy.Length)
+			add(aspect+"DecimalSize", cx.DecimalSize, cy.DecimalSize)
+		}
+
+		add(prefix+"rows", fmt.Sprint(len(x.Rows)), fmt.Sprint(len(y.Rows)))
+		for j := range min(len(x.Rows), len(y.Rows)) {
+			for k := range min(len(x.Rows[j]), len(y.Rows[j])) {
+				var (
+					vx, vy = x.Rows[j][k], y.Rows[j][k]
+					aspect = fmt.Sprintf("%srow[%d] %s", prefix, j, columnName(x.Columns, k))
+				)
+				// 型が異なる場合は文字列表現も異なることが多いため、まとめて1つの違いとする
+				add(aspect, vx.Type+" "+vx.Repr, vy.Type+" "+vy.Repr)
+			}
+		}
+	}
+
+	return diffs
+}
+
+func names(cols []Column) string {
+	var (
+		s = make([]string, len(cols))
+	)
+	for i, c := range cols {
+		s[i] = c.Name
+	}
+
+	return strings.Join(s, ",")
+}
+
+func columnName(cols []Column, i int) string {
+	if i < len(cols) {
+		return cols[i].Name
+	}
+
+	return fmt.Sprintf("#%d", i)
+}
+
+// oneLine は、改行と連続する空白を1つの空白にする。
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,204 @@
+package parity
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/devlights/try-golang-db/internal/dbopen"
+)
+
+const (
+	// fixture は、リポジトリのルートにあるフィクスチャ。各サンプルの Taskfile はここからコピーしている。
+	fixture = "../../chinook.db"
+)
+
+var (
+	// sampleDir は、番号付きのサンプルのディレクトリ名
+	sampleDir = regexp.MustCompile(`^\d{2}\.`)
+)
+
+// open は、mattn/go-sqlite3 と modernc.org/sqlite で DB を開く。src が空の場合は :memory: を、空でなければドライバ毎に src のコピーを開く。
+func open(t *testing.T, src string) (mattn, modernc *sql.DB) {
+	t.Helper()
+
+	var (
+		dir = t.TempDir()
+		dbs = make([]*sql.DB, 0, 2)
+	)
+	for _, drv := range []dbopen.Driver{dbopen.Mattn, dbopen.Modernc} {
+		var (
+			path = ":memory:"
+		)
+		if src != "" {
+			path = filepath.Join(dir, drv.String()+".db")
+			copyFile(t, path, src)
+		}
+
+		db, err := dbopen.OpenSQLite(path, dbopen.Options{Driver: drv})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		db.SetMaxOpenConns(1)
+
+		dbs = append(dbs, db)
+	}
+
+	return dbs[0], dbs[1]
+}
+
+func copyFile(t *testing.T, dst, src string) {
+	t.Helper()
+
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	if _, err = io.Copy(out, in); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCasesCoverSamples は、全ての番号付きのサンプルが Cases に含まれているか、excluded に理由が書かれていることを確認する。
+func TestCasesCoverSamples(t *testing.T) {
+	entries, err := os.ReadDir("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		covered = make(map[string]bool)
+		samples = make(map[string]bool)
+	)
+	for _, c := range Cases() {
+		for _, s := range strings.Split(c.Source, "/") {
+			covered[s] = true
+		}
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() || !sampleDir.MatchString(e.Name()) {
+			continue
+		}
+		samples[e.Name()] = true
+
+		_, skip := excluded[e.Name()]
+		switch {
+		case covered[e.Name()] && skip:
+			t.Errorf("%s is both in Cases and excluded", e.Name())
+		case !covered[e.Name()] && !skip:
+			t.Errorf("%s is neither in Cases nor excluded", e.Name())
+		}
+	}
+
+	for name := range excluded {
+		if !samples[name] {
+			t.Errorf("excluded %s does not exist", name)
+		}
+	}
+	for name := range covered {
+		if sampleDir.MatchString(name) && !samples[name] {
+			t.Errorf("Case source %s does not exist", name)
+		}
+	}
+}
+
+// TestCases は、フィクスチャに対して Cases を実行し、番号付きのサンプルのクエリは
+// 両方のドライバで成功して同じ値を返すことを確認する (違うのは列の情報のみ)。
+func TestCases(t *testing.T) {
+	if _, err := os.Stat(fixture); err != nil {
+		t.Skipf("fixture is not available: %v", err)
+	}
+
+	var (
+		mattn, modernc = open(t, fixture)
+		report         = Run(context.Background(), mattn, modernc, Cases())
+	)
+
+	if len(report.Comparisons) != len(Cases()) {
+		t.Fatalf("comparisons = %d, want %d", len(report.Comparisons), len(Cases()))
+	}
+
+	for _, c := range report.Comparisons {
+		if !sampleDir.MatchString(c.Case.Source) {
+			continue
+		}
+		for _, d := range c.Differences {
+			if !strings.Contains(d.Aspect, "column ") {
+				t.Errorf("%s: %s: %s: mattn=%s modernc=%s", c.Case.Source, oneLine(c.Case.Query), d.Aspect, d.Mattn, d.Modernc)
+			}
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	var (
+		mattn, modernc = open(t, "")
+		cases          = []Case{
+			{Source: "exec", Kind: Exec, Query: "CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT)"},
+			{Source: "exec", Kind: Exec, Query: "INSERT INTO t (name) VALUES (?), (?)", Args: []any{"a", "b"}},
+			{Source: "query", Query: "SELECT name FROM t ORDER BY id"},
+			{Source: "types", Query: "SELECT 1"},
+			{Source: "error", Query: "SELECT * FROM no_such_table"},
+		}
+		report = Run(context.Background(), mattn, modernc, cases)
+	)
+
+	var (
+		aspects = make([][]string, len(report.Comparisons))
+	)
+	for i, c := range report.Comparisons {
+		for _, d := range c.Differences {
+			aspects[i] = append(aspects[i], d.Aspect)
+		}
+	}
+
+	// Exec の結果 (RowsAffected, LastInsertId) は同じ
+	for i := range 2 {
+		if len(aspects[i]) != 0 {
+			t.Errorf("%s: differences = %v", cases[i].Query, aspects[i])
+		}
+	}
+	// 値は同じで、列の情報のみ異なる
+	for _, a := range aspects[2] {
+		if !strings.HasPrefix(a, "column ") {
+			t.Errorf("%s: unexpected difference %s", cases[2].Query, a)
+		}
+	}
+	// 式の列の ScanType は異なる
+	if !strings.Contains(strings.Join(aspects[3], ","), "column 1.ScanType") {
+		t.Errorf("%s: differences = %v, want ScanType", cases[3].Query, aspects[3])
+	}
+	// エラーはメッセージが異なる
+	if len(aspects[4]) != 1 || aspects[4][0] != "error" {
+		t.Errorf("%s: differences = %v, want [error]", cases[4].Query, aspects[4])
+	}
+
+	if got := report.Same(); got != 2 {
+		t.Errorf("Same() = %d, want 2", got)
+	}
+
+	var (
+		sb strings.Builder
+	)
+	if err := report.WriteText(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(sb.String(), "same=2 diff=3\n") {
+		t.Errorf("WriteText:\n%s", sb.String())
+	}
+}
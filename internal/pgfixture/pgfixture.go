@@ -0,0 +1,292 @@
+// Package pgfixture は、fergusstrange/embedded-postgres をテストやサンプルから使いやすくする。
+//
+// 15.embedded-postgresql では、run() の中でポート 5432 固定で起動していたため、
+// テストからは利用できず、同時に2つ実行することもできなかった。
+//
+// Server は、以下のように起動する。
+//
+//   - ポートは空いているものを選ぶ (127.0.0.1:0 で Listen して得たポート)
+//   - ランタイム・データのディレクトリはプロセス毎の一時ディレクトリ (Stop で削除する)
+//   - バイナリは CachePath (既定値 ~/.embedded-postgres-go) にキャッシュされたアーカイブを展開して利用する
+//   - TemplateSQL を投入したテンプレートデータベースを作成する
+//
+// CreateDatabase は、テンプレートデータベースから CREATE DATABASE ... TEMPLATE で新しいデータベースを作る。
+// ファイルのコピーで作成されるため、毎回 SQL を投入するより速く、テスト毎に独立したデータベースを用意できる。
+//
+// # テストでの利用
+//
+// テストバイナリ毎に1つの Server を起動し、テスト毎にデータベースを作成する。
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(pgfixture.Main(m, pgfixture.Options{TemplateSQL: northwindSQL}))
+//	}
+//
+//	func TestOrders(t *testing.T) {
+//		db := pgfixture.NewDB(t) // t.Cleanup で Close と DROP DATABASE を行う
+//		...
+//	}
+//
+// 15.embedded-postgresql の main_test.go が実際の利用例となっている。
+//
+// # REFERENCES
+//   - https://github.com/fergusstrange/embedded-postgres
+//   - https://www.postgresql.org/docs/current/manage-ag-templatedbs.html
+//   - https://pkg.go.dev/testing@go1.26.0#hdr-Main
+package pgfixture
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	embedpsql "github.com/fergusstrange/embedded-postgres"
+	"github.com/lib/pq"
+
+	"github.com/devlights/try-golang-db/internal/ready"
+)
+
+const (
+	// startAttempts は、空きポートを選んでから起動するまでの間に他のプロセスに使われた場合に再試行する回数。
+	startAttempts = 3
+)
+
+type (
+	// Options は、Start のオプション。
+	Options struct {
+		// Version は、PostgreSQL のバージョン。空の場合は embedded-postgres の既定値。
+		Version embedpsql.PostgresVersion
+		// Username, Password は、スーパーユーザー。空の場合は postgres。
+		Username string
+		Password string
+		// TemplateName は、テンプレートデータベースの名前。空の場合は fixture_template。
+		TemplateName string
+		// TemplateSQL は、テンプレートデータベースに投入する SQL (スキーマとデータ)。
+		TemplateSQL string
+		// CachePath は、バイナリのアーカイブのキャッシュディレクトリ。空の場合は ~/.embedded-postgres-go。
+		//
+		// キャッシュが無い場合はダウンロードする。
+		CachePath string
+		// StartTimeout は、起動を待つ時間。0 の場合は 60秒。
+		StartTimeout time.Duration
+		// Logger は、PostgreSQL のログの出力先。nil の場合は出力しない。
+		Logger io.Writer
+		// Grace は、Main でテストの実行中に中断 (SIGINT/SIGTERM) した場合に、m.Run() が戻るのを待つ時間。0 の場合は 1分。
+		//
+		// 待っている間に実行中のテストが終わらなければ、m.Run() を待たずに PostgreSQL を停止する。
+		// その時点でデータベースを使用していたテストはログに出力する。もう一度 Ctrl+C を押すと、待たずに強制終了する。
+		Grace time.Duration
+		// SkipIfUnavailable は、Main で起動に失敗した場合の扱い。
+		//
+		// false の場合はテストを実行せずに失敗とする。true の場合は m.Run() を実行し、NewDB を呼んだテストをスキップする。
+		// バイナリのキャッシュが無くダウンロードもできない環境や、root で実行している環境 (initdb が起動できない) 向け。
+		SkipIfUnavailable bool
+	}
+
+	// Server は、起動した embedded-postgres。
+	Server struct {
+		pg   *embedpsql.EmbeddedPostgres
+		opts Options
+		port uint32
+		dir  string
+
+		// admin は、postgres データベースへの接続。CREATE/DROP DATABASE に利用する。
+		admin *sql.DB
+
+		stopOnce sync.Once
+		stopErr  error
+	}
+)
+
+// Start は、空いているポートで PostgreSQL を起動し、テンプレートデータベースを作成する。
+func Start(ctx context.Context, opts Options) (*Server, error) {
+	if opts.Username == "" {
+		opts.Username = "postgres"
+	}
+	if opts.Password == "" {
+		opts.Password = "postgres"
+	}
+	if opts.TemplateName == "" {
+		opts.TemplateName = "fixture_template"
+	}
+	if opts.StartTimeout <= 0 {
+		opts.StartTimeout = 60 * time.Second
+	}
+	if opts.Logger == nil {
+		opts.Logger = io.Discard
+	}
+
+	dir, err := os.MkdirTemp("", "pgfixture-")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{opts: opts, dir: dir}
+	if err = s.start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	if err = s.setup(ctx); err != nil {
+		return nil, errors.Join(err, s.Stop())
+	}
+
+	return s, nil
+}
+
+// start は、空いているポートを選んで起動する。起動までの間にポートが使われた場合は選び直す。
+func (s *Server) start() error {
+	var (
+		errs []error
+	)
+	for range startAttempts {
+		port, err := freePort()
+		if err != nil {
+			return err
+		}
+
+		conf := embedpsql.DefaultConfig().
+			Username(s.opts.Username).
+			Password(s.opts.Password).
+			Database("postgres").
+			Port(port).
+			RuntimePath(filepath.Join(s.dir, "runtime")).
+			DataPath(filepath.Join(s.dir, "data")).
+			StartTimeout(s.opts.StartTimeout).
+			Logger(s.opts.Logger)
+		if s.opts.Version != "" {
+			conf = conf.Version(s.opts.Version)
+		}
+		if s.opts.CachePath != "" {
+			conf = conf.CachePath(s.opts.CachePath)
+		}
+
+		pg := embedpsql.NewDatabase(conf)
+		if err = pg.Start(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		s.pg = pg
+		s.port = port
+		return nil
+	}
+
+	return fmt.Errorf("pgfixture: start: %w", errors.Join(errs...))
+}
+
+// freePort は、空いている TCP ポートを返す。
+func freePort() (uint32, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return uint32(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+// setup は、管理用の接続を開き、テンプレートデータベースを作成する。
+func (s *Server) setup(ctx context.Context) error {
+	admin, err := sql.Open("postgres", s.DSN("postgres"))
+	if err != nil {
+		return err
+	}
+	s.admin = admin
+
+	if err = ready.WaitReady(ctx, admin, ready.Policy{MaxAttempts: 10}); err != nil {
+		return err
+	}
+
+	var (
+		tmpl = pq.QuoteIdentifier(s.opts.TemplateName)
+	)
+	if _, err = admin.ExecContext(ctx, "CREATE DATABASE "+tmpl); err != nil {
+		return fmt.Errorf("pgfixture: create template: %w", err)
+	}
+
+	if s.opts.TemplateSQL != "" {
+		db, err := sql.Open("postgres", s.DSN(s.opts.TemplateName))
+		if err != nil {
+			return err
+		}
+		_, err = db.ExecContext(ctx, s.opts.TemplateSQL)
+		if cerr := db.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("pgfixture: load template: %w", err)
+		}
+	}
+
+	// テンプレートに接続しているセッションがあると CREATE DATABASE ... TEMPLATE が失敗するため、接続も禁止しておく
+	if _, err = admin.ExecContext(ctx, "ALTER DATABASE "+tmpl+" WITH IS_TEMPLATE true ALLOW_CONNECTIONS false"); err != nil {
+		return fmt.Errorf("pgfixture: mark template: %w", err)
+	}
+
+	return nil
+}
+
+// Port は、PostgreSQL が待ち受けているポートを返す。
+func (s *Server) Port() uint32 {
+	return s.port
+}
+
+// DSN は、database に接続するための lib/pq の接続文字列を返す。
+func (s *Server) DSN(database string) string {
+	return fmt.Sprintf("host=127.0.0.1 port=%d user=%s password=%s dbname=%s sslmode=disable",
+		s.port, s.opts.Username, s.opts.Password, database)
+}
+
+// CreateDatabase は、テンプレートデータベースから name を作成する。
+func (s *Server) CreateDatabase(ctx context.Context, name string) error {
+	_, err := s.admin.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s",
+		pq.QuoteIdentifier(name), pq.QuoteIdentifier(s.opts.TemplateName)))
+	if err != nil {
+		return fmt.Errorf("pgfixture: create database %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// DropDatabase は、name を削除する。接続しているセッションがあれば切断する (WITH (FORCE), PostgreSQL 13 以降)。
+func (s *Server) DropDatabase(ctx context.Context, name string) error {
+	_, err := s.admin.ExecContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", pq.QuoteIdentifier(name)))
+	if err != nil {
+		return fmt.Errorf("pgfixture: drop database %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// Stop は、PostgreSQL を停止し、ランタイム・データのディレクトリを削除する。
+//
+// 何度呼び出してもよい (2回目以降は1回目の結果を返す)。
+func (s *Server) Stop() error {
+	s.stopOnce.Do(func() {
+		var (
+			errs []error
+		)
+		if s.admin != nil {
+			errs = append(errs, s.admin.Close())
+		}
+		if s.pg != nil {
+			if err := s.pg.Stop(); err != nil {
+				errs = append(errs, fmt.Errorf("pgfixture: stop: %w", err))
+			}
+		}
+		if err := os.RemoveAll(s.dir); err != nil {
+			errs = append(errs, fmt.Errorf("pgfixture: cleanup: %w", err))
+		}
+
+		s.stopErr = errors.Join(errs...)
+	})
+
+	return s.stopErr
+}
@@ -0,0 +1,191 @@
+package pgfixture
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/devlights/try-golang-db/internal/lifecycle"
+)
+
+var (
+	// shared は、Main で起動したテストバイナリ全体で共有する Server
+	shared *Server
+	// unavailable は、SkipIfUnavailable で起動に失敗した場合のエラー
+	unavailable error
+	// seq は、NewDB で作成するデータベースの名前の連番
+	seq atomic.Int64
+	// running は、NewDB で作成して、まだ t.Cleanup で削除していないデータベースと、それを使っているテストの名前
+	running = struct {
+		mu  sync.Mutex
+		dbs map[string]string
+	}{
+		dbs: make(map[string]string),
+	}
+)
+
+// Main は、TestMain から呼び出す。Server を起動してから m.Run() を実行し、終了後に停止する。
+//
+// 戻り値は os.Exit に渡す終了コード。起動に失敗した場合はテストを実行せずに 1 を返す
+// (Options.SkipIfUnavailable の場合は、テストを実行して NewDB でスキップする)。
+// テストの実行中に Ctrl+C などで中断した場合も、PostgreSQL を停止して一時ディレクトリを削除してから戻る。
+// 中断した場合は実行中のテストが終わるのを Options.Grace の間だけ待ち、それでも終わらなければ
+// データベースを使用中のテストの名前をログに出力してから停止する。
+func Main(m *testing.M, opts Options) int {
+	if opts.Grace <= 0 {
+		opts.Grace = time.Minute
+	}
+
+	var (
+		lc = lifecycle.New(context.Background(), lifecycle.Options{Grace: opts.Grace})
+	)
+
+	s, err := Start(lc.Context(), opts)
+	if err != nil {
+		log.Printf("pgfixture: %v", err)
+		lc.Shutdown()
+		if !opts.SkipIfUnavailable {
+			return 1
+		}
+
+		unavailable = err
+		return m.Run()
+	}
+	shared = s
+	lc.OnShutdown("pgfixture", func(context.Context) error { return s.Stop() })
+	// 後始末は登録と逆の順序で行うため、停止する前に実行される
+	lc.OnShutdown("pgfixture: running tests", func(context.Context) error {
+		if tests := runningTests(); len(tests) > 0 {
+			log.Printf("pgfixture: stopping PostgreSQL while %d test(s) still use a database: %s", len(tests), strings.Join(tests, ", "))
+		}
+		return nil
+	})
+
+	var (
+		code atomic.Int32
+	)
+	code.Store(1) // 中断して m.Run() を待たずに戻る場合は失敗とする
+
+	err = lc.Run(func(context.Context) error {
+		code.Store(int32(m.Run()))
+		return nil
+	})
+	if err != nil {
+		log.Printf("pgfixture: %v", err)
+		return 1
+	}
+
+	return int(code.Load())
+}
+
+// NewDB は、Main で起動した Server にテスト用のデータベースを作成して開く。
+func NewDB(t testing.TB) *sql.DB {
+	t.Helper()
+
+	if unavailable != nil {
+		t.Skipf("pgfixture: server is not available: %v", unavailable)
+	}
+	if shared == nil {
+		t.Fatal("pgfixture: server is not started (call pgfixture.Main from TestMain)")
+	}
+
+	return shared.NewDB(t)
+}
+
+// NewDB は、テンプレートデータベースからテスト用のデータベースを作成して開く。
+//
+// データベースの名前はテスト名と連番から作る。t.Cleanup で Close と DROP DATABASE を行う。
+func (s *Server) NewDB(t testing.TB) *sql.DB {
+	t.Helper()
+
+	var (
+		ctx  = context.Background()
+		name = databaseName(t.Name(), seq.Add(1))
+	)
+	if err := s.CreateDatabase(ctx, name); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("postgres", s.DSN(name))
+	if err != nil {
+		s.DropDatabase(ctx, name)
+		t.Fatal(err)
+	}
+
+	track(name, t.Name())
+	t.Cleanup(func() {
+		defer untrack(name)
+
+		if err := db.Close(); err != nil {
+			t.Errorf("pgfixture: close %s: %v", name, err)
+		}
+		if err := s.DropDatabase(context.Background(), name); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return db
+}
+
+// track は、テスト testName がデータベース name を使用していることを記録する。
+func track(name, testName string) {
+	running.mu.Lock()
+	defer running.mu.Unlock()
+
+	running.dbs[name] = testName
+}
+
+// untrack は、データベース name の記録を消す。
+func untrack(name string) {
+	running.mu.Lock()
+	defer running.mu.Unlock()
+
+	delete(running.dbs, name)
+}
+
+// runningTests は、データベースを使用中のテストの名前を重複を除いて名前順で返す。
+func runningTests() []string {
+	running.mu.Lock()
+	defer running.mu.Unlock()
+
+	var (
+		tests = make([]string, 0, len(running.dbs))
+	)
+	for _, t := range running.dbs {
+		tests = append(tests, t)
+	}
+	slices.Sort(tests)
+
+	return slices.Compact(tests)
+}
+
+// databaseName は、テスト名から PostgreSQL のデータベース名 (63バイトまで) を作る。
+func databaseName(testName string, n int64) string {
+	var (
+		sb     strings.Builder
+		suffix = fmt.Sprintf("_%d", n)
+	)
+	sb.WriteString("test_")
+	for _, r := range strings.ToLower(testName) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+
+	name := sb.String()
+	if len(name) > 63-len(suffix) {
+		name = name[:63-len(suffix)]
+	}
+
+	return name + suffix
+}
@@ -0,0 +1,47 @@
+package pgfixture
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDatabaseName(t *testing.T) {
+	tests := []struct {
+		testName string
+		n        int64
+		want     string
+	}{
+		{"TestOrders", 1, "test_testorders_1"},
+		{"TestOrders/sub-test#01", 12, "test_testorders_sub_test_01_12"},
+		{"Testテスト", 3, "test_test____3"},
+	}
+
+	for _, tt := range tests {
+		if got := databaseName(tt.testName, tt.n); got != tt.want {
+			t.Errorf("databaseName(%q, %d) = %q, want %q", tt.testName, tt.n, got, tt.want)
+		}
+	}
+
+	// 63バイトを超える場合は、連番を残して切り詰める
+	got := databaseName("Test"+strings.Repeat("x", 100), 123)
+	if len(got) != 63 || !strings.HasSuffix(got, "_123") {
+		t.Errorf("long name = %q (%d bytes)", got, len(got))
+	}
+}
+
+func TestRunningTests(t *testing.T) {
+	track("test_b_1", "TestB")
+	track("test_a_2", "TestA")
+	track("test_a_3", "TestA")
+
+	if got := strings.Join(runningTests(), ","); got != "TestA,TestB" {
+		t.Errorf("runningTests() = %s, want TestA,TestB", got)
+	}
+
+	for _, name := range []string{"test_b_1", "test_a_2", "test_a_3"} {
+		untrack(name)
+	}
+	if got := runningTests(); len(got) != 0 {
+		t.Errorf("runningTests() = %v, want empty", got)
+	}
+}
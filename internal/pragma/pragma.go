@@ -0,0 +1,236 @@
+// Package pragma は、SQLiteのPRAGMAの組み合わせを名前付きのプロファイルとして宣言し、
+// コネクション毎に適用した上で、実際に反映されたかを読み戻して検証する。
+//
+// 13.ConnHook_modernc, 14.ConnHook_mattn, 16.DriverBenchmark では、同じPRAGMA群を文字列で持ち、
+// 接続フックで実行するだけだった。しかし、PRAGMA は設定できなくてもエラーにならないものが多い。
+//
+//   - journal_mode=WAL は、:memory: や WAL 非対応のファイルシステムでは memory / delete のまま
+//   - mmap_size は、SQLITE_MAX_MMAP_SIZE を超える値を指定すると上限値に丸められる
+//   - 名前を間違えた PRAGMA (例: foreign_key=ON) は、何もせずに成功する
+//
+// Profile は、各設定を適用した後に PRAGMA name で値を読み戻して比較する。
+// Required な設定が反映されていない場合はエラーを返すため、接続フックから呼び出せば、そのコネクションは利用されない。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/pragma.html
+//   - https://www.sqlite.org/wal.html#persistence_of_wal_mode
+//   - https://www.sqlite.org/compile.html#max_mmap_size
+package pragma
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"modernc.org/sqlite"
+)
+
+var (
+	// ErrNotApplied は、Required な設定が読み戻した値と一致しなかったことを表すエラー。
+	ErrNotApplied = errors.New("pragma: required setting not applied")
+)
+
+var (
+	identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	valueRe = regexp.MustCompile(`^-?[A-Za-z0-9_]+$`)
+
+	// enums は、読み戻すと数値で返ってくるPRAGMAの、名前と数値の対応。
+	enums = map[string]map[string]string{
+		"synchronous": {"off": "0", "normal": "1", "full": "2", "extra": "3"},
+		"temp_store":  {"default": "0", "file": "1", "memory": "2"},
+		"auto_vacuum": {"none": "0", "full": "1", "incremental": "2"},
+	}
+
+	// booleans は、真偽値として扱う値。
+	booleans = map[string]string{
+		"on": "1", "true": "1", "yes": "1",
+		"off": "0", "false": "0", "no": "0",
+	}
+)
+
+type (
+	// Conn は、PRAGMA を実行するコネクション。
+	//
+	// *sqlite3.SQLiteConn, sqlite.ExecQuerierContext (modernc.org/sqlite の接続フックの引数) はどちらも満たす。
+	Conn interface {
+		driver.ExecerContext
+		driver.QueryerContext
+	}
+
+	// Mismatch は、適用した値と読み戻した値が一致しなかった設定。
+	Mismatch struct {
+		Setting
+		// Got は、読み戻した値。
+		Got string
+	}
+
+	// NotAppliedError は、Required な設定が反映されなかった場合のエラー。
+	NotAppliedError struct {
+		Profile    string
+		Mismatches []Mismatch
+	}
+)
+
+func (m Mismatch) String() string {
+	var (
+		got = m.Got
+	)
+	if got == "" {
+		// 存在しない PRAGMA 名など
+		got = "(none)"
+	}
+
+	return fmt.Sprintf("%s: want %s, got %s", m.Name, m.Value, got)
+}
+
+func (e *NotAppliedError) Error() string {
+	var (
+		items = make([]string, len(e.Mismatches))
+	)
+	for i, m := range e.Mismatches {
+		items[i] = m.String()
+	}
+
+	return fmt.Sprintf("pragma: profile %q: required setting not applied (%s)", e.Profile, strings.Join(items, ", "))
+}
+
+func (e *NotAppliedError) Unwrap() error {
+	return ErrNotApplied
+}
+
+// SQL は、プロファイルの設定を PRAGMA 文として並べた文字列を返す。
+func (p Profile) SQL() string {
+	var (
+		sb strings.Builder
+	)
+	for _, s := range p.Settings {
+		fmt.Fprintf(&sb, "PRAGMA %s=%s;\n", s.Name, s.Value)
+	}
+
+	return sb.String()
+}
+
+// Apply は、conn にプロファイルの設定を順に適用し、読み戻して検証する。
+//
+// 一致しなかった設定は全て返す。そのうち Required なものがあった場合は *NotAppliedError を返す。
+func (p Profile) Apply(ctx context.Context, conn Conn) ([]Mismatch, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	for _, s := range p.Settings {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA %s=%s", s.Name, s.Value), nil); err != nil {
+			return nil, fmt.Errorf("pragma: %s=%s: %w", s.Name, s.Value, err)
+		}
+	}
+
+	return p.Verify(ctx, conn)
+}
+
+// Verify は、conn の現在の値を読み戻し、プロファイルの設定と比較する。
+func (p Profile) Verify(ctx context.Context, conn Conn) ([]Mismatch, error) {
+	var (
+		mismatches []Mismatch
+		required   []Mismatch
+	)
+	for _, s := range p.Settings {
+		got, err := read(ctx, conn, s.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if canonical(s.Name, got) == canonical(s.Name, string(s.Value)) {
+			continue
+		}
+
+		m := Mismatch{Setting: s, Got: got}
+		mismatches = append(mismatches, m)
+		if s.Required {
+			required = append(required, m)
+		}
+	}
+
+	if len(required) > 0 {
+		return mismatches, &NotAppliedError{Profile: p.Name, Mismatches: required}
+	}
+
+	return mismatches, nil
+}
+
+// Hook は、mattn/go-sqlite3 の新しいコネクションにプロファイルを適用する。
+//
+// sqlite3.SQLiteDriver の ConnectHook から呼び出す。Required でない設定の不一致は無視する。
+func (p Profile) Hook(conn *sqlite3.SQLiteConn) error {
+	_, err := p.Apply(context.Background(), conn)
+	return err
+}
+
+// ModerncHook は、modernc.org/sqlite の新しいコネクションにプロファイルを適用する。
+//
+// modernc.org/sqlite の接続フックはプロセスグローバルであるため、
+// sqlite.RegisterConnectionHook(profile.ModerncHook) とした場合は全ての *sql.DB が対象となる。
+func (p Profile) ModerncHook(conn sqlite.ExecQuerierContext, _ string) error {
+	_, err := p.Apply(context.Background(), conn)
+	return err
+}
+
+// read は、PRAGMA name の1行目の1列目を文字列で返す。
+func read(ctx context.Context, conn Conn, name string) (string, error) {
+	rows, err := conn.QueryContext(ctx, "PRAGMA "+name, nil)
+	if err != nil {
+		return "", fmt.Errorf("pragma: read %s: %w", name, err)
+	}
+	defer rows.Close()
+
+	var (
+		dest = make([]driver.Value, len(rows.Columns()))
+	)
+	if len(dest) == 0 {
+		// 存在しない PRAGMA は結果の列を持たない
+		return "", nil
+	}
+
+	if err = rows.Next(dest); err != nil {
+		if errors.Is(err, io.EOF) {
+			return "", nil
+		}
+		return "", fmt.Errorf("pragma: read %s: %w", name, err)
+	}
+
+	switch v := dest[0].(type) {
+	case nil:
+		return "", nil
+	case []byte:
+		return string(v), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// canonical は、比較のために値を正規化する。
+//
+// 大文字小文字を無視し、synchronous=NORMAL のような名前は読み戻した時の数値に、ON/OFF は 1/0 に変換する。
+func canonical(name, value string) string {
+	var (
+		v = strings.ToLower(strings.TrimSpace(value))
+	)
+	if m, ok := enums[strings.ToLower(name)]; ok {
+		if n, ok := m[v]; ok {
+			return n
+		}
+	}
+	if b, ok := booleans[v]; ok {
+		return b
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return strconv.FormatInt(n, 10)
+	}
+
+	return v
+}
@@ -0,0 +1,269 @@
+package pragma
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Value は、PRAGMA に設定する値。
+	//
+	// JSON では "WAL" のような文字列の他に、2000 のような数値や true/false も指定できる。
+	Value string
+
+	// Setting は、1つのPRAGMAの設定。
+	Setting struct {
+		Name  string `json:"name"`
+		Value Value  `json:"value"`
+		// Required が true の場合、読み戻した値が一致しなければ Apply はエラーを返す。
+		Required bool `json:"required,omitempty"`
+	}
+
+	// Profile は、名前付きのPRAGMAの組み合わせ。設定は記述した順に適用する。
+	Profile struct {
+		Name     string    `json:"name"`
+		Settings []Setting `json:"settings"`
+	}
+)
+
+// UnmarshalJSON は、文字列・数値・真偽値を Value として読み込む。
+func (v *Value) UnmarshalJSON(b []byte) error {
+	var (
+		x any
+	)
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+
+	switch x := x.(type) {
+	case string:
+		*v = Value(x)
+	case float64:
+		*v = Value(strconv.FormatFloat(x, 'f', -1, 64))
+	case bool:
+		*v = Value(strconv.FormatBool(x))
+	default:
+		return fmt.Errorf("pragma: invalid value %s", b)
+	}
+
+	return nil
+}
+
+// Validate は、PRAGMA の名前と値に使えない文字が含まれていないかを検査する。
+//
+// PRAGMA はパラメータを利用できず、SQL文字列に埋め込むため、英数字とアンダースコアのみに制限している。
+func (p Profile) Validate() error {
+	for _, s := range p.Settings {
+		if !identRe.MatchString(s.Name) || !valueRe.MatchString(string(s.Value)) {
+			return fmt.Errorf("pragma: profile %q: invalid pragma %s=%s", p.Name, s.Name, s.Value)
+		}
+	}
+
+	return nil
+}
+
+// Server は、サーバーサイドで WRITER 1本 + READER 複数 で利用する場合のプロファイル。
+//
+// 13.ConnHook_modernc, 14.ConnHook_mattn, 16.DriverBenchmark で利用している。
+// journal_mode, synchronous, busy_timeout は反映されなければ並行性・耐障害性が変わってしまうため Required としている。
+//
+// 【パラメータの説明】
+//
+//	PRAGMA journal_mode=WAL;
+//
+//		ジャーナルモードをWAL(Write-Ahead Logging)に変更する。
+//		デフォルトのDELETEモード(ロールバックジャーナル)と異なり、
+//		書き込みと読み込みが互いをブロックしないため並行性が大幅に向上する。
+//		具体的にはリーダーはWALファイルの古いスナップショットを参照し続けられるため、
+//		WRITER 1本 + READER 複数 の同時アクセスが可能となる。
+//
+//		注意: WALモードはDBファイル単位で永続化される。
+//		一度設定すれば以降は不要だが、他ツールとDBを共有する場合はWALモード対応を確認すること。
+//		また -wal / -shm の2つの補助ファイルが生成される。
+//
+//	PRAGMA synchronous=NORMAL;
+//
+//		fsync(ディスク同期)の頻度を制御する。
+//
+//		FULL  : コミットごとに必ずfsync → 最も安全だがI/Oコストが高い(デフォルト)
+//		NORMAL: チェックポイント時のみfsync → WALモード時は実用上十分な耐障害性を維持しつつ書き込みスループットがFULLの約2〜5倍向上する(公式ドキュメント記載)
+//		OFF   : fsync一切なし → 最速だがOSクラッシュ時にDBが破損するリスクあり
+//
+//		WALモードではNORMALでも電源断以外のクラッシュに対してはACIDを満たすため、
+//		サーバーサイドではNORMALが推奨される組み合わせとなる。
+//
+//	PRAGMA busy_timeout=2000;
+//
+//		他のプロセス/スレッドがロックを保持している場合に待機する最大時間(ミリ秒)。
+//		デフォルト値は0(即座にBUSYエラーを返す)のため、並行書き込みが発生する
+//		環境では必ず設定すること。2000ms(2秒)はWebアプリ等の一般的な推奨値。
+//		sql.DB側のコンテキストタイムアウトより小さい値に設定するのが望ましい。
+//		なお PRAGMA busy_timeout はコネクション単位で有効。
+//
+//	PRAGMA cache_size=-32000;
+//
+//		ページキャッシュのサイズを指定する。
+//		正の値はページ数、負の値はKiB単位での指定となる。
+//		-32000 = 32,000 KiB = 約32MB のメモリをキャッシュに割り当てる。
+//		デフォルトは -2000(約2MB)であり、それに比べ約16倍のキャッシュ容量となる。
+//		頻繁にアクセスするDBが32MB未満であればほぼメモリ上で完結し、
+//		ディスクI/Oを大幅に削減できる。メモリに余裕がある環境での推奨設定。
+//
+//	PRAGMA temp_store=MEMORY;
+//
+//		一時テーブル・インデックス・ソート用ワーク領域の格納先を指定する。
+//
+//		- DEFAULT: デフォルト(コンパイル時設定に依存、多くの場合ファイル)
+//		- FILE   : 常にディスクファイルに書き出す
+//		- MEMORY : 常にメモリ上に展開する
+//
+//		MEMORYを指定することでソートや集計処理の一時領域がディスクI/Oを発生させず、
+//		クエリパフォーマンスが向上する。ただしメモリ使用量が増加するため
+//		大量データを扱うバッチ処理では注意が必要。
+//		なお本設定はコネクション単位で有効。
+//
+//	PRAGMA mmap_size=268435456;
+//
+//		メモリマップI/O(mmap)の上限サイズ(バイト)を指定する。
+//		268435456 = 256MB。
+//		mmapが有効な場合、OSのページキャッシュを直接アドレス空間にマップするため
+//		read()システムコールのオーバーヘッドを削減し、大規模なREAD処理が高速化する。
+//		0を指定するとmmapは無効となる(デフォルト)。
+//		32bitプロセスではアドレス空間の制約からOOMを引き起こす可能性があるため、
+//		64bitプロセス専用の設定として扱うこと。
+//		WALモードとの組み合わせで読み取りパフォーマンスが特に向上する。
+//
+//	PRAGMA wal_autocheckpoint=1000;
+//
+//		WALファイルのページ数がこの値を超えた際に自動チェックポイントを実行する閾値。
+//		チェックポイントとはWALファイルの内容をメインDBファイルに書き戻す処理。
+//		デフォルト値は1000ページ(通常1ページ=4096バイトのため約4MB相当)。
+//		値を大きくすると書き込みスループットが上がるがリカバリ時間が長くなり、
+//		WALファイルが肥大化する。値を小さくするとその逆のトレードオフとなる。
+//		本設定はDBファイル単位で永続化される。
+//		なお高負荷環境では自動チェックポイントを無効化(=0)して
+//		アプリ側で明示的にsqlite3_wal_checkpoint_v2()を呼ぶ設計も検討すること。
+func Server() Profile {
+	return Profile{
+		Name: "server",
+		Settings: []Setting{
+			{Name: "journal_mode", Value: "WAL", Required: true},
+			{Name: "synchronous", Value: "NORMAL", Required: true},
+			{Name: "busy_timeout", Value: "2000", Required: true},
+			{Name: "cache_size", Value: "-32000"},
+			{Name: "temp_store", Value: "MEMORY"},
+			{Name: "mmap_size", Value: "268435456"},
+			{Name: "wal_autocheckpoint", Value: "1000"},
+		},
+	}
+}
+
+// Batch は、大量のデータを一括で書き込むバッチ処理向けのプロファイル。
+//
+// synchronous=OFF のため、OSのクラッシュや電源断でDBが破損する可能性がある。
+// 失敗したら最初からやり直せる処理 (元データからの再作成など) でのみ利用すること。
+func Batch() Profile {
+	return Profile{
+		Name: "batch",
+		Settings: []Setting{
+			{Name: "journal_mode", Value: "WAL", Required: true},
+			{Name: "synchronous", Value: "OFF"},
+			{Name: "busy_timeout", Value: "10000", Required: true},
+			{Name: "cache_size", Value: "-256000"},
+			{Name: "temp_store", Value: "MEMORY"},
+			{Name: "wal_autocheckpoint", Value: "10000"},
+		},
+	}
+}
+
+// ReadOnly は、読み取り専用で利用する場合のプロファイル。
+//
+// query_only=ON のコネクションでは、書き込みを行う文が SQLITE_READONLY で失敗する。
+// journal_mode はDBファイルの設定を変更してしまうため指定しない。
+func ReadOnly() Profile {
+	return Profile{
+		Name: "read-only",
+		Settings: []Setting{
+			{Name: "query_only", Value: "ON", Required: true},
+			{Name: "busy_timeout", Value: "2000"},
+			{Name: "cache_size", Value: "-32000"},
+			{Name: "temp_store", Value: "MEMORY"},
+			{Name: "mmap_size", Value: "268435456"},
+		},
+	}
+}
+
+// Test は、テスト用の一時的なデータベース (:memory: や使い捨てのファイル) 向けのプロファイル。
+//
+// 耐障害性は不要なため fsync を行わず、外部キー制約は本番と同じく有効にする。
+func Test() Profile {
+	return Profile{
+		Name: "test",
+		Settings: []Setting{
+			{Name: "journal_mode", Value: "MEMORY"},
+			{Name: "synchronous", Value: "OFF"},
+			{Name: "foreign_keys", Value: "ON", Required: true},
+			{Name: "busy_timeout", Value: "5000"},
+			{Name: "temp_store", Value: "MEMORY"},
+		},
+	}
+}
+
+// Profiles は、定義済みのプロファイルを返す。
+func Profiles() []Profile {
+	return []Profile{Server(), Batch(), ReadOnly(), Test()}
+}
+
+// Lookup は、名前で定義済みのプロファイルを探す。
+func Lookup(name string) (Profile, error) {
+	var (
+		profiles = Profiles()
+		i        = slices.IndexFunc(profiles, func(p Profile) bool { return strings.EqualFold(p.Name, name) })
+	)
+	if i < 0 {
+		return Profile{}, fmt.Errorf("pragma: unknown profile %q", name)
+	}
+
+	return profiles[i], nil
+}
+
+// ParseProfiles は、JSONの配列からプロファイルを読み込む。
+//
+//	[
+//	  {"name": "server", "settings": [{"name": "journal_mode", "value": "WAL", "required": true}, ...]},
+//	  ...
+//	]
+func ParseProfiles(b []byte) ([]Profile, error) {
+	var (
+		profiles []Profile
+		dec      = json.NewDecoder(bytes.NewReader(b))
+	)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&profiles); err != nil {
+		return nil, fmt.Errorf("pragma: parse profiles: %w", err)
+	}
+
+	var (
+		seen = make(map[string]bool)
+	)
+	for _, p := range profiles {
+		if p.Name == "" {
+			return nil, fmt.Errorf("pragma: parse profiles: empty profile name")
+		}
+		if seen[strings.ToLower(p.Name)] {
+			return nil, fmt.Errorf("pragma: parse profiles: duplicate profile %q", p.Name)
+		}
+		seen[strings.ToLower(p.Name)] = true
+
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return profiles, nil
+}
@@ -0,0 +1,232 @@
+// Package ready は、データベースが接続可能になるまで PingContext を再試行して待つ。
+//
+// sql.Open はコネクションを開かないため、接続できるかどうかは最初の Ping (またはクエリ) まで分からない。
+// 15.embedded-postgresql のように起動直後のサーバに接続する場合や、コンテナで DB とアプリが同時に起動する場合は、
+// 最初の Ping が connection refused や the database system is starting up で失敗することがある。
+//
+// WaitReady は、Policy に従って指数バックオフで Ping を再試行する。
+// ただし、認証エラーや存在しないファイルのように、再試行しても成功しないエラー (Permanent) の場合はすぐに諦める。
+// 各試行の結果は log/slog で出力する。
+//
+// # REFERENCES
+//   - https://pkg.go.dev/database/sql@go1.26.0#DB.PingContext
+//   - https://pkg.go.dev/log/slog@go1.26.0
+//   - https://www.postgresql.org/docs/current/errcodes-appendix.html
+//   - https://www.sqlite.org/rescode.html
+package ready
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/lib/pq"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrPermanent は、再試行しても成功しないエラーで諦めたことを表す。
+	ErrPermanent = errors.New("ready: permanent error")
+	// ErrAttempts は、MaxAttempts 回試行しても成功しなかったことを表す。
+	ErrAttempts = errors.New("ready: max attempts exceeded")
+)
+
+// Class は、エラーの分類。
+type Class int
+
+const (
+	// Transient は、時間をおいて再試行すれば成功する可能性があるエラー。
+	Transient Class = iota
+	// Permanent は、再試行しても成功しないエラー。
+	Permanent
+)
+
+func (c Class) String() string {
+	if c == Permanent {
+		return "permanent"
+	}
+
+	return "transient"
+}
+
+type (
+	// Policy は、再試行の方法。
+	Policy struct {
+		// MaxAttempts は、最大の試行回数。0 の場合は ctx が終了するまで再試行する。
+		MaxAttempts int
+		// InitialDelay は、1回目の失敗後に待つ時間。0 の場合は 100ms。
+		InitialDelay time.Duration
+		// MaxDelay は、待ち時間の上限。0 の場合は 5秒。
+		MaxDelay time.Duration
+		// Multiplier は、失敗する度に待ち時間に掛ける値。1 以下の場合は 2。
+		Multiplier float64
+		// Jitter は、待ち時間をランダムに減らす割合 (0〜1)。複数のプロセスが同時に再試行するのを避ける。
+		Jitter float64
+		// AttemptTimeout は、1回の Ping のタイムアウト。0 の場合は ctx のみ。
+		AttemptTimeout time.Duration
+		// Classify は、エラーを分類する関数。nil の場合は DefaultClassify。
+		Classify func(error) Class
+		// Logger は、試行の結果を出力するロガー。nil の場合は slog.Default()。
+		Logger *slog.Logger
+	}
+
+	// Error は、WaitReady が諦めた場合のエラー。
+	//
+	// errors.Is で ErrPermanent, ErrAttempts, context.DeadlineExceeded などのどれで諦めたかを、
+	// errors.As で最後の Ping のエラー (*pq.Error など) を取り出せる。
+	Error struct {
+		Attempts int
+		// Reason は、諦めた理由 (ErrPermanent, ErrAttempts, ctx.Err())。
+		Reason error
+		// Last は、最後の Ping のエラー。
+		Last error
+	}
+)
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ready: gave up after %d attempt(s): %v: %v", e.Attempts, e.Reason, e.Last)
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Reason, e.Last}
+}
+
+// WaitReady は、db.PingContext が成功するまで p に従って再試行する。
+//
+// 成功した場合は nil、諦めた場合は *Error を返す。
+func WaitReady(ctx context.Context, db *sql.DB, p Policy) error {
+	p = p.withDefaults()
+
+	var (
+		start = time.Now()
+		delay = p.InitialDelay
+	)
+	for attempt := 1; ; attempt++ {
+		err := ping(ctx, db, p.AttemptTimeout)
+		if err == nil {
+			p.Logger.Info("database ready", "attempt", attempt, "elapsed", time.Since(start).Round(time.Millisecond))
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			p.Logger.Error("database not ready", "attempt", attempt, "err", err, "reason", ctx.Err())
+			return &Error{Attempts: attempt, Reason: ctx.Err(), Last: err}
+		}
+
+		class := p.Classify(err)
+		if class == Permanent {
+			p.Logger.Error("database not ready", "attempt", attempt, "class", class, "err", err)
+			return &Error{Attempts: attempt, Reason: ErrPermanent, Last: err}
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			p.Logger.Error("database not ready", "attempt", attempt, "class", class, "err", err)
+			return &Error{Attempts: attempt, Reason: ErrAttempts, Last: err}
+		}
+
+		wait := jitter(delay, p.Jitter)
+		p.Logger.Warn("ping failed, retrying", "attempt", attempt, "class", class, "delay", wait.Round(time.Millisecond), "err", err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			p.Logger.Error("database not ready", "attempt", attempt, "err", err, "reason", ctx.Err())
+			return &Error{Attempts: attempt, Reason: ctx.Err(), Last: err}
+		case <-timer.C:
+		}
+
+		delay = min(time.Duration(float64(delay)*p.Multiplier), p.MaxDelay)
+	}
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.InitialDelay <= 0 {
+		p.InitialDelay = 100 * time.Millisecond
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 5 * time.Second
+	}
+	if p.Multiplier <= 1 {
+		p.Multiplier = 2
+	}
+	p.Jitter = min(max(p.Jitter, 0), 1)
+	if p.Classify == nil {
+		p.Classify = DefaultClassify
+	}
+	if p.Logger == nil {
+		p.Logger = slog.Default()
+	}
+
+	return p
+}
+
+func ping(ctx context.Context, db *sql.DB, timeout time.Duration) error {
+	if timeout > 0 {
+		var (
+			cancel context.CancelFunc
+		)
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return db.PingContext(ctx)
+}
+
+// jitter は、d を最大 ratio の割合だけランダムに減らす。
+func jitter(d time.Duration, ratio float64) time.Duration {
+	if ratio <= 0 {
+		return d
+	}
+
+	return time.Duration(float64(d) * (1 - ratio*rand.Float64()))
+}
+
+// DefaultClassify は、PostgreSQL (lib/pq) と SQLite (mattn/go-sqlite3, modernc.org/sqlite) のエラーを分類する。
+//
+// 以下を Permanent とし、それ以外 (connection refused, starting up, too many connections, タイムアウトなど) は
+// Transient とする。分からないエラーも Transient とするため、MaxAttempts か ctx で上限を決めておくこと。
+//
+//   - PostgreSQL: 28 (認証エラー), 3D (データベースが存在しない), 42 (権限・構文エラー) の SQLSTATE
+//   - SQLite    : SQLITE_CANTOPEN (ファイルを開けない), SQLITE_NOTADB, SQLITE_CORRUPT, SQLITE_PERM, SQLITE_AUTH
+//
+// mattn/go-sqlite3, modernc.org/sqlite は、既定ではファイルが存在しない場合に新しく作成する。
+// 存在しないファイルをエラーにするには、file:xxx.db?mode=rw のように URI で mode を指定する。
+func DefaultClassify(err error) Class {
+	var (
+		pqErr    *pq.Error
+		mattnErr sqlite3.Error
+		coder    interface{ Code() int } // modernc.org/sqlite の *sqlite.Error
+	)
+	switch {
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return Transient
+	case errors.As(err, &pqErr):
+		switch pqErr.Code.Class() {
+		case "28", "3D", "42":
+			return Permanent
+		}
+		return Transient
+	case errors.As(err, &mattnErr):
+		return sqliteClass(int(mattnErr.Code))
+	case errors.As(err, &coder):
+		return sqliteClass(coder.Code() & 0xff)
+	}
+
+	// connection refused (*net.OpError) なども含めて再試行する
+	return Transient
+}
+
+func sqliteClass(code int) Class {
+	switch code {
+	case 3, 11, 14, 23, 26: // SQLITE_PERM, SQLITE_CORRUPT, SQLITE_CANTOPEN, SQLITE_AUTH, SQLITE_NOTADB
+		return Permanent
+	}
+
+	return Transient
+}
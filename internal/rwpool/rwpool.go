@@ -0,0 +1,344 @@
+// Package rwpool は、WALモードのSQLiteに対して、書き込み用と読み込み用のコネクションプールを分けて利用する。
+//
+// 13.ConnHook_modernc, 14.ConnHook_mattn では *sql.DB 全体を SetMaxOpenConns(1) にしているため、
+// 読み込みも1本のコネクションに直列化され、WALモードの「WRITER 1本 + READER 複数」の並行性を活かせない。
+//
+// Pool は、2つの *sql.DB を持つ。
+//
+//   - writer: コネクションは1本のみ。_txlock=immediate で、トランザクションを BEGIN IMMEDIATE で開始する
+//   - reader: コネクションは N 本。query_only=ON で、書き込みを行う文はエラーになる
+//
+// Query* は reader に、Exec と BeginTx は writer に振り分ける (BeginTx で ReadOnly を指定した場合は reader)。
+//
+// BEGIN IMMEDIATE にするのは、BEGIN (DEFERRED) で開始したトランザクションが途中で読み込みから書き込みに昇格する際に
+// 他のコネクションが書き込み中だと、busy_timeout で待たずに即座に SQLITE_BUSY になるため。
+// 書き込みは writer の1本のみだが、他のプロセスや reader 以外の *sql.DB が書き込む可能性もある。
+//
+// # Read-your-writes
+//
+// WALモードでは、コミット後に開始した読み込みトランザクションはコミットされた内容を参照する。
+// ただし、ReadOnly で BeginTx したトランザクションのように、書き込みより前に開始した読み込みトランザクションは
+// 開始時点のスナップショットを参照し続ける。
+// また、レプリカなどに置き換えた場合には、コミット直後の読み込みで書き込んだ内容が見えるとは限らない。
+//
+// ReadYourWrites で印を付けた context では、その context で書き込んだ後の Query* を writer に振り分ける。
+// 書き込んだ内容を必ず読み込みたい処理でのみ利用する (writer は1本のため、多用すると並行性が失われる)。
+//
+// その context で BeginTx したトランザクションが終わっていない間は、Query* と Exec をそのトランザクションで実行する。
+// writer のコネクションは1本のみで、トランザクションが保持しているため、writer で実行すると
+// コミットされるまで待ち続けてデッドロックになる。
+// 同じ理由で、トランザクションが終わっていない間に同じ context で BeginTx すると ErrTxActive を返す。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/wal.html#concurrency
+//   - https://www.sqlite.org/lang_transaction.html#deferred_immediate_and_exclusive_transactions
+//   - https://www.sqlite.org/pragma.html#pragma_query_only
+//   - https://www.sqlite.org/isolation.html
+package rwpool
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/devlights/try-golang-db/internal/dbopen"
+	"github.com/devlights/try-golang-db/internal/pragma"
+)
+
+var (
+	// ErrTxActive は、ReadYourWrites の context で開始したトランザクションが終わる前に、同じ context で BeginTx したことを表す。
+	ErrTxActive = errors.New("rwpool: transaction is still active in this ReadYourWrites context")
+)
+
+type (
+	// Options は、Open のオプション。
+	Options struct {
+		// Driver は、利用するドライバ。
+		Driver dbopen.Driver
+		// Readers は、reader のコネクション数。0 の場合は runtime.GOMAXPROCS(0)。
+		Readers int
+		// Writer は、writer に適用するPRAGMA。nil の場合は pragma.Server()。
+		//
+		// journal_mode=WAL でなければ読み込みと書き込みが互いをブロックするため、Required にしておくこと。
+		Writer *pragma.Profile
+		// Reader は、reader に適用するPRAGMA。nil の場合は pragma.ReadOnly()。
+		//
+		// query_only=ON が含まれていない場合は追加する。
+		Reader *pragma.Profile
+		// Hooks は、writer と reader の両方の新しいコネクションで呼ばれる。
+		Hooks []dbopen.Hook
+	}
+
+	// Pool は、書き込み用と読み込み用の *sql.DB の組。
+	Pool struct {
+		writer *sql.DB
+		reader *sql.DB
+	}
+
+	// rywKey は、ReadYourWrites の context のキー
+	rywKey struct{}
+
+	// ryw は、ReadYourWrites で context に持たせる印。その context で書き込んだかどうかと、
+	// その context で開始した writer のトランザクションを持つ。
+	ryw struct {
+		wrote atomic.Bool
+
+		mu sync.Mutex
+		tx *sql.Tx
+	}
+)
+
+// Open は、path のデータベースを writer と reader の *sql.DB で開く。
+//
+// path には URI やドライバのパラメータも指定できる。writer には _txlock=immediate を付け加える。
+// writer を先に開き、journal_mode=WAL が反映されたことを確認してから reader を開く。
+func Open(ctx context.Context, path string, opts Options) (*Pool, error) {
+	var (
+		writerProfile = pragma.Server()
+		readerProfile = pragma.ReadOnly()
+		readers       = opts.Readers
+	)
+	if opts.Writer != nil {
+		writerProfile = *opts.Writer
+	}
+	if opts.Reader != nil {
+		readerProfile = *opts.Reader
+	}
+	if readers <= 0 {
+		readers = runtime.GOMAXPROCS(0)
+	}
+	readerProfile = withQueryOnly(readerProfile)
+
+	writer, err := dbopen.OpenSQLite(withParam(path, "_txlock", "immediate"), dbopen.Options{
+		Driver:  opts.Driver,
+		Pragmas: &writerProfile,
+		Hooks:   opts.Hooks,
+	})
+	if err != nil {
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+	writer.SetMaxIdleConns(1)
+	writer.SetConnMaxLifetime(0)
+
+	if err = writer.PingContext(ctx); err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("rwpool: writer: %w", err)
+	}
+
+	reader, err := dbopen.OpenSQLite(path, dbopen.Options{
+		Driver:  opts.Driver,
+		Pragmas: &readerProfile,
+		Hooks:   opts.Hooks,
+	})
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	reader.SetMaxOpenConns(readers)
+	reader.SetMaxIdleConns(readers)
+	reader.SetConnMaxLifetime(0)
+
+	if err = reader.PingContext(ctx); err != nil {
+		writer.Close()
+		reader.Close()
+		return nil, fmt.Errorf("rwpool: reader: %w", err)
+	}
+
+	return &Pool{writer: writer, reader: reader}, nil
+}
+
+// withQueryOnly は、query_only=ON が Required で含まれるようにした profile を返す。
+func withQueryOnly(p pragma.Profile) pragma.Profile {
+	var (
+		settings = make([]pragma.Setting, 0, len(p.Settings)+1)
+	)
+	for _, s := range p.Settings {
+		if !strings.EqualFold(s.Name, "query_only") {
+			settings = append(settings, s)
+		}
+	}
+	p.Settings = append(settings, pragma.Setting{Name: "query_only", Value: "ON", Required: true})
+
+	return p
+}
+
+// withParam は、path にクエリパラメータを付け加える。
+func withParam(path, key, value string) string {
+	var (
+		sep = "?"
+	)
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+
+	return path + sep + key + "=" + value
+}
+
+// ReadYourWrites は、Pool に渡すと書き込み後の読み込みを writer で行うようにした context を返す。
+func ReadYourWrites(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(rywKey{}).(*ryw); ok {
+		return ctx
+	}
+
+	return context.WithValue(ctx, rywKey{}, &ryw{})
+}
+
+// markWrite は、ctx が ReadYourWrites であれば書き込んだことを記録する。
+func markWrite(ctx context.Context) {
+	if m, ok := ctx.Value(rywKey{}).(*ryw); ok {
+		m.wrote.Store(true)
+	}
+}
+
+// activeTx は、m で開始したトランザクションが終わっていなければ返す。終わっていれば記録を消して nil を返す。
+func (m *ryw) activeTx(ctx context.Context) *sql.Tx {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tx == nil {
+		return nil
+	}
+
+	// *sql.Tx には終了したかを調べるメソッドが無いため、簡単なクエリを実行して sql.ErrTxDone かどうかで判断する
+	var (
+		one int
+	)
+	if err := m.tx.QueryRowContext(ctx, "SELECT 1").Scan(&one); errors.Is(err, sql.ErrTxDone) {
+		m.tx = nil
+	}
+
+	return m.tx
+}
+
+// clearTx は、tx が終了していた場合に記録を消す。
+func (m *ryw) clearTx(tx *sql.Tx) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tx == tx {
+		m.tx = nil
+	}
+}
+
+// Writer は、書き込み用の *sql.DB を返す。
+func (p *Pool) Writer() *sql.DB {
+	return p.writer
+}
+
+// Reader は、読み込み用の *sql.DB を返す。
+func (p *Pool) Reader() *sql.DB {
+	return p.reader
+}
+
+// readerFor は、ctx で読み込みに利用する *sql.DB を返す。
+func (p *Pool) readerFor(ctx context.Context) *sql.DB {
+	if m, ok := ctx.Value(rywKey{}).(*ryw); ok && m.wrote.Load() {
+		return p.writer
+	}
+
+	return p.reader
+}
+
+// txFor は、ctx が ReadYourWrites で、その context で開始したトランザクションがあれば返す。
+func txFor(ctx context.Context) (*ryw, *sql.Tx) {
+	m, ok := ctx.Value(rywKey{}).(*ryw)
+	if !ok {
+		return nil, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m, m.tx
+}
+
+// QueryContext は、reader でクエリを実行する。
+//
+// ReadYourWrites の context で開始したトランザクションが終わっていなければ、そのトランザクションで実行する。
+func (p *Pool) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if m, tx := txFor(ctx); tx != nil {
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if !errors.Is(err, sql.ErrTxDone) {
+			return rows, err
+		}
+		m.clearTx(tx)
+	}
+
+	return p.readerFor(ctx).QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext は、reader でクエリを実行する。
+//
+// ReadYourWrites の context で開始したトランザクションが終わっていなければ、そのトランザクションで実行する。
+func (p *Pool) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if m, tx := txFor(ctx); tx != nil {
+		row := tx.QueryRowContext(ctx, query, args...)
+		if !errors.Is(row.Err(), sql.ErrTxDone) {
+			return row
+		}
+		m.clearTx(tx)
+	}
+
+	return p.readerFor(ctx).QueryRowContext(ctx, query, args...)
+}
+
+// ExecContext は、writer で実行する。
+//
+// ReadYourWrites の context で開始したトランザクションが終わっていなければ、そのトランザクションで実行する。
+func (p *Pool) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	markWrite(ctx)
+
+	if m, tx := txFor(ctx); tx != nil {
+		result, err := tx.ExecContext(ctx, query, args...)
+		if !errors.Is(err, sql.ErrTxDone) {
+			return result, err
+		}
+		m.clearTx(tx)
+	}
+
+	return p.writer.ExecContext(ctx, query, args...)
+}
+
+// BeginTx は、writer でトランザクションを開始する (BEGIN IMMEDIATE)。
+//
+// opts.ReadOnly が true の場合は reader で開始する (ReadYourWrites で書き込み済みの場合は writer)。
+// ReadYourWrites の context で writer に開始したトランザクションは、ReadOnly の場合も終わるまで Query* と Exec に利用する。
+// ReadYourWrites の context で開始したトランザクションが終わっていない場合は ErrTxActive を返す。
+func (p *Pool) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	m, ok := ctx.Value(rywKey{}).(*ryw)
+	if ok && m.activeTx(ctx) != nil {
+		return nil, ErrTxActive
+	}
+
+	var (
+		db = p.writer
+	)
+	if opts != nil && opts.ReadOnly {
+		db = p.readerFor(ctx)
+	} else {
+		markWrite(ctx)
+	}
+
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil || !ok || db != p.writer {
+		return tx, err
+	}
+
+	// writer のコネクションはこのトランザクションが保持するため、終わるまでの読み書きはこのトランザクションで行う
+	m.mu.Lock()
+	m.tx = tx
+	m.mu.Unlock()
+
+	return tx, nil
+}
+
+// Close は、writer と reader を閉じる。
+func (p *Pool) Close() error {
+	return errors.Join(p.reader.Close(), p.writer.Close())
+}
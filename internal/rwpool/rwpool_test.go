@@ -0,0 +1,190 @@
+package rwpool
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func open(t *testing.T) *Pool {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pool, err := Open(ctx, filepath.Join(t.TempDir(), "test.db"), Options{Readers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	if _, err = pool.ExecContext(ctx, "CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	return pool
+}
+
+func count(ctx context.Context, pool *Pool) (int, error) {
+	var (
+		n int
+	)
+	err := pool.QueryRowContext(ctx, "SELECT count(*) FROM items").Scan(&n)
+
+	return n, err
+}
+
+// TestReadYourWritesInTx は、ReadYourWrites の context でトランザクション中に Pool から読み込んでも
+// writer のコネクション待ちでデッドロックせず、トランザクションの中の内容が見えることを確認する。
+func TestReadYourWritesInTx(t *testing.T) {
+	var (
+		pool        = open(t)
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		rywCtx      = ReadYourWrites(ctx)
+	)
+	defer cancel()
+
+	tx, err := pool.BeginTx(rywCtx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(rywCtx, "INSERT INTO items (name) VALUES ('a')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pool.ExecContext(rywCtx, "INSERT INTO items (name) VALUES ('b')"); err != nil {
+		t.Fatal(err)
+	}
+
+	// トランザクションの中で実行されるため、コミット前の2行が見える
+	if n, err := count(rywCtx, pool); err != nil || n != 2 {
+		t.Errorf("QueryRowContext in tx: n=%d err=%v, want 2", n, err)
+	}
+	rows, err := pool.QueryContext(rywCtx, "SELECT name FROM items ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		names []string
+	)
+	for rows.Next() {
+		var s string
+		if err = rows.Scan(&s); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, s)
+	}
+	rows.Close()
+	if len(names) != 2 {
+		t.Errorf("QueryContext in tx: %v", names)
+	}
+
+	// 他の context からはコミット前の内容は見えない
+	if n, err := count(ctx, pool); err != nil || n != 0 {
+		t.Errorf("reader: n=%d err=%v, want 0", n, err)
+	}
+
+	// トランザクション中に同じ context で BeginTx するとエラー
+	for _, opts := range []*sql.TxOptions{nil, {ReadOnly: true}} {
+		if _, err = pool.BeginTx(rywCtx, opts); !errors.Is(err, ErrTxActive) {
+			t.Errorf("BeginTx(%+v): err = %v, want %v", opts, err, ErrTxActive)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// コミット後は writer で読み込み、新しいトランザクションも開始できる
+	if n, err := count(rywCtx, pool); err != nil || n != 2 {
+		t.Errorf("after commit: n=%d err=%v, want 2", n, err)
+	}
+	tx, err = pool.BeginTx(rywCtx, nil)
+	if err != nil {
+		t.Fatalf("BeginTx after commit: %v", err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pool.ExecContext(rywCtx, "INSERT INTO items (name) VALUES ('c')"); err != nil {
+		t.Fatalf("ExecContext after rollback: %v", err)
+	}
+	if n, err := count(ctx, pool); err != nil || n != 3 {
+		t.Errorf("reader after commit: n=%d err=%v, want 3", n, err)
+	}
+}
+
+// TestReadYourWritesReadOnlyTx は、書き込み済みの ReadYourWrites の context で ReadOnly のトランザクションを開始した場合
+// (writer で開始される) も、その間の Pool からの読み込みがデッドロックしないことを確認する。
+func TestReadYourWritesReadOnlyTx(t *testing.T) {
+	var (
+		pool        = open(t)
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		rywCtx      = ReadYourWrites(ctx)
+	)
+	defer cancel()
+
+	if _, err := pool.ExecContext(rywCtx, "INSERT INTO items (name) VALUES ('a')"); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := pool.BeginTx(rywCtx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	var (
+		inTx int
+	)
+	if err = tx.QueryRowContext(rywCtx, "SELECT count(*) FROM items").Scan(&inTx); err != nil || inTx != 1 {
+		t.Errorf("in tx: n=%d err=%v, want 1", inTx, err)
+	}
+	if n, err := count(rywCtx, pool); err != nil || n != 1 {
+		t.Errorf("QueryRowContext during read-only tx: n=%d err=%v, want 1", n, err)
+	}
+	if _, err = pool.BeginTx(rywCtx, &sql.TxOptions{ReadOnly: true}); !errors.Is(err, ErrTxActive) {
+		t.Errorf("nested BeginTx: err = %v, want %v", err, ErrTxActive)
+	}
+
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := count(rywCtx, pool); err != nil || n != 1 {
+		t.Errorf("after rollback: n=%d err=%v, want 1", n, err)
+	}
+
+	// 書き込む前の ReadYourWrites の context では、ReadOnly のトランザクションは reader で開始され、記録されない
+	var (
+		fresh = ReadYourWrites(ctx)
+	)
+	tx, err = pool.BeginTx(fresh, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err = pool.ExecContext(fresh, "INSERT INTO items (name) VALUES ('b')"); err != nil {
+		t.Errorf("ExecContext during reader tx: %v", err)
+	}
+}
+
+func TestReaderIsQueryOnly(t *testing.T) {
+	var (
+		pool = open(t)
+		ctx  = context.Background()
+	)
+
+	if _, err := pool.Reader().ExecContext(ctx, "INSERT INTO items (name) VALUES ('x')"); err == nil {
+		t.Error("write on reader: err = nil, want error")
+	}
+	if _, err := pool.ExecContext(ctx, "INSERT INTO items (name) VALUES ('x')"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := count(ctx, pool); err != nil || n != 1 {
+		t.Errorf("n=%d err=%v, want 1", n, err)
+	}
+}
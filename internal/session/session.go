@@ -0,0 +1,399 @@
+// Package session は、*sql.Conn を固定して利用するセッションを提供する。
+//
+// 08.Conn にある通り、db.Conn() で取得した *sql.Conn 上のクエリは同じデータベースセッションで実行される。
+// 以下のようなものはコネクション単位の状態であるため、*sql.DB のまま実行すると
+// 次のクエリが別のコネクションで実行されてしまい、期待通りに動かない。
+//
+//   - 一時テーブル (CREATE TEMP TABLE)
+//   - ATTACH DATABASE
+//   - コネクション単位のPRAGMA (cache_size, temp_store など)
+//   - PostgreSQL のセッション変数 (SET)
+//
+// また、これらの状態を持ったままコネクションをプールに戻すと
+// 後でそのコネクションを受け取った別の処理が影響を受けてしまう。
+//
+// Session は、上記の状態を変更する操作を記録しておき、Release で元に戻す。
+// 元に戻せなかった場合や、戻せたことを確認できなかった場合は
+// driver.ErrBadConn を利用してコネクションを破棄し、プールには戻さない。
+//
+// # REFERENCES
+//   - https://pkg.go.dev/database/sql@go1.26.0#Conn
+//   - https://pkg.go.dev/database/sql@go1.26.0#Conn.Raw
+//   - https://www.sqlite.org/lang_attach.html
+//   - https://www.postgresql.org/docs/current/sql-discard.html
+package session
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Dialect は、セッションの接続先データベースの種類を表す。
+type Dialect int
+
+const (
+	SQLite Dialect = iota
+	Postgres
+)
+
+var (
+	// ErrReleased は、Release 済みのセッションを利用しようとした場合のエラー。
+	ErrReleased = errors.New("session: already released")
+	// ErrDirty は、コネクションの状態を元に戻せなかったため、コネクションを破棄したことを表すエラー。
+	ErrDirty = errors.New("session: connection state could not be restored; connection discarded")
+	// ErrUnsupported は、接続先で利用できない操作を行おうとした場合のエラー。
+	ErrUnsupported = errors.New("session: operation not supported by dialect")
+	// ErrPersistentPragma は、DBファイルに永続化されるPRAGMAを SetPragma で変更しようとした場合のエラー。
+	ErrPersistentPragma = errors.New("session: pragma is persistent in the database file")
+)
+
+var (
+	// persistentPragmas は、DBファイルに永続化され、他のコネクションにも影響するPRAGMA。
+	//
+	// これらはセッションを Release しても元に戻すまでの間に他のコネクションから見えてしまうため、SetPragma では変更させない。
+	persistentPragmas = map[string]bool{
+		"journal_mode":       true, // WAL はファイルに記録される
+		"page_size":          true,
+		"auto_vacuum":        true,
+		"user_version":       true,
+		"application_id":     true,
+		"schema_version":     true,
+		"encoding":           true,
+		"legacy_file_format": true,
+	}
+)
+
+var (
+	identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	valueRe = regexp.MustCompile(`^-?[A-Za-z0-9_]+$`)
+)
+
+type (
+	// pragma は、変更前のPRAGMAの値
+	pragma struct {
+		name  string
+		value string
+	}
+
+	// Session は、一つのコネクションに固定されたセッション。
+	//
+	// Session はゴルーチンセーフではない。一つのゴルーチンから利用すること。
+	Session struct {
+		conn    *sql.Conn
+		dialect Dialect
+
+		tempTables []string
+		attached   []string
+		pragmas    []pragma
+
+		dirty    []string
+		released bool
+	}
+)
+
+// Open は、db からコネクションを一つ取得してセッションを開始する。
+//
+// 利用が終わったら必ず Release を呼び出すこと。
+func Open(ctx context.Context, db *sql.DB, dialect Dialect) (*Session, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("db.Conn: %w", err)
+	}
+
+	return &Session{conn: conn, dialect: dialect}, nil
+}
+
+// Conn は、セッションが保持している *sql.Conn を返す。
+//
+// このコネクションに対して直接コネクションの状態を変更する操作を行った場合は
+// MarkDirty を呼び出して、Release 時に破棄されるようにすること。
+func (s *Session) Conn() *sql.Conn {
+	return s.conn
+}
+
+// ExecContext は、セッションのコネクションでクエリを実行する。
+func (s *Session) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if s.released {
+		return nil, ErrReleased
+	}
+
+	return s.conn.ExecContext(ctx, query, args...)
+}
+
+// QueryContext は、セッションのコネクションでクエリを実行する。
+func (s *Session) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if s.released {
+		return nil, ErrReleased
+	}
+
+	return s.conn.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext は、セッションのコネクションでクエリを実行する。
+//
+// Release 済みの場合でも *sql.Row を返すが、Scan 時に sql.ErrConnDone となる。
+func (s *Session) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return s.conn.QueryRowContext(ctx, query, args...)
+}
+
+// BeginTx は、セッションのコネクションでトランザクションを開始する。
+func (s *Session) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if s.released {
+		return nil, ErrReleased
+	}
+
+	return s.conn.BeginTx(ctx, opts)
+}
+
+// CreateTempTable は、一時テーブルを作成する。作成したテーブルは Release 時に削除される。
+//
+// columns には、CREATE TABLE の括弧内に記述するカラム定義を指定する。
+func (s *Session) CreateTempTable(ctx context.Context, name, columns string) error {
+	if s.released {
+		return ErrReleased
+	}
+	if !identRe.MatchString(name) {
+		return fmt.Errorf("session: invalid table name %q", name)
+	}
+
+	_, err := s.conn.ExecContext(ctx, fmt.Sprintf("CREATE TEMP TABLE %s (%s)", quote(name), columns))
+	if err != nil {
+		return fmt.Errorf("create temp table %s: %w", name, err)
+	}
+
+	s.tempTables = append(s.tempTables, name)
+
+	return nil
+}
+
+// Attach は、SQLiteのデータベースファイルを alias という名前でアタッチする。Release 時にデタッチされる。
+func (s *Session) Attach(ctx context.Context, path, alias string) error {
+	if s.released {
+		return ErrReleased
+	}
+	if s.dialect != SQLite {
+		return fmt.Errorf("attach: %w", ErrUnsupported)
+	}
+	if !identRe.MatchString(alias) || isReservedSchema(alias) {
+		return fmt.Errorf("session: invalid schema name %q", alias)
+	}
+
+	_, err := s.conn.ExecContext(ctx, fmt.Sprintf("ATTACH DATABASE ? AS %s", quote(alias)), path)
+	if err != nil {
+		return fmt.Errorf("attach %s: %w", alias, err)
+	}
+
+	s.attached = append(s.attached, alias)
+
+	return nil
+}
+
+// SetPragma は、コネクション単位のPRAGMAを設定する。Release 時に変更前の値に戻す。
+//
+// 同じPRAGMAを複数回設定した場合でも、戻すのは最初に設定する前の値となる。
+// journal_mode, page_size, auto_vacuum, user_version のようにDBファイルに永続化されるPRAGMAは
+// ErrPersistentPragma を返す。
+func (s *Session) SetPragma(ctx context.Context, name, value string) error {
+	if s.released {
+		return ErrReleased
+	}
+	if s.dialect != SQLite {
+		return fmt.Errorf("pragma: %w", ErrUnsupported)
+	}
+	if !identRe.MatchString(name) || !valueRe.MatchString(value) {
+		return fmt.Errorf("session: invalid pragma %s=%s", name, value)
+	}
+	if persistentPragmas[strings.ToLower(name)] {
+		return fmt.Errorf("%w: %s", ErrPersistentPragma, name)
+	}
+
+	var (
+		orig string
+	)
+	if err := s.conn.QueryRowContext(ctx, "PRAGMA "+name).Scan(&orig); err != nil {
+		return fmt.Errorf("read pragma %s: %w", name, err)
+	}
+
+	if _, err := s.conn.ExecContext(ctx, fmt.Sprintf("PRAGMA %s=%s", name, value)); err != nil {
+		return fmt.Errorf("set pragma %s: %w", name, err)
+	}
+
+	if !s.hasPragma(name) {
+		s.pragmas = append(s.pragmas, pragma{name, orig})
+	}
+
+	return nil
+}
+
+// SetVar は、PostgreSQL のセッション変数を設定する。Release 時に DISCARD ALL で破棄される。
+//
+// SET 文はパラメータを受け付けないため set_config() を利用している。
+func (s *Session) SetVar(ctx context.Context, name, value string) error {
+	if s.released {
+		return ErrReleased
+	}
+	if s.dialect != Postgres {
+		return fmt.Errorf("set: %w", ErrUnsupported)
+	}
+
+	if _, err := s.conn.ExecContext(ctx, "SELECT set_config($1, $2, false)", name, value); err != nil {
+		return fmt.Errorf("set %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// MarkDirty は、セッションのコネクションを元に戻せない状態にしたことを記録する。
+//
+// MarkDirty を呼び出したセッションは、Release 時に必ずコネクションを破棄する。
+func (s *Session) MarkDirty(reason string) {
+	s.dirty = append(s.dirty, reason)
+}
+
+// Release は、セッション中に変更したコネクションの状態を元に戻してから、コネクションをプールに戻す。
+//
+// 状態を元に戻せなかった場合はコネクションを破棄し、ErrDirty を返す。
+// 2回目以降の呼び出しは何もしない。
+func (s *Session) Release(ctx context.Context) error {
+	if s.released {
+		return nil
+	}
+	s.released = true
+
+	var (
+		err error
+	)
+	if len(s.dirty) > 0 {
+		err = fmt.Errorf("marked dirty: %s", strings.Join(s.dirty, ", "))
+	} else {
+		err = s.restore(ctx)
+	}
+
+	if err != nil {
+		discard(s.conn)
+		return errors.Join(ErrDirty, err)
+	}
+
+	return s.conn.Close()
+}
+
+func (s *Session) restore(ctx context.Context) error {
+	switch s.dialect {
+	case Postgres:
+		// DISCARD ALL で一時テーブル、セッション変数、準備済みステートメントなどを全て破棄する
+		if _, err := s.conn.ExecContext(ctx, "DISCARD ALL"); err != nil {
+			return fmt.Errorf("discard all: %w", err)
+		}
+
+		return nil
+	default:
+		return s.restoreSQLite(ctx)
+	}
+}
+
+func (s *Session) restoreSQLite(ctx context.Context) error {
+	var (
+		errs []error
+	)
+
+	if err := s.checkAutoCommit(); err != nil {
+		return err
+	}
+
+	for i := len(s.pragmas) - 1; i >= 0; i-- {
+		p := s.pragmas[i]
+		if _, err := s.conn.ExecContext(ctx, fmt.Sprintf("PRAGMA %s=%s", p.name, p.value)); err != nil {
+			errs = append(errs, fmt.Errorf("restore pragma %s: %w", p.name, err))
+		}
+	}
+
+	for i := len(s.tempTables) - 1; i >= 0; i-- {
+		t := s.tempTables[i]
+		if _, err := s.conn.ExecContext(ctx, "DROP TABLE IF EXISTS temp."+quote(t)); err != nil {
+			errs = append(errs, fmt.Errorf("drop temp table %s: %w", t, err))
+		}
+	}
+
+	for i := len(s.attached) - 1; i >= 0; i-- {
+		a := s.attached[i]
+		if _, err := s.conn.ExecContext(ctx, "DETACH DATABASE "+quote(a)); err != nil {
+			errs = append(errs, fmt.Errorf("detach %s: %w", a, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return s.verifySQLite(ctx)
+}
+
+// verifySQLite は、Session を経由せずに作成された一時オブジェクトやアタッチが残っていないことを確認する。
+func (s *Session) verifySQLite(ctx context.Context) error {
+	var (
+		count int
+	)
+	if err := s.conn.QueryRowContext(ctx, "SELECT count(*) FROM pragma_database_list WHERE name NOT IN ('main', 'temp')").Scan(&count); err != nil {
+		return fmt.Errorf("verify database_list: %w", err)
+	}
+	if count > 0 {
+		return fmt.Errorf("%d database(s) still attached", count)
+	}
+
+	if err := s.conn.QueryRowContext(ctx, "SELECT count(*) FROM temp.sqlite_schema").Scan(&count); err != nil {
+		return fmt.Errorf("verify temp schema: %w", err)
+	}
+	if count > 0 {
+		return fmt.Errorf("%d temporary object(s) still exist", count)
+	}
+
+	return nil
+}
+
+// checkAutoCommit は、トランザクションが開いたままになっていないかを確認する。
+//
+// ドライバが AutoCommit() を公開している場合 (mattn/go-sqlite3) のみ確認できる。
+func (s *Session) checkAutoCommit() error {
+	type autoCommitter interface {
+		AutoCommit() bool
+	}
+
+	return s.conn.Raw(func(driverConn any) error {
+		if ac, ok := driverConn.(autoCommitter); ok && !ac.AutoCommit() {
+			return errors.New("transaction still open")
+		}
+
+		return nil
+	})
+}
+
+func (s *Session) hasPragma(name string) bool {
+	for _, p := range s.pragmas {
+		if strings.EqualFold(p.name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// discard は、コネクションをプールに戻さずに破棄する。
+//
+// Raw に渡した関数が driver.ErrBadConn を返すと、database/sql はそのコネクションを閉じる。
+func discard(conn *sql.Conn) {
+	conn.Raw(func(any) error { return driver.ErrBadConn })
+	conn.Close()
+}
+
+func isReservedSchema(name string) bool {
+	return strings.EqualFold(name, "main") || strings.EqualFold(name, "temp")
+}
+
+func quote(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
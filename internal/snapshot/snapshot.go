@@ -0,0 +1,319 @@
+// Package snapshot は、SQLiteデータベース全体を []byte に保存 (serialize) し、
+// メモリ上のデータベースとして復元 (deserialize) する。
+//
+// テストの度に Taskfile の cp -f ../chinook.db . のようにファイルをコピーし直す代わりに、
+// 最初に一度だけスナップショットを取っておき、テスト毎にメモリ上へ復元することで、
+// 元のデータベースを変更せずに、数ミリ秒で初期状態のデータベースを用意できる。
+//
+// database/sql からは直接利用できないため、*sql.Conn.Raw() でドライバのコネクションを取り出して利用する。
+//
+//   - mattn/go-sqlite3  : *sqlite3.SQLiteConn の Serialize(schema) / Deserialize(b, schema)
+//   - modernc.org/sqlite: ドライバのコネクションの Serialize()
+//
+// Serialize が利用できない場合は、バックアップAPI (internal/backup と同じもの) で一時ファイルを経由する。
+//
+// 注意点として、
+//
+//   - WALモードのデータベースをそのまま復元すると開けないため、ヘッダのジャーナルモードを戻してから復元している
+//   - mattn/go-sqlite3 の Deserialize はサイズ変更不可 (SQLITE_DESERIALIZE_RESIZEABLE 無し) で復元するため、
+//     行を追加すると "database or disk is full" になる。そのため、一旦別のコネクションに復元してから
+//     バックアップAPIでコピーしている (メモリ間のコピーなので十分に速い)
+//   - modernc.org/sqlite の Deserialize は、バッファを sqlite3_malloc ではなく TLS のスタック領域に確保したまま
+//     SQLITE_DESERIALIZE_FREEONCLOSE を指定しているため、行を追加したりコネクションを閉じたりするとクラッシュする (v1.46.1 時点)。
+//     そのため、modernc.org/sqlite では一時ファイルからバックアップAPI (NewRestore) で復元している
+//
+// # REFERENCES
+//   - https://www.sqlite.org/c3ref/serialize.html
+//   - https://www.sqlite.org/c3ref/deserialize.html
+//   - https://www.sqlite.org/fileformat.html#the_database_header
+//   - https://pkg.go.dev/database/sql@go1.26.0#Conn.Raw
+package snapshot
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/devlights/try-golang-db/internal/backup"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"modernc.org/sqlite"
+)
+
+// Method は、スナップショットの保存・復元に利用した方法を表す。
+type Method string
+
+const (
+	MethodSerialize Method = "serialize"
+	MethodBackup    Method = "backup API"
+)
+
+var (
+	errUnsupported = errors.New("snapshot: driver does not support serialize")
+)
+
+type (
+	// Snapshot は、データベース全体のイメージ。
+	Snapshot struct {
+		// Data は、データベースファイルと同じ形式のバイト列。
+		Data []byte
+		// Method は、Data の取得に利用した方法。
+		Method Method
+		// Elapsed は、Data の取得に掛かった時間。
+		Elapsed time.Duration
+	}
+
+	// moderncConn は、modernc.org/sqlite のコネクションが持つメソッド
+	moderncConn interface {
+		Serialize() ([]byte, error)
+		NewRestore(srcUri string) (*sqlite.Backup, error)
+	}
+)
+
+// Take は、db のメインデータベースのスナップショットを取得する。
+func Take(ctx context.Context, db *sql.DB) (*Snapshot, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("db.Conn: %w", err)
+	}
+	defer conn.Close()
+
+	var (
+		start = time.Now()
+		s     = &Snapshot{Method: MethodSerialize}
+	)
+
+	err = conn.Raw(func(driverConn any) error {
+		var (
+			data []byte
+			err  error
+		)
+		switch c := driverConn.(type) {
+		case *sqlite3.SQLiteConn:
+			data, err = c.Serialize("main")
+		case moderncConn:
+			data, err = c.Serialize()
+		default:
+			return errUnsupported
+		}
+		if err != nil {
+			// ビルドタグ等で serialize が無効になっている場合はフォールバックする
+			return errors.Join(errUnsupported, err)
+		}
+
+		s.Data = data
+
+		return nil
+	})
+	if errors.Is(err, errUnsupported) {
+		s.Method = MethodBackup
+		s.Data, err = takeBackup(ctx, db)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.Data) == 0 {
+		return nil, errors.New("snapshot: empty database")
+	}
+	normalize(s.Data)
+	s.Elapsed = time.Since(start)
+
+	return s, nil
+}
+
+// takeBackup は、バックアップAPIで一時ファイルにコピーしてから読み込む。
+func takeBackup(ctx context.Context, db *sql.DB) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "snapshot-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		path = filepath.Join(dir, "snapshot.db")
+	)
+	if _, err = backup.Backup(ctx, db, path, backup.Options{}); err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(path)
+}
+
+// normalize は、WALモードのデータベースヘッダをロールバックジャーナルモードに戻す。
+//
+// ヘッダの18, 19バイト目はファイル形式の書き込み・読み込みバージョンで、WALモードでは 2 になっている。
+// メモリ上のデータベースは WAL を扱えないため、2 のままだと復元後に開けない。
+func normalize(data []byte) {
+	if len(data) < 20 {
+		return
+	}
+
+	if data[18] == 2 {
+		data[18] = 1
+	}
+	if data[19] == 2 {
+		data[19] = 1
+	}
+}
+
+// Restore は、conn のメインデータベースをスナップショットの内容で置き換える。
+//
+// conn は :memory: で開いたコネクションであることを想定している。
+// ファイルのデータベースに対して実行した場合、以降そのコネクションはメモリ上のデータベースを参照するようになる
+// (ファイルは変更されない)。
+func (s *Snapshot) Restore(ctx context.Context, conn *sql.Conn) (Method, error) {
+	var (
+		method Method
+	)
+	err := conn.Raw(func(driverConn any) error {
+		var err error
+		method, err = s.restore(ctx, driverConn)
+		return err
+	})
+
+	return method, err
+}
+
+func (s *Snapshot) restore(ctx context.Context, driverConn any) (Method, error) {
+	var (
+		err error
+	)
+	switch c := driverConn.(type) {
+	case *sqlite3.SQLiteConn:
+		if err = restoreMattn(c, s.Data); err == nil {
+			return MethodSerialize, nil
+		}
+	case moderncConn:
+		// Deserialize は利用しない (パッケージのコメントを参照)
+	default:
+		return "", fmt.Errorf("snapshot: unsupported connection type %T", driverConn)
+	}
+
+	if err = s.restoreBackup(ctx, driverConn); err != nil {
+		return "", err
+	}
+
+	return MethodBackup, nil
+}
+
+// restoreMattn は、別のコネクションに Deserialize してから、バックアップAPIで dst にコピーする。
+//
+// mattn/go-sqlite3 の Deserialize で復元したデータベースはサイズを変更できないため、直接 dst には復元しない。
+func restoreMattn(dst *sqlite3.SQLiteConn, data []byte) error {
+	dc, err := (&sqlite3.SQLiteDriver{}).Open(":memory:")
+	if err != nil {
+		return err
+	}
+	defer dc.Close()
+
+	var (
+		scratch = dc.(*sqlite3.SQLiteConn)
+	)
+	if err = scratch.Deserialize(data, "main"); err != nil {
+		return err
+	}
+
+	return copyMattn(dst, scratch)
+}
+
+func copyMattn(dst, src *sqlite3.SQLiteConn) error {
+	bk, err := dst.Backup("main", src, "main")
+	if err != nil {
+		return fmt.Errorf("backup init: %w", err)
+	}
+
+	if _, err = bk.Step(-1); err != nil {
+		bk.Finish()
+		return fmt.Errorf("backup step: %w", err)
+	}
+
+	return bk.Finish()
+}
+
+// restoreBackup は、一時ファイルに書き出してから、バックアップAPIで driverConn に復元する。
+func (s *Snapshot) restoreBackup(ctx context.Context, driverConn any) error {
+	dir, err := os.MkdirTemp("", "snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		path = filepath.Join(dir, "snapshot.db")
+	)
+	if err = os.WriteFile(path, s.Data, 0o600); err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	switch c := driverConn.(type) {
+	case *sqlite3.SQLiteConn:
+		sc, err := (&sqlite3.SQLiteDriver{}).Open(path)
+		if err != nil {
+			return err
+		}
+		defer sc.Close()
+
+		return copyMattn(c, sc.(*sqlite3.SQLiteConn))
+	case moderncConn:
+		bk, err := c.NewRestore(path)
+		if err != nil {
+			return fmt.Errorf("restore init: %w", err)
+		}
+
+		if _, err = bk.Step(-1); err != nil {
+			bk.Finish()
+			return fmt.Errorf("restore step: %w", err)
+		}
+
+		// Finish はコピー元のコネクションもクローズする
+		return bk.Finish()
+	}
+
+	return fmt.Errorf("snapshot: unsupported connection type %T", driverConn)
+}
+
+// Open は、スナップショットを復元したメモリ上のデータベースを開く。
+//
+// drv は &sqlite3.SQLiteDriver{} または sql.Open("sqlite", "") の Driver() など。
+// コネクションを開く度にスナップショットから復元するため、同じ内容を参照し続けられるようにコネクションは1本に制限している。
+// テスト毎に Open し、終わったら Close すれば、テスト間で変更が残ることはない。
+func (s *Snapshot) Open(drv driver.Driver) *sql.DB {
+	db := sql.OpenDB(&connector{drv: drv, s: s})
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	return db
+}
+
+type connector struct {
+	drv driver.Driver
+	s   *Snapshot
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.drv.Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = c.s.restore(ctx, conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("snapshot: restore: %w", err)
+	}
+
+	return conn, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.drv
+}
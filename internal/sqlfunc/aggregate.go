@@ -0,0 +1,183 @@
+package sqlfunc
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"modernc.org/sqlite"
+)
+
+var (
+	// ErrNoInverse は、Inverter を実装していない集約関数がスライディングウィンドウで使われた場合のエラー。
+	ErrNoInverse = errors.New("sqlfunc: aggregate does not support window inverse")
+)
+
+type (
+	// AggregateState は、集約関数の1グループ (またはウィンドウ) 分の状態。
+	//
+	// 集約関数の評価の開始時に Aggregate.New で生成され、行毎に Step が呼ばれる。
+	// Value は全行を処理した後に結果を取得するために呼ばれるが、
+	// ウィンドウ関数として使われた場合は行の途中でも何度も呼ばれるため、状態を変更してはならない。
+	AggregateState interface {
+		Step(args []driver.Value) error
+		Value() (driver.Value, error)
+	}
+
+	// Inverter は、ウィンドウ関数として使われた際に、ウィンドウから外れた行を取り除く。
+	//
+	// 引数には、取り除く行に対して Step に渡したものと同じ値が渡される。
+	// 実装していない場合、フレームの開始位置が移動するウィンドウ (ROWS BETWEEN 2 PRECEDING AND CURRENT ROW 等) では
+	// ErrNoInverse となる。
+	//
+	// ウィンドウ関数として使えるのは modernc.org/sqlite のみ (mattn/go-sqlite3 については registerMattnAggregates を参照)。
+	Inverter interface {
+		Inverse(args []driver.Value) error
+	}
+
+	// Aggregate は、集約関数の定義。
+	Aggregate struct {
+		// Name は、SQLから呼び出す際の関数名。
+		Name string
+		// NArgs は、引数の数。負の値の場合は可変長引数となる。
+		NArgs int
+		// Deterministic は、同じ引数に対して常に同じ結果を返すかどうか。
+		Deterministic bool
+		// New は、グループ毎の状態を生成する。
+		New func() AggregateState
+	}
+)
+
+// AddAggregate は、集約関数を追加する。スカラー関数と同じ名前空間のため、同じ名前の関数があるとエラーとなる。
+func (r *Registry) AddAggregate(a Aggregate) error {
+	if !nameRe.MatchString(a.Name) {
+		return fmt.Errorf("sqlfunc: invalid function name %q", a.Name)
+	}
+	if a.New == nil {
+		return fmt.Errorf("sqlfunc: %s: New is nil", a.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.hasName(a.Name) {
+		return fmt.Errorf("sqlfunc: %s: already added", a.Name)
+	}
+
+	r.aggregates = append(r.aggregates, a)
+
+	return nil
+}
+
+// Aggregates は、追加されている集約関数の一覧を返す。
+func (r *Registry) Aggregates() []Aggregate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Aggregate(nil), r.aggregates...)
+}
+
+// registerMattnAggregates は、集約関数を mattn/go-sqlite3 のコネクションに登録する。
+//
+// mattn/go-sqlite3 の RegisterAggregator は、Step() と Done() を持つ型を返すコンストラクタを要求する。
+// 引数の数は Step() のシグネチャから決まるため、可変長の Step(...any) を持つ型を用意し、引数の数は Step 内で検査する。
+//
+// なお mattn/go-sqlite3 は sqlite3_create_window_function に対応していないため、
+// OVER 句で使用すると "may not be used as a window function" エラーとなる。
+func registerMattnAggregates(conn *sqlite3.SQLiteConn, aggs []Aggregate) error {
+	for _, a := range aggs {
+		ctor := func() *mattnAggregate {
+			return &mattnAggregate{def: a, state: a.New()}
+		}
+
+		if err := conn.RegisterAggregator(a.Name, ctor, a.Deterministic); err != nil {
+			return fmt.Errorf("sqlfunc: register %s (mattn): %w", a.Name, err)
+		}
+	}
+
+	return nil
+}
+
+type (
+	mattnAggregate struct {
+		def   Aggregate
+		state AggregateState
+	}
+)
+
+func (m *mattnAggregate) Step(args ...any) error {
+	if m.def.NArgs >= 0 && len(args) != m.def.NArgs {
+		return fmt.Errorf("%s: wrong number of arguments: got %d, want %d", m.def.Name, len(args), m.def.NArgs)
+	}
+
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		values[i] = fromMattn(a)
+	}
+
+	if err := m.state.Step(values); err != nil {
+		return fmt.Errorf("%s: %w", m.def.Name, err)
+	}
+
+	return nil
+}
+
+func (m *mattnAggregate) Done() (any, error) {
+	return aggregateValue(m.def, m.state)
+}
+
+// registerModerncAggregate は、集約関数を modernc.org/sqlite に登録する。
+//
+// modernc.org/sqlite は集約関数をウィンドウ関数としても登録するため、Inverter を実装していれば
+// フレームが移動する際に WindowInverse 経由で Inverse が呼ばれる。
+func registerModerncAggregate(a Aggregate) error {
+	return sqlite.RegisterFunction(a.Name, &sqlite.FunctionImpl{
+		NArgs:         int32(a.NArgs),
+		Deterministic: a.Deterministic,
+		MakeAggregate: func(sqlite.FunctionContext) (sqlite.AggregateFunction, error) {
+			return &moderncAggregate{def: a, state: a.New()}, nil
+		},
+	})
+}
+
+type (
+	moderncAggregate struct {
+		def   Aggregate
+		state AggregateState
+	}
+)
+
+func (m *moderncAggregate) Step(_ *sqlite.FunctionContext, args []driver.Value) error {
+	if err := m.state.Step(args); err != nil {
+		return fmt.Errorf("%s: %w", m.def.Name, err)
+	}
+
+	return nil
+}
+
+func (m *moderncAggregate) WindowInverse(_ *sqlite.FunctionContext, args []driver.Value) error {
+	inv, ok := m.state.(Inverter)
+	if !ok {
+		return fmt.Errorf("%s: %w", m.def.Name, ErrNoInverse)
+	}
+
+	if err := inv.Inverse(args); err != nil {
+		return fmt.Errorf("%s: %w", m.def.Name, err)
+	}
+
+	return nil
+}
+
+func (m *moderncAggregate) WindowValue(*sqlite.FunctionContext) (driver.Value, error) {
+	return aggregateValue(m.def, m.state)
+}
+
+func (m *moderncAggregate) Final(*sqlite.FunctionContext) {}
+
+// aggregateValue は、集約関数の結果をドライバ間で差が出ない形に揃える。
+func aggregateValue(a Aggregate, s AggregateState) (driver.Value, error) {
+	v, err := s.Value()
+
+	return result(a.Name, v, err)
+}
@@ -0,0 +1,145 @@
+package sqlfunc
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    any
+		wantErr bool
+	}{
+		{"median", `SELECT median(column1) FROM (VALUES (1), (3), (2), (NULL))`, float64(2), false},
+		{"percentile", `SELECT percentile(column1, 25) FROM (VALUES (1), (2), (3), (4), (5))`, float64(2), false},
+		{"percentile interpolated", `SELECT percentile(column1, 50) FROM (VALUES (1), (2), (3), (4))`, 2.5, false},
+		{"empty", `SELECT percentile(column1, 50) FROM (VALUES (NULL))`, nil, false},
+		{"p out of range", `SELECT percentile(column1, 101) FROM (VALUES (1))`, nil, true},
+		{"p changes", `SELECT percentile(column1, column2) FROM (VALUES (1, 10), (2, 20))`, nil, true},
+		// x が NULL の行でも p は検証される
+		{"p changes while x is null", `SELECT percentile(column1, column2) FROM (VALUES (NULL, 10), (NULL, 20), (1, 90))`, nil, true},
+	}
+
+	for _, d := range openBoth(t) {
+		for _, tt := range tests {
+			t.Run(d.name+"/"+tt.name, func(t *testing.T) {
+				var (
+					got any
+				)
+				err := d.db.QueryRow(tt.query).Scan(&got)
+				if tt.wantErr {
+					if err == nil {
+						t.Fatalf("err = nil, want error (got %v)", got)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want {
+					t.Errorf("got %#v, want %#v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestModeAndStringAggDistinct(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  any
+	}{
+		{"mode", `SELECT mode(column1) FROM (VALUES (1), (2), (2), (NULL), (NULL), (NULL), (3))`, int64(2)},
+		// 同数の場合は先に出現した値
+		{"mode tie", `SELECT mode(column1) FROM (VALUES (2), (1), (1), (2), (3))`, int64(2)},
+		{"mode tie reversed", `SELECT mode(column1) FROM (VALUES (1), (2), (2), (1), (3))`, int64(1)},
+		// 1 と '1' は別の値として数える
+		{"mode types", `SELECT mode(column1) FROM (VALUES (1), ('1'), ('1'))`, "1"},
+		{"mode empty", `SELECT mode(column1) FROM (VALUES (NULL))`, nil},
+		{"string_agg_distinct", `SELECT string_agg_distinct(column1, ',') FROM (VALUES ('b'), ('a'), ('b'), (NULL), ('c'), ('a'))`, "b,a,c"},
+		{"string_agg_distinct types", `SELECT string_agg_distinct(column1, '|') FROM (VALUES (1), ('1'), (1))`, "1|1"},
+		{"string_agg_distinct empty", `SELECT string_agg_distinct(column1, ',') FROM (VALUES (NULL))`, nil},
+	}
+
+	for _, d := range openBoth(t) {
+		for _, tt := range tests {
+			t.Run(d.name+"/"+tt.name, func(t *testing.T) {
+				var (
+					got any
+				)
+				if err := d.db.QueryRow(tt.query).Scan(&got); err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want {
+					t.Errorf("got %#v, want %#v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+// TestAggregateWindow は、集約関数をウィンドウ関数として使えること (modernc.org/sqlite)、
+// 使えないこと (mattn/go-sqlite3) を確認する。
+func TestAggregateWindow(t *testing.T) {
+	const (
+		frame = `OVER (ORDER BY column1 ROWS BETWEEN 1 PRECEDING AND CURRENT ROW)`
+	)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []any
+	}{
+		{"median", `SELECT median(column2) ` + frame + ` FROM (VALUES (1, 1), (2, 3), (3, 2), (4, 4))`, []any{float64(1), float64(2), 2.5, float64(3)}},
+		{"percentile", `SELECT percentile(column2, 0) ` + frame + ` FROM (VALUES (1, 1), (2, 3), (3, 2), (4, 4))`, []any{float64(1), float64(1), float64(2), float64(2)}},
+		// フレームから外れた値は数えず、同数の場合はフレーム内で先に出現した値
+		{"mode", `SELECT mode(column2) ` + frame + ` FROM (VALUES (1, 'a'), (2, 'b'), (3, 'b'), (4, 'c'), (5, 'a'))`, []any{"a", "a", "b", "b", "c"}},
+		{"string_agg_distinct", `SELECT string_agg_distinct(column2, ',') ` + frame + ` FROM (VALUES (1, 'a'), (2, 'b'), (3, 'b'), (4, 'c'), (5, 'a'))`, []any{"a", "a,b", "b", "b,c", "c,a"}},
+	}
+
+	for _, d := range openBoth(t) {
+		for _, tt := range tests {
+			t.Run(d.name+"/"+tt.name, func(t *testing.T) {
+				rows, err := d.db.Query(tt.query)
+				if d.name == mattnDriverName {
+					// mattn/go-sqlite3 は xInverse を登録できないため、ウィンドウ関数としては使えない
+					if err == nil {
+						rows.Close()
+						t.Fatal("err = nil, want error")
+					}
+					if !strings.Contains(err.Error(), "may not be used as a window function") {
+						t.Errorf("err = %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rows.Close()
+
+				var (
+					got []any
+				)
+				for rows.Next() {
+					var (
+						v any
+					)
+					if err = rows.Scan(&v); err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, v)
+				}
+				if err = rows.Err(); err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("got %#v, want %#v", got, tt.want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package sqlfunc
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Builtins は、本パッケージで用意している関数の一覧を返す。
+//
+//   - regexp(pattern, text)          : text が正規表現 pattern にマッチすれば 1 (X REGEXP Y 演算子でも利用される)
+//   - levenshtein(a, b)              : a と b の編集距離 (文字単位)
+//   - nfkc(s)                        : s をNFKC正規化した文字列 (全角英数→半角、半角カナ→全角 等)
+//   - json_path_exists(json, path)   : json に path ($.a.b[0] 形式) が存在すれば 1
+//
+// いずれも引数に NULL が含まれる場合は NULL を返す。
+func Builtins() []Func {
+	return []Func{
+		{Name: "regexp", NArgs: 2, Deterministic: true, Impl: regexpFunc},
+		{Name: "levenshtein", NArgs: 2, Deterministic: true, Impl: levenshteinFunc},
+		{Name: "nfkc", NArgs: 1, Deterministic: true, Impl: nfkcFunc},
+		{Name: "json_path_exists", NArgs: 2, Deterministic: true, Impl: jsonPathExistsFunc},
+	}
+}
+
+// text は、SQLiteの値を文字列として取り出す。NULL の場合は ok=false となる。
+func text(v driver.Value) (s string, ok bool) {
+	switch x := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return x, true
+	case []byte:
+		return string(x), true
+	case int64:
+		return strconv.FormatInt(x, 10), true
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64), true
+	default:
+		return fmt.Sprint(x), true
+	}
+}
+
+// texts は、全ての引数を文字列として取り出す。NULL が含まれる場合は ok=false となる。
+func texts(args []driver.Value) (ss []string, ok bool) {
+	ss = make([]string, len(args))
+	for i, a := range args {
+		if ss[i], ok = text(a); !ok {
+			return nil, false
+		}
+	}
+
+	return ss, true
+}
+
+var (
+	// WHERE Name REGEXP ? のように行毎に同じパターンで呼ばれるため、コンパイル結果をキャッシュする
+	regexpCache sync.Map // map[string]*regexp.Regexp
+)
+
+func regexpFunc(args []driver.Value) (driver.Value, error) {
+	ss, ok := texts(args)
+	if !ok {
+		return nil, nil
+	}
+
+	var (
+		pattern, s = ss[0], ss[1]
+		re         *regexp.Regexp
+	)
+	if v, found := regexpCache.Load(pattern); found {
+		re = v.(*regexp.Regexp)
+	} else {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return nil, err
+		}
+		regexpCache.Store(pattern, re)
+	}
+
+	return re.MatchString(s), nil
+}
+
+func levenshteinFunc(args []driver.Value) (driver.Value, error) {
+	ss, ok := texts(args)
+	if !ok {
+		return nil, nil
+	}
+
+	return int64(levenshtein([]rune(ss[0]), []rune(ss[1]))), nil
+}
+
+// levenshtein は、a と b の編集距離を求める。(1行分の作業領域のみを使う動的計画法)
+func levenshtein(a, b []rune) int {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+
+	var (
+		prev = make([]int, len(b)+1)
+		curr = make([]int, len(b)+1)
+	)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := range a {
+		curr[0] = i + 1
+		for j := range b {
+			cost := 1
+			if a[i] == b[j] {
+				cost = 0
+			}
+			curr[j+1] = min(prev[j+1]+1, curr[j]+1, prev[j]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+func nfkcFunc(args []driver.Value) (driver.Value, error) {
+	s, ok := text(args[0])
+	if !ok {
+		return nil, nil
+	}
+
+	return norm.NFKC.String(s), nil
+}
+
+func jsonPathExistsFunc(args []driver.Value) (driver.Value, error) {
+	ss, ok := texts(args)
+	if !ok {
+		return nil, nil
+	}
+
+	var (
+		doc any
+	)
+	if err := json.Unmarshal([]byte(ss[0]), &doc); err != nil {
+		return nil, fmt.Errorf("malformed JSON: %w", err)
+	}
+
+	steps, err := parseJSONPath(ss[1])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, st := range steps {
+		switch v := doc.(type) {
+		case map[string]any:
+			if st.isIndex {
+				return false, nil
+			}
+			if doc, ok = v[st.key]; !ok {
+				return false, nil
+			}
+		case []any:
+			if !st.isIndex || st.index >= len(v) {
+				return false, nil
+			}
+			doc = v[st.index]
+		default:
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+type (
+	pathStep struct {
+		key     string
+		index   int
+		isIndex bool
+	}
+)
+
+// parseJSONPath は、SQLiteのJSON関数と同じ形式のパス ($, .key, ."key", [n]) を分解する。
+func parseJSONPath(path string) ([]pathStep, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("bad JSON path: %q", path)
+	}
+
+	var (
+		steps []pathStep
+		rest  = path[1:]
+	)
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if strings.HasPrefix(rest, `"`) {
+				end := strings.IndexByte(rest[1:], '"')
+				if end < 0 {
+					return nil, fmt.Errorf("bad JSON path: %q", path)
+				}
+				steps = append(steps, pathStep{key: rest[1 : end+1]})
+				rest = rest[end+2:]
+				continue
+			}
+
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("bad JSON path: %q", path)
+			}
+			steps = append(steps, pathStep{key: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("bad JSON path: %q", path)
+			}
+			n, err := strconv.Atoi(rest[1:end])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("bad JSON path: %q", path)
+			}
+			steps = append(steps, pathStep{index: n, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("bad JSON path: %q", path)
+		}
+	}
+
+	return steps, nil
+}
@@ -0,0 +1,229 @@
+package sqlfunc
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// BuiltinAggregates は、本パッケージで用意している集約関数の一覧を返す。
+//
+//   - median(x)                   : x の中央値
+//   - percentile(x, p)            : x の p パーセンタイル (p は 0〜100、線形補間)
+//   - string_agg_distinct(x, sep) : x の重複を除いて sep で連結した文字列 (出現順)
+//   - mode(x)                     : x の最頻値 (同数の場合は先に出現した値)
+//
+// いずれも NULL は無視し、対象の行が無い場合は NULL を返す。
+// また、いずれも Inverter を実装しているため、ウィンドウ関数としても利用できる (modernc.org/sqlite のみ)。
+func BuiltinAggregates() []Aggregate {
+	return []Aggregate{
+		{Name: "median", NArgs: 1, Deterministic: true, New: func() AggregateState { return &percentileState{p: 50, fixed: true} }},
+		{Name: "percentile", NArgs: 2, Deterministic: true, New: func() AggregateState { return &percentileState{} }},
+		{Name: "string_agg_distinct", NArgs: 2, Deterministic: true, New: func() AggregateState { return &stringAggDistinctState{} }},
+		{Name: "mode", NArgs: 1, Deterministic: true, New: func() AggregateState { return &modeState{} }},
+	}
+}
+
+// number は、SQLiteの値を数値として取り出す。NULL の場合は ok=false となる。
+func number(v driver.Value) (f float64, ok bool, err error) {
+	switch x := v.(type) {
+	case nil:
+		return 0, false, nil
+	case int64:
+		return float64(x), true, nil
+	case float64:
+		return x, true, nil
+	case bool:
+		if x {
+			return 1, true, nil
+		}
+		return 0, true, nil
+	default:
+		s, _ := text(x)
+		if f, err = strconv.ParseFloat(strings.TrimSpace(s), 64); err != nil {
+			return 0, false, fmt.Errorf("not a number: %q", s)
+		}
+		return f, true, nil
+	}
+}
+
+// percentileState は、median と percentile の状態。
+//
+// ウィンドウから値を取り除けるよう、値は全て保持しておき Value の度にソートする。
+type percentileState struct {
+	values []float64
+	p      float64
+	fixed  bool
+	// pSet は、最初の行で p を記録したかどうか。x が NULL の行でも p は記録・検証する
+	pSet bool
+}
+
+func (s *percentileState) Step(args []driver.Value) error {
+	if !s.fixed {
+		p, ok, err := number(args[1])
+		switch {
+		case err != nil:
+			return err
+		case !ok || p < 0 || p > 100:
+			return errors.New("percentile must be between 0 and 100")
+		case s.pSet && p != s.p:
+			return errors.New("percentile must be the same for all rows")
+		}
+		s.p, s.pSet = p, true
+	}
+
+	v, ok, err := number(args[0])
+	if err != nil || !ok {
+		return err
+	}
+
+	s.values = append(s.values, v)
+
+	return nil
+}
+
+func (s *percentileState) Inverse(args []driver.Value) error {
+	v, ok, err := number(args[0])
+	if err != nil || !ok {
+		return err
+	}
+
+	if i := slices.Index(s.values, v); i >= 0 {
+		s.values = slices.Delete(s.values, i, i+1)
+	}
+
+	return nil
+}
+
+func (s *percentileState) Value() (driver.Value, error) {
+	if len(s.values) == 0 {
+		return nil, nil
+	}
+
+	var (
+		sorted = slices.Sorted(slices.Values(s.values))
+		pos    = s.p / 100 * float64(len(sorted)-1)
+		lo     = int(math.Floor(pos))
+		hi     = int(math.Ceil(pos))
+	)
+
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo)), nil
+}
+
+// counter は、値毎の出現回数を出現順を保ったまま数える。string_agg_distinct と mode で使う。
+type counter struct {
+	order  []string
+	counts map[string]int
+	values map[string]driver.Value
+}
+
+func (c *counter) add(v driver.Value, delta int) {
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+		c.values = make(map[string]driver.Value)
+	}
+
+	// 1 と '1' を区別するため、型名を含めたものをキーにする
+	var (
+		s, _ = text(v)
+		key  = fmt.Sprintf("%T:%s", v, s)
+	)
+
+	if _, found := c.counts[key]; !found {
+		if delta < 0 {
+			return
+		}
+		c.order = append(c.order, key)
+		c.values[key] = v
+	}
+
+	c.counts[key] += delta
+	if c.counts[key] <= 0 {
+		delete(c.counts, key)
+		delete(c.values, key)
+		c.order = slices.DeleteFunc(c.order, func(k string) bool { return k == key })
+	}
+}
+
+type stringAggDistinctState struct {
+	counter
+	sep string
+}
+
+func (s *stringAggDistinctState) Step(args []driver.Value) error {
+	if args[0] == nil {
+		return nil
+	}
+
+	s.sep, _ = text(args[1])
+	s.add(args[0], 1)
+
+	return nil
+}
+
+func (s *stringAggDistinctState) Inverse(args []driver.Value) error {
+	if args[0] == nil {
+		return nil
+	}
+
+	s.add(args[0], -1)
+
+	return nil
+}
+
+func (s *stringAggDistinctState) Value() (driver.Value, error) {
+	if len(s.order) == 0 {
+		return nil, nil
+	}
+
+	var (
+		parts = make([]string, len(s.order))
+	)
+	for i, key := range s.order {
+		parts[i], _ = text(s.values[key])
+	}
+
+	return strings.Join(parts, s.sep), nil
+}
+
+type modeState struct {
+	counter
+}
+
+func (s *modeState) Step(args []driver.Value) error {
+	if args[0] != nil {
+		s.add(args[0], 1)
+	}
+
+	return nil
+}
+
+func (s *modeState) Inverse(args []driver.Value) error {
+	if args[0] != nil {
+		s.add(args[0], -1)
+	}
+
+	return nil
+}
+
+func (s *modeState) Value() (driver.Value, error) {
+	var (
+		best  string
+		count int
+	)
+	for _, key := range s.order {
+		if n := s.counts[key]; n > count {
+			best, count = key, n
+		}
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+
+	return s.values[best], nil
+}
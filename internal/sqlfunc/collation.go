@@ -0,0 +1,81 @@
+package sqlfunc
+
+import (
+	"sync"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/width"
+)
+
+// JapaneseCollations は、日本語の辞書順で比較する照合順序の一覧を返す。
+//
+//   - ja_JP       : 日本語の辞書順 (ひらがな・カタカナ・漢字・英字が混在していても自然な順序になる)
+//   - ja_JP_ci    : ja_JP + 大文字小文字を区別しない ("apple" == "Apple")
+//   - ja_JP_ci_wi : ja_JP_ci + 全角半角を区別しない ("Ａｐｐｌｅ" == "apple", "ｻｸﾗ" == "サクラ")
+//
+// SQLite標準の BINARY はUTF-8のバイト順、NOCASE はASCIIの大文字小文字を無視するだけのため
+// 日本語を含むデータの並び替えには向かない。
+//
+// なお、collate.IgnoreCase は大文字小文字に加えて全角半角やひらがな・カタカナの違いもまとめて無視する (第3レベルを無視する) ため、
+// _ci / _wi では比較前に大文字小文字や全角半角を揃えてから ja_JP で比較している。
+func JapaneseCollations() []Collation {
+	return []Collation{
+		{Name: "ja_JP", Compare: newCollator(language.Japanese, false, false)},
+		{Name: "ja_JP_ci", Compare: newCollator(language.Japanese, true, false)},
+		{Name: "ja_JP_ci_wi", Compare: newCollator(language.Japanese, true, true)},
+	}
+}
+
+type (
+	collator struct {
+		c    *collate.Collator
+		fold cases.Caser
+	}
+)
+
+// newCollator は、tag の照合順序で比較する関数を生成する。
+//
+// collate.Collator と cases.Caser は内部に状態を持っておりゴルーチンセーフではない。
+// SQLiteからは複数のコネクションで並行して呼ばれるため、sync.Pool で使い回す。
+func newCollator(tag language.Tag, ignoreCase, ignoreWidth bool) func(a, b string) int {
+	var (
+		pool = sync.Pool{
+			New: func() any {
+				return &collator{
+					c:    collate.New(tag),
+					fold: cases.Fold(),
+				}
+			},
+		}
+	)
+
+	return func(a, b string) int {
+		c := pool.Get().(*collator)
+		defer pool.Put(c)
+
+		if ignoreWidth {
+			a, b = width.Fold.String(a), width.Fold.String(b)
+		}
+		if ignoreCase {
+			a, b = c.fold.String(a), c.fold.String(b)
+		}
+
+		if r := c.c.CompareString(a, b); r != 0 {
+			return r
+		}
+
+		// 照合順序上は等しくても異なる文字列の場合は、バイト順で順序を確定させる。
+		// (ORDER BY の結果を実行毎・ドライバ毎に安定させるため)
+		// _ci / _wi の場合も、揃えた後の文字列が異なる場合は等しいものとして扱わない
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+
+		return 0
+	}
+}
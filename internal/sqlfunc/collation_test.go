@@ -0,0 +1,112 @@
+package sqlfunc
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestJapaneseCollationOrder(t *testing.T) {
+	var (
+		names = []string{"タコ", "さくら", "Zebra", "かき", "アイス", "apple", "ｻｸﾗ", "東京", "Apple", "ＡＢＣ"}
+		// かな (ひらがな・カタカナ・半角カナ) は五十音順に混ざって並ぶ
+		kana    = []string{"アイス", "かき", "さくら", "ｻｸﾗ", "タコ"}
+		results = make(map[string][]string)
+	)
+
+	for _, d := range openBoth(t) {
+		t.Run(d.name, func(t *testing.T) {
+			var (
+				db = d.db
+			)
+			if _, err := db.Exec(`CREATE TABLE names (Name TEXT)`); err != nil {
+				t.Fatal(err)
+			}
+			for _, n := range names {
+				if _, err := db.Exec(`INSERT INTO names VALUES (?)`, n); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, coll := range []string{"BINARY", "ja_JP", "ja_JP_ci", "ja_JP_ci_wi"} {
+				rows, err := db.Query(`SELECT Name FROM names ORDER BY Name COLLATE ` + coll)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var (
+					got []string
+				)
+				for rows.Next() {
+					var n string
+					if err = rows.Scan(&n); err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, n)
+				}
+				rows.Close()
+
+				results[d.name+"/"+coll] = got
+
+				var (
+					sorted = slices.DeleteFunc(slices.Clone(got), func(s string) bool { return !slices.Contains(kana, s) })
+				)
+				switch coll {
+				case "BINARY":
+					// UTF-8のバイト順では、ひらがなが全てカタカナより前になり五十音順にならない
+					if slices.Equal(sorted, kana) {
+						t.Errorf("%s: kana in gojuon order %v", coll, sorted)
+					}
+				default:
+					if !slices.Equal(sorted, kana) {
+						t.Errorf("%s: kana order = %v, want %v", coll, sorted, kana)
+					}
+				}
+			}
+		})
+	}
+
+	// 両方のドライバで同じ順序になること
+	for _, coll := range []string{"ja_JP", "ja_JP_ci", "ja_JP_ci_wi"} {
+		var (
+			a = results[mattnDriverName+"/"+coll]
+			b = results["sqlite/"+coll]
+		)
+		if !slices.Equal(a, b) {
+			t.Errorf("%s: mattn=%v modernc=%v", coll, a, b)
+		}
+	}
+}
+
+func TestJapaneseCollationEquality(t *testing.T) {
+	tests := []struct {
+		a, b string
+		coll string
+		want bool
+	}{
+		{"apple", "Apple", "ja_JP", false},
+		{"apple", "Apple", "ja_JP_ci", true},
+		{"Ａｐｐｌｅ", "apple", "ja_JP_ci", false},
+		{"Ａｐｐｌｅ", "apple", "ja_JP_ci_wi", true},
+		{"ｻｸﾗ", "サクラ", "ja_JP_ci", false},
+		{"ｻｸﾗ", "サクラ", "ja_JP_ci_wi", true},
+		// ひらがなとカタカナは、どの照合順序でも区別する
+		{"さくら", "サクラ", "ja_JP_ci_wi", false},
+		{"東京", "東京", "ja_JP", true},
+	}
+
+	for _, d := range openBoth(t) {
+		for _, tt := range tests {
+			t.Run(d.name+"/"+tt.coll+"/"+tt.a, func(t *testing.T) {
+				var (
+					got bool
+				)
+				if err := d.db.QueryRow(`SELECT ? = ? COLLATE `+tt.coll, tt.a, tt.b).Scan(&got); err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want {
+					t.Errorf("%q = %q COLLATE %s: got %v, want %v", tt.a, tt.b, tt.coll, got, tt.want)
+				}
+			})
+		}
+	}
+}
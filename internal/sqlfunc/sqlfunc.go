@@ -0,0 +1,365 @@
+// Package sqlfunc は、Goで実装したSQL関数と照合順序を mattn/go-sqlite3 と modernc.org/sqlite の両方に登録する。
+//
+// SQLiteには、アプリケーション側で実装した関数をSQLから呼び出せるようにする仕組みがあるが
+// 登録方法はドライバ毎に異なる。
+//
+//   - mattn/go-sqlite3  : コネクション毎に *sqlite3.SQLiteConn.RegisterFunc() (14.ConnHook_mattn の ConnectHook 内で呼ぶ)
+//   - modernc.org/sqlite: プロセスで一度だけ sqlite.RegisterFunction() (以降に開いた全コネクションで有効)
+//
+// また、引数の渡され方も異なる (mattn は型付きの関数をリフレクションで呼び出し、modernc は []driver.Value を渡す)。
+// 本パッケージでは関数を []driver.Value を受け取る形で一度だけ定義し、両方のドライバに同じものを登録する。
+//
+// # REFERENCES
+//   - https://www.sqlite.org/appfunc.html
+//   - https://www.sqlite.org/deterministic.html
+//   - https://www.sqlite.org/datatype3.html#collation
+//   - https://pkg.go.dev/github.com/mattn/go-sqlite3#SQLiteConn.RegisterFunc
+//   - https://pkg.go.dev/modernc.org/sqlite#RegisterFunction
+package sqlfunc
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sync"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"modernc.org/sqlite"
+)
+
+var (
+	nameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+type (
+	// Func は、スカラー関数の定義。
+	Func struct {
+		// Name は、SQLから呼び出す際の関数名。
+		Name string
+		// NArgs は、引数の数。負の値の場合は可変長引数となる。
+		NArgs int
+		// Deterministic は、同じ引数に対して常に同じ結果を返すかどうか。
+		//
+		// true にするとSQLiteが結果を使い回すなどの最適化を行えるようになり、
+		// インデックス式や CHECK 制約、生成列の中でも利用できるようになる。
+		Deterministic bool
+		// Impl は、関数の実装。
+		//
+		// 引数には SQLite の値が int64, float64, string, []byte, nil (NULL) のいずれかで渡される。
+		// 戻り値も同様に、これらのいずれかか bool を返す。
+		Impl func(args []driver.Value) (driver.Value, error)
+	}
+
+	// Collation は、照合順序 (COLLATE 名) の定義。
+	Collation struct {
+		// Name は、COLLATE 句に指定する名前。
+		Name string
+		// Compare は、a と b を比較し、a < b なら負、a == b なら0、a > b なら正の値を返す。
+		//
+		// 複数のコネクションから並行して呼ばれるため、ゴルーチンセーフである必要がある。
+		Compare func(a, b string) int
+	}
+
+	// Registry は、両方のドライバに登録する関数と照合順序の一覧。
+	Registry struct {
+		mu         sync.Mutex
+		funcs      []Func
+		aggregates []Aggregate
+		collations []Collation
+	}
+)
+
+var (
+	// ErrRegistered は、modernc.org/sqlite に同じ名前の関数・照合順序が別の Registry から登録済みであることを表す。
+	ErrRegistered = errors.New("sqlfunc: already registered with modernc by another Registry")
+)
+
+var (
+	// modernc は登録がプロセスグローバルで、同じ名前で2回登録するとエラーになり、置き換えることもできないため
+	// 登録済みの関数名と照合順序名を、登録した Registry と共に覚えておく
+	moderncRegistered = struct {
+		mu         sync.Mutex
+		names      map[string]*Registry
+		collations map[string]*Registry
+	}{
+		names:      make(map[string]*Registry),
+		collations: make(map[string]*Registry),
+	}
+)
+
+// NewRegistry は、funcs を登録した Registry を生成する。
+func NewRegistry(funcs ...Func) (*Registry, error) {
+	var (
+		r = &Registry{}
+	)
+	for _, f := range funcs {
+		if err := r.Add(f); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Add は、関数を追加する。同じ名前の関数を2回追加するとエラーとなる。
+func (r *Registry) Add(f Func) error {
+	if !nameRe.MatchString(f.Name) {
+		return fmt.Errorf("sqlfunc: invalid function name %q", f.Name)
+	}
+	if f.Impl == nil {
+		return fmt.Errorf("sqlfunc: %s: Impl is nil", f.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.hasName(f.Name) {
+		return fmt.Errorf("sqlfunc: %s: already added", f.Name)
+	}
+
+	r.funcs = append(r.funcs, f)
+
+	return nil
+}
+
+// hasName は、スカラー関数または集約関数に name が追加済みかどうかを返す。r.mu をロックした状態で呼ぶこと。
+func (r *Registry) hasName(name string) bool {
+	for _, f := range r.funcs {
+		if f.Name == name {
+			return true
+		}
+	}
+	for _, a := range r.aggregates {
+		if a.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddCollation は、照合順序を追加する。同じ名前の照合順序を2回追加するとエラーとなる。
+func (r *Registry) AddCollation(c Collation) error {
+	if !nameRe.MatchString(c.Name) {
+		return fmt.Errorf("sqlfunc: invalid collation name %q", c.Name)
+	}
+	if c.Compare == nil {
+		return fmt.Errorf("sqlfunc: %s: Compare is nil", c.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, g := range r.collations {
+		if g.Name == c.Name {
+			return fmt.Errorf("sqlfunc: %s: already added", c.Name)
+		}
+	}
+
+	r.collations = append(r.collations, c)
+
+	return nil
+}
+
+// Funcs は、追加されている関数の一覧を返す。
+func (r *Registry) Funcs() []Func {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Func(nil), r.funcs...)
+}
+
+// Collations は、追加されている照合順序の一覧を返す。
+func (r *Registry) Collations() []Collation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Collation(nil), r.collations...)
+}
+
+// RegisterMattn は、mattn/go-sqlite3 のコネクションに関数・集約関数・照合順序を登録する。
+//
+// sqlite3.SQLiteDriver の ConnectHook から呼び出す。
+func (r *Registry) RegisterMattn(conn *sqlite3.SQLiteConn) error {
+	for _, f := range r.Funcs() {
+		if err := conn.RegisterFunc(f.Name, mattnFunc(f), f.Deterministic); err != nil {
+			return fmt.Errorf("sqlfunc: register %s (mattn): %w", f.Name, err)
+		}
+	}
+
+	if err := registerMattnAggregates(conn, r.Aggregates()); err != nil {
+		return err
+	}
+
+	for _, c := range r.Collations() {
+		if err := conn.RegisterCollation(c.Name, c.Compare); err != nil {
+			return fmt.Errorf("sqlfunc: register collation %s (mattn): %w", c.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// RegisterModernc は、modernc.org/sqlite に関数・集約関数・照合順序を登録する。
+//
+// modernc.org/sqlite への登録はプロセスグローバルで、登録後に開いたコネクションから有効になる。
+// そのため sql.Open() より前に呼び出すこと。
+//
+// 同じ Registry から登録済みの関数はスキップする。別の Registry から同じ名前で登録済みの場合は、
+// 実装が異なっていても modernc 側で置き換えることはできないため ErrRegistered を返す。
+// (関数値は比較できないため、同じ実装かどうかは判定しない。プロセス内で Registry を1つにまとめておくこと)
+func (r *Registry) RegisterModernc() error {
+	moderncRegistered.mu.Lock()
+	defer moderncRegistered.mu.Unlock()
+
+	var (
+		errs []error
+	)
+	for _, f := range r.Funcs() {
+		if owner, ok := moderncRegistered.names[f.Name]; ok {
+			if owner != r {
+				errs = append(errs, fmt.Errorf("%w: %s", ErrRegistered, f.Name))
+			}
+			continue
+		}
+
+		err := sqlite.RegisterFunction(f.Name, &sqlite.FunctionImpl{
+			NArgs:         int32(f.NArgs),
+			Deterministic: f.Deterministic,
+			Scalar: func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return call(f, args)
+			},
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sqlfunc: register %s (modernc): %w", f.Name, err))
+			continue
+		}
+
+		moderncRegistered.names[f.Name] = r
+	}
+
+	for _, a := range r.Aggregates() {
+		if owner, ok := moderncRegistered.names[a.Name]; ok {
+			if owner != r {
+				errs = append(errs, fmt.Errorf("%w: %s", ErrRegistered, a.Name))
+			}
+			continue
+		}
+
+		if err := registerModerncAggregate(a); err != nil {
+			errs = append(errs, fmt.Errorf("sqlfunc: register %s (modernc): %w", a.Name, err))
+			continue
+		}
+
+		moderncRegistered.names[a.Name] = r
+	}
+
+	for _, c := range r.Collations() {
+		if owner, ok := moderncRegistered.collations[c.Name]; ok {
+			if owner != r {
+				errs = append(errs, fmt.Errorf("%w: collation %s", ErrRegistered, c.Name))
+			}
+			continue
+		}
+
+		if err := sqlite.RegisterCollationUtf8(c.Name, c.Compare); err != nil {
+			errs = append(errs, fmt.Errorf("sqlfunc: register collation %s (modernc): %w", c.Name, err))
+			continue
+		}
+
+		moderncRegistered.collations[c.Name] = r
+	}
+
+	return errors.Join(errs...)
+}
+
+// mattnFunc は、f を RegisterFunc に渡せる関数に変換する。
+//
+// RegisterFunc は関数の引数の数をリフレクションで調べて sqlite3_create_function に渡すため
+// NArgs 個の any を受け取る関数 (可変長の場合は ...any) を reflect.MakeFunc で生成する。
+func mattnFunc(f Func) any {
+	var (
+		anyType = reflect.TypeFor[any]()
+		errType = reflect.TypeFor[error]()
+		in      []reflect.Type
+	)
+	if f.NArgs < 0 {
+		in = []reflect.Type{reflect.SliceOf(anyType)}
+	} else {
+		in = make([]reflect.Type, f.NArgs)
+		for i := range in {
+			in[i] = anyType
+		}
+	}
+
+	var (
+		typ = reflect.FuncOf(in, []reflect.Type{anyType, errType}, f.NArgs < 0)
+	)
+
+	return reflect.MakeFunc(typ, func(rargs []reflect.Value) []reflect.Value {
+		var (
+			args []driver.Value
+		)
+		if f.NArgs < 0 {
+			variadic := rargs[0]
+			for i := range variadic.Len() {
+				args = append(args, fromMattn(variadic.Index(i).Interface()))
+			}
+		} else {
+			for _, a := range rargs {
+				args = append(args, fromMattn(a.Interface()))
+			}
+		}
+
+		var (
+			v, err = call(f, args)
+			rv     = reflect.New(anyType).Elem()
+			re     = reflect.New(errType).Elem()
+		)
+		if v != nil {
+			rv.Set(reflect.ValueOf(v))
+		}
+		if err != nil {
+			re.Set(reflect.ValueOf(err))
+		}
+
+		return []reflect.Value{rv, re}
+	}).Interface()
+}
+
+// fromMattn は、mattn/go-sqlite3 から渡された引数を modernc.org/sqlite と同じ形に揃える。
+//
+// mattn/go-sqlite3 は any の引数に NULL を nil の []byte として渡すため、nil に変換する。
+func fromMattn(v any) driver.Value {
+	if b, ok := v.([]byte); ok && b == nil {
+		return nil
+	}
+
+	return v
+}
+
+// call は、f を呼び出し、戻り値をドライバ間で差が出ない形に揃える。
+func call(f Func, args []driver.Value) (driver.Value, error) {
+	v, err := f.Impl(args)
+
+	return result(f.Name, v, err)
+}
+
+// result は、関数の戻り値をドライバ間で差が出ない形に揃える。
+func result(name string, v driver.Value, err error) (driver.Value, error) {
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	if b, ok := v.(bool); ok {
+		if b {
+			return int64(1), nil
+		}
+
+		return int64(0), nil
+	}
+
+	return v, nil
+}
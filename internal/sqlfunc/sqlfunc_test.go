@@ -0,0 +1,207 @@
+package sqlfunc
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// mattnDriverName は、テスト用に testRegistry を ConnectHook で登録する mattn/go-sqlite3 のドライバ名
+	mattnDriverName = "sqlite3_sqlfunc_test"
+)
+
+var (
+	// testRegistry は、テスト全体で共有する Registry。
+	//
+	// modernc.org/sqlite への登録はプロセスグローバルで、別の Registry から同じ名前で登録すると ErrRegistered となるため、
+	// テスト毎に Registry を作らずにこれを使う。
+	testRegistry     *Registry
+	testRegistryOnce sync.Once
+)
+
+// testDB は、ドライバ名とそのドライバで開いた *sql.DB
+type testDB struct {
+	name string
+	db   *sql.DB
+}
+
+// openBoth は、testRegistry を登録した mattn/go-sqlite3 と modernc.org/sqlite のインメモリDBを開く。
+func openBoth(t *testing.T) []testDB {
+	t.Helper()
+
+	testRegistryOnce.Do(func() {
+		r, err := NewRegistry(Builtins()...)
+		if err != nil {
+			panic(err)
+		}
+		// 非決定的な関数 (式インデックスに使えないことの確認用)
+		err = r.Add(Func{Name: "test_random", NArgs: 0, Impl: func([]driver.Value) (driver.Value, error) {
+			return rand.Int64(), nil
+		}})
+		if err != nil {
+			panic(err)
+		}
+		for _, a := range BuiltinAggregates() {
+			if err = r.AddAggregate(a); err != nil {
+				panic(err)
+			}
+		}
+		for _, c := range JapaneseCollations() {
+			if err = r.AddCollation(c); err != nil {
+				panic(err)
+			}
+		}
+		if err = r.RegisterModernc(); err != nil {
+			panic(err)
+		}
+
+		sql.Register(mattnDriverName, &sqlite3.SQLiteDriver{ConnectHook: r.RegisterMattn})
+		testRegistry = r
+	})
+
+	var (
+		dbs []testDB
+	)
+	for _, name := range []string{mattnDriverName, "sqlite"} {
+		db, err := sql.Open(name, ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// :memory: はコネクション毎に別のDBになるため、1つに固定する
+		db.SetMaxOpenConns(1)
+		t.Cleanup(func() { db.Close() })
+
+		dbs = append(dbs, testDB{name, db})
+	}
+
+	return dbs
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  any
+	}{
+		{"regexp match", `SELECT 'AC/DC' REGEXP '^A[A-Z]/'`, int64(1)},
+		{"regexp no match", `SELECT regexp('^b', 'abc')`, int64(0)},
+		{"regexp null", `SELECT regexp('^a', NULL)`, nil},
+		{"levenshtein", `SELECT levenshtein('kitten', 'sitting')`, int64(3)},
+		{"levenshtein multibyte", `SELECT levenshtein('さくら', 'さくらんぼ')`, int64(2)},
+		{"levenshtein same", `SELECT levenshtein('', '')`, int64(0)},
+		{"nfkc fullwidth", `SELECT nfkc('ＡＣ／ＤＣ')`, "AC/DC"},
+		{"nfkc halfwidth kana", `SELECT nfkc('ｱｸｾﾌﾟﾄ')`, "アクセプト"},
+		{"nfkc null", `SELECT nfkc(NULL)`, nil},
+		{"json_path_exists key", `SELECT json_path_exists('{"a":{"b":[1,2]}}', '$.a.b[1]')`, int64(1)},
+		{"json_path_exists missing", `SELECT json_path_exists('{"a":{"b":[1,2]}}', '$.a.b[2]')`, int64(0)},
+		{"json_path_exists quoted", `SELECT json_path_exists('{"a b":1}', '$."a b"')`, int64(1)},
+	}
+
+	for _, d := range openBoth(t) {
+		for _, tt := range tests {
+			t.Run(d.name+"/"+tt.name, func(t *testing.T) {
+				var (
+					got any
+				)
+				if err := d.db.QueryRow(tt.query).Scan(&got); err != nil {
+					t.Fatal(err)
+				}
+				if b, ok := got.([]byte); ok {
+					got = string(b)
+				}
+				if got != tt.want {
+					t.Errorf("got %#v, want %#v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestBuiltinsError(t *testing.T) {
+	for _, d := range openBoth(t) {
+		t.Run(d.name, func(t *testing.T) {
+			for _, q := range []string{
+				`SELECT regexp('(', 'abc')`,
+				`SELECT json_path_exists('{', '$')`,
+				`SELECT json_path_exists('{}', 'a')`,
+			} {
+				var (
+					got any
+				)
+				if err := d.db.QueryRow(q).Scan(&got); err == nil {
+					t.Errorf("%s: err = nil, want error", q)
+				}
+			}
+		})
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	for _, d := range openBoth(t) {
+		t.Run(d.name, func(t *testing.T) {
+			var (
+				db = d.db
+			)
+			if _, err := db.Exec(`CREATE TABLE artists (Name TEXT)`); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(`INSERT INTO artists VALUES ('AC/DC'), ('Accept')`); err != nil {
+				t.Fatal(err)
+			}
+
+			// 決定的関数は式インデックスに使え、検索でも利用される
+			if _, err := db.Exec(`CREATE INDEX idx_nfkc ON artists (nfkc(Name))`); err != nil {
+				t.Fatalf("deterministic function in index: %v", err)
+			}
+
+			var (
+				name string
+			)
+			if err := db.QueryRow(`SELECT Name FROM artists WHERE nfkc(Name) = nfkc('ＡＣ／ＤＣ')`).Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+			if name != "AC/DC" {
+				t.Errorf("got %q, want %q", name, "AC/DC")
+			}
+
+			// 非決定的関数は式インデックスに使えない
+			if _, err := db.Exec(`CREATE INDEX idx_random ON artists (test_random())`); err == nil {
+				t.Error("non-deterministic function in index: err = nil, want error")
+			}
+		})
+	}
+}
+
+func TestRegisterModerncConflict(t *testing.T) {
+	openBoth(t)
+
+	// 同じ Registry からの2回目の登録はスキップされる
+	if err := testRegistry.RegisterModernc(); err != nil {
+		t.Errorf("same registry: %v", err)
+	}
+
+	// 別の Registry から同じ名前で登録するとエラーになる
+	other, err := NewRegistry(Func{Name: "nfkc", NArgs: 1, Impl: func([]driver.Value) (driver.Value, error) {
+		return "stale", nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = other.RegisterModernc(); !errors.Is(err, ErrRegistered) {
+		t.Errorf("other registry: err = %v, want %v", err, ErrRegistered)
+	}
+
+	other = &Registry{}
+	if err = other.AddCollation(Collation{Name: "ja_JP", Compare: func(a, b string) int { return 0 }}); err != nil {
+		t.Fatal(err)
+	}
+	if err = other.RegisterModernc(); !errors.Is(err, ErrRegistered) {
+		t.Errorf("other registry (collation): err = %v, want %v", err, ErrRegistered)
+	}
+}
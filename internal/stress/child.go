@@ -0,0 +1,134 @@
+package stress
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+const (
+	// childEnv は、子プロセスに測定内容を渡す環境変数。
+	childEnv = "STRESS_CHILD"
+)
+
+type (
+	// childRequest は、親プロセスから子プロセスに渡す内容。
+	childRequest struct {
+		Path   string
+		Config Config
+	}
+
+	// childResponse は、子プロセスが標準出力に書き込む結果。
+	childResponse struct {
+		Reads  tally
+		Writes tally
+		Err    string
+	}
+
+	child struct {
+		cmd    *exec.Cmd
+		stdout bytes.Buffer
+		stderr bytes.Buffer
+	}
+)
+
+// IsChild は、このプロセスが Run から起動された子プロセスかどうかを返す。
+func IsChild() bool {
+	return os.Getenv(childEnv) != ""
+}
+
+// ChildMain は、子プロセスとして負荷をかけ、結果を標準出力に書き込む。戻り値は終了コード。
+func ChildMain() int {
+	var (
+		req childRequest
+		res childResponse
+	)
+	if err := json.Unmarshal([]byte(os.Getenv(childEnv)), &req); err != nil {
+		res.Err = err.Error()
+	} else {
+		reads, writes, err := work(context.Background(), req.Path, req.Config)
+		if err != nil {
+			res.Err = err.Error()
+		}
+		res.Reads = merge(reads)
+		res.Writes = merge(writes)
+	}
+
+	if err := json.NewEncoder(os.Stdout).Encode(res); err != nil {
+		return 1
+	}
+
+	return 0
+}
+
+// spawn は、子プロセスを起動する。
+func spawn(ctx context.Context, path string, cfg Config) (*child, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := json.Marshal(childRequest{Path: path, Config: cfg})
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		c = &child{cmd: exec.CommandContext(ctx, exe)}
+	)
+	c.cmd.Env = append(os.Environ(), childEnv+"="+string(req))
+	c.cmd.Stdout = &c.stdout
+	c.cmd.Stderr = &c.stderr
+
+	if err = c.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("stress: start child: %w", err)
+	}
+
+	return c, nil
+}
+
+// wait は、子プロセスの終了を待ち、結果を返す。
+func (c *child) wait() (reads, writes tally, err error) {
+	if err = c.cmd.Wait(); err != nil {
+		return tally{}, tally{}, fmt.Errorf("stress: child %d: %w: %s", c.cmd.Process.Pid, err, bytes.TrimSpace(c.stderr.Bytes()))
+	}
+
+	var (
+		res childResponse
+	)
+	if err = json.Unmarshal(c.stdout.Bytes(), &res); err != nil {
+		// main の先頭で ChildMain を呼んでいない場合など
+		return tally{}, tally{}, fmt.Errorf("stress: child %d: invalid response (is ChildMain called?): %w", c.cmd.Process.Pid, err)
+	}
+	if res.Err != "" {
+		return tally{}, tally{}, fmt.Errorf("stress: child %d: %s", c.cmd.Process.Pid, res.Err)
+	}
+
+	return res.Reads, res.Writes, nil
+}
+
+func (c *child) kill() {
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+}
+
+// merge は、tallies を1つにまとめる (レイテンシは連結する)。
+func merge(tallies []tally) tally {
+	var (
+		m tally
+	)
+	for _, t := range tallies {
+		m.Latencies = append(m.Latencies, t.Latencies...)
+		m.Busy += t.Busy
+		m.Locked += t.Locked
+		m.Other += t.Other
+		if t.LastError != "" {
+			m.LastError = t.LastError
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,394 @@
+// Package stress は、SQLite に読み込み・書き込みの負荷をかけ、ロック競合の発生状況を測定する。
+//
+// 13.ConnHook_modernc, 14.ConnHook_mattn のコメントにある通り、コネクションプールから複数のコネクションで
+// 書き込むと database is locked (SQLITE_BUSY) が発生することがある。
+// 発生するかどうかは、ジャーナルモード・busy_timeout・トランザクションの開始方法 (BEGIN DEFERRED / IMMEDIATE)・
+// プールのコネクション数などの組み合わせで変わる。
+//
+// Run は、Config の設定で Readers 個の読み込みゴルーチンと Writers 個の書き込みゴルーチンを Duration の間動かし、
+// 以下を集計した Result を返す。
+//
+//   - 成功した操作の数とスループット
+//   - 成功した操作のレイテンシのパーセンタイル (busy_timeout で待った時間を含む)
+//   - SQLITE_BUSY, SQLITE_LOCKED, その他のエラーの数
+//
+// 書き込みは、1つのトランザクションの中で SELECT してから INSERT する。
+// BEGIN (DEFERRED) の場合、読み込みから書き込みへの昇格時に他のコネクションが書き込んでいると、
+// busy_timeout で待たずに SQLITE_BUSY になる (デッドロックを避けるため)。
+//
+// # 子プロセス
+//
+// Processes を指定すると、同じ負荷をかける子プロセスを起動する (ファイルロックによるプロセス間の競合を測定するため)。
+// 子プロセスは自分自身の実行ファイル (os.Executable) を環境変数 STRESS_CHILD を付けて起動する。
+// そのため、Processes を利用する場合は main の先頭で以下のようにすること。
+//
+//	if stress.IsChild() {
+//		os.Exit(stress.ChildMain())
+//	}
+//
+// # REFERENCES
+//   - https://www.sqlite.org/lockingv3.html
+//   - https://www.sqlite.org/wal.html#concurrency
+//   - https://www.sqlite.org/rescode.html#busy
+//   - https://www.sqlite.org/lang_transaction.html#deferred_immediate_and_exclusive_transactions
+package stress
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devlights/try-golang-db/internal/dbopen"
+	"github.com/devlights/try-golang-db/internal/pragma"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// groups は、読み込み・書き込みで対象とする grp の種類数。
+	groups = 100
+)
+
+type (
+	// Config は、1つの測定の設定。
+	Config struct {
+		// Name は、結果に表示する名前。
+		Name string
+		// Driver は、利用するドライバ。
+		Driver dbopen.Driver
+		// Profile は、コネクション毎に適用するPRAGMA。nil の場合はドライバの既定値のまま。
+		Profile *pragma.Profile
+		// TxLock は、トランザクションの開始方法 (deferred, immediate, exclusive)。空の場合はドライバの既定値 (deferred)。
+		TxLock string
+		// MaxOpenConns は、*sql.DB の SetMaxOpenConns。0 の場合は無制限。
+		MaxOpenConns int
+		// Readers, Writers は、読み込み・書き込みのゴルーチンの数 (プロセス毎)。
+		Readers int
+		Writers int
+		// Processes は、同じ負荷をかける子プロセスの数。
+		Processes int
+		// Duration は、負荷をかける時間。0 の場合は 1秒。
+		Duration time.Duration
+	}
+
+	// Op は、読み込みまたは書き込みの集計結果。
+	Op struct {
+		// Count は、成功した操作の数。
+		Count int64
+		// Busy, Locked, Other は、失敗した操作の数 (SQLITE_BUSY, SQLITE_LOCKED, その他)。
+		Busy   int64
+		Locked int64
+		Other  int64
+		// PerSec は、1秒あたりの成功した操作の数。
+		PerSec float64
+		// P50, P90, P99, Max は、成功した操作のレイテンシ。
+		P50, P90, P99, Max time.Duration
+		// LastError は、最後に発生したその他のエラー。
+		LastError string
+	}
+
+	// Result は、1つの測定の結果。
+	Result struct {
+		Config Config
+		Read   Op
+		Write  Op
+	}
+
+	// tally は、ワーカー (またはプロセス) 毎の集計途中の値。子プロセスからは JSON で受け取る。
+	tally struct {
+		Latencies []time.Duration
+		Busy      int64
+		Locked    int64
+		Other     int64
+		LastError string
+	}
+)
+
+// Errors は、失敗した操作の数を返す。
+func (o Op) Errors() int64 {
+	return o.Busy + o.Locked + o.Other
+}
+
+// Setup は、path に測定用のテーブルを作成する。既にある場合は何もしない。
+func Setup(ctx context.Context, path string, drv dbopen.Driver) error {
+	db, err := dbopen.OpenSQLite(path, dbopen.Options{Driver: drv})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	const (
+		ddl = `
+			CREATE TABLE IF NOT EXISTS stress (
+				id  INTEGER PRIMARY KEY,
+				grp INTEGER NOT NULL,
+				pad BLOB
+			);
+			CREATE INDEX IF NOT EXISTS stress_grp ON stress (grp);`
+		seed = `
+			INSERT INTO stress (grp, pad)
+			SELECT value % ?, randomblob(100)
+			FROM (WITH RECURSIVE r(value) AS (SELECT 1 UNION ALL SELECT value + 1 FROM r WHERE value < 1000) SELECT value FROM r)
+			WHERE NOT EXISTS (SELECT 1 FROM stress)`
+	)
+	if _, err = db.ExecContext(ctx, ddl); err != nil {
+		return fmt.Errorf("stress: setup: %w", err)
+	}
+	if _, err = db.ExecContext(ctx, seed, groups); err != nil {
+		return fmt.Errorf("stress: setup: %w", err)
+	}
+
+	return nil
+}
+
+// Run は、path のデータベースに cfg の設定で負荷をかけ、結果を返す。事前に Setup しておくこと。
+func Run(ctx context.Context, path string, cfg Config) (Result, error) {
+	if cfg.Duration <= 0 {
+		cfg.Duration = time.Second
+	}
+
+	var (
+		children []*child
+		err      error
+	)
+	for range cfg.Processes {
+		c, err := spawn(ctx, path, cfg)
+		if err != nil {
+			for _, c := range children {
+				c.kill()
+			}
+			return Result{}, err
+		}
+		children = append(children, c)
+	}
+
+	var (
+		reads, writes []tally
+	)
+	reads, writes, err = work(ctx, path, cfg)
+	if err != nil {
+		for _, c := range children {
+			c.kill()
+		}
+		return Result{}, err
+	}
+
+	var (
+		errs []error
+	)
+	for _, c := range children {
+		r, w, err := c.wait()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		reads = append(reads, r)
+		writes = append(writes, w)
+	}
+	if err = errors.Join(errs...); err != nil {
+		return Result{}, err
+	}
+
+	// 子プロセスは起動に時間が掛かるが、負荷をかける時間は同じ Duration のため、スループットは Duration で求める
+	return Result{
+		Config: cfg,
+		Read:   summarize(reads, cfg.Duration),
+		Write:  summarize(writes, cfg.Duration),
+	}, nil
+}
+
+// work は、このプロセスで Readers, Writers 個のゴルーチンを Duration の間動かす。
+func work(ctx context.Context, path string, cfg Config) (reads, writes []tally, err error) {
+	var (
+		dsn = path
+	)
+	if cfg.TxLock != "" {
+		dsn = withParam(dsn, "_txlock", cfg.TxLock)
+	}
+
+	db, err := dbopen.OpenSQLite(dsn, dbopen.Options{Driver: cfg.Driver, Pragmas: cfg.Profile})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	if err = db.PingContext(ctx); err != nil {
+		return nil, nil, fmt.Errorf("stress: %s: %w", cfg.Name, err)
+	}
+
+	var (
+		runCtx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		wg             sync.WaitGroup
+	)
+	defer cancel()
+
+	reads = make([]tally, cfg.Readers)
+	writes = make([]tally, cfg.Writers)
+	for i := range reads {
+		wg.Go(func() { reads[i] = loop(runCtx, db, readOnce) })
+	}
+	for i := range writes {
+		wg.Go(func() { writes[i] = loop(runCtx, db, writeOnce) })
+	}
+	wg.Wait()
+
+	return reads, writes, nil
+}
+
+// loop は、ctx が終了するまで op を繰り返し、レイテンシとエラーを集計する。
+func loop(ctx context.Context, db *sql.DB, op func(context.Context, *sql.DB, int) error) tally {
+	var (
+		t tally
+	)
+	for ctx.Err() == nil {
+		var (
+			start = time.Now()
+			err   = op(ctx, db, rand.IntN(groups))
+		)
+		if err == nil {
+			t.Latencies = append(t.Latencies, time.Since(start))
+			continue
+		}
+		if ctx.Err() != nil {
+			// 測定時間の終了による中断は数えない
+			break
+		}
+
+		switch Classify(err) {
+		case Busy:
+			t.Busy++
+		case Locked:
+			t.Locked++
+		default:
+			t.Other++
+			t.LastError = err.Error()
+		}
+	}
+
+	return t
+}
+
+func readOnce(ctx context.Context, db *sql.DB, grp int) error {
+	var (
+		count, size int64
+	)
+
+	return db.QueryRowContext(ctx, "SELECT count(*), coalesce(sum(length(pad)), 0) FROM stress WHERE grp = ?", grp).
+		Scan(&count, &size)
+}
+
+// writeOnce は、同じトランザクションの中で読み込んでから書き込む。
+func writeOnce(ctx context.Context, db *sql.DB, grp int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		count int64
+	)
+	if err = tx.QueryRowContext(ctx, "SELECT count(*) FROM stress WHERE grp = ?", grp).Scan(&count); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "INSERT INTO stress (grp, pad) VALUES (?, randomblob(100))", grp); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// summarize は、tallies をまとめてパーセンタイルなどを求める。
+func summarize(tallies []tally, elapsed time.Duration) Op {
+	var (
+		t = merge(tallies)
+		o = Op{Busy: t.Busy, Locked: t.Locked, Other: t.Other, LastError: t.LastError}
+	)
+
+	o.Count = int64(len(t.Latencies))
+	o.PerSec = float64(o.Count) / elapsed.Seconds()
+	if len(t.Latencies) > 0 {
+		slices.Sort(t.Latencies)
+		o.P50 = percentile(t.Latencies, 0.50)
+		o.P90 = percentile(t.Latencies, 0.90)
+		o.P99 = percentile(t.Latencies, 0.99)
+		o.Max = t.Latencies[len(t.Latencies)-1]
+	}
+
+	return o
+}
+
+// percentile は、ソート済みの sorted の p パーセンタイル (nearest-rank) を返す。
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	var (
+		i = int(float64(len(sorted))*p+0.5) - 1
+	)
+
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// Kind は、エラーの種類。
+type Kind int
+
+const (
+	Other Kind = iota
+	// Busy は、SQLITE_BUSY (database is locked)。他のコネクション・プロセスがロックを保持している。
+	Busy
+	// Locked は、SQLITE_LOCKED (database table is locked)。同じコネクション内、または共有キャッシュでの競合。
+	Locked
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Busy:
+		return "BUSY"
+	case Locked:
+		return "LOCKED"
+	}
+
+	return "OTHER"
+}
+
+// Classify は、err が SQLITE_BUSY, SQLITE_LOCKED (拡張エラーコードを含む) のどちらかを返す。
+func Classify(err error) Kind {
+	var (
+		mattnErr sqlite3.Error
+		coder    interface{ Code() int } // modernc.org/sqlite の *sqlite.Error
+		code     int
+	)
+	switch {
+	case errors.As(err, &mattnErr):
+		code = int(mattnErr.Code)
+	case errors.As(err, &coder):
+		code = coder.Code() & 0xff
+	default:
+		return Other
+	}
+
+	switch code {
+	case 5: // SQLITE_BUSY
+		return Busy
+	case 6: // SQLITE_LOCKED
+		return Locked
+	}
+
+	return Other
+}
+
+// withParam は、path にクエリパラメータを付け加える。
+func withParam(path, key, value string) string {
+	var (
+		sep = "?"
+	)
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+
+	return path + sep + key + "=" + value
+}
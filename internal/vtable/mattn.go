@@ -0,0 +1,130 @@
+//go:build sqlite_vtable || vtable
+
+package vtable
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// RegisterMattn は、mattn/go-sqlite3 のコネクションに仮想テーブルのモジュールを登録し、テーブルを作成する。
+//
+// sqlite3.SQLiteDriver の ConnectHook から呼び出す。
+// mattn/go-sqlite3 の仮想テーブル対応はビルドタグ sqlite_vtable (または vtable) を指定した場合のみ有効になる。
+func (r *Registry) RegisterMattn(conn *sqlite3.SQLiteConn) error {
+	for _, t := range r.list() {
+		if err := conn.CreateModule(t.module, &mattnModule{src: t.src}); err != nil {
+			return fmt.Errorf("vtable: register %s (mattn): %w", t.module, err)
+		}
+
+		if _, err := conn.ExecContext(context.Background(), t.createSQL(), nil); err != nil {
+			return fmt.Errorf("vtable: create %s: %w", t.name, err)
+		}
+	}
+
+	return nil
+}
+
+type mattnModule struct {
+	src Source
+}
+
+func (m *mattnModule) Create(c *sqlite3.SQLiteConn, _ []string) (sqlite3.VTab, error) {
+	return m.Connect(c, nil)
+}
+
+func (m *mattnModule) Connect(c *sqlite3.SQLiteConn, _ []string) (sqlite3.VTab, error) {
+	if err := c.DeclareVTab(schema(m.src)); err != nil {
+		return nil, err
+	}
+
+	return &mattnTable{src: m.src}, nil
+}
+
+func (m *mattnModule) DestroyModule() {}
+
+type mattnTable struct {
+	src Source
+}
+
+func (t *mattnTable) BestIndex(csts []sqlite3.InfoConstraint, _ []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	var (
+		cs = make([]constraint, len(csts))
+	)
+	for i, c := range csts {
+		cs[i] = constraint{column: c.Column, eq: c.Op == sqlite3.OpEQ, usable: c.Usable}
+	}
+
+	// mattn/go-sqlite3 は Used の順に Filter の引数を割り当て、omit も自動で設定する
+	p := bestIndex(t.src, cs)
+
+	return &sqlite3.IndexResult{
+		Used:          p.used,
+		IdxStr:        p.idxStr,
+		EstimatedCost: p.cost,
+	}, nil
+}
+
+func (t *mattnTable) Open() (sqlite3.VTabCursor, error) {
+	return &mattnCursor{cursor: cursor{src: t.src}}, nil
+}
+
+func (t *mattnTable) Disconnect() error { return nil }
+
+func (t *mattnTable) Destroy() error { return nil }
+
+type mattnCursor struct {
+	cursor
+}
+
+func (c *mattnCursor) Filter(_ int, idxStr string, vals []any) error {
+	values := make([]driver.Value, len(vals))
+	for i, v := range vals {
+		// NULL は nil の []byte として渡される
+		if b, ok := v.([]byte); ok && b == nil {
+			v = nil
+		}
+		values[i] = v
+	}
+
+	return c.filter(idxStr, values)
+}
+
+func (c *mattnCursor) Next() error {
+	return c.advance()
+}
+
+func (c *mattnCursor) EOF() bool {
+	return c.eof
+}
+
+func (c *mattnCursor) Column(ctx *sqlite3.SQLiteContext, col int) error {
+	switch v := c.column(col).(type) {
+	case nil:
+		ctx.ResultNull()
+	case int64:
+		ctx.ResultInt64(v)
+	case float64:
+		ctx.ResultDouble(v)
+	case string:
+		ctx.ResultText(v)
+	case []byte:
+		ctx.ResultBlob(v)
+	default:
+		return fmt.Errorf("vtable: unsupported value type %T", v)
+	}
+
+	return nil
+}
+
+func (c *mattnCursor) Rowid() (int64, error) {
+	return c.rowid, nil
+}
+
+func (c *mattnCursor) Close() error {
+	c.close()
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !(sqlite_vtable || vtable)
+
+package vtable
+
+import (
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// RegisterMattn は、ビルドタグ sqlite_vtable (または vtable) が指定されていない場合は常に ErrNoVTable を返す。
+func (r *Registry) RegisterMattn(*sqlite3.SQLiteConn) error {
+	return ErrNoVTable
+}
@@ -0,0 +1,200 @@
+package vtable
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+
+	_ "modernc.org/sqlite"
+	"modernc.org/sqlite/vtab"
+)
+
+var (
+	// ErrRegistered は、modernc.org/sqlite に同じ名前のモジュールが別の Registry から登録済みであることを表す。
+	ErrRegistered = errors.New("vtable: module already registered with modernc by another Registry")
+)
+
+var (
+	// modernc はモジュールの登録がプロセスグローバルで、同じ名前で2回登録するとエラーになり、置き換えることもできないため
+	// 登録済みのモジュール名を、登録した Registry と共に覚えておく
+	moderncRegistered = struct {
+		mu    sync.Mutex
+		names map[string]*Registry
+	}{
+		names: make(map[string]*Registry),
+	}
+)
+
+// RegisterModernc は、modernc.org/sqlite に仮想テーブルのモジュールを登録する。
+//
+// modernc.org/sqlite へのモジュールの登録はプロセスグローバルで、登録後に開いたコネクションから有効になる。
+// テーブルの作成まで行う場合は OpenModernc を使う。
+//
+// 同じ Registry から登録済みのモジュールはスキップする。別の Registry から同じ名前で登録済みの場合は、
+// そのモジュールは最初の Registry の Source を返し続けるため ErrRegistered を返す。
+func (r *Registry) RegisterModernc() error {
+	moderncRegistered.mu.Lock()
+	defer moderncRegistered.mu.Unlock()
+
+	var (
+		errs []error
+	)
+	for _, t := range r.list() {
+		if owner, ok := moderncRegistered.names[t.module]; ok {
+			if owner != r {
+				errs = append(errs, fmt.Errorf("%w: %s", ErrRegistered, t.module))
+			}
+			continue
+		}
+
+		if err := vtab.RegisterModule(nil, t.module, &moderncModule{src: t.src}); err != nil {
+			errs = append(errs, fmt.Errorf("vtable: register %s (modernc): %w", t.module, err))
+			continue
+		}
+
+		moderncRegistered.names[t.module] = r
+	}
+
+	return errors.Join(errs...)
+}
+
+// OpenModernc は、コネクション毎に仮想テーブルを作成する *sql.DB を開く。
+//
+// modernc.org/sqlite は接続フック (RegisterConnectionHook) の実行後にモジュールを登録するため、
+// 接続フックの中では CREATE VIRTUAL TABLE を実行できない。
+// そのため、コネクションを開いた後にテーブルを作成する driver.Connector を経由して *sql.DB を生成する。
+func (r *Registry) OpenModernc(dsn string) (*sql.DB, error) {
+	if err := r.RegisterModernc(); err != nil {
+		return nil, err
+	}
+
+	// 登録済みの関数や照合順序 (internal/sqlfunc) も有効になるよう、グローバルのドライバを使う
+	db, err := sql.Open("sqlite", "")
+	if err != nil {
+		return nil, err
+	}
+	drv := db.Driver()
+	db.Close()
+
+	return sql.OpenDB(&moderncConnector{drv: drv, dsn: dsn, tables: r.list()}), nil
+}
+
+type moderncConnector struct {
+	drv    driver.Driver
+	dsn    string
+	tables []table
+}
+
+func (c *moderncConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.drv.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close()
+		return nil, errors.New("vtable: connection does not implement driver.ExecerContext")
+	}
+
+	for _, t := range c.tables {
+		if _, err = execer.ExecContext(ctx, t.createSQL(), nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("vtable: create %s: %w", t.name, err)
+		}
+	}
+
+	return conn, nil
+}
+
+func (c *moderncConnector) Driver() driver.Driver {
+	return c.drv
+}
+
+type moderncModule struct {
+	src Source
+}
+
+func (m *moderncModule) Create(ctx vtab.Context, _ []string) (vtab.Table, error) {
+	return m.Connect(ctx, nil)
+}
+
+func (m *moderncModule) Connect(ctx vtab.Context, _ []string) (vtab.Table, error) {
+	if err := ctx.Declare(schema(m.src)); err != nil {
+		return nil, err
+	}
+
+	return &moderncTable{src: m.src}, nil
+}
+
+type moderncTable struct {
+	src Source
+}
+
+func (t *moderncTable) BestIndex(info *vtab.IndexInfo) error {
+	var (
+		cs = make([]constraint, len(info.Constraints))
+	)
+	for i, c := range info.Constraints {
+		cs[i] = constraint{column: c.Column, eq: c.Op == vtab.OpEQ, usable: c.Usable}
+	}
+
+	var (
+		p   = bestIndex(t.src, cs)
+		arg = 0
+	)
+	for i, used := range p.used {
+		if !used {
+			continue
+		}
+
+		info.Constraints[i].ArgIndex = arg
+		info.Constraints[i].Omit = true
+		arg++
+	}
+
+	info.IdxStr = p.idxStr
+	info.EstimatedCost = p.cost
+
+	return nil
+}
+
+func (t *moderncTable) Open() (vtab.Cursor, error) {
+	return &moderncCursor{cursor: cursor{src: t.src}}, nil
+}
+
+func (t *moderncTable) Disconnect() error { return nil }
+
+func (t *moderncTable) Destroy() error { return nil }
+
+type moderncCursor struct {
+	cursor
+}
+
+func (c *moderncCursor) Filter(_ int, idxStr string, vals []vtab.Value) error {
+	return c.filter(idxStr, vals)
+}
+
+func (c *moderncCursor) Next() error {
+	return c.advance()
+}
+
+func (c *moderncCursor) Eof() bool {
+	return c.eof
+}
+
+func (c *moderncCursor) Column(col int) (vtab.Value, error) {
+	return c.column(col), nil
+}
+
+func (c *moderncCursor) Rowid() (int64, error) {
+	return c.rowid, nil
+}
+
+func (c *moderncCursor) Close() error {
+	c.close()
+	return nil
+}
@@ -0,0 +1,366 @@
+package vtable
+
+import (
+	"database/sql/driver"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"reflect"
+	"time"
+)
+
+// Slice は、構造体のスライスを Source にする。
+//
+// 列は構造体のフィールドから決まる (structMapper を参照)。
+func Slice[T any](rows []T) (Source, error) {
+	return Seq(func(yield func(T) bool) {
+		for _, row := range rows {
+			if !yield(row) {
+				return
+			}
+		}
+	})
+}
+
+// Seq は、構造体の iter.Seq を Source にする。
+//
+// seq はテーブルを走査する度に呼ばれるため、何度でも同じ要素を返せる必要がある。
+func Seq[T any](seq iter.Seq[T]) (Source, error) {
+	m, err := newStructMapper(reflect.TypeFor[T]())
+	if err != nil {
+		return nil, err
+	}
+
+	return &seqSource[T]{mapper: m, seq: seq}, nil
+}
+
+// Map は、キーが key 列、値が構造体のマップを Source にする。
+//
+// key 列は先頭の列となり、key 列に対する等価条件はマップの検索で処理する。
+func Map[K comparable, V any](m map[K]V, key string) (Source, error) {
+	sm, err := newStructMapper(reflect.TypeFor[V]())
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		keyType = reflect.TypeFor[K]()
+	)
+	typ, ok := sqlType(keyType)
+	if !ok {
+		return nil, fmt.Errorf("vtable: unsupported key type %v", keyType)
+	}
+
+	return &mapSource[K, V]{
+		mapper:  sm,
+		m:       m,
+		keyCol:  Column{Name: key, Type: typ},
+		keyType: keyType,
+	}, nil
+}
+
+// CSV は、CSVを Source にする。
+//
+// 1行目をヘッダとして列名に使い、列の型は全て TEXT となる。
+// open はテーブルを走査する度に呼ばれ、データは都度ストリームで読み込む (メモリには全件を保持しない)。
+func CSV(open func() (io.ReadCloser, error)) (Source, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	header, err := csv.NewReader(rc).Read()
+	if err != nil {
+		return nil, fmt.Errorf("vtable: read CSV header: %w", err)
+	}
+
+	var (
+		cols = make([]Column, len(header))
+	)
+	for i, h := range header {
+		cols[i] = Column{Name: h, Type: "TEXT"}
+	}
+
+	return &csvSource{open: open, cols: cols}, nil
+}
+
+// CSVFile は、path のCSVファイルを Source にする。
+func CSVFile(path string) (Source, error) {
+	return CSV(func() (io.ReadCloser, error) {
+		return os.Open(path)
+	})
+}
+
+type seqSource[T any] struct {
+	mapper *structMapper
+	seq    iter.Seq[T]
+}
+
+func (s *seqSource[T]) Columns() []Column {
+	return s.mapper.cols
+}
+
+func (s *seqSource[T]) Scan(map[int]driver.Value) iter.Seq2[[]driver.Value, error] {
+	return func(yield func([]driver.Value, error) bool) {
+		for v := range s.seq {
+			if !yield(s.mapper.values(reflect.ValueOf(v)), nil) {
+				return
+			}
+		}
+	}
+}
+
+type mapSource[K comparable, V any] struct {
+	mapper  *structMapper
+	m       map[K]V
+	keyCol  Column
+	keyType reflect.Type
+}
+
+func (s *mapSource[K, V]) Columns() []Column {
+	return append([]Column{s.keyCol}, s.mapper.cols...)
+}
+
+func (s *mapSource[K, V]) Indexed(col int) bool {
+	return col == 0
+}
+
+func (s *mapSource[K, V]) Scan(eq map[int]driver.Value) iter.Seq2[[]driver.Value, error] {
+	return func(yield func([]driver.Value, error) bool) {
+		if want, ok := eq[0]; ok {
+			// キー列の等価条件はマップの検索で処理する
+			// (キーの型に変換できない値の場合は全件を走査し、比較は呼び出し側に任せる)
+			if k, ok := s.key(want); ok {
+				if v, found := s.m[k]; found {
+					yield(s.row(k, v), nil)
+				}
+				return
+			}
+		}
+
+		for k, v := range s.m {
+			if !yield(s.row(k, v), nil) {
+				return
+			}
+		}
+	}
+}
+
+// key は、SQLから渡された値をマップのキーの型に変換する。
+func (s *mapSource[K, V]) key(v driver.Value) (k K, ok bool) {
+	var (
+		rv = reflect.ValueOf(v)
+	)
+	switch s.keyType.Kind() {
+	case reflect.String:
+		if _, isText := v.(string); !isText {
+			return k, false
+		}
+	default:
+		if _, isNum := numeric(v); !isNum || !rv.CanConvert(s.keyType) {
+			return k, false
+		}
+	}
+
+	// 3.5 のような値が 3 に丸められないよう、変換後に元の値と比較する
+	kv := rv.Convert(s.keyType)
+	if !equal(toValue(kv), v) {
+		return k, false
+	}
+
+	return kv.Interface().(K), true
+}
+
+func (s *mapSource[K, V]) row(k K, v V) []driver.Value {
+	return append([]driver.Value{toValue(reflect.ValueOf(k))}, s.mapper.values(reflect.ValueOf(v))...)
+}
+
+type csvSource struct {
+	open func() (io.ReadCloser, error)
+	cols []Column
+}
+
+func (s *csvSource) Columns() []Column {
+	return s.cols
+}
+
+func (s *csvSource) Scan(map[int]driver.Value) iter.Seq2[[]driver.Value, error] {
+	return func(yield func([]driver.Value, error) bool) {
+		rc, err := s.open()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer rc.Close()
+
+		var (
+			r = csv.NewReader(rc)
+		)
+		r.FieldsPerRecord = len(s.cols)
+		r.ReuseRecord = true
+
+		if _, err = r.Read(); err != nil { // ヘッダ
+			yield(nil, err)
+			return
+		}
+
+		for {
+			rec, err := r.Read()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			var (
+				row = make([]driver.Value, len(rec))
+			)
+			for i, f := range rec {
+				row[i] = f
+			}
+
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+// structMapper は、構造体のフィールドと列の対応。
+//
+// 列名はタグ `db:"name"` で指定する (省略時はフィールド名)。`db:"-"` のフィールドと非公開フィールドは無視する。
+// 列の型はフィールドの型から決まる。
+//
+//   - int*, uint*, bool : INTEGER
+//   - float*            : REAL
+//   - string, time.Time : TEXT (time.Time は "2006-01-02 15:04:05" 形式)
+//   - []byte            : BLOB
+//
+// ポインタの場合は nil が NULL となる。
+type structMapper struct {
+	cols   []Column
+	fields [][]int
+}
+
+func newStructMapper(t reflect.Type) (*structMapper, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("vtable: %v is not a struct", t)
+	}
+
+	var (
+		m = &structMapper{}
+	)
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("db"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+
+		typ, ok := sqlType(f.Type)
+		if !ok {
+			return nil, fmt.Errorf("vtable: %v.%s: unsupported type %v", t, f.Name, f.Type)
+		}
+
+		m.cols = append(m.cols, Column{Name: name, Type: typ})
+		m.fields = append(m.fields, f.Index)
+	}
+
+	if len(m.cols) == 0 {
+		return nil, fmt.Errorf("vtable: %v has no columns", t)
+	}
+
+	return m, nil
+}
+
+func (m *structMapper) values(v reflect.Value) []driver.Value {
+	var (
+		row = make([]driver.Value, len(m.fields))
+	)
+	for i, idx := range m.fields {
+		row[i] = toValue(v.FieldByIndex(idx))
+	}
+
+	return row
+}
+
+var (
+	timeType  = reflect.TypeFor[time.Time]()
+	bytesType = reflect.TypeFor[[]byte]()
+)
+
+// sqlType は、Goの型に対応する列の型を返す。
+func sqlType(t reflect.Type) (string, bool) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return "TEXT", true
+	case t == bytesType:
+		return "BLOB", true
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Bool:
+		return "INTEGER", true
+	case reflect.Float32, reflect.Float64:
+		return "REAL", true
+	case reflect.String:
+		return "TEXT", true
+	}
+
+	return "", false
+}
+
+// toValue は、フィールドの値をSQLiteの値に変換する。
+func toValue(v reflect.Value) driver.Value {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch {
+	case v.Type() == timeType:
+		return v.Interface().(time.Time).Format(time.DateTime)
+	case v.Type() == bytesType:
+		return v.Bytes()
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.Bool:
+		if v.Bool() {
+			return int64(1)
+		}
+		return int64(0)
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	}
+
+	return nil
+}
@@ -0,0 +1,318 @@
+// Package vtable は、Goのデータ (スライス、マップ、iter.Seq、CSV) をSQLiteの仮想テーブルとして公開する。
+//
+// 仮想テーブルを使うと、実テーブルにデータをロードせずに、Go側のデータをSQLから参照できる。
+//
+//	SELECT a.Name, r.Rating
+//	FROM artists a JOIN ratings r ON r.ArtistId = a.ArtistId
+//
+// 仮想テーブルの実装方法はドライバ毎に異なる。
+//
+//   - mattn/go-sqlite3  : sqlite3.Module / sqlite3.VTab / sqlite3.VTabCursor (ビルドタグ sqlite_vtable が必要)
+//   - modernc.org/sqlite: modernc.org/sqlite/vtab の Module / Table / Cursor
+//
+// 本パッケージでは、データの読み出し (Source) と検索条件の処理を共通化し、
+// 各ドライバのインターフェースへの変換は mattn.go / modernc.go で行っている。
+//
+// 登録したテーブルは、コネクション毎に temp スキーマの仮想テーブルとして作成される。
+// (CREATE VIRTUAL TABLE temp.<name> USING <module>)
+//
+// # REFERENCES
+//   - https://www.sqlite.org/vtab.html
+//   - https://pkg.go.dev/github.com/mattn/go-sqlite3#Module
+//   - https://pkg.go.dev/modernc.org/sqlite/vtab
+package vtable
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"iter"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNoVTable は、ビルドタグ sqlite_vtable を指定せずに RegisterMattn を呼び出した場合のエラー。
+	ErrNoVTable = errors.New("vtable: mattn/go-sqlite3 virtual tables require build tag sqlite_vtable")
+)
+
+var (
+	nameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+type (
+	// Column は、仮想テーブルの列定義。
+	Column struct {
+		// Name は、列名。
+		Name string
+		// Type は、列の型 (INTEGER, REAL, TEXT, BLOB)。
+		Type string
+	}
+
+	// Source は、仮想テーブルのデータの取得元。
+	//
+	// Scan は、テーブルを走査する度 (JOIN の内側であれば外側の行毎) に呼ばれる。
+	// eq には、WHERE 句の等価条件のうち SQLite が本パッケージに任せたもの (列番号 → 値) が渡される。
+	// Source はこれを使って走査範囲を絞り込んでもよいし、無視してもよい。
+	// (返した行は本パッケージ側で改めて eq と比較するため、条件に合わない行を返しても結果は正しくなる)
+	//
+	// 行の値は int64, float64, string, []byte, nil のいずれかで返す。
+	Source interface {
+		Columns() []Column
+		Scan(eq map[int]driver.Value) iter.Seq2[[]driver.Value, error]
+	}
+
+	// Indexer は、Source が特定の列の等価条件で効率よく絞り込めることを示す。
+	//
+	// 実装している場合、その列に対する等価条件のコストを低く見積もり、SQLiteが優先して利用するようになる。
+	Indexer interface {
+		Indexed(col int) bool
+	}
+
+	table struct {
+		name   string
+		module string
+		src    Source
+	}
+
+	// Registry は、仮想テーブルとして公開する Source の一覧。
+	Registry struct {
+		mu     sync.Mutex
+		tables []table
+	}
+)
+
+// Add は、src を name という名前の仮想テーブルとして追加する。
+//
+// モジュール名は "go_<name>" となる。
+func (r *Registry) Add(name string, src Source) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("vtable: invalid table name %q", name)
+	}
+	if len(src.Columns()) == 0 {
+		return fmt.Errorf("vtable: %s: no columns", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.tables {
+		if t.name == name {
+			return fmt.Errorf("vtable: %s: already added", name)
+		}
+	}
+
+	r.tables = append(r.tables, table{name: name, module: "go_" + name, src: src})
+
+	return nil
+}
+
+func (r *Registry) list() []table {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]table(nil), r.tables...)
+}
+
+// schema は、仮想テーブルの宣言 (sqlite3_declare_vtab に渡す CREATE TABLE 文) を生成する。
+func schema(src Source) string {
+	var (
+		cols []string
+	)
+	for _, c := range src.Columns() {
+		cols = append(cols, fmt.Sprintf(`"%s" %s`, strings.ReplaceAll(c.Name, `"`, `""`), c.Type))
+	}
+
+	return fmt.Sprintf("CREATE TABLE x (%s)", strings.Join(cols, ", "))
+}
+
+// createSQL は、コネクション毎に実行する CREATE VIRTUAL TABLE 文を生成する。
+func (t table) createSQL() string {
+	return fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS temp.%s USING %s", t.name, t.module)
+}
+
+type (
+	// constraint は、ドライバに依存しない形の検索条件。
+	constraint struct {
+		column int
+		eq     bool
+		usable bool
+	}
+
+	// plan は、bestIndex の結果。
+	plan struct {
+		// used は、constraints と同じ順序で、Filter の引数として受け取る条件かどうか。
+		used []bool
+		// idxStr は、Filter の引数に対応する列番号をカンマ区切りにしたもの。
+		idxStr string
+		cost   float64
+	}
+)
+
+// bestIndex は、利用可能な等価条件を Filter に渡す実行計画を立てる。
+//
+// 同じ列に複数の等価条件がある場合 (p.x = v.a AND p.x = v.b など) は、最初の1つのみ Filter に渡し、
+// 残りは SQLite に評価させる (used=false のため omit されない)。Filter では列毎に1つの値しか扱わないため。
+//
+// 等価条件が多いほど (Indexer で絞り込める列であればさらに) コストを低く見積もることで、
+// SQLiteが JOIN の内側に仮想テーブルを置き、結合キーを等価条件として渡すようにする。
+func bestIndex(src Source, cs []constraint) plan {
+	var (
+		p = plan{
+			used: make([]bool, len(cs)),
+			cost: 1_000_000,
+		}
+		cols    []string
+		seen    = make(map[int]bool)
+		indexer = func(int) bool { return false }
+	)
+	if ix, ok := src.(Indexer); ok {
+		indexer = ix.Indexed
+	}
+
+	for i, c := range cs {
+		if !c.usable || !c.eq || seen[c.column] {
+			continue
+		}
+
+		seen[c.column] = true
+		p.used[i] = true
+		cols = append(cols, strconv.Itoa(c.column))
+
+		if indexer(c.column) {
+			p.cost /= 1000
+		} else {
+			p.cost /= 10
+		}
+	}
+
+	p.idxStr = strings.Join(cols, ",")
+
+	return p
+}
+
+// cursor は、ドライバに依存しないカーソルの実装。
+type cursor struct {
+	src   Source
+	next  func() ([]driver.Value, error, bool)
+	stop  func()
+	row   []driver.Value
+	rowid int64
+	eof   bool
+}
+
+// filter は、走査を (再) 開始する。idxStr と vals は bestIndex で決めた等価条件。
+func (c *cursor) filter(idxStr string, vals []driver.Value) error {
+	c.close()
+
+	var (
+		eq = make(map[int]driver.Value)
+	)
+	if idxStr != "" {
+		for i, s := range strings.Split(idxStr, ",") {
+			col, err := strconv.Atoi(s)
+			if err != nil || i >= len(vals) {
+				return fmt.Errorf("vtable: bad index string %q", idxStr)
+			}
+			eq[col] = vals[i]
+		}
+	}
+
+	var (
+		next, stop = iter.Pull2(c.src.Scan(eq))
+	)
+	c.next = func() ([]driver.Value, error, bool) {
+		for {
+			row, err, ok := next()
+			if !ok || err != nil || matches(row, eq) {
+				return row, err, ok
+			}
+		}
+	}
+	c.stop = stop
+	c.rowid = 0
+
+	return c.advance()
+}
+
+// advance は、次の行に進む。
+func (c *cursor) advance() error {
+	row, err, ok := c.next()
+	if err != nil {
+		return err
+	}
+
+	c.row, c.eof = row, !ok
+	c.rowid++
+
+	return nil
+}
+
+func (c *cursor) column(col int) driver.Value {
+	if col < 0 || col >= len(c.row) {
+		return nil
+	}
+
+	return c.row[col]
+}
+
+func (c *cursor) close() {
+	if c.stop != nil {
+		c.stop()
+		c.stop = nil
+	}
+}
+
+// matches は、row が等価条件を全て満たすかどうかを返す。
+//
+// 等価条件は SQLite 側で再評価しない (omit) ため、ここで確実に判定する。
+func matches(row []driver.Value, eq map[int]driver.Value) bool {
+	for col, want := range eq {
+		if col >= len(row) || !equal(row[col], want) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// equal は、SQLiteの値として a と b が等しいかどうかを返す。
+//
+// 数値同士は数値として、それ以外は文字列として比較する。NULL はどの値とも等しくない。
+func equal(a, b driver.Value) bool {
+	if a == nil || b == nil {
+		return false
+	}
+
+	if x, ok := numeric(a); ok {
+		if y, ok := numeric(b); ok {
+			return x == y
+		}
+	}
+
+	return text(a) == text(b)
+}
+
+func numeric(v driver.Value) (float64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+
+	return 0, false
+}
+
+func text(v driver.Value) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	default:
+		return fmt.Sprint(x)
+	}
+}
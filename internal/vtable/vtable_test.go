@@ -0,0 +1,132 @@
+package vtable
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// mattnDriverName は、テスト用に testRegistry を ConnectHook で登録する mattn/go-sqlite3 のドライバ名
+	mattnDriverName = "sqlite3_vtable_test"
+)
+
+var (
+	// testRegistry は、テスト全体で共有する Registry。
+	//
+	// modernc.org/sqlite へのモジュールの登録はプロセスグローバルで、別の Registry から同じ名前で登録すると ErrRegistered となるため、
+	// テスト毎に Registry を作らずにこれを使う。
+	testRegistry     *Registry
+	testRegistryOnce sync.Once
+)
+
+type probe struct {
+	X int64 `db:"x"`
+	Y string
+}
+
+// openBoth は、testRegistry を登録した mattn/go-sqlite3 と modernc.org/sqlite のインメモリDBを開く。
+//
+// ビルドタグ sqlite_vtable を指定していない場合、mattn/go-sqlite3 は含めない。
+func openBoth(t *testing.T) map[string]*sql.DB {
+	t.Helper()
+
+	testRegistryOnce.Do(func() {
+		src, err := Slice([]probe{{1, "a"}, {2, "b"}, {2, "c"}, {3, "d"}})
+		if err != nil {
+			panic(err)
+		}
+
+		r := &Registry{}
+		if err = r.Add("probe_t", src); err != nil {
+			panic(err)
+		}
+
+		sql.Register(mattnDriverName, &sqlite3.SQLiteDriver{ConnectHook: r.RegisterMattn})
+		testRegistry = r
+	})
+
+	var (
+		dbs = make(map[string]*sql.DB)
+	)
+
+	modernc, err := testRegistry.OpenModernc(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modernc.SetMaxOpenConns(1)
+	t.Cleanup(func() { modernc.Close() })
+	dbs["modernc"] = modernc
+
+	mattn, err := sql.Open(mattnDriverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mattn.SetMaxOpenConns(1)
+	t.Cleanup(func() { mattn.Close() })
+	switch err = mattn.Ping(); {
+	case errors.Is(err, ErrNoVTable):
+		t.Logf("mattn: %v", err)
+	case err != nil:
+		t.Fatal(err)
+	default:
+		dbs["mattn"] = mattn
+	}
+
+	return dbs
+}
+
+func TestBestIndexSameColumn(t *testing.T) {
+	var (
+		src, _ = Slice([]probe{})
+		p      = bestIndex(src, []constraint{
+			{column: 0, eq: true, usable: true},
+			{column: 0, eq: true, usable: true},
+			{column: 1, eq: false, usable: true},
+			{column: 1, eq: true, usable: false},
+			{column: 1, eq: true, usable: true},
+		})
+	)
+
+	if want := []bool{true, false, false, false, true}; !slices.Equal(p.used, want) {
+		t.Errorf("used = %v, want %v", p.used, want)
+	}
+	if p.idxStr != "0,1" {
+		t.Errorf("idxStr = %q, want %q", p.idxStr, "0,1")
+	}
+}
+
+// TestEqualitiesOnSameColumn は、同じ列に複数の等価条件がある場合に、全ての条件が評価されることを確認する。
+func TestEqualitiesOnSameColumn(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"different values", `SELECT count(*) FROM (SELECT 1 a, 2 b) v JOIN probe_t p ON p.x = v.a AND p.x = v.b`, 0},
+		{"same values", `SELECT count(*) FROM (SELECT 2 a, 2 b) v JOIN probe_t p ON p.x = v.a AND p.x = v.b`, 2},
+		{"literals", `SELECT count(*) FROM probe_t WHERE x = 1 AND x = 3`, 0},
+		{"other column", `SELECT count(*) FROM probe_t WHERE x = 2 AND Y = 'c'`, 1},
+		{"single", `SELECT count(*) FROM probe_t WHERE x = 2`, 2},
+	}
+
+	for name, db := range openBoth(t) {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				var (
+					n int
+				)
+				if err := db.QueryRow(tt.query).Scan(&n); err != nil {
+					t.Fatal(err)
+				}
+				if n != tt.want {
+					t.Errorf("count = %d, want %d", n, tt.want)
+				}
+			})
+		}
+	}
+}